@@ -1,5 +1,8 @@
 use clap::{Parser, Subcommand};
+use std::collections::HashMap;
 use std::path::PathBuf;
+use std::sync::Arc;
+use std::time::Duration;
 use anyhow::Result;
 use wadup_core::*;
 
@@ -30,14 +33,20 @@ enum Commands {
 
         #[arg(long, help = "Maximum stack size in bytes per module instance")]
         max_stack: Option<usize>,
+
+        #[arg(long, help = "Wall-clock limit in seconds per module per content")]
+        timeout: Option<f64>,
     },
 
     /// Run WASM modules on input files
     Run {
-        #[arg(long, help = "Directory containing WASM modules")]
-        modules: PathBuf,
+        #[arg(long, required_unless_present = "module", help = "Directory containing WASM modules")]
+        modules: Option<PathBuf>,
+
+        #[arg(long, conflicts_with = "modules", help = "Run one WASM module on one input file and print its output as JSON, as wadup test does")]
+        module: Option<PathBuf>,
 
-        #[arg(long, help = "Directory containing input files")]
+        #[arg(long, help = "Directory containing input files, or the input file with --module")]
         input: PathBuf,
 
         #[arg(long, default_value = "http://localhost:9200", help = "Elasticsearch URL")]
@@ -58,8 +67,101 @@ enum Commands {
         #[arg(long, help = "Maximum stack size in bytes per module instance")]
         max_stack: Option<usize>,
 
+        #[arg(long, help = "Wall-clock limit in seconds per module per content")]
+        timeout: Option<f64>,
+
+        #[arg(long, help = "Restart the timeout whenever a module reports progress")]
+        progress_extends_timeout: bool,
+
+        #[arg(long, help = "Maximum total bytes of the files a module keeps in /tmp")]
+        scratch_quota: Option<usize>,
+
+        #[arg(long, help = "Maximum bytes of metadata a module may write per content item")]
+        metadata_budget: Option<u64>,
+
         #[arg(long, default_value = "100", help = "Maximum recursion depth for sub-content")]
         max_recursion_depth: usize,
+
+        #[arg(long, default_value = "100000", help = "Distinct payloads remembered for duplicate skipping (0 disables)")]
+        dedup_capacity: usize,
+
+        #[arg(long, help = "Module that still runs on duplicate content (repeatable)")]
+        dedup_exempt_module: Vec<String>,
+
+        #[arg(long, value_name = "NAME:KEY=VALUE,...", help = "Per-module fuel, max-memory or timeout override (repeatable)")]
+        module_limit: Vec<String>,
+
+        #[arg(long, value_name = "NAME:CONDITION", help = "Only run a module on content matching a condition, e.g. 'zip:size > 1MB && mime == \"application/zip\"' (repeatable)")]
+        module_when: Vec<String>,
+
+        #[arg(long, value_name = "NAME:KEY=VALUE", help = "Setting passed to a module through wadup.Config() (repeatable)")]
+        module_config: Vec<String>,
+
+        #[arg(long, value_name = "PATH", help = "JSON file of module settings by module, e.g. {\"strings\": {\"min_length\": \"6\"}}")]
+        module_config_file: Option<PathBuf>,
+
+        #[arg(long, default_value = "1", help = "Threads each worker uses to run modules on the same content")]
+        module_threads: usize,
+
+        #[arg(long, default_value = "10000", help = "Maximum queued content items before sub-content is processed inline (0 = unbounded)")]
+        max_queued: usize,
+
+        #[arg(long, help = "Don't add content and module provenance fields to table rows")]
+        no_provenance: bool,
+
+        #[arg(long, help = "Prefix table names with the module name (shared tables keep their name)")]
+        namespace_tables: bool,
+
+        #[arg(long, default_value = "suffix", help = "Repeated sub-content filenames: keep or suffix")]
+        subcontent_collisions: CollisionPolicy,
+
+        #[arg(long, default_value = "preserve", help = "Directory paths in sub-content filenames: preserve or basename")]
+        subcontent_paths: PathPolicy,
+
+        #[arg(long, help = "Maximum sub-content items emitted per content")]
+        max_children: Option<usize>,
+
+        #[arg(long, help = "Maximum total bytes of sub-content emitted per content")]
+        max_emitted_bytes: Option<u64>,
+
+        #[arg(long, help = "Maximum size in bytes of a single sub-content item")]
+        max_child_size: Option<u64>,
+
+        #[arg(long, help = "Drop all further sub-content of a content once it exceeds a quota")]
+        quota_stop: bool,
+
+        #[arg(long, help = "Maximum rows a module stores per table for one content item")]
+        max_table_rows: Option<usize>,
+
+        #[arg(long, default_value = "0", help = "Rows sampled from the rest once a table reaches --max-table-rows")]
+        table_row_sample: usize,
+
+        #[arg(long, value_name = "PATH", help = "File recording finished module runs, so re-runs skip work already done")]
+        state: Option<PathBuf>,
+
+        #[arg(long, requires = "state", help = "Process everything again, discarding the runs recorded in --state")]
+        force: bool,
+
+        #[arg(long, value_name = "SECS", help = "Check the modules directory for new and changed modules every SECS seconds while processing")]
+        watch_modules: Option<u64>,
+
+        #[arg(long, value_name = "PATH", help = "JSON file of candidate secrets by kind, e.g. {\"password\": [\"infected\"]}")]
+        secrets_file: Option<PathBuf>,
+
+        #[arg(long, value_name = "KIND=VALUE", help = "Candidate secret offered to modules (repeatable)")]
+        secret: Vec<String>,
+
+        #[arg(long, value_name = "TABLE:COLUMN[:KIND]", help = "Table column whose values become secrets for later content (repeatable)")]
+        secrets_table: Vec<SecretSource>,
+
+        #[arg(long, value_name = "DIR", help = "Also write table rows as Parquet datasets, one directory per table")]
+        parquet_dir: Option<PathBuf>,
+
+        #[arg(long, value_name = "PATH", help = "Also write all tables and rows into one SQLite database")]
+        sqlite: Option<PathBuf>,
+
+        #[arg(long, help = "Don't index results in Elasticsearch; requires --sqlite or --parquet-dir")]
+        no_elasticsearch: bool,
     },
 
     /// Test a single WASM module against a sample file (outputs JSON)
@@ -67,7 +169,7 @@ enum Commands {
         #[arg(short = 'm', long, help = "Path to the WASM module file")]
         module: PathBuf,
 
-        #[arg(short = 's', long, help = "Path to the sample file to process")]
+        #[arg(short = 's', long, visible_alias = "input", help = "Path to the sample file to process")]
         sample: PathBuf,
 
         #[arg(short = 'f', long, default_value = "sample", help = "Original filename (passed as WADUP_FILENAME)")]
@@ -81,6 +183,33 @@ enum Commands {
 
         #[arg(long, help = "Maximum stack size in bytes")]
         max_stack: Option<usize>,
+
+        #[arg(long, help = "Wall-clock limit in seconds for module execution")]
+        timeout: Option<f64>,
+
+        #[arg(long, help = "Restart the timeout whenever the module reports progress")]
+        progress_extends_timeout: bool,
+
+        #[arg(long, help = "Maximum total bytes of the files the module keeps in /tmp")]
+        scratch_quota: Option<usize>,
+
+        #[arg(long, help = "Maximum bytes of metadata the module may write")]
+        metadata_budget: Option<u64>,
+
+        #[arg(long, default_value = "suffix", help = "Repeated sub-content filenames: keep or suffix")]
+        subcontent_collisions: CollisionPolicy,
+
+        #[arg(long, default_value = "preserve", help = "Directory paths in sub-content filenames: preserve or basename")]
+        subcontent_paths: PathPolicy,
+
+        #[arg(long, value_name = "PATH", help = "JSON file of candidate secrets by kind, e.g. {\"password\": [\"infected\"]}")]
+        secrets_file: Option<PathBuf>,
+
+        #[arg(long, value_name = "KIND=VALUE", help = "Candidate secret offered to modules (repeatable)")]
+        secret: Vec<String>,
+
+        #[arg(long, value_name = "KEY=VALUE", help = "Setting passed to the module through wadup.Config() (repeatable)")]
+        config: Vec<String>,
     },
 }
 
@@ -101,14 +230,41 @@ fn main() -> Result<()> {
         .init();
 
     match cli.command {
-        Commands::Compile { modules, fuel, max_memory, max_stack } => {
-            run_compile(modules, fuel, max_memory, max_stack)
+        Commands::Compile { modules, fuel, max_memory, max_stack, timeout } => {
+            run_compile(modules, fuel, max_memory, max_stack, timeout)
         }
-        Commands::Run { modules, input, es_url, es_index, threads, fuel, max_memory, max_stack, max_recursion_depth } => {
-            run_process(modules, input, es_url, es_index, threads, fuel, max_memory, max_stack, max_recursion_depth)
+        Commands::Run { modules, module, input, es_url, es_index, threads, fuel, max_memory, max_stack, timeout, progress_extends_timeout, scratch_quota, metadata_budget, max_recursion_depth, dedup_capacity, dedup_exempt_module, module_limit, module_when, module_config, module_config_file, module_threads, max_queued, no_provenance, namespace_tables, subcontent_collisions, subcontent_paths, max_children, max_emitted_bytes, max_child_size, quota_stop, max_table_rows, table_row_sample, state, force, watch_modules, secrets_file, secret, secrets_table, parquet_dir, sqlite, no_elasticsearch } => {
+            let naming = SubcontentNaming { collisions: subcontent_collisions, paths: subcontent_paths };
+            let quotas = SubcontentQuotas { max_children, max_total_bytes: max_emitted_bytes, max_child_size };
+            let secrets = load_secrets(secrets_file.as_deref(), &secret)?;
+            for source in secrets_table {
+                secrets.add_source(source);
+            }
+            let mut config = ModuleConfig::new();
+            if let Some(file) = module_config_file {
+                config.load_file(&file)?;
+            }
+            for spec in &module_config {
+                config.add_spec(spec)?;
+            }
+            if let Some(module) = module {
+                let filename = input.file_name()
+                    .map(|name| name.to_string_lossy().into_owned())
+                    .unwrap_or_else(|| "sample".to_string());
+                return run_test_command(module, input, filename, fuel, max_memory, max_stack, timeout, progress_extends_timeout, scratch_quota, metadata_budget, naming, secrets, config);
+            }
+            let modules = modules.ok_or_else(|| anyhow::anyhow!("--modules or --module is required"))?;
+            run_process(modules, input, es_url, es_index, threads, fuel, max_memory, max_stack, timeout, progress_extends_timeout, scratch_quota, metadata_budget, max_recursion_depth, dedup_capacity, dedup_exempt_module, module_limit, module_when, module_threads, max_queued, no_provenance, namespace_tables, naming, quotas, quota_stop, max_table_rows.map(|head| RowCap::new(head, table_row_sample)), state, force, watch_modules, secrets, config, parquet_dir, sqlite, no_elasticsearch)
         }
-        Commands::Test { module, sample, filename, fuel, max_memory, max_stack } => {
-            run_test_command(module, sample, filename, fuel, max_memory, max_stack)
+        Commands::Test { module, sample, filename, fuel, max_memory, max_stack, timeout, progress_extends_timeout, scratch_quota, metadata_budget, subcontent_collisions, subcontent_paths, secrets_file, secret, config } => {
+            let naming = SubcontentNaming { collisions: subcontent_collisions, paths: subcontent_paths };
+            let secrets = load_secrets(secrets_file.as_deref(), &secret)?;
+            let module_name = module_name(&module);
+            let mut module_config = ModuleConfig::new();
+            for setting in &config {
+                module_config.add(&module_name, setting)?;
+            }
+            run_test_command(module, sample, filename, fuel, max_memory, max_stack, timeout, progress_extends_timeout, scratch_quota, metadata_budget, naming, secrets, module_config)
         }
     }
 }
@@ -118,6 +274,7 @@ fn run_compile(
     fuel: Option<u64>,
     max_memory: Option<usize>,
     max_stack: Option<usize>,
+    timeout: Option<f64>,
 ) -> Result<()> {
     tracing::info!("WADUP - Precompiling WASM Modules");
     tracing::info!("============================================");
@@ -132,6 +289,14 @@ fn run_compile(
         fuel,
         max_memory,
         max_stack,
+        timeout: parse_timeout(timeout)?,
+        modules: HashMap::new(),
+        progress_extends_timeout: false,
+        subcontent_naming: SubcontentNaming::default(),
+        secrets: SecretStore::default(),
+        module_config: ModuleConfig::default(),
+        scratch_quota: None,
+        metadata_budget: None,
     };
 
     tracing::info!("Configuration:");
@@ -146,6 +311,9 @@ fn run_compile(
     if let Some(stack) = limits.max_stack {
         tracing::info!("  Stack limit: {} bytes", stack);
     }
+    if let Some(timeout) = limits.timeout {
+        tracing::info!("  Timeout: {:?}", timeout);
+    }
 
     // Create runtime and load modules (this triggers precompilation)
     tracing::info!("Precompiling WASM modules...");
@@ -167,7 +335,31 @@ fn run_process(
     fuel: Option<u64>,
     max_memory: Option<usize>,
     max_stack: Option<usize>,
+    timeout: Option<f64>,
+    progress_extends_timeout: bool,
+    scratch_quota: Option<usize>,
+    metadata_budget: Option<u64>,
     max_recursion_depth: usize,
+    dedup_capacity: usize,
+    dedup_exempt_modules: Vec<String>,
+    module_limits: Vec<String>,
+    module_conditions: Vec<String>,
+    module_threads: usize,
+    max_queued: usize,
+    no_provenance: bool,
+    namespace_tables: bool,
+    subcontent_naming: SubcontentNaming,
+    quotas: SubcontentQuotas,
+    quota_stop: bool,
+    row_cap: Option<RowCap>,
+    state_path: Option<PathBuf>,
+    force: bool,
+    watch_modules: Option<u64>,
+    secrets: SecretStore,
+    module_config: ModuleConfig,
+    parquet_dir: Option<PathBuf>,
+    sqlite_path: Option<PathBuf>,
+    no_elasticsearch: bool,
 ) -> Result<()> {
     tracing::info!("WADUP - Web Assembly Data Unified Processing");
     tracing::info!("============================================");
@@ -185,20 +377,52 @@ fn run_process(
         anyhow::bail!("Number of threads must be at least 1");
     }
 
+    if module_threads == 0 {
+        anyhow::bail!("Number of module threads must be at least 1");
+    }
+
+    if no_elasticsearch && parquet_dir.is_none() && sqlite_path.is_none() {
+        anyhow::bail!("--no-elasticsearch needs --sqlite or --parquet-dir, or no results would be kept");
+    }
+
     // Configure resource limits
+    let mut modules_limits = HashMap::new();
+    for spec in &module_limits {
+        let (name, overrides) = ModuleLimits::parse_override(spec)?;
+        modules_limits.insert(name, overrides);
+    }
+    let mut conditions = HashMap::new();
+    for spec in &module_conditions {
+        let (name, condition) = Condition::parse_module_spec(spec)?;
+        conditions.insert(name, condition);
+    }
     let limits = ResourceLimits {
         fuel,
         max_memory,
         max_stack,
+        timeout: parse_timeout(timeout)?,
+        modules: modules_limits,
+        progress_extends_timeout,
+        subcontent_naming,
+        secrets,
+        module_config,
+        scratch_quota,
+        metadata_budget,
     };
 
     tracing::info!("Configuration:");
     tracing::info!("  Modules directory: {:?}", modules);
     tracing::info!("  Input directory: {:?}", input);
-    tracing::info!("  Elasticsearch URL: {}", es_url);
-    tracing::info!("  Elasticsearch index: {}", es_index);
+    if no_elasticsearch {
+        tracing::info!("  Elasticsearch: disabled");
+    } else {
+        tracing::info!("  Elasticsearch URL: {}", es_url);
+        tracing::info!("  Elasticsearch index: {}", es_index);
+    }
     tracing::info!("  Worker threads: {}", threads);
+    tracing::info!("  Module threads per worker: {}", module_threads);
     tracing::info!("  Max recursion depth: {}", max_recursion_depth);
+    tracing::info!("  Dedup capacity: {}", dedup_capacity);
 
     if let Some(fuel) = limits.fuel {
         tracing::info!("  Fuel limit: {}", fuel);
@@ -218,33 +442,166 @@ fn run_process(
         tracing::info!("  Stack limit: None (wasmtime defaults)");
     }
 
+    if let Some(timeout) = limits.timeout {
+        if limits.progress_extends_timeout {
+            tracing::info!("  Timeout: {:?} (restarted on progress)", timeout);
+        } else {
+            tracing::info!("  Timeout: {:?}", timeout);
+        }
+    } else {
+        tracing::info!("  Timeout: None (no wall-clock limit)");
+    }
+
+    if let Some(quota) = limits.scratch_quota {
+        tracing::info!("  Scratch quota: {} bytes", quota);
+    }
+
+    if let Some(budget) = limits.metadata_budget {
+        tracing::info!("  Metadata budget: {} bytes per content", budget);
+    }
+
+    for spec in &module_limits {
+        tracing::info!("  Module limit: {}", spec);
+    }
+
+    for (name, condition) in &conditions {
+        tracing::info!("  Module condition: {} when {}", name, condition);
+    }
+
+    for name in limits.module_config.modules() {
+        tracing::info!("  Module config: {}", name);
+    }
+
+    if quotas.is_enabled() {
+        tracing::info!(
+            "  Sub-content quotas: children {:?}, total bytes {:?}, child size {:?}{}",
+            quotas.max_children,
+            quotas.max_total_bytes,
+            quotas.max_child_size,
+            if quota_stop { " (stop on first)" } else { "" }
+        );
+    }
+
+    if let Some(cap) = row_cap {
+        tracing::info!("  Rows per table: first {} plus a sample of {}", cap.head, cap.sample);
+    }
+
+    if limits.secrets.has_sources() {
+        tracing::info!("  Harvesting secrets from credentials tables");
+    }
+
+    if let Some(dir) = &parquet_dir {
+        tracing::info!("  Parquet directory: {:?}", dir);
+    }
+
+    if let Some(path) = &sqlite_path {
+        tracing::info!("  SQLite database: {:?}", path);
+    }
+
     // Load WASM modules (uses precompiled cache if available)
     tracing::info!("Loading WASM modules...");
     let mut runtime = WasmRuntime::new(limits)?;
     runtime.load_modules(&modules)?;
 
+    // Open the record of earlier runs
+    let state = match state_path {
+        Some(path) => {
+            let (state, invalidated) = ProcessingState::open(&path, &runtime.module_binaries(), force)?;
+            for module in &invalidated {
+                tracing::info!("Module '{}' changed since the last run; processing everything with it again", module);
+            }
+            tracing::info!("State file {}: {} module runs already done", path.display(), state.len());
+            Some(state)
+        }
+        None => None,
+    };
+
     // Create metadata store (connects to Elasticsearch)
-    tracing::info!("Connecting to Elasticsearch...");
-    let metadata_store = MetadataStore::new(&es_url, &es_index)?;
+    let metadata_store = if no_elasticsearch {
+        MetadataStore::without_elasticsearch()
+    } else {
+        tracing::info!("Connecting to Elasticsearch...");
+        MetadataStore::new(&es_url, &es_index)?
+    };
+    let metadata_store = metadata_store
+        .with_provenance(!no_provenance)
+        .with_table_namespaces(namespace_tables);
+
+    // Further destinations for table rows
+    let mut sinks: Vec<Arc<dyn MetadataSink>> = Vec::new();
+    if let Some(dir) = &parquet_dir {
+        sinks.push(Arc::new(ParquetSink::new(dir)?));
+    }
+    if let Some(path) = &sqlite_path {
+        sinks.push(Arc::new(SqliteSink::new(path)?));
+    }
+    let metadata_store = sinks.iter()
+        .fold(metadata_store, |store, sink| store.with_sink(Arc::clone(sink)));
 
     // Load input files
     tracing::info!("Loading input files...");
     let contents = load_files(&input)?;
     tracing::info!("Found {} input files", contents.len());
 
+    // Pick up new and rebuilt modules while processing
+    let _watcher = watch_modules.map(|secs| {
+        tracing::info!("Watching {} for module changes every {}s", modules.display(), secs);
+        ModuleWatcher::start(runtime.registry().clone(), Duration::from_secs(secs.max(1)))
+    });
+
     // Create processor
     let processor = ContentProcessor::new(
         runtime,
         metadata_store,
         max_recursion_depth,
-    );
+    )
+    .with_dedup(dedup_capacity, dedup_exempt_modules)
+    .with_scheduling(module_threads, max_queued)
+    .with_subcontent_quotas(quotas)
+    .with_conditions(conditions);
+    let processor = if quota_stop {
+        processor.with_quota_policy(std::sync::Arc::new(|_: &QuotaExceeded| QuotaAction::SkipRemaining))
+    } else {
+        processor
+    };
+    let processor = match row_cap {
+        Some(cap) => processor.with_row_cap(cap),
+        None => processor,
+    };
+    let processor = match state {
+        Some(state) => processor.with_state(state),
+        None => processor,
+    };
 
     // Process content
     tracing::info!("Starting processing...");
-    processor.process(contents, threads)?;
+    let stats = processor.process(contents, threads)?;
+    for sink in &sinks {
+        sink.finish()?;
+    }
 
     tracing::info!("============================================");
-    tracing::info!("Processing complete! Results indexed to: {}/{}", es_url, es_index);
+    if stats.condition_skips > 0 {
+        tracing::info!("  Module runs skipped by conditions: {}", stats.condition_skips);
+    }
+    if stats.already_processed > 0 {
+        tracing::info!("  Module runs skipped as already processed: {}", stats.already_processed);
+    }
+    if stats.rows_truncated > 0 {
+        tracing::info!("  Rows dropped by --max-table-rows: {}", stats.rows_truncated);
+    }
+    for (depth, count) in stats.contents_per_depth.iter().enumerate() {
+        tracing::info!("  Depth {}: {} items", depth, count);
+    }
+    if !no_elasticsearch {
+        tracing::info!("Processing complete! Results indexed to: {}/{}", es_url, es_index);
+    }
+    if let Some(dir) = &parquet_dir {
+        tracing::info!("Parquet datasets written to: {}", dir.display());
+    }
+    if let Some(path) = &sqlite_path {
+        tracing::info!("Tables written to: {}", path.display());
+    }
 
     Ok(())
 }
@@ -281,6 +638,13 @@ fn run_test_command(
     fuel: Option<u64>,
     max_memory: Option<usize>,
     max_stack: Option<usize>,
+    timeout: Option<f64>,
+    progress_extends_timeout: bool,
+    scratch_quota: Option<usize>,
+    metadata_budget: Option<u64>,
+    subcontent_naming: SubcontentNaming,
+    secrets: SecretStore,
+    module_config: ModuleConfig,
 ) -> Result<()> {
     use wadup_core::wasm::ModuleInstance;
     use wadup_core::precompile::load_module_with_cache;
@@ -293,38 +657,37 @@ fn run_test_command(
         anyhow::bail!("Sample not found: {:?}", sample);
     }
 
+    let module_name = module_name(&module);
+
     // Configure resource limits
     let limits = ResourceLimits {
         fuel,
         max_memory,
         max_stack,
+        timeout: parse_timeout(timeout)?,
+        modules: HashMap::new(),
+        progress_extends_timeout,
+        subcontent_naming,
+        secrets,
+        module_config,
+        scratch_quota,
+        metadata_budget,
     };
 
     // Create engine with resource limits
     let mut config = wasmtime::Config::new();
     config.wasm_multi_memory(true);
     config.async_support(false);
-    if limits.fuel.is_some() {
-        config.consume_fuel(true);
-    }
-    if let Some(max_stack) = limits.max_stack {
-        config.max_wasm_stack(max_stack);
-    }
+    limits.configure(&mut config);
     let engine = wasmtime::Engine::new(&config)?;
+    let _epoch_ticker = limits.uses_epoch().then(|| EpochTicker::start(&engine));
 
     // Load module
     let wasm_module = load_module_with_cache(&engine, &module)?;
 
-    // Extract module name from path
-    let module_name = module
-        .file_stem()
-        .and_then(|s| s.to_str())
-        .unwrap_or("module")
-        .to_string();
-
     // Set up environment variables (WADUP_FILENAME)
     let env_vars = vec![
-        ("WADUP_FILENAME".to_string(), filename),
+        ("WADUP_FILENAME".to_string(), filename.clone()),
     ];
 
     // Create module instance with environment variables
@@ -340,7 +703,7 @@ fn run_test_command(
     let sample_data = wadup_core::shared_buffer::SharedBuffer::from_file(&sample)?;
 
     // Run the test
-    let output = instance.process_content_for_test(sample_data);
+    let output = instance.process_content_for_test(&filename, sample_data);
 
     // Output JSON to stdout
     let json = serde_json::to_string_pretty(&output)?;
@@ -353,3 +716,35 @@ fn run_test_command(
         std::process::exit(1);
     }
 }
+
+/// Name of a module, taken from its file name
+fn module_name(path: &std::path::Path) -> String {
+    path.file_stem()
+        .and_then(|s| s.to_str())
+        .unwrap_or("module")
+        .to_string()
+}
+
+/// Collect the candidate secrets given in a secrets file and as KIND=VALUE
+/// arguments
+fn load_secrets(file: Option<&std::path::Path>, specs: &[String]) -> Result<SecretStore> {
+    let secrets = SecretStore::new();
+    if let Some(file) = file {
+        secrets.load_file(file)?;
+    }
+    for spec in specs {
+        let (kind, value) = spec.split_once('=')
+            .ok_or_else(|| anyhow::anyhow!("Invalid secret: expected KIND=VALUE"))?;
+        secrets.add(kind, value);
+    }
+    Ok(secrets)
+}
+
+/// Convert a timeout given in seconds on the command line
+fn parse_timeout(secs: Option<f64>) -> Result<Option<Duration>> {
+    secs.map(|secs| {
+        Duration::try_from_secs_f64(secs)
+            .map_err(|e| anyhow::anyhow!("Invalid timeout {}: {}", secs, e))
+    })
+    .transpose()
+}
@@ -0,0 +1,391 @@
+//! Host-side archive listing and extraction.
+//!
+//! Zip and tar archives are indexed straight from the content bytes, so
+//! stored zip entries and all tar entries can be emitted as zero-copy slices
+//! of the parent. Compressed zip entries and 7z entries are decompressed on
+//! the host, never in module memory.
+
+use crate::decompress::MAX_DECOMPRESSED_SIZE;
+use anyhow::{Context, Result};
+use serde::Serialize;
+use std::io::{Cursor, Read};
+
+/// Archive formats the host can index
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ArchiveFormat {
+    Zip,
+    Tar,
+    SevenZip,
+}
+
+impl ArchiveFormat {
+    /// The archive format of content with the given detected MIME type
+    pub fn from_content_type(content_type: &str) -> Option<Self> {
+        match content_type {
+            "application/zip" => Some(Self::Zip),
+            "application/x-tar" => Some(Self::Tar),
+            "application/x-7z-compressed" => Some(Self::SevenZip),
+            _ => None,
+        }
+    }
+}
+
+/// One entry of an archive, as listed to guests
+#[derive(Debug, Clone, Serialize)]
+pub struct ArchiveEntry {
+    pub index: usize,
+    pub name: String,
+    /// Uncompressed size in bytes
+    pub size: u64,
+    /// Size of the entry's data within the archive, where known
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub compressed_size: Option<u64>,
+    /// "stored", "deflate", "bzip2", "zstd", "xz", "7z" or "method-N"
+    pub method: String,
+    pub is_dir: bool,
+    pub encrypted: bool,
+    /// Offset of the entry's data within the content
+    #[serde(skip)]
+    data_offset: Option<u64>,
+}
+
+/// The entries of an archive
+#[derive(Debug, Clone, Serialize)]
+pub struct ArchiveIndex {
+    pub format: ArchiveFormat,
+    pub entries: Vec<ArchiveEntry>,
+}
+
+/// An extracted entry: a range of the archive, or decompressed bytes
+pub enum ExtractedEntry {
+    Slice { offset: usize, length: usize },
+    Bytes(Vec<u8>),
+}
+
+/// Why an entry could not be extracted
+#[derive(Debug)]
+pub enum ExtractError {
+    /// No such entry, or the entry is a directory
+    NoEntry,
+    /// The entry is encrypted
+    Encrypted,
+    /// The entry's data is invalid or uses an unsupported method
+    Corrupt(anyhow::Error),
+}
+
+impl ArchiveIndex {
+    /// Index an archive in the given format
+    pub fn build(format: ArchiveFormat, data: &[u8]) -> Result<Self> {
+        let entries = match format {
+            ArchiveFormat::Zip => zip_entries(data)?,
+            ArchiveFormat::Tar => tar_entries(data)?,
+            ArchiveFormat::SevenZip => sevenz_entries(data)?,
+        };
+        Ok(Self { format, entries })
+    }
+
+    /// Extract entry `index` of the archive `data` this index was built from.
+    /// Decompressed entries may take at most `limit` bytes.
+    pub fn extract(&self, data: &[u8], index: usize, limit: u64) -> Result<ExtractedEntry, ExtractError> {
+        let entry = self.entries.get(index).filter(|e| !e.is_dir).ok_or(ExtractError::NoEntry)?;
+        if entry.encrypted {
+            return Err(ExtractError::Encrypted);
+        }
+
+        match self.format {
+            ArchiveFormat::Tar => {
+                let offset = entry.data_offset.unwrap_or(0) as usize;
+                Ok(ExtractedEntry::Slice { offset, length: entry.size as usize })
+            }
+            ArchiveFormat::Zip => zip_extract(data, entry, limit).map_err(ExtractError::Corrupt),
+            ArchiveFormat::SevenZip => sevenz_extract(data, index, limit)
+                .map(ExtractedEntry::Bytes)
+                .map_err(ExtractError::Corrupt),
+        }
+    }
+}
+
+fn read_u16(data: &[u8], at: usize) -> Result<u16> {
+    data.get(at..at + 2)
+        .map(|b| u16::from_le_bytes([b[0], b[1]]))
+        .context("Truncated archive")
+}
+
+fn read_u32(data: &[u8], at: usize) -> Result<u32> {
+    data.get(at..at + 4)
+        .map(|b| u32::from_le_bytes([b[0], b[1], b[2], b[3]]))
+        .context("Truncated archive")
+}
+
+fn read_u64(data: &[u8], at: usize) -> Result<u64> {
+    data.get(at..at + 8)
+        .map(|b| u64::from_le_bytes(b.try_into().unwrap()))
+        .context("Truncated archive")
+}
+
+const ZIP_EOCD: u32 = 0x0605_4b50;
+const ZIP64_EOCD_LOCATOR: u32 = 0x0706_4b50;
+const ZIP64_EOCD: u32 = 0x0606_4b50;
+const ZIP_CENTRAL_HEADER: u32 = 0x0201_4b50;
+const ZIP_LOCAL_HEADER: u32 = 0x0403_4b50;
+
+/// Name of a zip compression method
+fn zip_method_name(method: u16) -> String {
+    match method {
+        0 => "stored".to_string(),
+        8 => "deflate".to_string(),
+        12 => "bzip2".to_string(),
+        93 => "zstd".to_string(),
+        95 => "xz".to_string(),
+        n => format!("method-{}", n),
+    }
+}
+
+/// Read the central directory of a zip archive
+fn zip_entries(data: &[u8]) -> Result<Vec<ArchiveEntry>> {
+    // The end of central directory record is in the last 64 KiB + 22 bytes
+    let search_start = data.len().saturating_sub(22 + 0xffff);
+    let eocd = (search_start..data.len().saturating_sub(21))
+        .rev()
+        .find(|&i| read_u32(data, i).ok() == Some(ZIP_EOCD))
+        .context("Zip end of central directory not found")?;
+
+    let mut count = read_u16(data, eocd + 10)? as u64;
+    let mut cd_offset = read_u32(data, eocd + 16)? as u64;
+    if count == 0xffff || cd_offset == 0xffff_ffff {
+        // Zip64: the real values are in the zip64 end of central directory
+        let locator = eocd.checked_sub(20).context("Zip64 locator not found")?;
+        if read_u32(data, locator)? != ZIP64_EOCD_LOCATOR {
+            anyhow::bail!("Zip64 locator not found");
+        }
+        let zip64_eocd = read_u64(data, locator + 8)? as usize;
+        if read_u32(data, zip64_eocd)? != ZIP64_EOCD {
+            anyhow::bail!("Zip64 end of central directory not found");
+        }
+        count = read_u64(data, zip64_eocd + 32)?;
+        cd_offset = read_u64(data, zip64_eocd + 48)?;
+    }
+
+    let mut entries = Vec::new();
+    let mut pos = cd_offset as usize;
+    for index in 0..count as usize {
+        if read_u32(data, pos)? != ZIP_CENTRAL_HEADER {
+            anyhow::bail!("Invalid zip central directory header at {}", pos);
+        }
+        let flags = read_u16(data, pos + 8)?;
+        let method = read_u16(data, pos + 10)?;
+        let mut compressed_size = read_u32(data, pos + 20)? as u64;
+        let mut size = read_u32(data, pos + 24)? as u64;
+        let name_len = read_u16(data, pos + 28)? as usize;
+        let extra_len = read_u16(data, pos + 30)? as usize;
+        let comment_len = read_u16(data, pos + 32)? as usize;
+        let mut local_offset = read_u32(data, pos + 42)? as u64;
+
+        let name_start = pos + 46;
+        let name = data.get(name_start..name_start + name_len).context("Truncated archive")?;
+        let name = String::from_utf8_lossy(name).into_owned();
+
+        // Zip64 extended information replaces the fields that overflowed
+        let extra = data
+            .get(name_start + name_len..name_start + name_len + extra_len)
+            .context("Truncated archive")?;
+        let mut at = 0;
+        while at + 4 <= extra.len() {
+            let id = read_u16(extra, at)?;
+            let len = read_u16(extra, at + 2)? as usize;
+            if id == 0x0001 {
+                let mut field = at + 4;
+                for value in [&mut size, &mut compressed_size, &mut local_offset] {
+                    if *value == 0xffff_ffff && field + 8 <= at + 4 + len {
+                        *value = read_u64(extra, field)?;
+                        field += 8;
+                    }
+                }
+            }
+            at += 4 + len;
+        }
+
+        entries.push(ArchiveEntry {
+            index,
+            is_dir: name.ends_with('/'),
+            name,
+            size,
+            compressed_size: Some(compressed_size),
+            method: zip_method_name(method),
+            encrypted: flags & 1 != 0,
+            data_offset: Some(local_offset),
+        });
+        pos = name_start + name_len + extra_len + comment_len;
+    }
+    Ok(entries)
+}
+
+/// Extract a zip entry: stored entries are slices, others are decompressed
+/// into at most `limit` bytes
+fn zip_extract(data: &[u8], entry: &ArchiveEntry, limit: u64) -> Result<ExtractedEntry> {
+    // data_offset is the local header; the data follows its name and extra field
+    let header = entry.data_offset.unwrap_or(0) as usize;
+    if read_u32(data, header)? != ZIP_LOCAL_HEADER {
+        anyhow::bail!("Invalid zip local header for '{}'", entry.name);
+    }
+    let start = header + 30 + read_u16(data, header + 26)? as usize + read_u16(data, header + 28)? as usize;
+    let compressed_size = entry.compressed_size.unwrap_or(0) as usize;
+    let end = start.checked_add(compressed_size).filter(|&end| end <= data.len())
+        .with_context(|| format!("Zip entry '{}' extends past the end of the archive", entry.name))?;
+
+    match entry.method.as_str() {
+        "stored" => Ok(ExtractedEntry::Slice { offset: start, length: compressed_size }),
+        "deflate" | "bzip2" | "zstd" | "xz" => {
+            let output = crate::decompress::decompress_limited(&entry.method, &data[start..end], limit)?;
+            Ok(ExtractedEntry::Bytes(output))
+        }
+        method => anyhow::bail!("Unsupported zip compression method {} for '{}'", method, entry.name),
+    }
+}
+
+/// Parse an octal or base-256 tar number field
+fn tar_number(field: &[u8]) -> Result<u64> {
+    if field.first().is_some_and(|b| b & 0x80 != 0) {
+        let mut value: u64 = 0;
+        for &b in &field[1..] {
+            value = value.checked_mul(256).context("Tar size overflow")? | b as u64;
+        }
+        return Ok(value);
+    }
+    let text = std::str::from_utf8(field)?.trim_matches(|c: char| c == '\0' || c == ' ');
+    if text.is_empty() {
+        return Ok(0);
+    }
+    Ok(u64::from_str_radix(text, 8)?)
+}
+
+/// A NUL-terminated tar string field
+fn tar_string(field: &[u8]) -> String {
+    let end = field.iter().position(|&b| b == 0).unwrap_or(field.len());
+    String::from_utf8_lossy(&field[..end]).into_owned()
+}
+
+/// Read the headers of a tar archive. Regular files and directories are
+/// listed; links and special files are skipped.
+fn tar_entries(data: &[u8]) -> Result<Vec<ArchiveEntry>> {
+    let mut entries = Vec::new();
+    let mut pos = 0;
+    let mut long_name: Option<String> = None;
+    let mut pax_path: Option<String> = None;
+    let mut pax_size: Option<u64> = None;
+
+    while pos + 512 <= data.len() {
+        let header = &data[pos..pos + 512];
+        if header.iter().all(|&b| b == 0) {
+            break;
+        }
+
+        let mut size = tar_number(&header[124..136])?;
+        let typeflag = header[156];
+        let data_start = pos + 512;
+        let payload = data
+            .get(data_start..data_start.saturating_add(size as usize))
+            .with_context(|| format!("Tar entry at {} extends past the end of the archive", pos))?;
+
+        match typeflag {
+            // GNU long name for the next entry
+            b'L' => long_name = Some(tar_string(payload)),
+            // PAX extended header for the next entry
+            b'x' => {
+                for record in String::from_utf8_lossy(payload).split('\n') {
+                    let Some((_, kv)) = record.split_once(' ') else { continue };
+                    match kv.split_once('=') {
+                        Some(("path", path)) => pax_path = Some(path.to_string()),
+                        Some(("size", value)) => pax_size = value.parse().ok(),
+                        _ => {}
+                    }
+                }
+            }
+            b'0' | b'\0' | b'7' | b'5' => {
+                if let Some(pax) = pax_size.take() {
+                    size = pax;
+                }
+                let name = match (pax_path.take(), long_name.take()) {
+                    (Some(path), _) | (None, Some(path)) => path,
+                    (None, None) => {
+                        let name = tar_string(&header[0..100]);
+                        let prefix = if &header[257..262] == b"ustar" { tar_string(&header[345..500]) } else { String::new() };
+                        if prefix.is_empty() { name } else { format!("{}/{}", prefix, name) }
+                    }
+                };
+                if data_start as u64 + size > data.len() as u64 {
+                    anyhow::bail!("Tar entry '{}' extends past the end of the archive", name);
+                }
+                entries.push(ArchiveEntry {
+                    index: entries.len(),
+                    is_dir: typeflag == b'5' || name.ends_with('/'),
+                    name,
+                    size,
+                    compressed_size: None,
+                    method: "stored".to_string(),
+                    encrypted: false,
+                    data_offset: Some(data_start as u64),
+                });
+            }
+            _ => {
+                long_name = None;
+                pax_path = None;
+                pax_size = None;
+            }
+        }
+
+        pos = data_start + (size as usize).div_ceil(512) * 512;
+    }
+    Ok(entries)
+}
+
+/// List the entries of a 7z archive
+fn sevenz_entries(data: &[u8]) -> Result<Vec<ArchiveEntry>> {
+    let reader = sevenz_rust::SevenZReader::new(Cursor::new(data), data.len() as u64, sevenz_rust::Password::empty())
+        .map_err(|e| anyhow::anyhow!("Invalid 7z archive: {}", e))?;
+
+    Ok(reader.archive().files.iter().enumerate()
+        .map(|(index, file)| ArchiveEntry {
+            index,
+            name: file.name().to_string(),
+            size: file.size(),
+            compressed_size: None,
+            method: "7z".to_string(),
+            is_dir: file.is_directory(),
+            encrypted: false,
+            data_offset: None,
+        })
+        .collect())
+}
+
+/// Decompress entry `index` of a 7z archive into at most `limit` bytes
+fn sevenz_extract(data: &[u8], index: usize, limit: u64) -> Result<Vec<u8>> {
+    let limit = limit.min(MAX_DECOMPRESSED_SIZE);
+    let mut reader = sevenz_rust::SevenZReader::new(Cursor::new(data), data.len() as u64, sevenz_rust::Password::empty())
+        .map_err(|e| anyhow::anyhow!("Invalid 7z archive: {}", e))?;
+
+    let mut output = None;
+    let mut position = 0;
+    reader
+        .for_each_entries(|_, entry_reader| {
+            let current = position;
+            position += 1;
+            if current != index {
+                // Entries of a solid block must be read through in order
+                std::io::copy(entry_reader, &mut std::io::sink())?;
+                return Ok(true);
+            }
+            let mut buf = Vec::new();
+            entry_reader.take(limit + 1).read_to_end(&mut buf)?;
+            output = Some(buf);
+            Ok(false)
+        })
+        .map_err(|e| anyhow::anyhow!("Failed to extract 7z entry {}: {}", index, e))?;
+
+    let output = output.with_context(|| format!("7z entry {} not found", index))?;
+    if output.len() as u64 > limit {
+        anyhow::bail!("Decompressed size exceeds limit of {} bytes", limit);
+    }
+    Ok(output)
+}
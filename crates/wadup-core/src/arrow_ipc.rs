@@ -0,0 +1,186 @@
+//! Reading of Arrow IPC row files.
+//!
+//! Guests that negotiate the `arrow_ipc_metadata` feature may write the rows
+//! of a table as /metadata/output_N.arrow, an Arrow IPC stream instead of rows
+//! in the metadata file. The schema names the table in its "wadup.table"
+//! metadata and each field carries its column's type in "wadup.data_type".
+//! Table definitions still come in the regular metadata files, written before
+//! the rows that use them.
+//!
+//! Int64, Float64, Boolean, Bytes, Timestamp (microseconds, UTC) and Duration
+//! (nanoseconds) columns have their native Arrow types. All other types are
+//! Utf8 columns holding the guest's text form of each value, e.g. JSON for
+//! arrays and hex for the elements of a BytesArray.
+
+use anyhow::{Context, Result};
+use arrow::array::{ArrayRef, AsArray};
+use arrow::datatypes::{DurationNanosecondType, Float64Type, Int64Type, TimestampMicrosecondType};
+use arrow::ipc::reader::StreamReader;
+use chrono::DateTime;
+use crate::bindings_context::MetadataRow;
+use crate::bindings_types::{DataType, Value};
+
+/// Schema metadata key naming the table the rows belong to
+const TABLE_KEY: &str = "wadup.table";
+
+/// Field metadata key holding the column's WADUP type
+const DATA_TYPE_KEY: &str = "wadup.data_type";
+
+/// Read the rows of an Arrow IPC stream
+pub fn read_rows(data: &[u8]) -> Result<Vec<MetadataRow>> {
+    let reader = StreamReader::try_new(data, None)
+        .map_err(|e| anyhow::anyhow!("Invalid Arrow IPC stream: {}", e))?;
+    let schema = reader.schema();
+    let table = schema.metadata().get(TABLE_KEY)
+        .ok_or_else(|| anyhow::anyhow!("Arrow schema has no '{}' metadata", TABLE_KEY))?
+        .clone();
+    let types = schema.fields().iter()
+        .map(|field| {
+            let name = field.metadata().get(DATA_TYPE_KEY).ok_or_else(|| anyhow::anyhow!(
+                "Field '{}' of table '{}' has no '{}' metadata", field.name(), table, DATA_TYPE_KEY))?;
+            serde_json::from_value(serde_json::Value::String(name.clone()))
+                .map_err(|_| anyhow::anyhow!("Field '{}' of table '{}' has unknown type '{}'", field.name(), table, name))
+        })
+        .collect::<Result<Vec<DataType>>>()?;
+
+    let mut rows = Vec::new();
+    for batch in reader {
+        let batch = batch.map_err(|e| anyhow::anyhow!("Invalid Arrow record batch for table '{}': {}", table, e))?;
+        let mut columns = Vec::with_capacity(types.len());
+        for ((array, data_type), field) in batch.columns().iter().zip(&types).zip(schema.fields().iter()) {
+            let values = column_values(array, data_type)
+                .with_context(|| format!("Column '{}' of table '{}'", field.name(), table))?;
+            columns.push(values.into_iter());
+        }
+        for _ in 0..batch.num_rows() {
+            rows.push(MetadataRow {
+                table_name: table.clone(),
+                values: columns.iter_mut().map(|column| column.next().unwrap_or(Value::Null)).collect(),
+            });
+        }
+    }
+    Ok(rows)
+}
+
+/// Convert an Arrow column to the values of a WADUP column
+fn column_values(array: &ArrayRef, data_type: &DataType) -> Result<Vec<Value>> {
+    let mismatch = || anyhow::anyhow!("Arrow type {} does not hold {:?} values", array.data_type(), data_type);
+    let values = match data_type {
+        DataType::Int64 => array.as_primitive_opt::<Int64Type>().ok_or_else(mismatch)?
+            .iter().map(|v| v.map_or(Value::Null, Value::Int64)).collect(),
+        DataType::Float64 => array.as_primitive_opt::<Float64Type>().ok_or_else(mismatch)?
+            .iter().map(|v| v.map_or(Value::Null, Value::Float64)).collect(),
+        DataType::Boolean => array.as_boolean_opt().ok_or_else(mismatch)?
+            .iter().map(|v| v.map_or(Value::Null, Value::Boolean)).collect(),
+        DataType::Bytes => array.as_binary_opt::<i32>().ok_or_else(mismatch)?
+            .iter().map(|v| v.map_or(Value::Null, |b| Value::Bytes(b.to_vec()))).collect(),
+        DataType::Duration => array.as_primitive_opt::<DurationNanosecondType>().ok_or_else(mismatch)?
+            .iter().map(|v| v.map_or(Value::Null, Value::Duration)).collect(),
+        DataType::Timestamp => array.as_primitive_opt::<TimestampMicrosecondType>().ok_or_else(mismatch)?
+            .iter()
+            .map(|v| match v {
+                None => Ok(Value::Null),
+                Some(micros) => DateTime::from_timestamp_micros(micros)
+                    .map(|t| Value::Timestamp(t.fixed_offset()))
+                    .ok_or_else(|| anyhow::anyhow!("Timestamp {} µs is out of range", micros)),
+            })
+            .collect::<Result<_>>()?,
+        _ => array.as_string_opt::<i32>().ok_or_else(mismatch)?
+            .iter()
+            .map(|v| v.map_or(Ok(Value::Null), |text| parse_text(data_type, text)))
+            .collect::<Result<_>>()?,
+    };
+    Ok(values)
+}
+
+/// Parse the text form of a value of a type without a native Arrow type
+fn parse_text(data_type: &DataType, text: &str) -> Result<Value> {
+    let value = match data_type {
+        DataType::String => Value::String(text.to_string()),
+        DataType::IPAddress => Value::IPAddress(text.parse()?),
+        DataType::IPv4 => Value::IPv4(text.parse()?),
+        DataType::IPv6 => Value::IPv6(text.parse()?),
+        DataType::UUID => Value::UUID(text.parse()?),
+        DataType::Json => Value::Json(serde_json::from_str(text)?),
+        DataType::StringArray => Value::StringArray(serde_json::from_str(text)?),
+        DataType::Int64Array => Value::Int64Array(serde_json::from_str(text)?),
+        DataType::BytesArray => {
+            let elements: Vec<String> = serde_json::from_str(text)?;
+            Value::BytesArray(elements.iter().map(hex::decode).collect::<Result<_, _>>()?)
+        }
+        DataType::Decimal => Value::Decimal(text.to_string()),
+        DataType::BigInt => Value::BigInt(text.to_string()),
+        DataType::SubContentID => {
+            let index = text.strip_prefix("subcontent:")
+                .and_then(|index| index.parse().ok())
+                .ok_or_else(|| anyhow::anyhow!("'{}' is not a sub-content reference", text))?;
+            Value::SubContentID(index)
+        }
+        _ => anyhow::bail!("{:?} values can't be read from Arrow text", data_type),
+    };
+    Ok(value)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+    use std::sync::Arc;
+    use arrow::array::{Int64Array, StringArray};
+    use arrow::datatypes::{DataType as ArrowType, Field, Schema};
+    use arrow::ipc::writer::StreamWriter;
+    use arrow::record_batch::RecordBatch;
+
+    fn field(name: &str, arrow_type: ArrowType, data_type: &str) -> Field {
+        Field::new(name, arrow_type, true)
+            .with_metadata(HashMap::from([(DATA_TYPE_KEY.to_string(), data_type.to_string())]))
+    }
+
+    #[test]
+    fn test_text_forms() {
+        assert_eq!(parse_text(&DataType::IPv4, "10.0.0.1").unwrap(), Value::IPv4("10.0.0.1".parse().unwrap()));
+        assert_eq!(parse_text(&DataType::Int64Array, "[1,-2]").unwrap(), Value::Int64Array(vec![1, -2]));
+        assert_eq!(parse_text(&DataType::BytesArray, "[\"00ff\"]").unwrap(), Value::BytesArray(vec![vec![0, 255]]));
+        assert_eq!(parse_text(&DataType::SubContentID, "subcontent:3").unwrap(), Value::SubContentID(3));
+        assert!(parse_text(&DataType::SubContentID, "3").is_err());
+        assert!(parse_text(&DataType::UUID, "not-a-uuid").is_err());
+    }
+
+    #[test]
+    fn test_stream_rows() {
+        let schema = Arc::new(Schema::new(vec![
+            field("size", ArrowType::Int64, "Int64"),
+            field("addr", ArrowType::Utf8, "IPAddress"),
+        ]).with_metadata(HashMap::from([(TABLE_KEY.to_string(), "hosts".to_string())])));
+        let batch = RecordBatch::try_new(schema.clone(), vec![
+            Arc::new(Int64Array::from(vec![Some(7), None])),
+            Arc::new(StringArray::from(vec![Some("::1"), Some("10.0.0.1")])),
+        ]).unwrap();
+        let mut data = Vec::new();
+        let mut writer = StreamWriter::try_new(&mut data, &schema).unwrap();
+        writer.write(&batch).unwrap();
+        writer.finish().unwrap();
+        drop(writer);
+
+        let rows = read_rows(&data).unwrap();
+        assert_eq!(rows.len(), 2);
+        assert_eq!(rows[0].table_name, "hosts");
+        assert_eq!(rows[0].values, vec![Value::Int64(7), Value::IPAddress("::1".parse().unwrap())]);
+        assert_eq!(rows[1].values, vec![Value::Null, Value::IPAddress("10.0.0.1".parse().unwrap())]);
+    }
+
+    #[test]
+    fn test_mismatched_type_is_rejected() {
+        let schema = Arc::new(Schema::new(vec![field("size", ArrowType::Utf8, "Int64")])
+            .with_metadata(HashMap::from([(TABLE_KEY.to_string(), "t".to_string())])));
+        let batch = RecordBatch::try_new(schema.clone(), vec![Arc::new(StringArray::from(vec!["7"]))]).unwrap();
+        let mut data = Vec::new();
+        let mut writer = StreamWriter::try_new(&mut data, &schema).unwrap();
+        writer.write(&batch).unwrap();
+        writer.finish().unwrap();
+        drop(writer);
+
+        assert!(read_rows(&data).is_err());
+        assert!(read_rows(b"not arrow").is_err());
+    }
+}
@@ -1,3 +1,5 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
 use uuid::Uuid;
 use crate::bindings_types::{Value, TableSchema};
 use crate::shared_buffer::SharedBuffer;
@@ -8,6 +10,13 @@ pub struct ProcessingContext {
     pub subcontent: Vec<SubContentEmission>,
     pub metadata: Vec<MetadataRow>,
     pub table_schemas: Vec<TableSchema>,
+    /// How thoroughly the module examined the content, if it said
+    pub scan_status: Option<ScanStatus>,
+    /// Aggregates the module computed over its rows
+    pub aggregates: Vec<Aggregate>,
+    /// Scalar facts the module recorded about the content, in the order
+    /// their keys were first set
+    pub attributes: Vec<Attribute>,
     /// Captured stdout from module (None if empty)
     pub stdout: Option<String>,
     /// Captured stderr from module (None if empty)
@@ -16,6 +25,10 @@ pub struct ProcessingContext {
     pub stdout_truncated: bool,
     /// Whether stderr was truncated due to size limit
     pub stderr_truncated: bool,
+    /// Messages the module logged, up to MAX_LOG_MESSAGES
+    pub log: Vec<LogMessage>,
+    /// Whether messages were dropped because of MAX_LOG_MESSAGES
+    pub log_truncated: bool,
 }
 
 impl ProcessingContext {
@@ -26,10 +39,15 @@ impl ProcessingContext {
             subcontent: Vec::new(),
             metadata: Vec::new(),
             table_schemas: Vec::new(),
+            scan_status: None,
+            aggregates: Vec::new(),
+            attributes: Vec::new(),
             stdout: None,
             stderr: None,
             stdout_truncated: false,
             stderr_truncated: false,
+            log: Vec::new(),
+            log_truncated: false,
         }
     }
 
@@ -37,16 +55,176 @@ impl ProcessingContext {
         self.subcontent.clear();
         self.metadata.clear();
         self.table_schemas.clear();
+        self.scan_status = None;
+        self.aggregates.clear();
+        self.attributes.clear();
         self.stdout = None;
         self.stderr = None;
         self.stdout_truncated = false;
         self.stderr_truncated = false;
+        self.log.clear();
+        self.log_truncated = false;
+    }
+
+    /// Keep a logged message, shortening it to MAX_LOG_MESSAGE_BYTES and
+    /// dropping it once MAX_LOG_MESSAGES have been kept
+    pub fn push_log(&mut self, mut entry: LogMessage) {
+        if self.log.len() >= MAX_LOG_MESSAGES {
+            self.log_truncated = true;
+            return;
+        }
+        if entry.message.len() > MAX_LOG_MESSAGE_BYTES {
+            let mut end = MAX_LOG_MESSAGE_BYTES;
+            while !entry.message.is_char_boundary(end) {
+                end -= 1;
+            }
+            entry.message.truncate(end);
+        }
+        self.log.push(entry);
+    }
+}
+
+/// Messages kept per module run
+pub const MAX_LOG_MESSAGES: usize = 1000;
+
+/// Longest log message kept, longer ones are cut
+pub const MAX_LOG_MESSAGE_BYTES: usize = 4096;
+
+/// A message a module logged, through the `log_message` import or as a line
+/// of /log/messages.jsonl
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct LogMessage {
+    /// "debug", "info", "warn" or "error"
+    pub level: String,
+    pub message: String,
+    pub time: DateTime<Utc>,
+}
+
+impl LogMessage {
+    /// Level name of a `log_message` level number. Numbers outside 0 (debug)
+    /// to 3 (error) are clamped.
+    pub fn level_name(level: i32) -> &'static str {
+        match level {
+            i32::MIN..=0 => "debug",
+            1 => "info",
+            2 => "warn",
+            _ => "error",
+        }
     }
 }
 
 pub struct SubContentEmission {
     pub data: SubContentData,
     pub filename: String,
+    /// Index the guest gave the emission, None for sub-content the host
+    /// emitted on the module's behalf
+    pub index: Option<u64>,
+    /// Content ID the child will get, assigned up front so rows can refer to it
+    pub uuid: Uuid,
+    pub annotations: SubContentAnnotations,
+}
+
+/// What a module said about a sub-content item besides its data, from the
+/// sidecar it was emitted with. Sub-content the host emitted on a module's
+/// behalf has none. Recorded on the child's content document.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct SubContentAnnotations {
+    /// Modules that analyzed the item's ancestors, outermost first, ending
+    /// with the module that emitted it
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub analyzed_by: Vec<String>,
+    /// Free-form labels such as "embedded" or "decrypted", matched by the
+    /// `tag` field of module conditions
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub tags: Vec<String>,
+    /// Modules to run on the item even if their trigger manifests wouldn't
+    /// select it, in order of preference
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub suggested_parsers: Vec<String>,
+    /// How the item relates to its parent, e.g. "attachment" or "resource",
+    /// matched by the `relationship` field of module conditions
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub relationship: Option<String>,
+    /// The parent resource the item was extracted from
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub resource: Option<ResourceRef>,
+    /// The row of the emitting module that describes the item
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub parent_row: Option<ParentRowRef>,
+    /// Encryption and compression state the emitting module already knows
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub flags: Option<ContentFlags>,
+    /// The format version the emitting module detected, e.g. "PDF 1.7",
+    /// matched by the `format_version` field of module conditions
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub format_version: Option<String>,
+    /// How the item was obtained, e.g. "carved" or "decompressed: gzip",
+    /// matched by the `method` field of module conditions
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub method: Option<String>,
+    /// The group of related children the item belongs to
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub collection: Option<CollectionRef>,
+    /// Ranges of the parent the item was reassembled from, in order. The
+    /// host reassembles the item itself if the module sent no data.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub fragments: Vec<FragmentSpan>,
+}
+
+/// A byte range of the parent content
+#[derive(Debug, Clone, Copy, Serialize, Deserialize)]
+pub struct FragmentSpan {
+    pub offset: usize,
+    pub length: usize,
+}
+
+/// A group of related children, such as the frames of a video. IDs are
+/// assigned by the emitting module and unique within one module instance.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CollectionRef {
+    pub id: u64,
+    pub name: String,
+    /// The collection this one is nested in
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub parent_id: Option<u64>,
+}
+
+impl SubContentAnnotations {
+    /// Annotations of sub-content the host extracted itself
+    pub fn with_method(method: String) -> Self {
+        Self { method: Some(method), ..Self::default() }
+    }
+}
+
+/// Encryption and compression state of an item, matched by the `encrypted`,
+/// `compressed` and `compression` fields of module conditions
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ContentFlags {
+    /// The item can't be analyzed without a key
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub encrypted: bool,
+    /// The item is still compressed
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub compressed: bool,
+    /// The compression method, e.g. "deflate" or "lzma"
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub compression: Option<String>,
+}
+
+/// A row the emitting module inserted, by its zero-based position among the
+/// module's rows of the table for the parent content
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ParentRowRef {
+    pub table: String,
+    pub row_index: usize,
+}
+
+/// A resource of the parent content, such as an icon or string table
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ResourceRef {
+    #[serde(rename = "type")]
+    pub resource_type: String,
+    pub id: String,
 }
 
 pub enum SubContentData {
@@ -56,6 +234,38 @@ pub enum SubContentData {
     Slice { offset: usize, length: usize },
 }
 
+/// Scan status a module reported for its content: "complete", "partial" or
+/// "skipped". Modules that report none examined the content completely.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ScanStatus {
+    pub status: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub reason: Option<String>,
+}
+
+/// An aggregate a module computed over a table's rows as it inserted them,
+/// for one group when the aggregate is grouped by a column
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Aggregate {
+    pub table: String,
+    pub column: String,
+    /// "sum", "count", "min", "max" or "count_distinct"
+    pub function: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub group_by: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub group: Option<Value>,
+    pub value: Value,
+}
+
+/// A key-value fact a module recorded about its content without defining a
+/// table, e.g. "zip.entry_count"
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Attribute {
+    pub key: String,
+    pub value: Value,
+}
+
 pub struct MetadataRow {
     pub table_name: String,
     pub values: Vec<Value>,
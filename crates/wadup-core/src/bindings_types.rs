@@ -1,4 +1,6 @@
+use chrono::{DateTime, FixedOffset};
 use serde::{Deserialize, Serialize};
+use std::net::{IpAddr, Ipv4Addr, Ipv6Addr};
 
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq, Eq)]
 pub enum DataType {
@@ -6,24 +8,236 @@ pub enum DataType {
     Float64,
     String,
     Boolean,
+    /// Binary data, base64-encoded on the wire
+    Bytes,
+    /// Binary data the guest streamed to a side file, stored as Bytes
+    BytesRef,
+    /// Point in time as RFC 3339 text, keeping its offset from UTC
+    Timestamp,
+    /// IPv4 or IPv6 address in canonical text form
+    IPAddress,
+    /// Nested document (object, array or scalar) embedded as JSON
+    Json,
+    /// List of strings
+    StringArray,
+    /// List of 64-bit signed integers
+    Int64Array,
+    /// List of binary values, each base64-encoded on the wire
+    BytesArray,
+    /// Exact decimal number carried as base-10 text, such as "-12.50"
+    Decimal,
+    /// Integer of arbitrary size carried as base-10 text
+    BigInt,
+    /// Length of time in integer nanoseconds
+    Duration,
+    /// IPv4 address in dotted decimal form
+    IPv4,
+    /// IPv6 address in canonical text form
+    IPv6,
+    /// 128-bit identifier in lowercase hyphenated form
+    UUID,
+    /// Content ID of a sub-content item of the row's content
+    SubContentID,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct Column {
     pub name: String,
     pub data_type: DataType,
+    /// Column may hold NULL values
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub nullable: bool,
+    /// Column is part of the table's primary key
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub primary_key: bool,
+    /// Column values must be unique within the table
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub unique: bool,
+    /// String column with few distinct values, sent by the guest as codes
+    /// into a per-file dictionary and decoded before the rows are stored
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub dictionary: bool,
 }
 
 #[derive(Debug, Clone)]
 pub struct TableSchema {
     pub name: String,
     pub columns: Vec<Column>,
+    /// Whether rows get the host's provenance fields
+    pub provenance: bool,
+    /// Shared by several modules, never prefixed with the module name
+    pub shared: bool,
 }
 
-#[derive(Debug, Clone, Serialize, Deserialize)]
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
 pub enum Value {
+    /// NULL, only in nullable columns; encoded as the bare tag "Null"
+    Null,
     Int64(i64),
     Float64(f64),
     String(String),
     Boolean(bool),
+    Bytes(#[serde(with = "base64_bytes")] Vec<u8>),
+    /// Side file under /metadata holding a streamed binary value, replaced
+    /// with its contents as Bytes when the metadata file is ingested
+    BytesRef { path: String, size: u64 },
+    Timestamp(DateTime<FixedOffset>),
+    IPAddress(IpAddr),
+    Json(serde_json::Value),
+    StringArray(Vec<String>),
+    Int64Array(Vec<i64>),
+    BytesArray(#[serde(with = "base64_bytes_list")] Vec<Vec<u8>>),
+    Decimal(String),
+    BigInt(String),
+    Duration(i64),
+    IPv4(Ipv4Addr),
+    IPv6(Ipv6Addr),
+    UUID(uuid::Uuid),
+    /// Guest index of an emitted sub-content item, resolved to the child's
+    /// content ID before the row is stored
+    SubContentID(u64),
+}
+
+/// Serde helpers for binary values, which guests send as base64 text
+mod base64_bytes {
+    use base64::engine::general_purpose::STANDARD;
+    use base64::Engine;
+    use serde::{Deserialize, Deserializer, Serializer};
+
+    pub fn serialize<S: Serializer>(bytes: &[u8], serializer: S) -> Result<S::Ok, S::Error> {
+        serializer.serialize_str(&STANDARD.encode(bytes))
+    }
+
+    pub fn deserialize<'de, D: Deserializer<'de>>(deserializer: D) -> Result<Vec<u8>, D::Error> {
+        let text = String::deserialize(deserializer)?;
+        STANDARD.decode(text).map_err(serde::de::Error::custom)
+    }
+}
+
+/// Serde helpers for lists of binary values, each sent as base64 text
+mod base64_bytes_list {
+    use base64::engine::general_purpose::STANDARD;
+    use base64::Engine;
+    use serde::ser::SerializeSeq;
+    use serde::{Deserialize, Deserializer, Serializer};
+
+    pub fn serialize<S: Serializer>(elems: &[Vec<u8>], serializer: S) -> Result<S::Ok, S::Error> {
+        let mut seq = serializer.serialize_seq(Some(elems.len()))?;
+        for bytes in elems {
+            seq.serialize_element(&STANDARD.encode(bytes))?;
+        }
+        seq.end()
+    }
+
+    pub fn deserialize<'de, D: Deserializer<'de>>(deserializer: D) -> Result<Vec<Vec<u8>>, D::Error> {
+        Vec::<String>::deserialize(deserializer)?
+            .into_iter()
+            .map(|text| STANDARD.decode(text).map_err(serde::de::Error::custom))
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Values as the Go guest encodes them in /metadata/*.json
+    fn round_trip(json: &str) -> Value {
+        let value: Value = serde_json::from_str(json).unwrap();
+        let encoded = serde_json::to_string(&value).unwrap();
+        let decoded: Value = serde_json::from_str(&encoded).unwrap();
+        assert_eq!(decoded, value, "{} re-encoded as {}", json, encoded);
+        value
+    }
+
+    #[test]
+    fn test_scalar_values_round_trip() {
+        assert_eq!(round_trip(r#""Null""#), Value::Null);
+        assert_eq!(round_trip(r#"{"Int64":-42}"#), Value::Int64(-42));
+        assert_eq!(round_trip(r#"{"String":"pe"}"#), Value::String("pe".to_string()));
+        assert_eq!(round_trip(r#"{"SubContentID":3}"#), Value::SubContentID(3));
+    }
+
+    #[test]
+    fn test_bytes_round_trip_as_base64() {
+        assert_eq!(round_trip(r#"{"Bytes":"AAH/"}"#), Value::Bytes(vec![0x00, 0x01, 0xff]));
+        assert_eq!(round_trip(r#"{"Bytes":""}"#), Value::Bytes(Vec::new()));
+        assert_eq!(serde_json::to_string(&Value::Bytes(b"MZ".to_vec())).unwrap(), r#"{"Bytes":"TVo="}"#);
+        assert!(serde_json::from_str::<Value>(r#"{"Bytes":"not base64!"}"#).is_err());
+    }
+
+    #[test]
+    fn test_bytes_ref_round_trip() {
+        let value = round_trip(r#"{"BytesRef":{"path":"/metadata/blob_0.bin","size":12}}"#);
+        assert_eq!(value, Value::BytesRef { path: "/metadata/blob_0.bin".to_string(), size: 12 });
+    }
+
+    #[test]
+    fn test_timestamp_round_trip_keeps_offset() {
+        let Value::Timestamp(utc) = round_trip(r#"{"Timestamp":"2024-03-01T08:30:00.25Z"}"#) else {
+            panic!("not a timestamp");
+        };
+        assert_eq!(utc.offset().local_minus_utc(), 0);
+
+        let Value::Timestamp(local) = round_trip(r#"{"Timestamp":"2024-03-01T09:30:00+01:00"}"#) else {
+            panic!("not a timestamp");
+        };
+        assert_eq!(local.offset().local_minus_utc(), 3600);
+        assert_eq!(local.timestamp(), utc.timestamp());
+        assert!(serde_json::from_str::<Value>(r#"{"Timestamp":"yesterday"}"#).is_err());
+    }
+
+    #[test]
+    fn test_ip_address_round_trip() {
+        assert_eq!(round_trip(r#"{"IPAddress":"192.0.2.1"}"#), Value::IPAddress("192.0.2.1".parse().unwrap()));
+        assert_eq!(round_trip(r#"{"IPAddress":"2001:db8::1"}"#), Value::IPAddress("2001:db8::1".parse().unwrap()));
+        assert!(serde_json::from_str::<Value>(r#"{"IPAddress":"300.1.1.1"}"#).is_err());
+    }
+
+    #[test]
+    fn test_json_round_trip() {
+        let value = round_trip(r#"{"Json":{"headers":{"From":"a@example.com"},"parts":[1,2]}}"#);
+        assert_eq!(value, Value::Json(serde_json::json!({"headers": {"From": "a@example.com"}, "parts": [1, 2]})));
+        assert_eq!(round_trip(r#"{"Json":null}"#), Value::Json(serde_json::Value::Null));
+    }
+
+    #[test]
+    fn test_arrays_round_trip() {
+        assert_eq!(
+            round_trip(r#"{"StringArray":["a","b"]}"#),
+            Value::StringArray(vec!["a".to_string(), "b".to_string()]),
+        );
+        assert_eq!(round_trip(r#"{"Int64Array":[]}"#), Value::Int64Array(Vec::new()));
+        assert_eq!(
+            round_trip(r#"{"BytesArray":["TVo=",""]}"#),
+            Value::BytesArray(vec![b"MZ".to_vec(), Vec::new()]),
+        );
+    }
+
+    #[test]
+    fn test_decimal_and_big_int_round_trip_as_text() {
+        assert_eq!(round_trip(r#"{"Decimal":"-12.50"}"#), Value::Decimal("-12.50".to_string()));
+        assert_eq!(
+            round_trip(r#"{"BigInt":"340282366920938463463374607431768211455"}"#),
+            Value::BigInt("340282366920938463463374607431768211455".to_string()),
+        );
+    }
+
+    #[test]
+    fn test_duration_round_trip_as_nanoseconds() {
+        assert_eq!(round_trip(r#"{"Duration":1500000000}"#), Value::Duration(1_500_000_000));
+        assert_eq!(round_trip(r#"{"Duration":-1}"#), Value::Duration(-1));
+    }
+
+    #[test]
+    fn test_address_and_uuid_round_trip() {
+        assert_eq!(round_trip(r#"{"IPv4":"10.0.0.1"}"#), Value::IPv4(Ipv4Addr::new(10, 0, 0, 1)));
+        assert_eq!(round_trip(r#"{"IPv6":"::ffff:10.0.0.1"}"#), Value::IPv6(Ipv4Addr::new(10, 0, 0, 1).to_ipv6_mapped()));
+        assert!(serde_json::from_str::<Value>(r#"{"IPv4":"::1"}"#).is_err());
+        assert!(serde_json::from_str::<Value>(r#"{"IPv6":"10.0.0.1"}"#).is_err());
+
+        let id = round_trip(r#"{"UUID":"123e4567-e89b-12d3-a456-426614174000"}"#);
+        assert_eq!(id, Value::UUID(uuid::Uuid::from_u128(0x123e4567_e89b_12d3_a456_426614174000)));
+        assert_eq!(serde_json::to_string(&id).unwrap(), r#"{"UUID":"123e4567-e89b-12d3-a456-426614174000"}"#);
+    }
 }
@@ -0,0 +1,540 @@
+//! Predicates deciding whether a module is invoked on a content item.
+//!
+//! Conditions are evaluated by the host before a module is instantiated for
+//! a content item, e.g.
+//!
+//! ```text
+//! size > 1MB && mime == "application/zip"
+//! parent.module == "email-parser" || extension ~ "eml*"
+//! ```
+//!
+//! Fields are `size` and `depth` (numbers), and `filename`, `extension`,
+//! `mime`, `parent.filename` and `parent.module` (strings, empty for top-level
+//! content). Numbers compare with `==`, `!=`, `<`, `<=`, `>` and `>=` and may
+//! carry a KB, MB or GB suffix. Strings compare with `==`, `!=` and `~`, which
+//! matches a glob. `tag` compares the same way against each tag the emitting
+//! module gave the content: `==` and `~` match if any tag does, `!=` if none
+//! equals the string. `relationship` is how the emitting module related the
+//! content to its parent, e.g. "resource", and is empty if it didn't say.
+//! `encrypted` and `compressed` stand alone and hold if the emitting module
+//! flagged the content so; `compression` is the method it named, if any.
+//! `format_version` is the version it detected, e.g. "PDF 1.7", and `method`
+//! how it obtained the content, e.g. "decompressed: gzip". `collection` is
+//! the name of the group of related children it put the content in.
+//! Comparisons combine with `&&`, `||`, `!` and parentheses.
+
+use crate::bindings_context::ContentFlags;
+use crate::manifest::glob_match;
+use std::fmt;
+use std::str::FromStr;
+
+/// What a condition is evaluated against
+pub struct ConditionTarget<'a> {
+    pub size: u64,
+    pub depth: usize,
+    pub filename: &'a str,
+    pub content_type: &'a str,
+    pub parent_filename: Option<&'a str>,
+    /// Module that emitted the content, None for top-level content
+    pub parent_module: Option<&'a str>,
+    /// Tags the emitting module gave the content
+    pub tags: &'a [String],
+    /// How the emitting module related the content to its parent
+    pub relationship: Option<&'a str>,
+    /// Encryption and compression state the emitting module reported
+    pub flags: Option<&'a ContentFlags>,
+    /// Format version the emitting module detected
+    pub format_version: Option<&'a str>,
+    /// How the emitting module obtained the content
+    pub method: Option<&'a str>,
+    /// Name of the collection the emitting module put the content in
+    pub collection: Option<&'a str>,
+}
+
+/// A parsed condition
+#[derive(Debug, Clone)]
+pub struct Condition {
+    source: String,
+    expr: Expr,
+}
+
+#[derive(Debug, Clone)]
+enum Expr {
+    And(Box<Expr>, Box<Expr>),
+    Or(Box<Expr>, Box<Expr>),
+    Not(Box<Expr>),
+    Flag(FlagField),
+    Number(NumberField, Op, u64),
+    Text(TextField, Op, String),
+}
+
+#[derive(Debug, Clone, Copy)]
+enum NumberField {
+    Size,
+    Depth,
+}
+
+#[derive(Debug, Clone, Copy)]
+enum FlagField {
+    Encrypted,
+    Compressed,
+}
+
+#[derive(Debug, Clone, Copy)]
+enum TextField {
+    Filename,
+    Extension,
+    Mime,
+    ParentFilename,
+    ParentModule,
+    Relationship,
+    Compression,
+    FormatVersion,
+    Method,
+    Collection,
+    Tag,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Op {
+    Eq,
+    Ne,
+    Lt,
+    Le,
+    Gt,
+    Ge,
+    Glob,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Token {
+    Ident(String),
+    Number(u64),
+    Str(String),
+    Op(Op),
+    And,
+    Or,
+    Not,
+    Open,
+    Close,
+}
+
+impl Condition {
+    /// Parse a condition
+    pub fn parse(source: &str) -> anyhow::Result<Self> {
+        let tokens = tokenize(source)
+            .map_err(|e| anyhow::anyhow!("Invalid condition '{}': {}", source, e))?;
+        let mut parser = Parser { tokens, pos: 0 };
+        let expr = parser.or()
+            .and_then(|expr| match parser.tokens.get(parser.pos) {
+                None => Ok(expr),
+                Some(token) => Err(format!("unexpected {:?}", token)),
+            })
+            .map_err(|e| anyhow::anyhow!("Invalid condition '{}': {}", source, e))?;
+        Ok(Self { source: source.to_string(), expr })
+    }
+
+    /// Parse a NAME:CONDITION command-line spec
+    pub fn parse_module_spec(spec: &str) -> anyhow::Result<(String, Self)> {
+        let (name, source) = spec.split_once(':')
+            .ok_or_else(|| anyhow::anyhow!("Invalid module condition '{}': expected NAME:CONDITION", spec))?;
+        Ok((name.to_string(), Self::parse(source)?))
+    }
+
+    /// Whether the module should be invoked for the content
+    pub fn matches(&self, target: &ConditionTarget) -> bool {
+        self.expr.eval(target)
+    }
+}
+
+impl FromStr for Condition {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        Self::parse(s)
+    }
+}
+
+impl fmt::Display for Condition {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(&self.source)
+    }
+}
+
+impl Expr {
+    fn eval(&self, target: &ConditionTarget) -> bool {
+        match self {
+            Expr::And(a, b) => a.eval(target) && b.eval(target),
+            Expr::Or(a, b) => a.eval(target) || b.eval(target),
+            Expr::Not(a) => !a.eval(target),
+            Expr::Flag(field) => target.flags.is_some_and(|flags| match field {
+                FlagField::Encrypted => flags.encrypted,
+                FlagField::Compressed => flags.compressed,
+            }),
+            Expr::Number(field, op, value) => {
+                let actual = match field {
+                    NumberField::Size => target.size,
+                    NumberField::Depth => target.depth as u64,
+                };
+                match op {
+                    Op::Eq => actual == *value,
+                    Op::Ne => actual != *value,
+                    Op::Lt => actual < *value,
+                    Op::Le => actual <= *value,
+                    Op::Gt => actual > *value,
+                    Op::Ge => actual >= *value,
+                    Op::Glob => false,
+                }
+            }
+            Expr::Text(TextField::Tag, op, value) => match op {
+                Op::Eq => target.tags.iter().any(|tag| tag == value),
+                Op::Ne => !target.tags.iter().any(|tag| tag == value),
+                Op::Glob => target.tags.iter().any(|tag| glob_match(value, tag)),
+                _ => false,
+            },
+            Expr::Text(field, op, value) => {
+                let actual = match field {
+                    TextField::Filename => target.filename,
+                    TextField::Extension => target.filename.rsplit_once('.').map_or("", |(_, ext)| ext),
+                    TextField::Mime => target.content_type,
+                    TextField::ParentFilename => target.parent_filename.unwrap_or(""),
+                    TextField::ParentModule => target.parent_module.unwrap_or(""),
+                    TextField::Relationship => target.relationship.unwrap_or(""),
+                    TextField::Compression => target.flags.and_then(|flags| flags.compression.as_deref()).unwrap_or(""),
+                    TextField::FormatVersion => target.format_version.unwrap_or(""),
+                    TextField::Method => target.method.unwrap_or(""),
+                    TextField::Collection => target.collection.unwrap_or(""),
+                    TextField::Tag => "",
+                };
+                match op {
+                    Op::Eq => actual == value,
+                    Op::Ne => actual != value,
+                    Op::Glob => glob_match(value, actual),
+                    _ => false,
+                }
+            }
+        }
+    }
+}
+
+/// Recursive descent parser over the tokens of a condition
+struct Parser {
+    tokens: Vec<Token>,
+    pos: usize,
+}
+
+impl Parser {
+    fn next(&mut self) -> Option<Token> {
+        let token = self.tokens.get(self.pos).cloned();
+        self.pos += 1;
+        token
+    }
+
+    fn eat(&mut self, token: &Token) -> bool {
+        if self.tokens.get(self.pos) == Some(token) {
+            self.pos += 1;
+            true
+        } else {
+            false
+        }
+    }
+
+    fn or(&mut self) -> Result<Expr, String> {
+        let mut expr = self.and()?;
+        while self.eat(&Token::Or) {
+            expr = Expr::Or(Box::new(expr), Box::new(self.and()?));
+        }
+        Ok(expr)
+    }
+
+    fn and(&mut self) -> Result<Expr, String> {
+        let mut expr = self.unary()?;
+        while self.eat(&Token::And) {
+            expr = Expr::And(Box::new(expr), Box::new(self.unary()?));
+        }
+        Ok(expr)
+    }
+
+    fn unary(&mut self) -> Result<Expr, String> {
+        if self.eat(&Token::Not) {
+            return Ok(Expr::Not(Box::new(self.unary()?)));
+        }
+        if self.eat(&Token::Open) {
+            let expr = self.or()?;
+            if !self.eat(&Token::Close) {
+                return Err("missing ')'".to_string());
+            }
+            return Ok(expr);
+        }
+        self.comparison()
+    }
+
+    fn comparison(&mut self) -> Result<Expr, String> {
+        let field = match self.next() {
+            Some(Token::Ident(field)) => field,
+            Some(token) => return Err(format!("expected a field, found {:?}", token)),
+            None => return Err("expected a field".to_string()),
+        };
+
+        let flag = match field.as_str() {
+            "encrypted" => Some(FlagField::Encrypted),
+            "compressed" => Some(FlagField::Compressed),
+            _ => None,
+        };
+        if let Some(flag) = flag {
+            return match self.tokens.get(self.pos) {
+                Some(Token::Op(_)) => Err(format!("'{}' stands alone and can't be compared", field)),
+                _ => Ok(Expr::Flag(flag)),
+            };
+        }
+        let op = match self.next() {
+            Some(Token::Op(op)) => op,
+            _ => return Err(format!("expected an operator after '{}'", field)),
+        };
+        let value = self.next();
+
+        let number = match field.as_str() {
+            "size" => Some(NumberField::Size),
+            "depth" => Some(NumberField::Depth),
+            _ => None,
+        };
+        if let Some(number) = number {
+            return match (op, value) {
+                (Op::Glob, _) => Err(format!("'~' needs a string field, not '{}'", field)),
+                (op, Some(Token::Number(value))) => Ok(Expr::Number(number, op, value)),
+                _ => Err(format!("'{}' must be compared with a number", field)),
+            };
+        }
+
+        let text = match field.as_str() {
+            "filename" => TextField::Filename,
+            "extension" => TextField::Extension,
+            "mime" => TextField::Mime,
+            "parent.filename" => TextField::ParentFilename,
+            "parent.module" => TextField::ParentModule,
+            "relationship" => TextField::Relationship,
+            "compression" => TextField::Compression,
+            "format_version" => TextField::FormatVersion,
+            "method" => TextField::Method,
+            "collection" => TextField::Collection,
+            "tag" => TextField::Tag,
+            _ => return Err(format!("unknown field '{}'", field)),
+        };
+        match (op, value) {
+            (Op::Eq | Op::Ne | Op::Glob, Some(Token::Str(value))) => Ok(Expr::Text(text, op, value)),
+            (Op::Eq | Op::Ne | Op::Glob, _) => Err(format!("'{}' must be compared with a string", field)),
+            _ => Err(format!("'{}' only supports ==, != and ~", field)),
+        }
+    }
+}
+
+fn tokenize(source: &str) -> Result<Vec<Token>, String> {
+    let chars: Vec<char> = source.chars().collect();
+    let mut tokens = Vec::new();
+    let mut i = 0;
+    while i < chars.len() {
+        let c = chars[i];
+        let symbol = match (c, chars.get(i + 1).copied()) {
+            ('(', _) => Some((Token::Open, 1)),
+            (')', _) => Some((Token::Close, 1)),
+            ('~', _) => Some((Token::Op(Op::Glob), 1)),
+            ('&', Some('&')) => Some((Token::And, 2)),
+            ('|', Some('|')) => Some((Token::Or, 2)),
+            ('=', Some('=')) => Some((Token::Op(Op::Eq), 2)),
+            ('!', Some('=')) => Some((Token::Op(Op::Ne), 2)),
+            ('!', _) => Some((Token::Not, 1)),
+            ('<', Some('=')) => Some((Token::Op(Op::Le), 2)),
+            ('>', Some('=')) => Some((Token::Op(Op::Ge), 2)),
+            ('<', _) => Some((Token::Op(Op::Lt), 1)),
+            ('>', _) => Some((Token::Op(Op::Gt), 1)),
+            _ => None,
+        };
+        if let Some((token, len)) = symbol {
+            tokens.push(token);
+            i += len;
+            continue;
+        }
+
+        match c {
+            c if c.is_whitespace() => i += 1,
+            '"' => {
+                let mut value = String::new();
+                i += 1;
+                loop {
+                    match chars.get(i) {
+                        None => return Err("unterminated string".to_string()),
+                        Some('"') => break,
+                        Some('\\') if i + 1 < chars.len() => {
+                            value.push(chars[i + 1]);
+                            i += 2;
+                        }
+                        Some(&c) => {
+                            value.push(c);
+                            i += 1;
+                        }
+                    }
+                }
+                tokens.push(Token::Str(value));
+                i += 1;
+            }
+            c if c.is_ascii_digit() => {
+                let start = i;
+                while i < chars.len() && chars[i].is_ascii_digit() {
+                    i += 1;
+                }
+                let digits: String = chars[start..i].iter().collect();
+                let value: u64 = digits.parse().map_err(|_| format!("number {} is too large", digits))?;
+                let unit_start = i;
+                while i < chars.len() && chars[i].is_ascii_alphabetic() {
+                    i += 1;
+                }
+                let unit: String = chars[unit_start..i].iter().collect();
+                let scale: u64 = match unit.to_ascii_uppercase().as_str() {
+                    "" | "B" => 1,
+                    "KB" => 1 << 10,
+                    "MB" => 1 << 20,
+                    "GB" => 1 << 30,
+                    _ => return Err(format!("unknown unit '{}'", unit)),
+                };
+                tokens.push(Token::Number(value.checked_mul(scale).ok_or_else(|| format!("number {}{} is too large", digits, unit))?));
+            }
+            c if c.is_ascii_alphabetic() || c == '_' => {
+                let start = i;
+                while i < chars.len() && (chars[i].is_ascii_alphanumeric() || chars[i] == '_' || chars[i] == '.') {
+                    i += 1;
+                }
+                let word: String = chars[start..i].iter().collect();
+                tokens.push(match word.as_str() {
+                    "and" => Token::And,
+                    "or" => Token::Or,
+                    "not" => Token::Not,
+                    _ => Token::Ident(word),
+                });
+            }
+            c => return Err(format!("unexpected character '{}'", c)),
+        }
+    }
+    Ok(tokens)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn target(filename: &str) -> ConditionTarget<'_> {
+        ConditionTarget {
+            size: 2 << 20,
+            depth: 1,
+            filename,
+            content_type: "application/zip",
+            parent_filename: Some("mail.eml"),
+            parent_module: Some("email-parser"),
+            tags: &[],
+            relationship: None,
+            flags: None,
+            format_version: None,
+            method: None,
+            collection: None,
+        }
+    }
+
+    fn matches(source: &str, target: &ConditionTarget) -> bool {
+        Condition::parse(source).unwrap().matches(target)
+    }
+
+    #[test]
+    fn test_comparisons() {
+        let t = target("report.zip");
+        assert!(matches("size > 1MB", &t));
+        assert!(matches("size <= 2MB && depth == 1", &t));
+        assert!(!matches("size < 2048KB", &t));
+        assert!(matches(r#"mime == "application/zip""#, &t));
+        assert!(matches(r#"extension ~ "z*""#, &t));
+        assert!(matches(r#"parent.module != "pdf-parser""#, &t));
+        assert!(matches(r#"filename ~ "*.zip" and not depth > 3"#, &t));
+    }
+
+    #[test]
+    fn test_top_level_content_has_empty_parent_fields() {
+        let mut t = target("report.zip");
+        t.parent_filename = None;
+        t.parent_module = None;
+        assert!(matches(r#"parent.module == """#, &t));
+        assert!(!matches(r#"parent.filename ~ "*.eml""#, &t));
+    }
+
+    #[test]
+    fn test_precedence() {
+        let t = target("report.zip");
+        // && binds tighter than ||
+        assert!(matches("depth == 0 && size > 1GB || size > 1MB", &t));
+        assert!(!matches("depth == 0 && (size > 1GB || size > 1MB)", &t));
+        // ! binds tighter than &&
+        assert!(!matches("!depth == 1 && size > 1MB", &t));
+        assert!(matches("!(depth == 0 && size > 1MB)", &t));
+    }
+
+    #[test]
+    fn test_annotation_fields() {
+        let tags = ["decrypted".to_string(), "embedded".to_string()];
+        let flags = ContentFlags { encrypted: true, compressed: false, compression: Some("lzma".to_string()) };
+        let mut t = target("payload.bin");
+        t.tags = &tags;
+        t.relationship = Some("attachment");
+        t.flags = Some(&flags);
+        t.format_version = Some("PDF 1.7");
+        t.collection = Some("sheets");
+        assert!(matches(r#"tag == "embedded""#, &t));
+        assert!(matches(r#"tag ~ "dec*""#, &t));
+        assert!(!matches(r#"tag != "embedded""#, &t));
+        assert!(matches(r#"relationship == "attachment""#, &t));
+        assert!(matches(r#"encrypted && !compressed && compression == "lzma""#, &t));
+        assert!(matches(r#"format_version ~ "PDF 1.*""#, &t));
+        assert!(matches(r#"method == "" && collection == "sheets""#, &t));
+
+        // Content without annotations
+        let t = target("payload.bin");
+        assert!(matches(r#"tag != "embedded""#, &t));
+        assert!(!matches("encrypted || compressed", &t));
+    }
+
+    #[test]
+    fn test_malformed_conditions_are_rejected() {
+        for source in [
+            "",
+            "size >",
+            "size > 1MB &&",
+            "(size > 1MB",
+            "size > 1MB)",
+            "size 1MB",
+            r#"size == "big""#,
+            r#"size ~ "1*""#,
+            "filename == zip",
+            r#"filename < "b""#,
+            r#"filename == "unterminated"#,
+            "size > 1TB",
+            "size > 99999999999999999999",
+            "size > 20000000000GB",
+            "size > 1MB & depth == 1",
+            "encrypted == 1",
+            "size > 1MB; depth == 1",
+        ] {
+            assert!(Condition::parse(source).is_err(), "'{}' should be rejected", source);
+        }
+    }
+
+    #[test]
+    fn test_unknown_fields_are_rejected() {
+        let e = Condition::parse(r#"owner == "root""#).unwrap_err();
+        assert!(e.to_string().contains("unknown field 'owner'"), "{}", e);
+        assert!(Condition::parse(r#"parent.size > 1"#).is_err());
+    }
+
+    #[test]
+    fn test_module_spec() {
+        let (name, condition) = Condition::parse_module_spec("scanner:size < 50MB").unwrap();
+        assert_eq!(name, "scanner");
+        assert_eq!(condition.to_string(), "size < 50MB");
+        assert!(Condition::parse_module_spec("size < 50MB").is_err());
+    }
+}
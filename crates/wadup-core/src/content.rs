@@ -2,15 +2,88 @@ use uuid::Uuid;
 use std::sync::{Arc, RwLock};
 use std::collections::HashMap;
 use anyhow::Result;
+use serde::Serialize;
+use crate::bindings_context::SubContentAnnotations;
 use crate::shared_buffer::SharedBuffer;
 
+/// Where modules find the description of the content they process
+pub const CONTENT_INFO_PATH: &str = "/wadup/content.json";
+
+/// Where modules find the seed for the content they process, as decimal text
+pub const SEED_PATH: &str = "/wadup/seed";
+
+/// Where modules find the modules that analyzed the ancestors of the content
+/// they process, as a JSON array, outermost first. Absent for top-level content.
+pub const ANCESTRY_PATH: &str = "/wadup/ancestry.json";
+
+/// What the host tells a module about the content it processes, written to
+/// CONTENT_INFO_PATH before each run
+#[derive(Debug, Clone, Serialize)]
+pub struct ContentInfo {
+    pub size: u64,
+    pub filename: String,
+    /// MIME type detected from the leading bytes
+    pub mime_type: String,
+    /// Lowercase hex SHA-256 of the content
+    pub sha256: String,
+    pub content_id: Uuid,
+    /// None for top-level content
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub parent_id: Option<Uuid>,
+    /// Modules that analyzed the content's ancestors, outermost first,
+    /// written to ANCESTRY_PATH rather than the description
+    #[serde(skip)]
+    pub ancestry: Vec<String>,
+}
+
+impl ContentInfo {
+    /// Describe content with the given ID, name, parent and data
+    pub fn describe(content_id: Uuid, filename: &str, parent_id: Option<Uuid>, data: &[u8]) -> Self {
+        Self {
+            size: data.len() as u64,
+            filename: filename.to_string(),
+            mime_type: crate::magic::detect_content_type(data).to_string(),
+            sha256: crate::state::content_hash(data),
+            content_id,
+            parent_id,
+            ancestry: Vec::new(),
+        }
+    }
+
+    /// Set the modules that analyzed the content's ancestors
+    pub fn with_ancestry(mut self, ancestry: &[String]) -> Self {
+        self.ancestry = ancestry.to_vec();
+        self
+    }
+
+    /// Seed for modules' random number generators, taken from the content
+    /// hash so identical content gets the same seed in every run
+    pub fn seed(&self) -> i64 {
+        self.sha256.get(..16)
+            .and_then(|prefix| u64::from_str_radix(prefix, 16).ok())
+            .map_or(0, |seed| seed as i64)
+    }
+}
+
 #[derive(Debug, Clone)]
 pub struct Content {
     pub uuid: Uuid,
     pub data: ContentData,
     pub filename: String,
     pub parent_uuid: Option<Uuid>,
+    /// Filename of the parent content, used to match module manifests
+    pub parent_filename: Option<String>,
+    /// Module that emitted the content, None for top-level content
+    pub parent_module: Option<String>,
     pub depth: usize,
+    /// UUID of the root content this item was extracted from
+    pub root_uuid: Uuid,
+    /// SHA-256 digests of every ancestor, root first, used to detect cycles
+    pub ancestors: Arc<Vec<String>>,
+    /// Modules that analyzed the content's ancestors, outermost first
+    pub ancestry: Arc<Vec<String>>,
+    /// What the emitting module said about the content
+    pub annotations: Arc<SubContentAnnotations>,
 }
 
 #[derive(Debug, Clone)]
@@ -25,17 +98,31 @@ pub enum ContentData {
 
 impl Content {
     pub fn new_root(buffer: SharedBuffer, filename: String) -> Self {
+        let uuid = Uuid::new_v4();
         Self {
-            uuid: Uuid::new_v4(),
+            uuid,
             data: ContentData::Owned(buffer),
             filename,
             parent_uuid: None,
+            parent_filename: None,
+            parent_module: None,
             depth: 0,
+            root_uuid: uuid,
+            ancestors: Arc::new(Vec::new()),
+            ancestry: Arc::new(Vec::new()),
+            annotations: Arc::default(),
         }
     }
 
+    /// Create sub-content that `module` emitted from `parent`, whose data has
+    /// the given SHA-256 digest, with a content ID assigned when it was emitted.
+    ///
+    /// Fails if the depth limit is reached.
     pub fn new_subcontent(
+        uuid: Uuid,
         parent: &Content,
+        parent_sha256: &str,
+        module: &str,
         data: ContentData,
         filename: String,
         max_depth: usize,
@@ -44,14 +131,34 @@ impl Content {
             anyhow::bail!("Max recursion depth exceeded (limit: {})", max_depth);
         }
 
+        let mut ancestors = Vec::with_capacity(parent.ancestors.len() + 1);
+        ancestors.extend_from_slice(&parent.ancestors);
+        ancestors.push(parent_sha256.to_string());
+
+        let mut ancestry = Vec::with_capacity(parent.ancestry.len() + 1);
+        ancestry.extend_from_slice(&parent.ancestry);
+        ancestry.push(module.to_string());
+
         Ok(Self {
-            uuid: Uuid::new_v4(),
+            uuid,
             data,
             filename,
             parent_uuid: Some(parent.uuid),
+            parent_filename: Some(parent.filename.clone()),
+            parent_module: Some(module.to_string()),
             depth: parent.depth + 1,
+            root_uuid: parent.root_uuid,
+            ancestors: Arc::new(ancestors),
+            ancestry: Arc::new(ancestry),
+            annotations: Arc::default(),
         })
     }
+
+    /// Check whether data with this SHA-256 digest is the content itself or
+    /// one of its ancestors, e.g. a zip containing itself
+    pub fn is_cycle(&self, self_sha256: &str, child_sha256: &str) -> bool {
+        child_sha256 == self_sha256 || self.ancestors.iter().any(|a| a == child_sha256)
+    }
 }
 
 pub struct ContentStore {
@@ -96,3 +203,40 @@ impl Clone for ContentStore {
         }
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_seed_follows_content() {
+        let a = ContentInfo::describe(Uuid::new_v4(), "a.bin", None, b"same bytes");
+        let b = ContentInfo::describe(Uuid::new_v4(), "b.bin", Some(Uuid::new_v4()), b"same bytes");
+        let c = ContentInfo::describe(Uuid::new_v4(), "a.bin", None, b"other bytes");
+        assert_eq!(a.seed(), b.seed());
+        assert_ne!(a.seed(), c.seed());
+        // First 8 bytes of SHA-256("")
+        let empty = ContentInfo::describe(Uuid::new_v4(), "empty", None, b"");
+        assert_eq!(empty.seed(), 0xe3b0c44298fc1c14u64 as i64);
+    }
+
+    #[test]
+    fn test_ancestry_follows_emitting_modules() {
+        let root = Content::new_root(SharedBuffer::from_vec(b"root".to_vec()), "root".to_string());
+        assert!(root.ancestry.is_empty());
+        let data = || ContentData::Borrowed { parent_uuid: root.uuid, offset: 0, length: 1 };
+        let child = Content::new_subcontent(Uuid::new_v4(), &root, "1", "zip", data(), "a".to_string(), 10).unwrap();
+        let grandchild = Content::new_subcontent(Uuid::new_v4(), &child, "2", "gzip", data(), "b".to_string(), 10).unwrap();
+        assert_eq!(*grandchild.ancestry, ["zip", "gzip"]);
+    }
+
+    #[test]
+    fn test_cycle_compares_digests() {
+        let root = Content::new_root(SharedBuffer::from_vec(b"root".to_vec()), "root".to_string());
+        let data = || ContentData::Borrowed { parent_uuid: root.uuid, offset: 0, length: 1 };
+        let child = Content::new_subcontent(Uuid::new_v4(), &root, "aa", "zip", data(), "a".to_string(), 10).unwrap();
+        assert!(child.is_cycle("bb", "bb"));
+        assert!(child.is_cycle("bb", "aa"));
+        assert!(!child.is_cycle("bb", "cc"));
+    }
+}
@@ -0,0 +1,62 @@
+use anyhow::Result;
+use std::io::Read;
+
+/// Largest output a single decompression may produce, guarding against
+/// decompression bombs
+pub const MAX_DECOMPRESSED_SIZE: u64 = 1 << 32;
+
+/// Host memory the sub-content decompressed for one module run may take when
+/// the module has no memory limit
+pub const DEFAULT_DECOMPRESS_BUDGET: u64 = 1 << 30;
+
+/// Whether an algorithm name is supported by `decompress`
+pub fn is_supported(algorithm: &str) -> bool {
+    matches!(algorithm, "deflate" | "gzip" | "zlib" | "bzip2" | "xz" | "zstd")
+}
+
+/// Decompress data in the named format. Fails if the data is invalid or the
+/// output would exceed `MAX_DECOMPRESSED_SIZE`.
+pub fn decompress(algorithm: &str, data: &[u8]) -> Result<Vec<u8>> {
+    decompress_limited(algorithm, data, MAX_DECOMPRESSED_SIZE)
+}
+
+/// Decompress like `decompress`, failing as soon as the output would exceed
+/// `limit` bytes (at most `MAX_DECOMPRESSED_SIZE`)
+pub fn decompress_limited(algorithm: &str, data: &[u8], limit: u64) -> Result<Vec<u8>> {
+    let limit = limit.min(MAX_DECOMPRESSED_SIZE);
+    let reader: Box<dyn Read + '_> = match algorithm {
+        "deflate" => Box::new(flate2::read::DeflateDecoder::new(data)),
+        "gzip" => Box::new(flate2::read::MultiGzDecoder::new(data)),
+        "zlib" => Box::new(flate2::read::ZlibDecoder::new(data)),
+        "bzip2" => Box::new(bzip2::read::BzDecoder::new(data)),
+        "xz" => Box::new(xz2::read::XzDecoder::new(data)),
+        "zstd" => Box::new(zstd::stream::read::Decoder::new(data)?),
+        _ => anyhow::bail!("Unsupported compression: {}", algorithm),
+    };
+
+    let mut output = Vec::new();
+    reader.take(limit + 1).read_to_end(&mut output)?;
+    if output.len() as u64 > limit {
+        anyhow::bail!("Decompressed size exceeds limit of {} bytes", limit);
+    }
+    Ok(output)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::Write;
+
+    #[test]
+    fn test_output_is_limited() {
+        let mut encoder = flate2::write::GzEncoder::new(Vec::new(), flate2::Compression::default());
+        encoder.write_all(&[0; 4096]).unwrap();
+        let data = encoder.finish().unwrap();
+
+        assert_eq!(decompress("gzip", &data).unwrap().len(), 4096);
+        assert_eq!(decompress_limited("gzip", &data, 4096).unwrap().len(), 4096);
+        assert!(decompress_limited("gzip", &data, 4095).is_err());
+        assert!(decompress_limited("gzip", &data, 0).is_err());
+        assert!(decompress("lz4", &data).is_err());
+    }
+}
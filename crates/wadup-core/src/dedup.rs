@@ -0,0 +1,70 @@
+use std::collections::{HashMap, VecDeque};
+use std::sync::{Arc, Mutex};
+use uuid::Uuid;
+
+/// Key identifying content data: the hex SHA-256 of its bytes
+type DedupKey = String;
+
+struct DedupState {
+    /// First content seen for each key
+    originals: HashMap<DedupKey, Uuid>,
+    /// Keys in insertion order, oldest first, for eviction
+    order: VecDeque<DedupKey>,
+}
+
+/// Cache of content already processed, keyed by the SHA-256 of its data.
+///
+/// When the same payload is extracted many times (identical DLLs inside many
+/// installers), only the first occurrence is processed; later occurrences are
+/// linked to it. Once `capacity` entries are tracked the oldest are evicted,
+/// so a duplicate of an evicted payload is processed again.
+pub struct DedupCache {
+    capacity: usize,
+    state: Arc<Mutex<DedupState>>,
+}
+
+impl DedupCache {
+    pub fn new(capacity: usize) -> Self {
+        Self {
+            capacity,
+            state: Arc::new(Mutex::new(DedupState {
+                originals: HashMap::new(),
+                order: VecDeque::new(),
+            })),
+        }
+    }
+
+    /// Record content with the given SHA-256 digest.
+    ///
+    /// Returns the UUID of the content first seen with the same data, or None
+    /// if this is the first occurrence (which is then remembered).
+    pub fn check_or_insert(&self, sha256: &str, uuid: Uuid) -> Option<Uuid> {
+        if self.capacity == 0 {
+            return None;
+        }
+
+        let mut state = self.state.lock().unwrap();
+        if let Some(original) = state.originals.get(sha256) {
+            return Some(*original);
+        }
+
+        let key = sha256.to_string();
+        state.originals.insert(key.clone(), uuid);
+        state.order.push_back(key);
+        while state.order.len() > self.capacity {
+            if let Some(oldest) = state.order.pop_front() {
+                state.originals.remove(&oldest);
+            }
+        }
+        None
+    }
+}
+
+impl Clone for DedupCache {
+    fn clone(&self) -> Self {
+        Self {
+            capacity: self.capacity,
+            state: Arc::clone(&self.state),
+        }
+    }
+}
@@ -0,0 +1,140 @@
+use anyhow::Result;
+use md5::Md5;
+use sha1::Sha1;
+use sha2::{Digest, Sha256, Sha512};
+
+/// Hash data with the named algorithm, returning the digest as text:
+/// lowercase hex, or "blocksize:hash:hash" for ssdeep
+pub fn hash_data(algorithm: &str, data: &[u8]) -> Result<String> {
+    let digest = match algorithm {
+        "md5" => hex::encode(Md5::digest(data)),
+        "sha1" => hex::encode(Sha1::digest(data)),
+        "sha256" => hex::encode(Sha256::digest(data)),
+        "sha512" => hex::encode(Sha512::digest(data)),
+        "ssdeep" => ssdeep(data),
+        _ => anyhow::bail!("Unsupported hash algorithm: {}", algorithm),
+    };
+    Ok(digest)
+}
+
+const ROLLING_WINDOW: usize = 7;
+const MIN_BLOCKSIZE: u32 = 3;
+const HASH_PRIME: u32 = 0x0100_0193;
+const HASH_INIT: u32 = 0x2802_1967;
+const SPAMSUM_LENGTH: usize = 64;
+const B64: &[u8; 64] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/";
+
+/// Rolling hash over the last ROLLING_WINDOW bytes, used to find the
+/// context-triggered piece boundaries
+#[derive(Default)]
+struct RollingHash {
+    window: [u8; ROLLING_WINDOW],
+    h1: u32,
+    h2: u32,
+    h3: u32,
+    n: usize,
+}
+
+impl RollingHash {
+    fn update(&mut self, c: u8) {
+        self.h2 = self.h2.wrapping_sub(self.h1);
+        self.h2 = self.h2.wrapping_add(ROLLING_WINDOW as u32 * c as u32);
+
+        self.h1 = self.h1.wrapping_add(c as u32);
+        self.h1 = self.h1.wrapping_sub(self.window[self.n % ROLLING_WINDOW] as u32);
+
+        self.window[self.n % ROLLING_WINDOW] = c;
+        self.n += 1;
+
+        self.h3 = (self.h3 << 5) ^ c as u32;
+    }
+
+    fn sum(&self) -> u32 {
+        self.h1.wrapping_add(self.h2).wrapping_add(self.h3)
+    }
+}
+
+fn sum_hash(c: u8, h: u32) -> u32 {
+    h.wrapping_mul(HASH_PRIME) ^ c as u32
+}
+
+/// Append a piece hash to a signature of at most max_len characters. Once
+/// full, the last character keeps being replaced; returns whether the piece
+/// hash should be reset.
+fn push_piece(sig: &mut Vec<u8>, max_len: usize, h: u32) -> bool {
+    let c = B64[(h % 64) as usize];
+    if sig.len() < max_len - 1 {
+        sig.push(c);
+        true
+    } else {
+        if sig.len() < max_len {
+            sig.push(c);
+        } else {
+            sig[max_len - 1] = c;
+        }
+        false
+    }
+}
+
+/// Context-triggered piecewise hash, compatible with the ssdeep tool
+pub fn ssdeep(data: &[u8]) -> String {
+    let mut block_size = MIN_BLOCKSIZE;
+    while (block_size as usize) * SPAMSUM_LENGTH < data.len() {
+        block_size *= 2;
+    }
+
+    loop {
+        let mut roll = RollingHash::default();
+        let (mut h1, mut h2) = (HASH_INIT, HASH_INIT);
+        let mut sig1 = Vec::with_capacity(SPAMSUM_LENGTH);
+        let mut sig2 = Vec::with_capacity(SPAMSUM_LENGTH / 2);
+
+        for &c in data {
+            h1 = sum_hash(c, h1);
+            h2 = sum_hash(c, h2);
+            roll.update(c);
+            let rh = roll.sum();
+
+            if rh % block_size == block_size - 1 && push_piece(&mut sig1, SPAMSUM_LENGTH, h1) {
+                h1 = HASH_INIT;
+            }
+            if rh % (block_size * 2) == block_size * 2 - 1
+                && push_piece(&mut sig2, SPAMSUM_LENGTH / 2, h2)
+            {
+                h2 = HASH_INIT;
+            }
+        }
+
+        // Too few pieces means the block size was too large
+        let pieces = sig1.len();
+
+        // The trailing piece is kept unless the input ended on a boundary
+        if roll.sum() != 0 {
+            push_tail(&mut sig1, SPAMSUM_LENGTH, h1);
+            push_tail(&mut sig2, SPAMSUM_LENGTH / 2, h2);
+        }
+
+        if block_size > MIN_BLOCKSIZE && pieces < SPAMSUM_LENGTH / 2 {
+            block_size /= 2;
+            continue;
+        }
+
+        return format!(
+            "{}:{}:{}",
+            block_size,
+            String::from_utf8_lossy(&sig1),
+            String::from_utf8_lossy(&sig2)
+        );
+    }
+}
+
+/// Write the hash of the trailing piece, replacing the last character of a
+/// full signature
+fn push_tail(sig: &mut Vec<u8>, max_len: usize, h: u32) {
+    let c = B64[(h % 64) as usize];
+    if sig.len() < max_len {
+        sig.push(c);
+    } else {
+        sig[max_len - 1] = c;
+    }
+}
@@ -1,5 +1,30 @@
+pub mod archive;
+pub mod arrow_ipc;
+pub mod condition;
 pub mod content;
+pub mod decompress;
+pub mod dedup;
+pub mod hashing;
+pub mod magic;
+pub mod manifest;
+pub mod module_config;
+pub mod naming;
+pub mod limits;
+pub mod progress;
+pub mod query;
+pub mod quota;
+pub mod registry;
+pub mod sampling;
+pub mod scan;
+pub mod schedule;
+pub mod secrets;
+pub mod sink;
+pub mod parquet_sink;
+pub mod sqlite_sink;
+pub mod state;
+pub mod schema;
 pub mod metadata;
+pub mod msgpack;
 pub mod wasm;
 pub mod processor;
 pub mod memory_fs;
@@ -10,8 +35,23 @@ pub mod shared_buffer;
 pub mod precompile;
 pub mod test_output;
 
+pub use condition::*;
 pub use content::*;
+pub use dedup::*;
+pub use limits::*;
+pub use module_config::*;
+pub use naming::*;
+pub use query::*;
+pub use quota::*;
+pub use registry::*;
+pub use sampling::*;
 pub use metadata::*;
+pub use schema::*;
+pub use secrets::*;
+pub use sink::*;
+pub use parquet_sink::*;
+pub use sqlite_sink::*;
+pub use state::*;
 pub use wasm::*;
 pub use processor::*;
 pub use bindings_types::*;
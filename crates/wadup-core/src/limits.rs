@@ -0,0 +1,228 @@
+//! Per-invocation execution limits.
+//!
+//! Fuel and memory are enforced by wasmtime directly. Wall-clock timeouts use
+//! epoch interruption: an `EpochTicker` advances the engine epoch every
+//! `EPOCH_TICK`, and each invocation gets a deadline in ticks.
+
+use serde::Serialize;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::time::Duration;
+use wasmtime::{Engine, Trap};
+
+/// Interval between engine epoch increments
+pub const EPOCH_TICK: Duration = Duration::from_millis(10);
+
+/// Epoch deadline for invocations without a timeout
+const NO_DEADLINE: u64 = u64::MAX / 2;
+
+/// Limits overriding the global ones for a single module
+#[derive(Debug, Clone, Default)]
+pub struct ModuleLimits {
+    pub fuel: Option<u64>,
+    pub max_memory: Option<usize>,
+    pub timeout: Option<Duration>,
+}
+
+impl ModuleLimits {
+    /// Parse a `NAME:key=value,...` override, where keys are `fuel`,
+    /// `max-memory` (bytes) and `timeout` (seconds)
+    pub fn parse_override(spec: &str) -> anyhow::Result<(String, Self)> {
+        let (name, settings) = spec.split_once(':')
+            .ok_or_else(|| anyhow::anyhow!("Invalid module limit '{}': expected NAME:key=value,...", spec))?;
+        if name.is_empty() {
+            anyhow::bail!("Invalid module limit '{}': missing module name", spec);
+        }
+
+        let mut limits = ModuleLimits::default();
+        for setting in settings.split(',') {
+            let (key, value) = setting.split_once('=')
+                .ok_or_else(|| anyhow::anyhow!("Invalid module limit setting '{}': expected key=value", setting))?;
+            let invalid = |e: &dyn std::fmt::Display| anyhow::anyhow!("Invalid value for '{}' in module limit '{}': {}", key, spec, e);
+            match key {
+                "fuel" => limits.fuel = Some(value.parse().map_err(|e| invalid(&e))?),
+                "max-memory" => limits.max_memory = Some(value.parse().map_err(|e| invalid(&e))?),
+                "timeout" => {
+                    let secs: f64 = value.parse().map_err(|e| invalid(&e))?;
+                    limits.timeout = Some(Duration::try_from_secs_f64(secs).map_err(|e| invalid(&e))?);
+                }
+                _ => anyhow::bail!("Unknown module limit '{}' (expected fuel, max-memory or timeout)", key),
+            }
+        }
+        Ok((name.to_string(), limits))
+    }
+}
+
+/// The limit a module invocation ran into
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum LimitKind {
+    Timeout,
+    Fuel,
+    Memory,
+    Stack,
+}
+
+impl LimitKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            LimitKind::Timeout => "timeout",
+            LimitKind::Fuel => "fuel",
+            LimitKind::Memory => "memory",
+            LimitKind::Stack => "stack",
+        }
+    }
+}
+
+/// Error returned when a module invocation is stopped by an execution limit
+#[derive(Debug)]
+pub struct LimitExceeded {
+    pub module: String,
+    pub limit: LimitKind,
+    /// The timeout in effect, for timeouts
+    pub timeout: Option<Duration>,
+}
+
+impl std::fmt::Display for LimitExceeded {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self.limit {
+            LimitKind::Timeout => match self.timeout {
+                Some(timeout) => write!(f, "Module '{}' exceeded time limit ({:?})", self.module, timeout),
+                None => write!(f, "Module '{}' exceeded time limit", self.module),
+            },
+            LimitKind::Fuel => write!(f, "Module '{}' exceeded fuel limit (CPU limit)", self.module),
+            LimitKind::Memory => write!(f, "Module '{}' memory limit exceeded", self.module),
+            LimitKind::Stack => write!(f, "Module '{}' stack overflow", self.module),
+        }
+    }
+}
+
+impl std::error::Error for LimitExceeded {}
+
+/// Determine which limit, if any, stopped a module invocation
+pub fn classify_error(error: &anyhow::Error) -> Option<LimitKind> {
+    match error.downcast_ref::<Trap>() {
+        Some(Trap::Interrupt) => return Some(LimitKind::Timeout),
+        Some(Trap::OutOfFuel) => return Some(LimitKind::Fuel),
+        Some(Trap::StackOverflow) => return Some(LimitKind::Stack),
+        _ => {}
+    }
+
+    let message = error.to_string();
+    if message.contains("fuel") {
+        Some(LimitKind::Fuel)
+    } else if message.contains("stack overflow") {
+        Some(LimitKind::Stack)
+    } else if message.contains("memory") {
+        Some(LimitKind::Memory)
+    } else {
+        None
+    }
+}
+
+/// Epoch deadline, in ticks from now, for an invocation with the given timeout
+pub fn deadline_ticks(timeout: Option<Duration>) -> u64 {
+    match timeout {
+        Some(timeout) => (timeout.as_nanos().div_ceil(EPOCH_TICK.as_nanos()) as u64).max(1),
+        None => NO_DEADLINE,
+    }
+}
+
+/// Background thread advancing an engine's epoch, stopped when dropped
+pub struct EpochTicker {
+    stop: Arc<AtomicBool>,
+}
+
+impl EpochTicker {
+    pub fn start(engine: &Engine) -> Self {
+        let stop = Arc::new(AtomicBool::new(false));
+        let engine = engine.clone();
+        let thread_stop = stop.clone();
+        std::thread::spawn(move || {
+            while !thread_stop.load(Ordering::Relaxed) {
+                std::thread::sleep(EPOCH_TICK);
+                engine.increment_epoch();
+            }
+        });
+        Self { stop }
+    }
+}
+
+impl Drop for EpochTicker {
+    fn drop(&mut self) {
+        self.stop.store(true, Ordering::Relaxed);
+    }
+}
+
+/// Fraction of a timeout, from the end, left to a module between the
+/// cancellation signal and the hard interrupt
+const CANCEL_GRACE_DIVISOR: u64 = 10;
+
+/// Cooperative cancellation state of a module instance.
+///
+/// With a timeout, the epoch deadline is first armed at the soft deadline,
+/// `1 - 1/CANCEL_GRACE_DIVISOR` of the timeout. Reaching it requests
+/// cancellation, which the guest can poll through `wadup.cancelled`, and
+/// re-arms the deadline for the remaining grace period; reaching that
+/// interrupts the module.
+#[derive(Debug, Default)]
+pub struct CancelState {
+    requested: Arc<AtomicBool>,
+    soft_deadline_passed: bool,
+    grace_ticks: u64,
+}
+
+impl CancelState {
+    /// Handle for requesting cancellation from outside the module
+    pub fn handle(&self) -> CancelHandle {
+        CancelHandle(self.requested.clone())
+    }
+
+    pub fn is_requested(&self) -> bool {
+        self.requested.load(Ordering::Relaxed)
+    }
+
+    /// Reset for a new invocation, returning the epoch deadline to arm
+    pub fn start(&mut self, timeout: Option<Duration>) -> u64 {
+        self.requested.store(false, Ordering::Relaxed);
+        self.restart(timeout)
+    }
+
+    /// Restart the clock without clearing a cancellation request, returning
+    /// the epoch deadline to arm
+    pub fn restart(&mut self, timeout: Option<Duration>) -> u64 {
+        let total = deadline_ticks(timeout);
+        self.soft_deadline_passed = false;
+        if timeout.is_none() {
+            self.grace_ticks = 0;
+            return total;
+        }
+        self.grace_ticks = (total / CANCEL_GRACE_DIVISOR).max(1).min(total);
+        total - self.grace_ticks
+    }
+
+    /// Handle an epoch deadline: request cancellation and return the ticks of
+    /// grace at the soft deadline, None to interrupt the module at the hard one
+    pub fn on_deadline(&mut self) -> Option<u64> {
+        if self.soft_deadline_passed || self.grace_ticks == 0 {
+            return None;
+        }
+        self.soft_deadline_passed = true;
+        self.requested.store(true, Ordering::Relaxed);
+        Some(self.grace_ticks)
+    }
+}
+
+/// Requests cooperative cancellation of a module's current invocation
+#[derive(Debug, Clone)]
+pub struct CancelHandle(Arc<AtomicBool>);
+
+impl CancelHandle {
+    pub fn cancel(&self) {
+        self.0.store(true, Ordering::Relaxed);
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.0.load(Ordering::Relaxed)
+    }
+}
@@ -0,0 +1,77 @@
+/// A file signature: every (offset, bytes) check must match
+struct Signature {
+    checks: &'static [(usize, &'static [u8])],
+    mime: &'static str,
+}
+
+/// Known signatures, most specific first
+const SIGNATURES: &[Signature] = &[
+    Signature { checks: &[(0, b"SQLite format 3\x00")], mime: "application/vnd.sqlite3" },
+    Signature { checks: &[(0, b"\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")], mime: "application/x-ole-storage" },
+    Signature { checks: &[(0, b"\x89PNG\r\n\x1a\n")], mime: "image/png" },
+    Signature { checks: &[(0, b"7z\xbc\xaf\x27\x1c")], mime: "application/x-7z-compressed" },
+    Signature { checks: &[(0, b"\xfd7zXZ\x00")], mime: "application/x-xz" },
+    Signature { checks: &[(0, b"Rar!\x1a\x07")], mime: "application/vnd.rar" },
+    Signature { checks: &[(0, b"GIF87a")], mime: "image/gif" },
+    Signature { checks: &[(0, b"GIF89a")], mime: "image/gif" },
+    Signature { checks: &[(0, b"-----BEGIN ")], mime: "application/x-pem-file" },
+    Signature { checks: &[(0, b"%PDF-")], mime: "application/pdf" },
+    Signature { checks: &[(0, b"{\\rtf")], mime: "application/rtf" },
+    Signature { checks: &[(0, b"<?xml")], mime: "application/xml" },
+    Signature { checks: &[(257, b"ustar")], mime: "application/x-tar" },
+    Signature { checks: &[(0, b"RIFF"), (8, b"WAVE")], mime: "audio/wav" },
+    Signature { checks: &[(0, b"RIFF"), (8, b"WEBP")], mime: "image/webp" },
+    Signature { checks: &[(0, b"RIFF"), (8, b"AVI ")], mime: "video/x-msvideo" },
+    Signature { checks: &[(4, b"ftyp")], mime: "video/mp4" },
+    Signature { checks: &[(0, b"PK\x03\x04")], mime: "application/zip" },
+    Signature { checks: &[(0, b"PK\x05\x06")], mime: "application/zip" },
+    Signature { checks: &[(0, b"\x28\xb5\x2f\xfd")], mime: "application/zstd" },
+    Signature { checks: &[(0, b"\x7fELF")], mime: "application/x-elf" },
+    Signature { checks: &[(0, b"\xcf\xfa\xed\xfe")], mime: "application/x-mach-binary" },
+    Signature { checks: &[(0, b"\xce\xfa\xed\xfe")], mime: "application/x-mach-binary" },
+    Signature { checks: &[(0, b"\x00asm")], mime: "application/wasm" },
+    Signature { checks: &[(0, b"OggS")], mime: "audio/ogg" },
+    Signature { checks: &[(0, b"II*\x00")], mime: "image/tiff" },
+    Signature { checks: &[(0, b"MM\x00*")], mime: "image/tiff" },
+    Signature { checks: &[(0, b"BZh")], mime: "application/x-bzip2" },
+    Signature { checks: &[(0, b"ID3")], mime: "audio/mpeg" },
+    Signature { checks: &[(0, b"\xff\xd8\xff")], mime: "image/jpeg" },
+    Signature { checks: &[(0, b"\x1f\x8b")], mime: "application/gzip" },
+    Signature { checks: &[(0, b"MZ")], mime: "application/vnd.microsoft.portable-executable" },
+    Signature { checks: &[(0, b"BM")], mime: "image/bmp" },
+];
+
+/// Number of leading bytes examined for the text heuristic
+const TEXT_SNIFF_LEN: usize = 512;
+
+/// Detect the MIME type of content from its leading bytes, libmagic style.
+/// Falls back to text/plain for NUL-free UTF-8 and application/octet-stream
+/// otherwise.
+pub fn detect_content_type(data: &[u8]) -> &'static str {
+    if data.is_empty() {
+        return "application/x-empty";
+    }
+
+    for signature in SIGNATURES {
+        let matched = signature.checks.iter().all(|(offset, magic)| {
+            data.get(*offset..offset + magic.len()) == Some(*magic)
+        });
+        if matched {
+            return signature.mime;
+        }
+    }
+
+    let head = &data[..data.len().min(TEXT_SNIFF_LEN)];
+    if !head.contains(&0) && is_utf8_prefix(head) {
+        return "text/plain";
+    }
+    "application/octet-stream"
+}
+
+/// Whether data is valid UTF-8, allowing a character cut off at the end
+fn is_utf8_prefix(data: &[u8]) -> bool {
+    match std::str::from_utf8(data) {
+        Ok(_) => true,
+        Err(e) => e.error_len().is_none(),
+    }
+}
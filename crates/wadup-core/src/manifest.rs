@@ -0,0 +1,191 @@
+use anyhow::Result;
+use serde::Deserialize;
+
+/// Name of the custom WASM section holding a module's manifest
+pub const MANIFEST_SECTION: &str = "wadup.manifest";
+
+/// Declares which content a module handles, so the host can skip modules
+/// that would ignore it anyway.
+///
+/// Content is offered to a module if it is within the size bounds, matches at
+/// least one trigger (magic prefix, extension or MIME pattern) when any are
+/// given, and its parent's filename matches one of the parent globs when any
+/// are given. A module without a manifest sees all content.
+///
+/// `after` and `requires_tables` order the module after the modules whose
+/// results it reads (see `schedule`).
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ModuleManifest {
+    #[serde(default)]
+    pub magic: Vec<MagicRule>,
+    /// Filename extensions without the dot, compared case-insensitively
+    #[serde(default)]
+    pub extensions: Vec<String>,
+    /// MIME type globs, such as "image/*"
+    #[serde(default)]
+    pub mime_types: Vec<String>,
+    /// Globs matched against the filename of the parent content
+    #[serde(default)]
+    pub parent_filenames: Vec<String>,
+    pub min_size: Option<u64>,
+    pub max_size: Option<u64>,
+    /// Module version recorded in row provenance
+    pub version: Option<String>,
+    /// Modules that must finish on a content item before this one runs
+    #[serde(default)]
+    pub after: Vec<String>,
+    /// Tables this module reads, so it runs after the modules writing them
+    #[serde(default)]
+    pub requires_tables: Vec<String>,
+    /// Tables this module writes, for modules that require them
+    #[serde(default)]
+    pub tables: Vec<String>,
+}
+
+/// Bytes expected at an offset of the content
+#[derive(Debug, Clone, Deserialize)]
+pub struct MagicRule {
+    #[serde(default)]
+    pub offset: usize,
+    /// Expected bytes, hex encoded
+    pub hex: String,
+}
+
+/// What a manifest is matched against
+pub struct ManifestTarget<'a> {
+    pub data: &'a [u8],
+    pub filename: &'a str,
+    pub parent_filename: Option<&'a str>,
+    pub content_type: &'a str,
+}
+
+impl ModuleManifest {
+    /// Parse a manifest, checking its magic rules
+    pub fn parse(data: &[u8]) -> Result<Self> {
+        let manifest: ModuleManifest = serde_json::from_slice(data)
+            .map_err(|e| anyhow::anyhow!("Invalid module manifest: {}", e))?;
+        for rule in &manifest.magic {
+            hex::decode(&rule.hex)
+                .map_err(|e| anyhow::anyhow!("Invalid magic '{}' in module manifest: {}", rule.hex, e))?;
+        }
+        Ok(manifest)
+    }
+
+    /// Whether the module should be invoked for the content
+    pub fn matches(&self, target: &ManifestTarget) -> bool {
+        let size = target.data.len() as u64;
+        if self.min_size.is_some_and(|min| size < min) || self.max_size.is_some_and(|max| size > max) {
+            return false;
+        }
+
+        if !self.parent_filenames.is_empty() {
+            let Some(parent) = target.parent_filename else {
+                return false;
+            };
+            if !self.parent_filenames.iter().any(|glob| glob_match(glob, parent)) {
+                return false;
+            }
+        }
+
+        if self.magic.is_empty() && self.extensions.is_empty() && self.mime_types.is_empty() {
+            return true;
+        }
+
+        let magic = self.magic.iter().any(|rule| {
+            let Ok(expected) = hex::decode(&rule.hex) else {
+                return false;
+            };
+            target.data.get(rule.offset..rule.offset + expected.len()) == Some(expected.as_slice())
+        });
+        let extension = target.filename.rsplit_once('.').is_some_and(|(_, ext)| {
+            self.extensions.iter().any(|e| e.trim_start_matches('.').eq_ignore_ascii_case(ext))
+        });
+        let mime = self.mime_types.iter().any(|glob| glob_match(glob, target.content_type));
+
+        magic || extension || mime
+    }
+}
+
+/// Read the manifest from the custom section of a WASM binary, if present
+pub fn read_manifest(wasm: &[u8]) -> Result<Option<ModuleManifest>> {
+    if wasm.len() < 8 || &wasm[..4] != b"\0asm" {
+        anyhow::bail!("Not a WASM binary");
+    }
+
+    let mut pos = 8;
+    while pos < wasm.len() {
+        let id = wasm[pos];
+        pos += 1;
+        let size = read_leb128(wasm, &mut pos)? as usize;
+        let end = pos.checked_add(size)
+            .filter(|&end| end <= wasm.len())
+            .ok_or_else(|| anyhow::anyhow!("Truncated WASM section"))?;
+
+        if id == 0 {
+            let mut name_pos = pos;
+            let name_len = read_leb128(wasm, &mut name_pos)? as usize;
+            let name = wasm.get(name_pos..name_pos + name_len)
+                .ok_or_else(|| anyhow::anyhow!("Truncated WASM custom section name"))?;
+            if name == MANIFEST_SECTION.as_bytes() {
+                return ModuleManifest::parse(&wasm[name_pos + name_len..end]).map(Some);
+            }
+        }
+        pos = end;
+    }
+    Ok(None)
+}
+
+/// Version of a module: the one declared in its manifest, otherwise the
+/// first 12 hex digits of the SHA-256 of the module file
+pub fn module_version(manifest: Option<&ModuleManifest>, wasm: &[u8]) -> String {
+    use sha2::{Digest, Sha256};
+
+    match manifest.and_then(|m| m.version.clone()) {
+        Some(version) => version,
+        None => format!("sha256:{}", &hex::encode(Sha256::digest(wasm))[..12]),
+    }
+}
+
+/// Read an unsigned LEB128 value
+fn read_leb128(data: &[u8], pos: &mut usize) -> Result<u64> {
+    let mut value = 0u64;
+    let mut shift = 0;
+    loop {
+        let byte = *data.get(*pos).ok_or_else(|| anyhow::anyhow!("Truncated LEB128 value"))?;
+        *pos += 1;
+        value |= ((byte & 0x7f) as u64) << shift;
+        if byte & 0x80 == 0 {
+            return Ok(value);
+        }
+        shift += 7;
+        if shift >= 64 {
+            anyhow::bail!("LEB128 value too large");
+        }
+    }
+}
+
+/// Match text against a glob where '*' matches any run of characters and
+/// '?' any single character
+pub fn glob_match(glob: &str, text: &str) -> bool {
+    let glob: Vec<char> = glob.chars().collect();
+    let text: Vec<char> = text.chars().collect();
+    let (mut g, mut t) = (0, 0);
+    let mut backtrack: Option<(usize, usize)> = None;
+
+    while t < text.len() {
+        if g < glob.len() && (glob[g] == '?' || glob[g] == text[t]) {
+            g += 1;
+            t += 1;
+        } else if g < glob.len() && glob[g] == '*' {
+            backtrack = Some((g, t));
+            g += 1;
+        } else if let Some((star, matched)) = backtrack {
+            g = star + 1;
+            t = matched + 1;
+            backtrack = Some((star, matched + 1));
+        } else {
+            return false;
+        }
+    }
+    glob[g..].iter().all(|&c| c == '*')
+}
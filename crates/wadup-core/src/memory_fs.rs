@@ -67,6 +67,11 @@ impl MemoryFile {
         self.len() == 0
     }
 
+    /// Offset the next read or write of this handle starts at
+    pub fn position(&self) -> usize {
+        *self.position.read()
+    }
+
     /// Take ownership of the file data as Bytes (zero-copy for read-write files).
     ///
     /// For ReadWrite files, this freezes the BytesMut into Bytes without copying.
@@ -282,6 +287,22 @@ impl MemoryDirectory {
             .collect()
     }
 
+    /// Total size of the files in this directory and its subdirectories
+    pub fn size(&self) -> usize {
+        self.entries.read()
+            .values()
+            .map(|entry| match entry {
+                Entry::File(file) => file.len(),
+                Entry::Directory(dir) => dir.size(),
+            })
+            .sum()
+    }
+
+    /// Remove every entry of the directory
+    pub fn clear(&self) {
+        self.entries.write().clear();
+    }
+
     pub fn remove(&self, name: &str) -> io::Result<()> {
         let mut entries = self.entries.write();
         entries.remove(name).ok_or_else(|| {
@@ -357,6 +378,19 @@ impl MemoryFilesystem {
         parent_dir.create_file(&filename, data)
     }
 
+    /// Remove the file at a path
+    pub fn remove_file(&self, path: &str) -> io::Result<()> {
+        let (parent_dir, filename) = self.resolve_path(path)?;
+        parent_dir.remove(&filename)
+    }
+
+    /// Create a file, replacing any file already at the path
+    pub fn replace_file(&self, path: &str, data: Vec<u8>) -> io::Result<()> {
+        let (parent_dir, filename) = self.resolve_path(path)?;
+        let _ = parent_dir.remove(&filename);
+        parent_dir.create_file(&filename, data)
+    }
+
     pub fn open_file(&self, path: &str) -> io::Result<MemoryFile> {
         let (parent_dir, filename) = self.resolve_path(path)?;
         parent_dir.get_file(&filename)
@@ -3,7 +3,13 @@ use std::sync::{Arc, Mutex};
 use anyhow::Result;
 use serde::Serialize;
 use chrono::{DateTime, Utc};
-use crate::bindings_types::{TableSchema, Value};
+use uuid::Uuid;
+use crate::bindings_context::{Aggregate, Attribute, LogMessage, ProcessingContext, ScanStatus, SubContentAnnotations};
+use crate::bindings_types::{Column, TableSchema, Value};
+use crate::quota::{QuotaExceeded, QuotaKind};
+use crate::sampling::RowTruncation;
+use crate::schema::{SchemaChange, SchemaRegistry};
+use crate::sink::{MetadataSink, SinkRow};
 
 /// Content metadata document
 #[derive(Debug, Clone, Serialize)]
@@ -15,9 +21,45 @@ pub struct ContentDoc {
     pub processed_at: DateTime<Utc>,
     pub status: String,
     pub error_message: Option<String>,
+    /// UUID of the identical content whose results apply to this one
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub duplicate_of: Option<String>,
+    /// MIME type detected from the content's leading bytes
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub content_type: Option<String>,
+    /// Modules that failed on this content
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub module_errors: Vec<ModuleErrorDoc>,
+    /// Modules that reported how thoroughly they examined this content
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub scan_status: Vec<ModuleScanStatusDoc>,
+    /// What the emitting module said about this sub-content
+    #[serde(flatten)]
+    pub annotations: SubContentAnnotations,
 }
 
-/// Module stdout/stderr output document
+/// A module failure recorded on its content document
+#[derive(Debug, Clone, Serialize)]
+pub struct ModuleErrorDoc {
+    pub module_name: String,
+    /// "timeout", "fuel", "memory" or "stack" for execution limits,
+    /// "schema_conflict" for rejected table definitions, "error" otherwise
+    pub kind: String,
+    pub message: String,
+}
+
+/// A module's scan status recorded on its content document, so content a
+/// module didn't fully examine can be told apart from content without findings
+#[derive(Debug, Clone, Serialize)]
+pub struct ModuleScanStatusDoc {
+    pub module_name: String,
+    /// "complete", "partial" or "skipped"
+    pub status: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub reason: Option<String>,
+}
+
+/// Module stdout/stderr and log output document
 #[derive(Debug, Clone, Serialize)]
 pub struct ModuleOutputDoc {
     pub doc_type: &'static str,
@@ -28,6 +70,76 @@ pub struct ModuleOutputDoc {
     pub stderr: Option<String>,
     pub stdout_truncated: bool,
     pub stderr_truncated: bool,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub log: Vec<LogMessage>,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub log_truncated: bool,
+}
+
+/// Sub-content dropped because it exceeded a quota
+#[derive(Debug, Clone, Serialize)]
+pub struct QuotaEventDoc {
+    pub doc_type: &'static str,
+    pub content_uuid: String,
+    pub module_name: String,
+    pub processed_at: DateTime<Utc>,
+    pub quota: QuotaKind,
+    pub limit: u64,
+    pub filename: String,
+    pub size: u64,
+}
+
+/// Rows of a table dropped by the row cap
+#[derive(Debug, Clone, Serialize)]
+pub struct RowTruncationDoc {
+    pub doc_type: &'static str,
+    pub content_uuid: String,
+    pub module_name: String,
+    pub processed_at: DateTime<Utc>,
+    pub table: String,
+    pub total_rows: usize,
+    pub kept_rows: usize,
+}
+
+/// An aggregate a module computed over a table's rows, with the group and
+/// value stored as text like row columns
+#[derive(Debug, Clone, Serialize)]
+pub struct AggregateDoc {
+    pub doc_type: &'static str,
+    pub content_uuid: String,
+    pub module_name: String,
+    pub processed_at: DateTime<Utc>,
+    pub table: String,
+    pub column: String,
+    pub function: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub group_by: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub group: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub value: Option<String>,
+}
+
+/// The attributes a module recorded about a content item, with values stored
+/// as text like row columns
+#[derive(Debug, Clone, Serialize)]
+pub struct AttributesDoc {
+    pub doc_type: &'static str,
+    pub content_uuid: String,
+    pub module_name: String,
+    pub processed_at: DateTime<Utc>,
+    pub attributes: HashMap<String, String>,
+}
+
+/// A module's definition of a table, recorded when the table is first
+/// defined or extended so that tables without rows still have a schema
+#[derive(Debug, Clone, Serialize)]
+pub struct TableSchemaDoc {
+    pub doc_type: &'static str,
+    pub module_name: String,
+    pub processed_at: DateTime<Utc>,
+    pub table: String,
+    pub columns: Vec<Column>,
 }
 
 /// Table row document with flattened column values
@@ -41,16 +153,74 @@ pub struct RowDoc {
     #[serde(rename = "_table")]
     pub table_name: String,
     pub processed_at: DateTime<Utc>,
+    /// Provenance fields, unless the table or the store opted out
+    #[serde(flatten)]
+    pub provenance: Option<RowProvenance>,
+    /// Content IDs of the sub-content the module linked to this row
+    #[serde(rename = "_children", skip_serializing_if = "Vec::is_empty")]
+    pub children: Vec<String>,
     /// Column values flattened as key-value pairs
     #[serde(flatten)]
     pub columns: HashMap<String, String>,
 }
 
+/// Identity of the content and module a row came from, added by the host so
+/// modules don't have to carry it in their own columns
+#[derive(Debug, Clone, Serialize)]
+pub struct RowProvenance {
+    #[serde(rename = "_content_id")]
+    pub content_id: String,
+    #[serde(rename = "_parent_id", skip_serializing_if = "Option::is_none")]
+    pub parent_id: Option<String>,
+    #[serde(rename = "_module_name")]
+    pub module_name: String,
+    #[serde(rename = "_module_version")]
+    pub module_version: String,
+}
+
 /// Tracking state for content being processed
 struct ContentState {
     filename: String,
     parent_uuid: Option<String>,
+    content_type: Option<String>,
+    module_errors: Vec<ModuleErrorDoc>,
+    scan_status: Vec<ModuleScanStatusDoc>,
     current_module: Option<String>,
+    current_module_version: Option<String>,
+    annotations: SubContentAnnotations,
+}
+
+impl ContentState {
+    fn new(filename: &str, parent_uuid: Option<&str>) -> Self {
+        Self {
+            filename: filename.to_string(),
+            parent_uuid: parent_uuid.map(|s| s.to_string()),
+            content_type: None,
+            module_errors: Vec::new(),
+            scan_status: Vec::new(),
+            current_module: None,
+            current_module_version: None,
+            annotations: SubContentAnnotations::default(),
+        }
+    }
+
+    /// Content document of a finalized content
+    fn into_doc(self, uuid: &str, status: &str, error_message: Option<String>, duplicate_of: Option<String>) -> ContentDoc {
+        ContentDoc {
+            doc_type: "content",
+            content_uuid: uuid.to_string(),
+            filename: self.filename,
+            parent_uuid: self.parent_uuid,
+            processed_at: Utc::now(),
+            status: status.to_string(),
+            error_message,
+            duplicate_of,
+            content_type: self.content_type,
+            module_errors: self.module_errors,
+            scan_status: self.scan_status,
+            annotations: self.annotations,
+        }
+    }
 }
 
 pub struct MetadataStore {
@@ -59,8 +229,27 @@ pub struct MetadataStore {
     client: reqwest::blocking::Client,
     /// Content state tracking, keyed by content UUID
     content_state: Arc<Mutex<HashMap<String, ContentState>>>,
-    /// Table schemas, keyed by table name -> column names
-    table_schemas: Arc<Mutex<HashMap<String, Vec<String>>>>,
+    /// Column layout of each module's tables, keyed by (module name, table name)
+    table_schemas: Arc<Mutex<HashMap<(String, String), TableColumns>>>,
+    /// Table schemas merged across modules, for conflict detection
+    schema_registry: Arc<Mutex<SchemaRegistry>>,
+    /// Whether rows get provenance fields, for tables that don't opt out
+    provenance: bool,
+    /// Whether table names are prefixed with the module name
+    namespace_tables: bool,
+    /// Further destinations for table definitions and rows
+    sinks: Vec<Arc<dyn MetadataSink>>,
+}
+
+/// Column layout of a defined table, used to flatten row values
+#[derive(Debug, Clone)]
+struct TableColumns {
+    /// Name the rows are stored under, after namespacing
+    table_name: String,
+    names: Vec<String>,
+    /// Indices of the primary key columns, in column order
+    primary_key: Vec<usize>,
+    provenance: bool,
 }
 
 impl MetadataStore {
@@ -95,17 +284,54 @@ impl MetadataStore {
             client,
             content_state: Arc::new(Mutex::new(HashMap::new())),
             table_schemas: Arc::new(Mutex::new(HashMap::new())),
+            schema_registry: Arc::new(Mutex::new(SchemaRegistry::new())),
+            provenance: true,
+            namespace_tables: false,
+            sinks: Vec::new(),
         })
     }
 
+    /// Enable or disable provenance fields on row documents. Enabled by
+    /// default; tables can also opt out individually.
+    pub fn with_provenance(mut self, enabled: bool) -> Self {
+        self.provenance = enabled;
+        self
+    }
+
+    /// Prefix table names with the name of the module defining them, as
+    /// "module.table", so modules can't write into each other's tables by
+    /// accident. Shared tables keep their plain name.
+    pub fn with_table_namespaces(mut self, enabled: bool) -> Self {
+        self.namespace_tables = enabled;
+        self
+    }
+
+    /// Also hand table definitions and rows to a sink, such as a ParquetSink
+    /// or SqliteSink.
+    /// The caller finishes the sink once processing is done.
+    pub fn with_sink(mut self, sink: Arc<dyn MetadataSink>) -> Self {
+        self.sinks.push(sink);
+        self
+    }
+
     /// Create a dummy MetadataStore for test mode (no Elasticsearch connection).
     pub fn new_dummy() -> Self {
+        Self::without_elasticsearch()
+    }
+
+    /// Create a MetadataStore that indexes nothing in Elasticsearch, for runs
+    /// whose results only go to sinks such as a SqliteSink
+    pub fn without_elasticsearch() -> Self {
         Self {
             es_url: String::new(),
             es_index: String::new(),
             client: reqwest::blocking::Client::new(),
             content_state: Arc::new(Mutex::new(HashMap::new())),
             table_schemas: Arc::new(Mutex::new(HashMap::new())),
+            schema_registry: Arc::new(Mutex::new(SchemaRegistry::new())),
+            provenance: true,
+            namespace_tables: false,
+            sinks: Vec::new(),
         }
     }
 
@@ -117,90 +343,200 @@ impl MetadataStore {
         parent_uuid: Option<&str>,
     ) -> Result<()> {
         let mut state = self.content_state.lock().unwrap();
-        state.insert(uuid.to_string(), ContentState {
-            filename: filename.to_string(),
-            parent_uuid: parent_uuid.map(|s| s.to_string()),
-            current_module: None,
-        });
+        state.insert(uuid.to_string(), ContentState::new(filename, parent_uuid));
+        Ok(())
+    }
+
+    /// Record the detected MIME type of a content item
+    pub fn set_content_type(&self, uuid: &str, content_type: &str) -> Result<()> {
+        let mut state = self.content_state.lock().unwrap();
+        if let Some(content) = state.get_mut(uuid) {
+            content.content_type = Some(content_type.to_string());
+        }
+        Ok(())
+    }
+
+    /// Record what the emitting module said about a sub-content item
+    pub fn set_annotations(&self, uuid: &str, annotations: &SubContentAnnotations) -> Result<()> {
+        let mut state = self.content_state.lock().unwrap();
+        if let Some(content) = state.get_mut(uuid) {
+            content.annotations = annotations.clone();
+        }
+        Ok(())
+    }
+
+    /// Record that a module failed on a content item
+    pub fn record_module_error(&self, uuid: &str, module_name: &str, kind: &str, message: &str) -> Result<()> {
+        let mut state = self.content_state.lock().unwrap();
+        if let Some(content) = state.get_mut(uuid) {
+            content.module_errors.push(ModuleErrorDoc {
+                module_name: module_name.to_string(),
+                kind: kind.to_string(),
+                message: message.to_string(),
+            });
+        }
+        Ok(())
+    }
+
+    /// Record the scan status a module reported for a content item
+    pub fn record_scan_status(&self, uuid: &str, module_name: &str, status: &ScanStatus) -> Result<()> {
+        let mut state = self.content_state.lock().unwrap();
+        if let Some(content) = state.get_mut(uuid) {
+            content.scan_status.push(ModuleScanStatusDoc {
+                module_name: module_name.to_string(),
+                status: status.status.clone(),
+                reason: status.reason.clone(),
+            });
+        }
         Ok(())
     }
 
     /// Set the current module context for subsequent operations
-    pub fn set_current_module(&self, uuid: &str, module_name: &str) -> Result<()> {
+    pub fn set_current_module(&self, uuid: &str, module_name: &str, module_version: &str) -> Result<()> {
         let mut state = self.content_state.lock().unwrap();
         if let Some(content) = state.get_mut(uuid) {
             content.current_module = Some(module_name.to_string());
+            content.current_module_version = Some(module_version.to_string());
         }
         Ok(())
     }
 
-    /// Define a table schema - stores column names for flattening row values
-    pub fn define_table(&self, schema: TableSchema) -> Result<()> {
-        let column_names: Vec<String> = schema.columns.iter()
-            .map(|c| c.name.clone())
-            .collect();
-        let mut schemas = self.table_schemas.lock().unwrap();
-        schemas.insert(schema.name, column_names);
+    /// Define a table schema for a module - checks it against the definitions
+    /// of other modules, then stores column names for flattening row values
+    /// and hands the merged schema to the sinks if it grew.
+    /// Fails with a SchemaConflict if it can't be merged.
+    pub fn define_table(&self, module_name: &str, mut schema: TableSchema) -> Result<SchemaChange> {
+        let defined_name = schema.name.clone();
+        schema.name = self.stored_table_name(module_name, &schema);
+        let change = self.schema_registry.lock().unwrap().register(module_name, &schema)?;
+
+        let columns = TableColumns {
+            table_name: schema.name,
+            names: schema.columns.iter().map(|c| c.name.clone()).collect(),
+            primary_key: schema.columns.iter().enumerate()
+                .filter(|(_, c)| c.primary_key)
+                .map(|(i, _)| i)
+                .collect(),
+            provenance: schema.provenance,
+        };
+        let table_name = columns.table_name.clone();
+        self.table_schemas.lock().unwrap().insert((module_name.to_string(), defined_name), columns);
+
+        // Sinks get the schema merged across modules whenever it grows
+        if change != SchemaChange::Unchanged && !self.sinks.is_empty() {
+            let merged = self.schema_registry.lock().unwrap().columns(&table_name).unwrap_or_default();
+            for sink in &self.sinks {
+                sink.define_table(&table_name, &merged)?;
+            }
+        }
+        Ok(change)
+    }
+
+    /// Name a module's table is stored under
+    fn stored_table_name(&self, module_name: &str, schema: &TableSchema) -> String {
+        if self.namespace_tables && !schema.shared {
+            format!("{}.{}", module_name, schema.name)
+        } else {
+            schema.name.clone()
+        }
+    }
+
+    /// Record a module's table definition - PUTs a TableSchemaDoc, one per
+    /// module and table, so consumers see every table even without rows
+    pub fn record_table_schema(&self, module_name: &str, schema: &TableSchema) -> Result<()> {
+        let doc = TableSchemaDoc {
+            doc_type: "table_schema",
+            module_name: module_name.to_string(),
+            processed_at: Utc::now(),
+            table: self.stored_table_name(module_name, schema),
+            columns: schema.columns.clone(),
+        };
+
+        let doc_id = format!("table_schema:{}:{}", doc.module_name, doc.table);
+        self.post_document_with_id(&doc, &doc_id)?;
         Ok(())
     }
 
-    /// Insert a row - POSTs a RowDoc immediately with flattened column values
-    pub fn insert_row(&self, table: &str, uuid: &str, values: &[Value]) -> Result<()> {
-        let module_name = {
+    /// Insert a row - POSTs a RowDoc immediately with flattened column values,
+    /// then hands the row to the sinks
+    pub fn insert_row(&self, table: &str, uuid: &str, values: &[Value], children: &[Uuid]) -> Result<()> {
+        let (module_name, module_version, parent_uuid) = {
             let state = self.content_state.lock().unwrap();
-            state.get(uuid)
-                .and_then(|s| s.current_module.clone())
-                .ok_or_else(|| anyhow::anyhow!("No current module set for content {}", uuid))?
+            let content = state.get(uuid)
+                .filter(|s| s.current_module.is_some())
+                .ok_or_else(|| anyhow::anyhow!("No current module set for content {}", uuid))?;
+            (
+                content.current_module.clone().unwrap_or_default(),
+                content.current_module_version.clone().unwrap_or_default(),
+                content.parent_uuid.clone(),
+            )
         };
 
-        // Get column names from schema
-        let column_names = {
+        // Get column layout from schema
+        let schema = {
             let schemas = self.table_schemas.lock().unwrap();
-            schemas.get(table).cloned()
-                .ok_or_else(|| anyhow::anyhow!("No schema defined for table {}", table))?
+            schemas.get(&(module_name.clone(), table.to_string())).cloned()
+                .ok_or_else(|| anyhow::anyhow!("No schema defined for table {} by module {}", table, module_name))?
         };
 
         // Build flattened column map
         let mut columns = HashMap::new();
         for (i, value) in values.iter().enumerate() {
-            if let Some(col_name) = column_names.get(i) {
-                let string_value = match value {
-                    Value::Int64(i) => i.to_string(),
-                    Value::Float64(f) => f.to_string(),
-                    Value::String(s) => s.clone(),
-                    Value::Boolean(b) => b.to_string(),
-                };
-                columns.insert(col_name.clone(), string_value);
+            if let Some(col_name) = schema.names.get(i) {
+                // NULL columns are left out of the document
+                if let Some(string_value) = column_text(value) {
+                    columns.insert(col_name.clone(), string_value);
+                }
             }
         }
 
+        let provenance = (self.provenance && schema.provenance).then(|| RowProvenance {
+            content_id: uuid.to_string(),
+            parent_id: parent_uuid,
+            module_name: module_name.clone(),
+            module_version,
+        });
+
         let doc = RowDoc {
             doc_type: "row",
             content_uuid: uuid.to_string(),
             module_name,
-            table_name: table.to_string(),
+            table_name: schema.table_name.clone(),
             processed_at: Utc::now(),
+            provenance,
+            children: children.iter().map(Uuid::to_string).collect(),
             columns,
         };
 
-        // POST without explicit ID - let ES generate one
-        self.post_document_auto_id(&doc)?;
+        if schema.primary_key.is_empty() {
+            // POST without explicit ID - let ES generate one
+            self.post_document_auto_id(&doc)?;
+        } else {
+            // Derive the ID from the primary key so duplicate rows overwrite
+            // each other instead of being indexed twice
+            let doc_id = row_doc_id(uuid, &doc.module_name, &doc.table_name, &schema, &doc.columns);
+            self.post_document_with_id(&doc, &doc_id)?;
+        }
+
+        let row = SinkRow {
+            table: &schema.table_name,
+            content_uuid: uuid,
+            module_name: &doc.module_name,
+            columns: &schema.names,
+            values,
+        };
+        for sink in &self.sinks {
+            sink.insert_row(&row)?;
+        }
 
         Ok(())
     }
 
-    /// Record module stdout/stderr - POSTs a ModuleOutputDoc immediately
-    pub fn record_module_output(
-        &self,
-        content_uuid: &str,
-        module_name: &str,
-        stdout: Option<&str>,
-        stderr: Option<&str>,
-        stdout_truncated: bool,
-        stderr_truncated: bool,
-    ) -> Result<()> {
+    /// Record module stdout/stderr and logged messages - POSTs a
+    /// ModuleOutputDoc immediately
+    pub fn record_module_output(&self, content_uuid: &str, module_name: &str, ctx: &ProcessingContext) -> Result<()> {
         // Skip if nothing to record
-        if stdout.is_none() && stderr.is_none() {
+        if ctx.stdout.is_none() && ctx.stderr.is_none() && ctx.log.is_empty() {
             return Ok(());
         }
 
@@ -209,10 +545,12 @@ impl MetadataStore {
             content_uuid: content_uuid.to_string(),
             module_name: module_name.to_string(),
             processed_at: Utc::now(),
-            stdout: stdout.map(|s| s.to_string()),
-            stderr: stderr.map(|s| s.to_string()),
-            stdout_truncated,
-            stderr_truncated,
+            stdout: ctx.stdout.clone(),
+            stderr: ctx.stderr.clone(),
+            stdout_truncated: ctx.stdout_truncated,
+            stderr_truncated: ctx.stderr_truncated,
+            log: ctx.log.clone(),
+            log_truncated: ctx.log_truncated,
         };
 
         // Use content_uuid + module_name as ID
@@ -222,59 +560,124 @@ impl MetadataStore {
         Ok(())
     }
 
-    /// Finalize a successful content - POSTs the ContentDoc
-    pub fn finalize_content_success(&self, uuid: &str) -> Result<()> {
-        let (filename, parent_uuid) = {
-            let mut state = self.content_state.lock().unwrap();
-            if let Some(content) = state.remove(uuid) {
-                (content.filename, content.parent_uuid)
-            } else {
-                return Ok(());
-            }
+    /// Record a child dropped by a sub-content quota - POSTs a QuotaEventDoc
+    pub fn record_quota_exceeded(&self, content_uuid: &str, event: &QuotaExceeded) -> Result<()> {
+        let doc = QuotaEventDoc {
+            doc_type: "quota_exceeded",
+            content_uuid: content_uuid.to_string(),
+            module_name: event.module_name.clone(),
+            processed_at: Utc::now(),
+            quota: event.quota,
+            limit: event.limit,
+            filename: event.filename.clone(),
+            size: event.size,
         };
 
-        let doc = ContentDoc {
-            doc_type: "content",
-            content_uuid: uuid.to_string(),
-            filename,
-            parent_uuid,
+        self.post_document_auto_id(&doc)?;
+        Ok(())
+    }
+
+    /// Record that a module's rows for a table were cut down to the row cap
+    pub fn record_rows_truncated(&self, content_uuid: &str, module_name: &str, truncation: &RowTruncation) -> Result<()> {
+        let doc = RowTruncationDoc {
+            doc_type: "rows_truncated",
+            content_uuid: content_uuid.to_string(),
+            module_name: module_name.to_string(),
             processed_at: Utc::now(),
-            status: "success".to_string(),
-            error_message: None,
+            table: truncation.table.clone(),
+            total_rows: truncation.total_rows,
+            kept_rows: truncation.kept_rows,
         };
 
-        self.post_document_with_id(&doc, uuid)?;
+        self.post_document_auto_id(&doc)?;
         Ok(())
     }
 
-    /// Finalize a failed content - POSTs the ContentDoc with error
-    pub fn finalize_content_failure(&self, uuid: &str, error: &str) -> Result<()> {
-        let (filename, parent_uuid) = {
-            let mut state = self.content_state.lock().unwrap();
-            if let Some(content) = state.remove(uuid) {
-                (content.filename, content.parent_uuid)
-            } else {
-                // Content not started, create minimal doc
-                ("unknown".to_string(), None)
-            }
-        };
+    /// Record the aggregates a module computed for a content item - POSTs an
+    /// AggregateDoc per aggregate and group
+    pub fn record_aggregates(&self, content_uuid: &str, module_name: &str, aggregates: &[Aggregate]) -> Result<()> {
+        for aggregate in aggregates {
+            let doc = AggregateDoc {
+                doc_type: "aggregate",
+                content_uuid: content_uuid.to_string(),
+                module_name: module_name.to_string(),
+                processed_at: Utc::now(),
+                table: aggregate.table.clone(),
+                column: aggregate.column.clone(),
+                function: aggregate.function.clone(),
+                group_by: aggregate.group_by.clone(),
+                group: aggregate.group.as_ref().and_then(column_text),
+                value: column_text(&aggregate.value),
+            };
+            self.post_document_auto_id(&doc)?;
+        }
+        Ok(())
+    }
 
-        let doc = ContentDoc {
-            doc_type: "content",
-            content_uuid: uuid.to_string(),
-            filename,
-            parent_uuid,
+    /// Record the attributes a module set on a content item - PUTs one
+    /// AttributesDoc per content and module. NULL attributes are left out.
+    pub fn record_attributes(&self, content_uuid: &str, module_name: &str, attributes: &[Attribute]) -> Result<()> {
+        if attributes.is_empty() {
+            return Ok(());
+        }
+
+        let doc = AttributesDoc {
+            doc_type: "attributes",
+            content_uuid: content_uuid.to_string(),
+            module_name: module_name.to_string(),
             processed_at: Utc::now(),
-            status: "failed".to_string(),
-            error_message: Some(error.to_string()),
+            attributes: attributes.iter()
+                .filter_map(|attribute| Some((attribute.key.clone(), column_text(&attribute.value)?)))
+                .collect(),
         };
 
+        let doc_id = format!("{}_{}_attributes", content_uuid, module_name);
+        self.post_document_with_id(&doc, &doc_id)?;
+        Ok(())
+    }
+
+    /// Stop tracking a content item without recording it, for content an
+    /// earlier run already recorded
+    pub fn discard_content(&self, uuid: &str) {
+        self.content_state.lock().unwrap().remove(uuid);
+    }
+
+    /// Finalize a successful content - POSTs the ContentDoc
+    pub fn finalize_content_success(&self, uuid: &str) -> Result<()> {
+        let Some(content) = self.content_state.lock().unwrap().remove(uuid) else {
+            return Ok(());
+        };
+        let doc = content.into_doc(uuid, "success", None, None);
+        self.post_document_with_id(&doc, uuid)?;
+        Ok(())
+    }
+
+    /// Finalize a content that was skipped as a duplicate - POSTs the
+    /// ContentDoc linking it to the content that was processed
+    pub fn finalize_content_duplicate(&self, uuid: &str, original_uuid: &str) -> Result<()> {
+        let Some(content) = self.content_state.lock().unwrap().remove(uuid) else {
+            return Ok(());
+        };
+        let doc = content.into_doc(uuid, "duplicate", None, Some(original_uuid.to_string()));
+        self.post_document_with_id(&doc, uuid)?;
+        Ok(())
+    }
+
+    /// Finalize a failed content - POSTs the ContentDoc with error
+    pub fn finalize_content_failure(&self, uuid: &str, error: &str) -> Result<()> {
+        // Content not started gets a minimal doc
+        let content = self.content_state.lock().unwrap().remove(uuid)
+            .unwrap_or_else(|| ContentState::new("unknown", None));
+        let doc = content.into_doc(uuid, "failed", Some(error.to_string()), None);
         self.post_document_with_id(&doc, uuid)?;
         Ok(())
     }
 
     /// POST a document with auto-generated ID
     fn post_document_auto_id<T: Serialize>(&self, doc: &T) -> Result<()> {
+        if self.es_url.is_empty() {
+            return Ok(());
+        }
         let url = format!("{}/{}/_doc", self.es_url, self.es_index);
 
         let response = self.client
@@ -294,6 +697,9 @@ impl MetadataStore {
 
     /// POST a document with explicit ID
     fn post_document_with_id<T: Serialize>(&self, doc: &T, id: &str) -> Result<()> {
+        if self.es_url.is_empty() {
+            return Ok(());
+        }
         let url = format!("{}/{}/_doc/{}", self.es_url, self.es_index, id);
 
         let response = self.client
@@ -353,6 +759,133 @@ impl Clone for MetadataStore {
             client: self.client.clone(),
             content_state: Arc::clone(&self.content_state),
             table_schemas: Arc::clone(&self.table_schemas),
+            schema_registry: Arc::clone(&self.schema_registry),
+            provenance: self.provenance,
+            namespace_tables: self.namespace_tables,
+            sinks: self.sinks.clone(),
+        }
+    }
+}
+
+/// Text a value is stored as in a row document's columns, None for NULL
+pub(crate) fn column_text(value: &Value) -> Option<String> {
+    use base64::Engine;
+
+    let text = match value {
+        Value::Null => return None,
+        Value::Int64(i) => i.to_string(),
+        Value::Float64(f) => f.to_string(),
+        Value::String(s) => s.clone(),
+        Value::Boolean(b) => b.to_string(),
+        // Base64, as Elasticsearch binary fields expect
+        Value::Bytes(bytes) => base64::engine::general_purpose::STANDARD.encode(bytes),
+        Value::Timestamp(time) => time.to_rfc3339_opts(chrono::SecondsFormat::AutoSi, true),
+        Value::IPAddress(addr) => addr.to_string(),
+        // Stored as compact JSON text
+        Value::Json(doc) => doc.to_string(),
+        // Arrays are stored as JSON text, binary elements in base64
+        Value::StringArray(elems) => serde_json::to_string(elems).unwrap_or_default(),
+        Value::Int64Array(elems) => serde_json::to_string(elems).unwrap_or_default(),
+        Value::BytesArray(elems) => {
+            let elems: Vec<String> = elems.iter()
+                .map(|bytes| base64::engine::general_purpose::STANDARD.encode(bytes))
+                .collect();
+            serde_json::to_string(&elems).unwrap_or_default()
         }
+        // Kept as text so no precision is lost
+        Value::Decimal(text) | Value::BigInt(text) => text.clone(),
+        Value::Duration(nanos) => nanos.to_string(),
+        Value::IPv4(addr) => addr.to_string(),
+        Value::IPv6(addr) => addr.to_string(),
+        Value::UUID(id) => id.to_string(),
+        // Resolved to content IDs by the processor; an index left here
+        // matched no emitted sub-content
+        Value::SubContentID(_) => String::new(),
+        // Replaced with the side file's bytes on ingest
+        Value::BytesRef { .. } => String::new(),
+    };
+    Some(text)
+}
+
+/// Build a deterministic row document ID from the primary key column values
+fn row_doc_id(
+    uuid: &str,
+    module_name: &str,
+    table: &str,
+    schema: &TableColumns,
+    columns: &HashMap<String, String>,
+) -> String {
+    use sha2::{Digest, Sha256};
+
+    let mut hasher = Sha256::new();
+    for part in [uuid, module_name, table] {
+        hasher.update(part.as_bytes());
+        hasher.update([0u8]);
+    }
+    for &i in &schema.primary_key {
+        let value = schema.names.get(i)
+            .and_then(|name| columns.get(name))
+            .map(String::as_str)
+            .unwrap_or("");
+        hasher.update((value.len() as u64).to_le_bytes());
+        hasher.update(value.as_bytes());
+    }
+    hex::encode(hasher.finalize())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_column_text_leaves_out_null() {
+        assert_eq!(column_text(&Value::Null), None);
+        assert_eq!(column_text(&Value::Int64(-7)).as_deref(), Some("-7"));
+        assert_eq!(column_text(&Value::Boolean(true)).as_deref(), Some("true"));
+    }
+
+    #[test]
+    fn test_column_text_of_bytes_and_timestamps() {
+        assert_eq!(column_text(&Value::Bytes(vec![0x00, 0x01, 0xff])).as_deref(), Some("AAH/"));
+
+        let time = chrono::DateTime::parse_from_rfc3339("2024-03-01T09:30:00.5+01:00").unwrap();
+        assert_eq!(column_text(&Value::Timestamp(time)).as_deref(), Some("2024-03-01T09:30:00.500+01:00"));
+        let time = chrono::DateTime::parse_from_rfc3339("2024-03-01T08:30:00Z").unwrap();
+        assert_eq!(column_text(&Value::Timestamp(time)).as_deref(), Some("2024-03-01T08:30:00Z"));
+    }
+
+    #[test]
+    fn test_column_text_of_json() {
+        let doc = Value::Json(serde_json::json!({"parts": [1, 2]}));
+        assert_eq!(column_text(&doc).as_deref(), Some(r#"{"parts":[1,2]}"#));
+    }
+
+    #[test]
+    fn test_column_text_of_arrays() {
+        let names = Value::StringArray(vec!["a".to_string(), "b\"".to_string()]);
+        assert_eq!(column_text(&names).as_deref(), Some(r#"["a","b\""]"#));
+        assert_eq!(column_text(&Value::Int64Array(vec![1, -2])).as_deref(), Some("[1,-2]"));
+        assert_eq!(column_text(&Value::BytesArray(vec![b"MZ".to_vec()])).as_deref(), Some(r#"["TVo="]"#));
+    }
+
+    #[test]
+    fn test_column_text_of_uuids() {
+        let id = Value::UUID(uuid::Uuid::from_u128(0x123e4567_e89b_12d3_a456_426614174000));
+        assert_eq!(column_text(&id).as_deref(), Some("123e4567-e89b-12d3-a456-426614174000"));
+    }
+
+    #[test]
+    fn test_aggregate_from_sidecar() {
+        let aggregate: Aggregate = serde_json::from_str(
+            r#"{"table":"files","column":"size","function":"max","group_by":"kind","group":{"String":"pe"},"value":{"Int64":42}}"#,
+        ).unwrap();
+        assert_eq!(aggregate.group.as_ref().and_then(column_text).as_deref(), Some("pe"));
+        assert_eq!(column_text(&aggregate.value).as_deref(), Some("42"));
+
+        let aggregate: Aggregate = serde_json::from_str(
+            r#"{"table":"files","column":"size","function":"min","value":"Null"}"#,
+        ).unwrap();
+        assert!(aggregate.group_by.is_none());
+        assert_eq!(column_text(&aggregate.value), None);
     }
 }
@@ -0,0 +1,67 @@
+//! Per-module configuration mounted into each module's filesystem.
+//!
+//! A module's settings are written to `CONFIG_PATH` as a JSON object of
+//! strings, which the Go guest library reads through `wadup.Config()`, so
+//! parsers can be parameterized without rebuilding them.
+
+use anyhow::{Context, Result};
+use std::collections::HashMap;
+use std::path::Path;
+
+/// Where a module finds its configuration
+pub const CONFIG_PATH: &str = "/wadup/config.json";
+
+/// Settings of every module, keyed by module name
+#[derive(Debug, Clone, Default)]
+pub struct ModuleConfig {
+    modules: HashMap<String, HashMap<String, String>>,
+}
+
+impl ModuleConfig {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Load a JSON file of settings by module, e.g.
+    /// `{"strings": {"min_length": "6"}}`. Later settings replace earlier
+    /// ones with the same key.
+    pub fn load_file(&mut self, path: &Path) -> Result<()> {
+        let text = std::fs::read_to_string(path)
+            .with_context(|| format!("Failed to read module config file {:?}", path))?;
+        let modules: HashMap<String, HashMap<String, String>> = serde_json::from_str(&text)
+            .with_context(|| format!("Invalid module config file {:?}", path))?;
+        for (module, settings) in modules {
+            self.modules.entry(module).or_default().extend(settings);
+        }
+        Ok(())
+    }
+
+    /// Add a `NAME:KEY=VALUE` setting given on the command line
+    pub fn add_spec(&mut self, spec: &str) -> Result<()> {
+        let (module, setting) = spec.split_once(':')
+            .ok_or_else(|| anyhow::anyhow!("Invalid module config '{}': expected NAME:KEY=VALUE", spec))?;
+        self.add(module, setting)
+    }
+
+    /// Add a `KEY=VALUE` setting for a module
+    pub fn add(&mut self, module: &str, setting: &str) -> Result<()> {
+        let (key, value) = setting.split_once('=')
+            .ok_or_else(|| anyhow::anyhow!("Invalid module config setting '{}': expected KEY=VALUE", setting))?;
+        if module.is_empty() || key.is_empty() {
+            anyhow::bail!("Invalid module config setting '{}' for module '{}'", setting, module);
+        }
+        self.modules.entry(module.to_string()).or_default()
+            .insert(key.to_string(), value.to_string());
+        Ok(())
+    }
+
+    /// Settings of a module, None if it has none
+    pub fn get(&self, module: &str) -> Option<&HashMap<String, String>> {
+        self.modules.get(module)
+    }
+
+    /// Names of the modules that have settings
+    pub fn modules(&self) -> impl Iterator<Item = &str> {
+        self.modules.keys().map(String::as_str)
+    }
+}
@@ -0,0 +1,181 @@
+//! Decoding of MessagePack metadata files.
+//!
+//! Guests that negotiate the `msgpack_metadata` feature write
+//! /metadata/output_N.msgpack instead of output_N.json, with the same
+//! structure and field names but Bytes values stored raw. The file is decoded
+//! into the equivalent JSON document, with binary values turned into the
+//! base64 text the JSON form carries, so both encodings are read into the
+//! same structures.
+
+use anyhow::Result;
+use base64::engine::general_purpose::STANDARD;
+use base64::Engine;
+use serde_json::{Map, Number, Value};
+
+/// Deepest nesting of arrays and maps accepted, so a hostile file can't
+/// exhaust the stack
+const MAX_DEPTH: usize = 128;
+
+/// Decode a MessagePack document into the equivalent JSON value
+pub fn to_json(data: &[u8]) -> Result<Value> {
+    let mut reader = Reader { data, pos: 0 };
+    let value = reader.value(0)?;
+    if reader.pos != data.len() {
+        anyhow::bail!("{} trailing bytes after MessagePack document", data.len() - reader.pos);
+    }
+    Ok(value)
+}
+
+struct Reader<'a> {
+    data: &'a [u8],
+    pos: usize,
+}
+
+impl<'a> Reader<'a> {
+    fn take(&mut self, n: usize) -> Result<&'a [u8]> {
+        if self.data.len() - self.pos < n {
+            anyhow::bail!("MessagePack document truncated at byte {}", self.pos);
+        }
+        let bytes = &self.data[self.pos..self.pos + n];
+        self.pos += n;
+        Ok(bytes)
+    }
+
+    fn byte(&mut self) -> Result<u8> {
+        Ok(self.take(1)?[0])
+    }
+
+    fn uint(&mut self, width: usize) -> Result<u64> {
+        Ok(self.take(width)?.iter().fold(0, |n, &b| (n << 8) | u64::from(b)))
+    }
+
+    /// Length of a string, binary, array or map
+    fn len(&mut self, width: usize) -> Result<usize> {
+        Ok(self.uint(width)? as usize)
+    }
+
+    fn value(&mut self, depth: usize) -> Result<Value> {
+        if depth > MAX_DEPTH {
+            anyhow::bail!("MessagePack document nested deeper than {} levels", MAX_DEPTH);
+        }
+        let marker = self.byte()?;
+        let value = match marker {
+            0x00..=0x7f => Value::from(marker),
+            0x80..=0x8f => self.map((marker & 0x0f) as usize, depth)?,
+            0x90..=0x9f => self.array((marker & 0x0f) as usize, depth)?,
+            0xa0..=0xbf => self.str((marker & 0x1f) as usize)?,
+            0xc0 => Value::Null,
+            0xc2 => Value::Bool(false),
+            0xc3 => Value::Bool(true),
+            0xc4 => { let n = self.len(1)?; self.bin(n)? }
+            0xc5 => { let n = self.len(2)?; self.bin(n)? }
+            0xc6 => { let n = self.len(4)?; self.bin(n)? }
+            0xca => Self::float(f64::from(f32::from_bits(self.uint(4)? as u32)))?,
+            0xcb => Self::float(f64::from_bits(self.uint(8)?))?,
+            0xcc => Value::from(self.uint(1)?),
+            0xcd => Value::from(self.uint(2)?),
+            0xce => Value::from(self.uint(4)?),
+            0xcf => Value::from(self.uint(8)?),
+            0xd0 => Value::from(self.uint(1)? as u8 as i8),
+            0xd1 => Value::from(self.uint(2)? as u16 as i16),
+            0xd2 => Value::from(self.uint(4)? as u32 as i32),
+            0xd3 => Value::from(self.uint(8)? as i64),
+            0xd9 => { let n = self.len(1)?; self.str(n)? }
+            0xda => { let n = self.len(2)?; self.str(n)? }
+            0xdb => { let n = self.len(4)?; self.str(n)? }
+            0xdc => { let n = self.len(2)?; self.array(n, depth)? }
+            0xdd => { let n = self.len(4)?; self.array(n, depth)? }
+            0xde => { let n = self.len(2)?; self.map(n, depth)? }
+            0xdf => { let n = self.len(4)?; self.map(n, depth)? }
+            0xe0..=0xff => Value::from(marker as i8),
+            _ => anyhow::bail!("Unsupported MessagePack type 0x{:02x} at byte {}", marker, self.pos - 1),
+        };
+        Ok(value)
+    }
+
+    fn str(&mut self, n: usize) -> Result<Value> {
+        let text = std::str::from_utf8(self.take(n)?)
+            .map_err(|e| anyhow::anyhow!("MessagePack string is not valid UTF-8: {}", e))?;
+        Ok(Value::String(text.to_string()))
+    }
+
+    fn bin(&mut self, n: usize) -> Result<Value> {
+        Ok(Value::String(STANDARD.encode(self.take(n)?)))
+    }
+
+    fn float(f: f64) -> Result<Value> {
+        Number::from_f64(f)
+            .map(Value::Number)
+            .ok_or_else(|| anyhow::anyhow!("MessagePack float {} has no JSON equivalent", f))
+    }
+
+    fn array(&mut self, n: usize, depth: usize) -> Result<Value> {
+        // Every element takes at least one byte, which bounds the allocation
+        let mut items = Vec::with_capacity(n.min(self.data.len() - self.pos));
+        for _ in 0..n {
+            items.push(self.value(depth + 1)?);
+        }
+        Ok(Value::Array(items))
+    }
+
+    fn map(&mut self, n: usize, depth: usize) -> Result<Value> {
+        let mut map = Map::new();
+        for _ in 0..n {
+            let Value::String(key) = self.value(depth + 1)? else {
+                anyhow::bail!("MessagePack map key at byte {} is not a string", self.pos);
+            };
+            let value = self.value(depth + 1)?;
+            map.insert(key, value);
+        }
+        Ok(Value::Object(map))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_scalars() {
+        assert_eq!(to_json(&[0x2a]).unwrap(), json!(42));
+        assert_eq!(to_json(&[0xff]).unwrap(), json!(-1));
+        assert_eq!(to_json(&[0xd0, 0x80]).unwrap(), json!(-128));
+        assert_eq!(to_json(&[0xd3, 0x80, 0, 0, 0, 0, 0, 0, 0]).unwrap(), json!(i64::MIN));
+        assert_eq!(to_json(&[0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0]).unwrap(), json!(1.5));
+        assert_eq!(to_json(&[0xc0]).unwrap(), json!(null));
+        assert_eq!(to_json(&[0xc3]).unwrap(), json!(true));
+        assert_eq!(to_json(&[0xa2, b'p', b'e']).unwrap(), json!("pe"));
+    }
+
+    #[test]
+    fn test_binary_becomes_base64() {
+        assert_eq!(to_json(&[0xc4, 0x03, 0x00, 0x01, 0xff]).unwrap(), json!("AAH/"));
+    }
+
+    #[test]
+    fn test_tagged_value_in_map() {
+        // {"values": [{"Int64": 7}, "Null"]}
+        let data = [
+            0x81, 0xa6, b'v', b'a', b'l', b'u', b'e', b's',
+            0x92, 0x81, 0xa5, b'I', b'n', b't', b'6', b'4', 0x07, 0xa4, b'N', b'u', b'l', b'l',
+        ];
+        assert_eq!(to_json(&data).unwrap(), json!({"values": [{"Int64": 7}, "Null"]}));
+    }
+
+    #[test]
+    fn test_malformed_documents_are_rejected() {
+        // Truncated string
+        assert!(to_json(&[0xa3, b'a']).is_err());
+        // Trailing bytes
+        assert!(to_json(&[0x01, 0x02]).is_err());
+        // Non-string map key
+        assert!(to_json(&[0x81, 0x01, 0x02]).is_err());
+        // Extension types aren't used by guests
+        assert!(to_json(&[0xd4, 0x01, 0x00]).is_err());
+        // Array claiming more elements than there are bytes
+        assert!(to_json(&[0xdd, 0xff, 0xff, 0xff, 0xff]).is_err());
+        // Nesting beyond the limit
+        assert!(to_json(&vec![0x91; MAX_DEPTH + 2]).is_err());
+    }
+}
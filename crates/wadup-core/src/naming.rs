@@ -0,0 +1,131 @@
+use std::collections::HashSet;
+use std::str::FromStr;
+
+/// How repeated sub-content filenames are handled
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum CollisionPolicy {
+    /// Keep names as emitted, even if several children share one
+    Keep,
+    /// Insert "_2", "_3", ... before the extension of repeated names
+    #[default]
+    Suffix,
+}
+
+impl FromStr for CollisionPolicy {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "keep" => Ok(Self::Keep),
+            "suffix" => Ok(Self::Suffix),
+            _ => anyhow::bail!("Unknown collision policy '{}' (expected keep or suffix)", s),
+        }
+    }
+}
+
+/// How directory-like paths in sub-content filenames are handled
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum PathPolicy {
+    /// Keep the path, with forward slashes and without empty, "." or ".."
+    /// components
+    #[default]
+    Preserve,
+    /// Keep only the last path component
+    Basename,
+}
+
+impl FromStr for PathPolicy {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "preserve" => Ok(Self::Preserve),
+            "basename" => Ok(Self::Basename),
+            _ => anyhow::bail!("Unknown path policy '{}' (expected preserve or basename)", s),
+        }
+    }
+}
+
+/// Naming policy for sub-content
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct SubcontentNaming {
+    pub collisions: CollisionPolicy,
+    pub paths: PathPolicy,
+}
+
+/// Assigns the final filenames of the sub-content emitted by one module
+/// invocation. Names depend only on the order of emission, not on the
+/// guest's file counters, so the same input always yields the same names.
+#[derive(Debug, Default)]
+pub struct SubcontentNamer {
+    naming: SubcontentNaming,
+    used: HashSet<String>,
+}
+
+impl SubcontentNamer {
+    pub fn new(naming: SubcontentNaming) -> Self {
+        Self { naming, used: HashSet::new() }
+    }
+
+    /// Forget the names of the previous invocation
+    pub fn reset(&mut self) {
+        self.used.clear();
+    }
+
+    /// The filename a child emitted as `requested` is stored under
+    pub fn assign(&mut self, requested: &str) -> String {
+        let normalized = normalize_path(requested);
+        let name = match self.naming.paths {
+            PathPolicy::Preserve => normalized,
+            PathPolicy::Basename => match normalized.rsplit_once('/') {
+                Some((_, base)) => base.to_string(),
+                None => normalized,
+            },
+        };
+
+        if self.naming.collisions == CollisionPolicy::Keep || self.used.insert(name.clone()) {
+            return name;
+        }
+        let mut n = 2;
+        loop {
+            let candidate = with_suffix(&name, n);
+            if self.used.insert(candidate.clone()) {
+                return candidate;
+            }
+            n += 1;
+        }
+    }
+}
+
+/// Normalize an archive member path: backslashes become slashes, and empty,
+/// "." and ".." components are resolved so the path can't climb above its
+/// root. An empty result becomes "unnamed".
+pub fn normalize_path(name: &str) -> String {
+    let name = name.replace('\\', "/");
+    let mut parts: Vec<&str> = Vec::new();
+    for part in name.split('/') {
+        match part {
+            "" | "." => {}
+            ".." => {
+                parts.pop();
+            }
+            _ => parts.push(part),
+        }
+    }
+    if parts.is_empty() {
+        return "unnamed".to_string();
+    }
+    parts.join("/")
+}
+
+/// Insert "_n" before the extension of the last path component
+fn with_suffix(name: &str, n: usize) -> String {
+    let base_start = name.rfind('/').map_or(0, |i| i + 1);
+    match name[base_start..].rfind('.') {
+        Some(dot) if dot > 0 => {
+            let dot = base_start + dot;
+            format!("{}_{}{}", &name[..dot], n, &name[dot..])
+        }
+        _ => format!("{}_{}", name, n),
+    }
+}
@@ -0,0 +1,342 @@
+//! Parquet output for table rows.
+//!
+//! Each table becomes a Parquet dataset: a directory named after the table
+//! holding part files, written whenever enough rows of the table have been
+//! buffered and once more when processing finishes. Every part file has the
+//! table's merged schema at the time it was written, so a table extended by a
+//! later module has more columns in later parts; readers merging schemas by
+//! name (e.g. DuckDB's `union_by_name` or Spark's `mergeSchema`) see one table.
+//!
+//! Besides the table's own columns, each row carries `_content_id` and
+//! `_module`. Int64, Float64, Boolean, Bytes and Timestamp columns keep their
+//! types, Durations are stored as Int64 nanoseconds, and all other types are
+//! stored as the same text the Elasticsearch row documents use.
+
+use std::collections::HashMap;
+use std::fs::File;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use anyhow::{Context, Result};
+use arrow::array::{
+    ArrayRef, BinaryBuilder, BooleanBuilder, Float64Builder, Int64Builder, StringArray,
+    StringBuilder, TimestampMicrosecondBuilder,
+};
+use arrow::datatypes::{DataType as ArrowType, Field, Schema, TimeUnit};
+use arrow::record_batch::RecordBatch;
+use parquet::arrow::ArrowWriter;
+use parquet::basic::Compression;
+use parquet::file::properties::WriterProperties;
+use uuid::Uuid;
+use crate::bindings_types::{Column, DataType, Value};
+use crate::metadata::column_text;
+use crate::sink::{MetadataSink, SinkRow};
+
+/// Rows of a table buffered before a part file is written
+pub const DEFAULT_ROWS_PER_FILE: usize = 100_000;
+
+/// Writes table rows as one Parquet dataset per table under a directory
+pub struct ParquetSink {
+    dir: PathBuf,
+    rows_per_file: usize,
+    /// Distinguishes the part files of this run from those of earlier runs
+    /// writing to the same directory
+    run_id: Uuid,
+    tables: Mutex<HashMap<String, TableBuffer>>,
+}
+
+/// Merged schema and buffered rows of one table
+#[derive(Default)]
+struct TableBuffer {
+    columns: Vec<Column>,
+    content_ids: Vec<String>,
+    modules: Vec<String>,
+    /// Values in merged column order. Rows buffered before the table was
+    /// extended are shorter and get NULL in the added columns.
+    rows: Vec<Vec<Value>>,
+    parts: usize,
+}
+
+/// Rows taken from a buffer, to be written as one part file
+struct Part {
+    path: PathBuf,
+    columns: Vec<Column>,
+    content_ids: Vec<String>,
+    modules: Vec<String>,
+    rows: Vec<Vec<Value>>,
+}
+
+impl ParquetSink {
+    /// Create a sink writing under `dir`, which is created if missing
+    pub fn new(dir: &Path) -> Result<Self> {
+        std::fs::create_dir_all(dir)
+            .with_context(|| format!("Failed to create Parquet directory {}", dir.display()))?;
+        Ok(Self {
+            dir: dir.to_path_buf(),
+            rows_per_file: DEFAULT_ROWS_PER_FILE,
+            run_id: Uuid::new_v4(),
+            tables: Mutex::new(HashMap::new()),
+        })
+    }
+
+    /// Write a part file every `rows` rows of a table instead of every
+    /// DEFAULT_ROWS_PER_FILE
+    pub fn with_rows_per_file(mut self, rows: usize) -> Self {
+        self.rows_per_file = rows.max(1);
+        self
+    }
+
+    /// Take the buffered rows of a table as the next part file, if it has any.
+    /// Caller holds the tables lock.
+    fn take_part(&self, table: &str, buffer: &mut TableBuffer) -> Option<Part> {
+        if buffer.rows.is_empty() {
+            return None;
+        }
+        let path = self.dir
+            .join(dataset_dir(table))
+            .join(format!("part-{:05}-{}.parquet", buffer.parts, self.run_id.simple()));
+        buffer.parts += 1;
+        Some(Part {
+            path,
+            columns: buffer.columns.clone(),
+            content_ids: std::mem::take(&mut buffer.content_ids),
+            modules: std::mem::take(&mut buffer.modules),
+            rows: std::mem::take(&mut buffer.rows),
+        })
+    }
+}
+
+impl MetadataSink for ParquetSink {
+    fn define_table(&self, table: &str, columns: &[Column]) -> Result<()> {
+        let mut tables = self.tables.lock().unwrap();
+        tables.entry(table.to_string()).or_default().columns = columns.to_vec();
+        Ok(())
+    }
+
+    fn insert_row(&self, row: &SinkRow) -> Result<()> {
+        let part = {
+            let mut tables = self.tables.lock().unwrap();
+            let buffer = tables.get_mut(row.table)
+                .ok_or_else(|| anyhow::anyhow!("Table '{}' was not defined for the Parquet sink", row.table))?;
+            let values = buffer.columns.iter()
+                .map(|column| row.value(&column.name).cloned().unwrap_or(Value::Null))
+                .collect();
+            buffer.content_ids.push(row.content_uuid.to_string());
+            buffer.modules.push(row.module_name.to_string());
+            buffer.rows.push(values);
+            if buffer.rows.len() < self.rows_per_file {
+                return Ok(());
+            }
+            self.take_part(row.table, buffer)
+        };
+
+        // Written outside the lock so other tables keep buffering
+        match part {
+            Some(part) => write_part(part),
+            None => Ok(()),
+        }
+    }
+
+    fn finish(&self) -> Result<()> {
+        let parts: Vec<Part> = {
+            let mut tables = self.tables.lock().unwrap();
+            tables.iter_mut()
+                .filter_map(|(table, buffer)| self.take_part(table, buffer))
+                .collect()
+        };
+        for part in parts {
+            write_part(part)?;
+        }
+        Ok(())
+    }
+}
+
+/// Directory name of a table's dataset. Characters that can't safely appear
+/// in a path component are replaced with '_'.
+fn dataset_dir(table: &str) -> String {
+    let name: String = table.chars()
+        .map(|c| if c.is_ascii_alphanumeric() || matches!(c, '_' | '-' | '.') { c } else { '_' })
+        .collect();
+    if name.is_empty() || name.chars().all(|c| c == '.') {
+        format!("_{}", name)
+    } else {
+        name
+    }
+}
+
+/// Arrow type a column is stored as
+fn arrow_type(data_type: &DataType) -> ArrowType {
+    match data_type {
+        DataType::Int64 | DataType::Duration => ArrowType::Int64,
+        DataType::Float64 => ArrowType::Float64,
+        DataType::Boolean => ArrowType::Boolean,
+        DataType::Bytes | DataType::BytesRef => ArrowType::Binary,
+        DataType::Timestamp => ArrowType::Timestamp(TimeUnit::Microsecond, Some("UTC".into())),
+        _ => ArrowType::Utf8,
+    }
+}
+
+/// Build the array of one column. Values that don't match the column's type
+/// are stored as NULL.
+fn column_array<'a>(data_type: &DataType, values: impl Iterator<Item = Option<&'a Value>>) -> ArrayRef {
+    match data_type {
+        DataType::Int64 | DataType::Duration => {
+            let mut builder = Int64Builder::new();
+            for value in values {
+                builder.append_option(match value {
+                    Some(Value::Int64(v)) | Some(Value::Duration(v)) => Some(*v),
+                    _ => None,
+                });
+            }
+            Arc::new(builder.finish())
+        }
+        DataType::Float64 => {
+            let mut builder = Float64Builder::new();
+            for value in values {
+                builder.append_option(match value {
+                    Some(Value::Float64(v)) => Some(*v),
+                    _ => None,
+                });
+            }
+            Arc::new(builder.finish())
+        }
+        DataType::Boolean => {
+            let mut builder = BooleanBuilder::new();
+            for value in values {
+                builder.append_option(match value {
+                    Some(Value::Boolean(v)) => Some(*v),
+                    _ => None,
+                });
+            }
+            Arc::new(builder.finish())
+        }
+        DataType::Bytes | DataType::BytesRef => {
+            let mut builder = BinaryBuilder::new();
+            for value in values {
+                match value {
+                    Some(Value::Bytes(v)) => builder.append_value(v),
+                    _ => builder.append_null(),
+                }
+            }
+            Arc::new(builder.finish())
+        }
+        DataType::Timestamp => {
+            let mut builder = TimestampMicrosecondBuilder::new().with_timezone("UTC");
+            for value in values {
+                builder.append_option(match value {
+                    Some(Value::Timestamp(v)) => Some(v.timestamp_micros()),
+                    _ => None,
+                });
+            }
+            Arc::new(builder.finish())
+        }
+        _ => {
+            let mut builder = StringBuilder::new();
+            for value in values {
+                builder.append_option(value.and_then(column_text));
+            }
+            Arc::new(builder.finish())
+        }
+    }
+}
+
+/// Write buffered rows as a Parquet file
+fn write_part(part: Part) -> Result<()> {
+    let mut fields = vec![
+        Field::new("_content_id", ArrowType::Utf8, false),
+        Field::new("_module", ArrowType::Utf8, false),
+    ];
+    fields.extend(part.columns.iter().map(|column| Field::new(&column.name, arrow_type(&column.data_type), true)));
+    let schema = Arc::new(Schema::new(fields));
+
+    let mut arrays: Vec<ArrayRef> = vec![
+        Arc::new(StringArray::from(part.content_ids)),
+        Arc::new(StringArray::from(part.modules)),
+    ];
+    for (i, column) in part.columns.iter().enumerate() {
+        arrays.push(column_array(&column.data_type, part.rows.iter().map(|row| row.get(i))));
+    }
+    let batch = RecordBatch::try_new(schema.clone(), arrays)?;
+
+    if let Some(dir) = part.path.parent() {
+        std::fs::create_dir_all(dir)?;
+    }
+    let file = File::create(&part.path)
+        .with_context(|| format!("Failed to create {}", part.path.display()))?;
+    let properties = WriterProperties::builder()
+        .set_compression(Compression::SNAPPY)
+        .build();
+    let mut writer = ArrowWriter::try_new(file, schema, Some(properties))?;
+    writer.write(&batch)?;
+    writer.close()?;
+
+    tracing::debug!("Wrote {} rows to {}", batch.num_rows(), part.path.display());
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_dataset_dir_keeps_safe_names() {
+        assert_eq!(dataset_dir("pe_sections"), "pe_sections");
+        assert_eq!(dataset_dir("zip.entries"), "zip.entries");
+        assert_eq!(dataset_dir("../etc/passwd"), ".._etc_passwd");
+        assert_eq!(dataset_dir(".."), "_..");
+        assert_eq!(dataset_dir(""), "_");
+    }
+
+    #[test]
+    fn test_rows_get_merged_columns() {
+        let dir = tempfile::tempdir().unwrap();
+        let sink = ParquetSink::new(dir.path()).unwrap();
+        let column = |name: &str| Column {
+            name: name.to_string(),
+            data_type: DataType::Int64,
+            nullable: true,
+            primary_key: false,
+            unique: false,
+            dictionary: false,
+        };
+
+        sink.define_table("sizes", &[column("a")]).unwrap();
+        let names = vec!["a".to_string()];
+        sink.insert_row(&SinkRow {
+            table: "sizes",
+            content_uuid: "c1",
+            module_name: "m1",
+            columns: &names,
+            values: &[Value::Int64(1)],
+        }).unwrap();
+
+        // A second module extends the table and writes its columns in another order
+        sink.define_table("sizes", &[column("a"), column("b")]).unwrap();
+        let names = vec!["b".to_string(), "a".to_string()];
+        sink.insert_row(&SinkRow {
+            table: "sizes",
+            content_uuid: "c1",
+            module_name: "m2",
+            columns: &names,
+            values: &[Value::Int64(3), Value::Int64(2)],
+        }).unwrap();
+
+        {
+            let tables = sink.tables.lock().unwrap();
+            let buffer = &tables["sizes"];
+            assert_eq!(buffer.rows, vec![vec![Value::Int64(1)], vec![Value::Int64(2), Value::Int64(3)]]);
+            assert_eq!(buffer.modules, vec!["m1", "m2"]);
+        }
+
+        sink.finish().unwrap();
+        let parts: Vec<_> = std::fs::read_dir(dir.path().join("sizes")).unwrap().collect();
+        assert_eq!(parts.len(), 1);
+    }
+
+    #[test]
+    fn test_undefined_table_is_rejected() {
+        let dir = tempfile::tempdir().unwrap();
+        let sink = ParquetSink::new(dir.path()).unwrap();
+        let row = SinkRow { table: "missing", content_uuid: "c1", module_name: "m1", columns: &[], values: &[] };
+        assert!(sink.insert_row(&row).is_err());
+    }
+}
@@ -1,15 +1,85 @@
 use anyhow::Result;
+use std::collections::{HashMap, HashSet};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex};
 use std::thread;
 use crossbeam_deque::{Worker, Stealer, Steal};
-use crate::content::{Content, ContentData, ContentStore};
+use uuid::Uuid;
+use crate::condition::{Condition, ConditionTarget};
+use crate::content::{Content, ContentData, ContentInfo, ContentStore};
+use crate::dedup::DedupCache;
+use crate::manifest::ManifestTarget;
+use crate::limits::LimitExceeded;
 use crate::wasm::{WasmRuntime, ModuleInstance};
+use crate::registry::ModuleRegistry;
 use crate::metadata::MetadataStore;
-use crate::bindings_context::SubContentData;
+use crate::quota::{QuotaAction, QuotaPolicy, QuotaTracker, SubcontentQuotas};
+use crate::sampling::{cap_rows, RowCap};
+use crate::schema::{SchemaChange, SchemaConflict};
+use crate::query::ContentRows;
+use crate::secrets::SecretStore;
+use crate::state::{PreviousRun, ProcessingState};
+use crate::bindings_context::{LogMessage, MetadataRow, ProcessingContext, SubContentData, SubContentEmission};
+use crate::bindings_types::Value;
+use crate::shared_buffer::SharedBuffer;
+
+/// Statistics about the recursive extraction performed by a run
+#[derive(Debug, Default, Clone)]
+pub struct ProcessingStats {
+    /// Number of content items processed at each depth (index 0 = roots)
+    pub contents_per_depth: Vec<usize>,
+    /// Deepest extraction chain below each root content
+    pub chain_depths: HashMap<Uuid, usize>,
+    /// Sub-content dropped because it repeated itself or an ancestor
+    pub cycles_detected: usize,
+    /// Sub-content dropped because the depth limit was reached
+    pub depth_limit_hits: usize,
+    /// Content linked to an identical, already processed item
+    pub duplicates_skipped: usize,
+    /// Module invocations stopped by a timeout, fuel, memory or stack limit
+    pub limits_exceeded: usize,
+    /// Table definitions rejected because they conflict with another module's
+    pub schema_conflicts: usize,
+    /// Sub-content dropped because it exceeded a quota
+    pub quotas_exceeded: usize,
+    /// Module invocations skipped because the module's condition was false
+    pub condition_skips: usize,
+    /// Rows dropped by the row cap
+    pub rows_truncated: usize,
+    /// Module invocations skipped because an earlier run already did them
+    pub already_processed: usize,
+}
+
+impl ProcessingStats {
+    /// Depth of the deepest extraction chain
+    pub fn max_depth(&self) -> usize {
+        self.chain_depths.values().copied().max().unwrap_or(0)
+    }
+
+    fn record_content(&mut self, content: &Content) {
+        if self.contents_per_depth.len() <= content.depth {
+            self.contents_per_depth.resize(content.depth + 1, 0);
+        }
+        self.contents_per_depth[content.depth] += 1;
+
+        let chain_depth = self.chain_depths.entry(content.root_uuid).or_insert(0);
+        *chain_depth = (*chain_depth).max(content.depth);
+    }
+}
 
 pub struct ContentProcessor {
     runtime: WasmRuntime,
     metadata_store: MetadataStore,
     max_recursion_depth: usize,
+    dedup: Option<DedupCache>,
+    dedup_exempt_modules: Arc<HashSet<String>>,
+    module_parallelism: usize,
+    max_backlog: usize,
+    quotas: SubcontentQuotas,
+    quota_policy: Option<QuotaPolicy>,
+    conditions: Arc<HashMap<String, Condition>>,
+    row_cap: Option<RowCap>,
+    state: Option<ProcessingState>,
 }
 
 impl ContentProcessor {
@@ -22,15 +92,91 @@ impl ContentProcessor {
             runtime,
             metadata_store,
             max_recursion_depth,
+            dedup: None,
+            dedup_exempt_modules: Arc::new(HashSet::new()),
+            module_parallelism: 1,
+            max_backlog: 0,
+            quotas: SubcontentQuotas::default(),
+            quota_policy: None,
+            conditions: Arc::new(HashMap::new()),
+            row_cap: None,
+            state: None,
         }
     }
 
-    pub fn process(&self, initial_contents: Vec<Content>, num_threads: usize) -> Result<()> {
+    /// Configure scheduling within each worker thread.
+    ///
+    /// `module_parallelism` is the number of threads each worker uses to run
+    /// its module instances against the same content (1 runs them one after
+    /// another). `max_backlog` bounds the number of queued content items
+    /// across all workers; once reached, sub-content is processed immediately
+    /// by the worker that emitted it rather than queued (0 is unbounded).
+    pub fn with_scheduling(mut self, module_parallelism: usize, max_backlog: usize) -> Self {
+        self.module_parallelism = module_parallelism.max(1);
+        self.max_backlog = max_backlog;
+        self
+    }
+
+    /// Skip reprocessing content identical to content already processed,
+    /// remembering up to `capacity` distinct payloads (0 disables the cache).
+    ///
+    /// Modules listed in `exempt_modules` opt out and still run on every
+    /// duplicate, e.g. modules that record per-occurrence context.
+    pub fn with_dedup(mut self, capacity: usize, exempt_modules: Vec<String>) -> Self {
+        self.dedup = if capacity > 0 { Some(DedupCache::new(capacity)) } else { None };
+        self.dedup_exempt_modules = Arc::new(exempt_modules.into_iter().collect());
+        self
+    }
+
+    /// Limit the sub-content emitted for each content item. Children over a
+    /// quota are dropped and recorded as `quota_exceeded` documents.
+    pub fn with_subcontent_quotas(mut self, quotas: SubcontentQuotas) -> Self {
+        self.quotas = quotas;
+        self
+    }
+
+    /// Decide what happens after a quota is exceeded. Without a policy only
+    /// the offending child is dropped.
+    pub fn with_quota_policy(mut self, policy: QuotaPolicy) -> Self {
+        self.quota_policy = Some(policy);
+        self
+    }
+
+    /// Only invoke a module on content for which its condition holds,
+    /// checked before the module's manifest. Modules without a condition run
+    /// on all content.
+    pub fn with_conditions(mut self, conditions: HashMap<String, Condition>) -> Self {
+        self.conditions = Arc::new(conditions);
+        self
+    }
+
+    /// Cap the rows each module stores per table for one content item,
+    /// keeping the first rows and a sample of the rest. Truncated tables are
+    /// recorded as `rows_truncated` documents.
+    pub fn with_row_cap(mut self, cap: RowCap) -> Self {
+        self.row_cap = Some(cap);
+        self
+    }
+
+    /// Skip module runs recorded in `state` by an earlier run, and record
+    /// the runs that finish. A module that already ran on a content item
+    /// only runs again when it emitted sub-content, or when another module
+    /// has yet to run on the item and may query its rows; its rows and
+    /// output are then discarded rather than stored twice.
+    pub fn with_state(mut self, state: ProcessingState) -> Self {
+        self.state = Some(state);
+        self
+    }
+
+    pub fn process(&self, initial_contents: Vec<Content>, num_threads: usize) -> Result<ProcessingStats> {
         tracing::info!("Starting processing with {} threads", num_threads);
         tracing::info!("Initial content count: {}", initial_contents.len());
         tracing::info!("Max recursion depth: {}", self.max_recursion_depth);
 
         let content_store = ContentStore::new();
+        let stats = Arc::new(Mutex::new(ProcessingStats::default()));
+        let backlog = Arc::new(AtomicUsize::new(initial_contents.len()));
+        let content_rows = ContentRows::new();
 
         // Store initial content data
         for content in &initial_contents {
@@ -67,11 +213,28 @@ impl ContentProcessor {
                 .collect();
 
             let content_store = content_store.clone();
+            let stats = Arc::clone(&stats);
+            let dedup = self.dedup.clone();
+            let dedup_exempt_modules = Arc::clone(&self.dedup_exempt_modules);
+            let backlog = Arc::clone(&backlog);
+            let module_parallelism = self.module_parallelism;
+            let max_backlog = self.max_backlog;
             let metadata_store = self.metadata_store.clone();
             let max_recursion_depth = self.max_recursion_depth;
+            let quotas = self.quotas;
+            let quota_policy = self.quota_policy.clone();
+            let conditions = Arc::clone(&self.conditions);
+            let row_cap = self.row_cap;
+            let state = self.state.clone();
+            let secrets = self.runtime.limits().secrets.clone();
+            let content_rows = content_rows.clone();
 
             // Create module instances for this thread
-            let instances = self.runtime.create_instances(metadata_store.clone())?;
+            let registry = self.runtime.registry().clone();
+            let (generation, mut instances) = registry.create_instances(metadata_store.clone())?;
+            for instance in &mut instances {
+                instance.set_content_rows(content_rows.clone());
+            }
 
             let handle = thread::spawn(move || -> Result<()> {
                 let mut worker_thread = WorkerThread {
@@ -82,6 +245,21 @@ impl ContentProcessor {
                     metadata_store,
                     max_recursion_depth,
                     instances,
+                    registry,
+                    generation,
+                    stats,
+                    dedup,
+                    dedup_exempt_modules,
+                    module_parallelism,
+                    backlog,
+                    max_backlog,
+                    quotas,
+                    quota_policy,
+                    conditions,
+                    row_cap,
+                    state,
+                    secrets,
+                    content_rows,
                 };
 
                 worker_thread.run()
@@ -106,8 +284,19 @@ impl ContentProcessor {
             }
         }
 
+        let stats = stats.lock().unwrap().clone();
         tracing::info!("Processing complete");
-        Ok(())
+        tracing::info!(
+            "Deepest extraction chain: {} (cycles skipped: {}, depth limit hits: {}, duplicates skipped: {}, module limits exceeded: {}, schema conflicts: {}, quotas exceeded: {})",
+            stats.max_depth(),
+            stats.cycles_detected,
+            stats.depth_limit_hits,
+            stats.duplicates_skipped,
+            stats.limits_exceeded,
+            stats.schema_conflicts,
+            stats.quotas_exceeded
+        );
+        Ok(stats)
     }
 }
 
@@ -119,6 +308,28 @@ struct WorkerThread {
     metadata_store: MetadataStore,
     max_recursion_depth: usize,
     instances: Vec<ModuleInstance>,
+    /// Modules to instantiate, and the generation the instances belong to
+    registry: ModuleRegistry,
+    generation: u64,
+    stats: Arc<Mutex<ProcessingStats>>,
+    dedup: Option<DedupCache>,
+    dedup_exempt_modules: Arc<HashSet<String>>,
+    module_parallelism: usize,
+    /// Content items queued across all workers
+    backlog: Arc<AtomicUsize>,
+    max_backlog: usize,
+    quotas: SubcontentQuotas,
+    quota_policy: Option<QuotaPolicy>,
+    /// Per-module conditions deciding whether a module runs on a content
+    conditions: Arc<HashMap<String, Condition>>,
+    /// Rows kept per table, per module, per content
+    row_cap: Option<RowCap>,
+    /// Module runs finished by earlier runs
+    state: Option<ProcessingState>,
+    /// Harvests secrets from the rows of credentials tables
+    secrets: SecretStore,
+    /// Rows published by finished module runs, for query_metadata
+    content_rows: ContentRows,
 }
 
 impl WorkerThread {
@@ -148,6 +359,7 @@ impl WorkerThread {
     fn get_work(&self) -> Option<Content> {
         // Try local queue first (LIFO for depth-first)
         if let Some(content) = self.worker.pop() {
+            self.backlog.fetch_sub(1, Ordering::SeqCst);
             return Some(content);
         }
 
@@ -170,6 +382,7 @@ impl WorkerThread {
             }
 
             if let Some(content) = found {
+                self.backlog.fetch_sub(1, Ordering::SeqCst);
                 return Some(content);
             }
 
@@ -181,7 +394,28 @@ impl WorkerThread {
         None
     }
 
+    /// Recreate the instances if the modules were reloaded since they were
+    /// created. Called between content items, so an item is always processed
+    /// by one set of modules.
+    fn refresh_instances(&mut self) -> Result<()> {
+        if self.registry.generation() == self.generation {
+            return Ok(());
+        }
+        let (generation, mut instances) = self.registry.create_instances(self.metadata_store.clone())?;
+        for instance in &mut instances {
+            instance.set_content_rows(self.content_rows.clone());
+        }
+        tracing::debug!("Worker {} switched to module generation {}", self.id, generation);
+        self.instances = instances;
+        self.generation = generation;
+        Ok(())
+    }
+
     fn process_content(&mut self, content: Content) -> Result<()> {
+        if let Err(e) = self.refresh_instances() {
+            tracing::error!("Worker {} failed to instantiate reloaded modules: {}", self.id, e);
+        }
+
         tracing::debug!(
             "Worker {} processing content: {} (depth: {})",
             self.id,
@@ -198,6 +432,23 @@ impl WorkerThread {
             self.content_store.insert(content.uuid, owned_data.clone());
         }
 
+        self.stats.lock().unwrap().record_content(&content);
+        let info = ContentInfo::describe(content.uuid, &content.filename, content.parent_uuid, data.as_slice())
+            .with_ancestry(&content.ancestry);
+
+        // Identical data already processed: only exempt modules run again
+        let duplicate_of = self.dedup.as_ref()
+            .and_then(|cache| cache.check_or_insert(&info.sha256, content.uuid));
+        if let Some(original) = duplicate_of {
+            tracing::debug!(
+                "Worker {} content {} is a duplicate of {}",
+                self.id,
+                content.filename,
+                original
+            );
+            self.stats.lock().unwrap().duplicates_skipped += 1;
+        }
+
         // Start accumulating document data for this content
         let parent_uuid_str = content.parent_uuid.map(|u| u.to_string());
         let parent_uuid_ref = parent_uuid_str.as_deref();
@@ -208,87 +459,302 @@ impl WorkerThread {
             &content.filename,
             parent_uuid_ref,
         )?;
+        let content_type = info.mime_type.as_str();
+        self.metadata_store.set_content_type(&content_uuid_str, content_type)?;
+        self.metadata_store.set_annotations(&content_uuid_str, &content.annotations)?;
 
         let mut all_subcontent = Vec::new();
         let mut processing_errors = Vec::new();
+        let mut quota = QuotaTracker::new(self.quotas);
 
-        // Process through each module
-        for instance in &mut self.instances {
+        // Run the modules (concurrently if configured), then record their
+        // results in module order. Duplicates only go to exempt modules, and
+        // modules with a condition or manifest only get content matching it,
+        // unless the emitting module suggested them.
+        let target = ManifestTarget {
+            data: data.as_slice(),
+            filename: &content.filename,
+            parent_filename: content.parent_filename.as_deref(),
+            content_type,
+        };
+        let condition_target = ConditionTarget {
+            size: data.len() as u64,
+            depth: content.depth,
+            filename: &content.filename,
+            content_type,
+            parent_filename: content.parent_filename.as_deref(),
+            parent_module: content.parent_module.as_deref(),
+            tags: &content.annotations.tags,
+            relationship: content.annotations.relationship.as_deref(),
+            flags: content.annotations.flags.as_ref(),
+            format_version: content.annotations.format_version.as_deref(),
+            method: content.annotations.method.as_deref(),
+            collection: content.annotations.collection.as_ref().map(|collection| collection.name.as_str()),
+        };
+        let mut condition_skips = 0;
+        let mut selected: Vec<bool> = self.instances.iter()
+            .map(|instance| {
+                if duplicate_of.is_some() && !self.dedup_exempt_modules.contains(instance.name()) {
+                    return false;
+                }
+                if let Some(condition) = self.conditions.get(instance.name()) {
+                    if !condition.matches(&condition_target) {
+                        condition_skips += 1;
+                        return false;
+                    }
+                }
+                content.annotations.suggested_parsers.iter().any(|name| name == instance.name())
+                    || instance.manifest().map_or(true, |manifest| manifest.matches(&target))
+            })
+            .collect();
+        if condition_skips > 0 {
+            self.stats.lock().unwrap().condition_skips += condition_skips;
+        }
+
+        // Modules that finished on this data in an earlier run are skipped,
+        // or replayed when their sub-content or rows are needed again
+        let hash = self.state.as_ref().map(|_| info.sha256.clone());
+        let mut replayed = HashSet::new();
+        let mut already_processed = 0;
+        if let (Some(state), Some(hash)) = (&self.state, &hash) {
+            let previous: Vec<Option<PreviousRun>> = self.instances.iter()
+                .zip(&selected)
+                .map(|(instance, &selected)| {
+                    selected.then(|| state.previous_run(hash, instance.name(), instance.version(), instance.binary_hash())).flatten()
+                })
+                .collect();
+            let any_pending = selected.iter().zip(&previous).any(|(&selected, previous)| selected && previous.is_none());
+            for ((instance, selected), previous) in self.instances.iter().zip(selected.iter_mut()).zip(&previous) {
+                let Some(previous) = previous else { continue };
+                if previous.emitted || any_pending {
+                    replayed.insert(instance.name().to_string());
+                } else {
+                    *selected = false;
+                    already_processed += 1;
+                }
+            }
+            if already_processed > 0 {
+                self.stats.lock().unwrap().already_processed += already_processed;
+            }
+        }
+        let mut fresh_runs = 0;
+        let runs = run_modules(
+            &mut self.instances,
+            &selected,
+            &info,
+            &data,
+            self.module_parallelism,
+            &self.content_rows,
+        );
+
+        for run in runs {
             // Set current module context for metadata accumulation
-            self.metadata_store.set_current_module(&content_uuid_str, instance.name())?;
+            self.metadata_store.set_current_module(&content_uuid_str, &run.name, &run.version)?;
+
+            let replay = replayed.contains(&run.name);
+            if !replay {
+                fresh_runs += 1;
+            }
+
+            match run.result {
+                Ok(mut ctx) => {
+                    let emitted = !ctx.subcontent.is_empty();
 
-            match instance.process_content(content.uuid, data.clone()) {
-                Ok(ctx) => {
                     // First, define any tables requested by the module
                     for table_schema in &ctx.table_schemas {
-                        if let Err(e) = instance.metadata_store().define_table(table_schema.clone()) {
-                            tracing::warn!(
-                                "Failed to define table '{}' for module '{}': {}",
-                                table_schema.name,
-                                instance.name(),
-                                e
-                            );
+                        self.secrets.observe_schema(&run.name, table_schema);
+                        match self.metadata_store.define_table(&run.name, table_schema.clone()) {
+                            Ok(SchemaChange::Unchanged) => {}
+                            Ok(change) => {
+                                if let SchemaChange::Extended(columns) = &change {
+                                    tracing::info!(
+                                        "Module '{}' extended table '{}' with columns: {}",
+                                        run.name,
+                                        table_schema.name,
+                                        columns.join(", ")
+                                    );
+                                }
+                                self.metadata_store.record_table_schema(&run.name, table_schema)?;
+                            }
+                            Err(e) => {
+                                // Rows of a conflicting table are rejected by insert_row
+                                if e.downcast_ref::<SchemaConflict>().is_some() {
+                                    self.stats.lock().unwrap().schema_conflicts += 1;
+                                    self.metadata_store.record_module_error(
+                                        &content_uuid_str,
+                                        &run.name,
+                                        "schema_conflict",
+                                        &e.to_string(),
+                                    )?;
+                                }
+                                tracing::warn!(
+                                    "Failed to define table '{}' for module '{}': {}",
+                                    table_schema.name,
+                                    run.name,
+                                    e
+                                );
+                            }
+                        }
+                    }
+
+                    // Drop children over quota before rows can refer to them
+                    if self.quotas.is_enabled() {
+                        let emissions = std::mem::take(&mut ctx.subcontent);
+                        ctx.subcontent = self.apply_quotas(&mut quota, &content_uuid_str, &run.name, emissions)?;
+                    }
+
+                    // A replayed run only contributes its sub-content
+                    if replay {
+                        all_subcontent.extend(ctx.subcontent.into_iter().map(|emission| (run.name.clone(), emission)));
+                        continue;
+                    }
+
+                    // Attach sub-content to the rows describing it while the
+                    // rows are still in insertion order
+                    let mut rows = link_row_children(&run.name, std::mem::take(&mut ctx.metadata), &ctx.subcontent);
+
+                    // Keep pathological outputs from swamping storage
+                    if let Some(cap) = self.row_cap {
+                        let (kept, truncations) = cap_rows(rows, |(row, _)| row.table_name.as_str(), cap, info.seed() as u64);
+                        rows = kept;
+                        for truncation in &truncations {
+                            tracing::warn!("Module '{}' on {}: {}", run.name, content.filename, truncation);
+                            self.stats.lock().unwrap().rows_truncated += truncation.dropped_rows();
+                            self.metadata_store.record_rows_truncated(&content_uuid_str, &run.name, truncation)?;
                         }
                     }
 
-                    // Handle metadata
-                    for metadata_row in &ctx.metadata {
-                        if let Err(e) = instance.metadata_store().insert_row(
+                    // Handle metadata, pointing sub-content references at the
+                    // content IDs the children will get
+                    let child_ids: HashMap<u64, Uuid> = ctx.subcontent.iter()
+                        .filter_map(|emission| emission.index.map(|index| (index, emission.uuid)))
+                        .collect();
+                    for (metadata_row, children) in &rows {
+                        let values = resolve_subcontent_ids(&metadata_row.values, &child_ids);
+                        self.secrets.harvest(&run.name, &metadata_row.table_name, &values);
+                        if let Err(e) = self.metadata_store.insert_row(
                             &metadata_row.table_name,
                             &content.uuid.to_string(),
-                            &metadata_row.values,
+                            &values,
+                            children,
                         ) {
                             tracing::warn!(
                                 "Failed to insert row for module '{}': {}",
-                                instance.name(),
+                                run.name,
                                 e
                             );
                         }
                     }
 
-                    // Record module stdout/stderr output
-                    if let Err(e) = self.metadata_store.record_module_output(
-                        &content.uuid.to_string(),
-                        instance.name(),
-                        ctx.stdout.as_deref(),
-                        ctx.stderr.as_deref(),
-                        ctx.stdout_truncated,
-                        ctx.stderr_truncated,
-                    ) {
+                    // Record the aggregates the module computed over its rows
+                    if let Err(e) = self.metadata_store.record_aggregates(&content_uuid_str, &run.name, &ctx.aggregates) {
+                        tracing::warn!("Failed to record aggregates for module '{}': {}", run.name, e);
+                    }
+
+                    if let Err(e) = self.metadata_store.record_attributes(&content_uuid_str, &run.name, &ctx.attributes) {
+                        tracing::warn!("Failed to record attributes for module '{}': {}", run.name, e);
+                    }
+
+                    // Record whether the module fully examined the content
+                    if let Some(status) = &ctx.scan_status {
+                        self.metadata_store.record_scan_status(&content_uuid_str, &run.name, status)?;
+                    }
+
+                    // Record module stdout/stderr and logged messages
+                    for entry in &ctx.log {
+                        trace_log_message(&run.name, &content.filename, entry);
+                    }
+                    if let Err(e) = self.metadata_store.record_module_output(&content_uuid_str, &run.name, &ctx) {
                         tracing::warn!(
                             "Failed to record module output for '{}': {}",
-                            instance.name(),
+                            run.name,
                             e
                         );
                     }
 
+                    if let (Some(state), Some(hash)) = (&self.state, &hash) {
+                        if let Err(e) = state.record_done(hash, &run.name, &run.version, &run.binary_hash, emitted) {
+                            tracing::warn!("Failed to record run of module '{}' in {}: {}", run.name, state.path().display(), e);
+                        }
+                    }
+
                     // Collect sub-content
-                    all_subcontent.extend(ctx.subcontent);
+                    all_subcontent.extend(ctx.subcontent.into_iter().map(|emission| (run.name.clone(), emission)));
                 }
                 Err(e) => {
-                    let error_msg = format!("Module '{}' failed: {}", instance.name(), e);
+                    let limit = e.downcast_ref::<LimitExceeded>().map(|exceeded| exceeded.limit);
+                    if limit.is_some() {
+                        self.stats.lock().unwrap().limits_exceeded += 1;
+                    }
+                    let kind = limit.map_or("error", |limit| limit.as_str());
+                    self.metadata_store.record_module_error(&content_uuid_str, &run.name, kind, &e.to_string())?;
+
+                    let error_msg = format!("Module '{}' failed: {}", run.name, e);
                     tracing::warn!("{}", error_msg);
                     processing_errors.push(error_msg);
                 }
             }
         }
 
-        // Finalize content document and POST to Elasticsearch
-        if processing_errors.is_empty() {
+        // Finalize content document and POST to Elasticsearch, unless an
+        // earlier run already did all the work on this content
+        let nothing_new = fresh_runs == 0 && (already_processed > 0 || !replayed.is_empty());
+        if nothing_new && processing_errors.is_empty() {
+            self.metadata_store.discard_content(&content_uuid_str);
+        } else if let (Some(original), true) = (duplicate_of, processing_errors.is_empty()) {
+            self.metadata_store.finalize_content_duplicate(&content_uuid_str, &original.to_string())?;
+        } else if processing_errors.is_empty() {
             self.metadata_store.finalize_content_success(&content_uuid_str)?;
         } else {
             let error_summary = processing_errors.join("; ");
             self.metadata_store.finalize_content_failure(&content_uuid_str, &error_summary)?;
         }
+        self.content_rows.finish(content.uuid);
 
         // Process sub-content (depth-first)
-        for subcontent_emission in all_subcontent {
+        for (module_name, subcontent_emission) in all_subcontent {
+            if content.depth >= self.max_recursion_depth {
+                tracing::warn!(
+                    "Skipping sub-content '{}': max recursion depth {} reached",
+                    subcontent_emission.filename,
+                    self.max_recursion_depth
+                );
+                self.stats.lock().unwrap().depth_limit_hits += 1;
+                continue;
+            }
+
+            let child_sha256 = match &subcontent_emission.data {
+                SubContentData::Bytes(bytes) => crate::state::content_hash(bytes),
+                SubContentData::Slice { offset, length } => {
+                    match data.as_slice().get(*offset..offset.saturating_add(*length)) {
+                        Some(slice) => crate::state::content_hash(slice),
+                        None => {
+                            tracing::warn!(
+                                "Skipping sub-content '{}': slice {}+{} is out of range",
+                                subcontent_emission.filename,
+                                offset,
+                                length
+                            );
+                            continue;
+                        }
+                    }
+                }
+            };
+            if content.is_cycle(&info.sha256, &child_sha256) {
+                tracing::warn!(
+                    "Skipping sub-content '{}': identical to '{}' or one of its ancestors",
+                    subcontent_emission.filename,
+                    content.filename
+                );
+                self.stats.lock().unwrap().cycles_detected += 1;
+                continue;
+            }
+
             let subcontent_data = match subcontent_emission.data {
                 SubContentData::Bytes(bytes) => {
                     // Zero-copy: SharedBuffer wraps the Bytes directly
                     let buffer = crate::shared_buffer::SharedBuffer::from_bytes(bytes);
-                    let uuid = uuid::Uuid::new_v4();
-                    self.content_store.insert(uuid, buffer.clone());
+                    self.content_store.insert(subcontent_emission.uuid, buffer.clone());
                     ContentData::Owned(buffer)
                 }
                 SubContentData::Slice { offset, length } => {
@@ -301,18 +767,47 @@ impl WorkerThread {
             };
 
             match Content::new_subcontent(
+                subcontent_emission.uuid,
                 &content,
+                &info.sha256,
+                &module_name,
                 subcontent_data,
                 subcontent_emission.filename,
                 self.max_recursion_depth,
             ) {
-                Ok(subcontent) => {
+                Ok(mut subcontent) => {
+                    // A chain the module recorded takes the place of the one
+                    // derived here, and is kept on the child's document
+                    let mut annotations = subcontent_emission.annotations;
+                    if annotations.analyzed_by.is_empty() {
+                        annotations.analyzed_by = subcontent.ancestry.to_vec();
+                    } else {
+                        subcontent.ancestry = Arc::new(annotations.analyzed_by.clone());
+                    }
+                    subcontent.annotations = Arc::new(annotations);
+
+                    if self.max_backlog > 0 && self.backlog.load(Ordering::SeqCst) >= self.max_backlog {
+                        // Back-pressure: the queues are full, so process the
+                        // child on this thread instead of queueing it
+                        tracing::debug!(
+                            "Worker {} processing sub-content inline: {} (depth: {})",
+                            self.id,
+                            subcontent.filename,
+                            subcontent.depth
+                        );
+                        if let Err(e) = self.process_content(subcontent) {
+                            tracing::error!("Failed to process content: {}", e);
+                        }
+                        continue;
+                    }
+
                     tracing::debug!(
                         "Worker {} enqueuing sub-content: {} (depth: {})",
                         self.id,
                         subcontent.filename,
                         subcontent.depth
                     );
+                    self.backlog.fetch_add(1, Ordering::SeqCst);
                     self.worker.push(subcontent);
                 }
                 Err(e) => {
@@ -323,4 +818,209 @@ impl WorkerThread {
 
         Ok(())
     }
+
+    /// Keep the emissions of one module run that fit within the content's
+    /// quotas, recording a quota_exceeded document for each one dropped
+    fn apply_quotas(
+        &self,
+        quota: &mut QuotaTracker,
+        content_uuid: &str,
+        module_name: &str,
+        emissions: Vec<SubContentEmission>,
+    ) -> Result<Vec<SubContentEmission>> {
+        let mut kept = Vec::with_capacity(emissions.len());
+        for emission in emissions {
+            if quota.is_stopped() {
+                tracing::debug!("Skipping sub-content '{}': content is over quota", emission.filename);
+                continue;
+            }
+
+            let size = match &emission.data {
+                SubContentData::Bytes(bytes) => bytes.len() as u64,
+                SubContentData::Slice { length, .. } => *length as u64,
+            };
+            match quota.check(module_name, &emission.filename, size) {
+                Ok(()) => kept.push(emission),
+                Err(event) => {
+                    tracing::warn!("Skipping {}", event);
+                    self.stats.lock().unwrap().quotas_exceeded += 1;
+                    self.metadata_store.record_quota_exceeded(content_uuid, &event)?;
+                    let action = self.quota_policy.as_ref().map_or(QuotaAction::Skip, |policy| policy(&event));
+                    if action == QuotaAction::SkipRemaining {
+                        quota.stop();
+                    }
+                }
+            }
+        }
+        Ok(kept)
+    }
+}
+
+/// Result of running one module against a content item
+struct ModuleRun {
+    name: String,
+    version: String,
+    binary_hash: String,
+    result: Result<ProcessingContext>,
+}
+
+/// Pair each row with the sub-content the module linked to it. Rows are
+/// numbered per table in the order the module inserted them, as the guest
+/// numbers them.
+fn link_row_children(
+    module_name: &str,
+    rows: Vec<MetadataRow>,
+    subcontent: &[SubContentEmission],
+) -> Vec<(MetadataRow, Vec<Uuid>)> {
+    let mut links: HashMap<(String, usize), Vec<Uuid>> = HashMap::new();
+    for emission in subcontent {
+        if let Some(parent_row) = &emission.annotations.parent_row {
+            links.entry((parent_row.table.clone(), parent_row.row_index)).or_default().push(emission.uuid);
+        }
+    }
+    if links.is_empty() {
+        return rows.into_iter().map(|row| (row, Vec::new())).collect();
+    }
+
+    let mut counts: HashMap<String, usize> = HashMap::new();
+    let rows = rows.into_iter()
+        .map(|row| {
+            let count = counts.entry(row.table_name.clone()).or_default();
+            let children = links.remove(&(row.table_name.clone(), *count)).unwrap_or_default();
+            *count += 1;
+            (row, children)
+        })
+        .collect();
+    for ((table, row_index), children) in links {
+        tracing::warn!(
+            "Module '{}' linked {} sub-content item(s) to row {} of table '{}', which it didn't insert",
+            module_name,
+            children.len(),
+            row_index,
+            table
+        );
+    }
+    rows
+}
+
+/// Replace sub-content indices in row values with the children's content IDs.
+/// Indices that match no emission are left for the metadata store, which
+/// stores them as empty values.
+fn resolve_subcontent_ids(values: &[Value], child_ids: &HashMap<u64, Uuid>) -> Vec<Value> {
+    values.iter()
+        .map(|value| match value {
+            Value::SubContentID(index) => match child_ids.get(index) {
+                Some(uuid) => Value::String(uuid.to_string()),
+                None => {
+                    tracing::warn!("Row refers to sub-content {} which was not emitted", index);
+                    value.clone()
+                }
+            },
+            _ => value.clone(),
+        })
+        .collect()
+}
+
+/// Pass a message a module logged on to the host's own log, at its level
+fn trace_log_message(module: &str, filename: &str, entry: &LogMessage) {
+    match entry.level.as_str() {
+        "debug" => tracing::debug!("Module '{}' on {}: {}", module, filename, entry.message),
+        "warn" => tracing::warn!("Module '{}' on {}: {}", module, filename, entry.message),
+        "error" => tracing::error!("Module '{}' on {}: {}", module, filename, entry.message),
+        _ => tracing::info!("Module '{}' on {}: {}", module, filename, entry.message),
+    }
+}
+
+/// Run the selected module instances against a content item, one stage
+/// after another so modules see the rows of the modules they depend on.
+/// Instances are ordered by stage. Results are returned in instance order.
+fn run_modules(
+    instances: &mut [ModuleInstance],
+    selected: &[bool],
+    info: &ContentInfo,
+    data: &SharedBuffer,
+    parallelism: usize,
+    rows: &ContentRows,
+) -> Vec<ModuleRun> {
+    let mut runs = Vec::new();
+    let mut instances = instances;
+    let mut selected = selected;
+    while !instances.is_empty() {
+        let stage = instances[0].stage();
+        let len = instances.iter().take_while(|instance| instance.stage() == stage).count();
+        let (stage_instances, rest) = std::mem::take(&mut instances).split_at_mut(len);
+        let (stage_selected, rest_selected) = selected.split_at(len);
+        runs.extend(run_stage(stage_instances, stage_selected, info, data, parallelism, rows));
+        instances = rest;
+        selected = rest_selected;
+    }
+    runs
+}
+
+/// Run the selected instances of one stage, spreading them over up to
+/// `parallelism` threads. Results are returned in instance order.
+fn run_stage(
+    instances: &mut [ModuleInstance],
+    selected: &[bool],
+    info: &ContentInfo,
+    data: &SharedBuffer,
+    parallelism: usize,
+    rows: &ContentRows,
+) -> Vec<ModuleRun> {
+    if parallelism <= 1 || instances.len() <= 1 {
+        return run_module_chunk(instances, selected, info, data, rows);
+    }
+
+    let chunk_size = instances.len().div_ceil(parallelism);
+    thread::scope(|scope| {
+        let handles: Vec<_> = instances.chunks_mut(chunk_size)
+            .zip(selected.chunks(chunk_size))
+            .map(|(chunk, chunk_selected)| {
+                scope.spawn(move || run_module_chunk(chunk, chunk_selected, info, data, rows))
+            })
+            .collect();
+
+        handles.into_iter()
+            .flat_map(|handle| match handle.join() {
+                Ok(runs) => runs,
+                Err(panic) => std::panic::resume_unwind(panic),
+            })
+            .collect()
+    })
+}
+
+/// Run the selected instances of a chunk one after another, publishing the
+/// rows of each run for the modules after it
+fn run_module_chunk(
+    instances: &mut [ModuleInstance],
+    selected: &[bool],
+    info: &ContentInfo,
+    data: &SharedBuffer,
+    rows: &ContentRows,
+) -> Vec<ModuleRun> {
+    instances.iter_mut()
+        .zip(selected)
+        .filter(|(_, selected)| **selected)
+        .map(|(instance, _)| {
+            let result = instance.process_content(info, data.clone());
+            if let Ok(ctx) = &result {
+                rows.publish(instance.name(), ctx);
+            }
+
+            // Replace instances that trapped so the next content gets a clean one
+            if instance.needs_reset() {
+                tracing::debug!("Re-instantiating module '{}' after a trap", instance.name());
+                if let Err(e) = instance.reset() {
+                    tracing::error!("Failed to re-instantiate module '{}': {}", instance.name(), e);
+                }
+            }
+
+            ModuleRun {
+                name: instance.name().to_string(),
+                version: instance.version().to_string(),
+                binary_hash: instance.binary_hash().to_string(),
+                result,
+            }
+        })
+        .collect()
 }
@@ -0,0 +1,65 @@
+//! Progress reported by long-running modules through `wadup.report_progress`.
+
+use serde::Serialize;
+use std::time::{Duration, Instant};
+
+/// Minimum interval between progress log lines for one invocation
+const PROGRESS_LOG_INTERVAL: Duration = Duration::from_secs(5);
+
+/// The most recent progress report of a module invocation
+#[derive(Debug, Clone, Copy, Serialize)]
+pub struct ProgressReport {
+    pub done: i64,
+    /// Total amount of work, None if the module doesn't know it
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub total: Option<i64>,
+}
+
+impl std::fmt::Display for ProgressReport {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self.total {
+            Some(total) if total > 0 => write!(
+                f,
+                "{}/{} ({:.0}%)",
+                self.done,
+                total,
+                self.done as f64 * 100.0 / total as f64
+            ),
+            Some(total) => write!(f, "{}/{}", self.done, total),
+            None => write!(f, "{}", self.done),
+        }
+    }
+}
+
+/// Progress state of the current invocation of a module instance
+#[derive(Debug, Default)]
+pub struct ProgressTracker {
+    pub last: Option<ProgressReport>,
+    started: Option<Instant>,
+    last_logged: Option<Instant>,
+}
+
+impl ProgressTracker {
+    /// Forget the previous invocation's progress
+    pub fn reset(&mut self) {
+        *self = Self {
+            started: Some(Instant::now()),
+            ..Self::default()
+        };
+    }
+
+    /// Record a report, returning whether it shows forward progress and
+    /// whether it is due to be logged
+    pub fn record(&mut self, report: ProgressReport) -> (bool, bool) {
+        let advanced = self.last.map_or(report.done > 0, |last| report.done > last.done);
+        self.last = Some(report);
+
+        let now = Instant::now();
+        let since = self.last_logged.or(self.started).unwrap_or(now);
+        let log = now.duration_since(since) >= PROGRESS_LOG_INTERVAL;
+        if log {
+            self.last_logged = Some(now);
+        }
+        (advanced, log)
+    }
+}
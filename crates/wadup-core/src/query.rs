@@ -0,0 +1,98 @@
+//! Rows published for the content being processed, so modules can read what
+//! other modules already produced for it.
+//!
+//! Each module run publishes its rows when it finishes. A module that runs
+//! later on the same content can query them through the `query_metadata`
+//! import, e.g. a triage module reading the `pe_sections` table. Rows are
+//! dropped once the content is finalized.
+
+use crate::bindings_context::ProcessingContext;
+use crate::bindings_types::{Column, Value};
+use serde::Serialize;
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use uuid::Uuid;
+
+/// A row another module produced for the current content
+#[derive(Debug, Clone, Serialize)]
+pub struct PublishedRow {
+    pub module: String,
+    /// Values keyed by column name
+    pub values: HashMap<String, Value>,
+}
+
+#[derive(Default)]
+struct ContentRowsInner {
+    /// Columns of each table, keyed by (module, table), kept across contents
+    /// since modules define a table once and then only insert rows
+    schemas: HashMap<(String, String), Vec<Column>>,
+    /// Published rows by content, then by table
+    rows: HashMap<Uuid, HashMap<String, Vec<PublishedRow>>>,
+}
+
+/// Rows published by finished module runs, shared by every worker
+#[derive(Clone, Default)]
+pub struct ContentRows {
+    inner: Arc<Mutex<ContentRowsInner>>,
+}
+
+impl ContentRows {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Publish the tables and rows of a finished module run
+    pub fn publish(&self, module_name: &str, ctx: &ProcessingContext) {
+        let mut guard = self.inner.lock().unwrap();
+        let ContentRowsInner { schemas, rows } = &mut *guard;
+        for schema in &ctx.table_schemas {
+            schemas.insert((module_name.to_string(), schema.name.clone()), schema.columns.clone());
+        }
+
+        let child_ids: HashMap<u64, Uuid> = ctx.subcontent.iter()
+            .filter_map(|emission| emission.index.map(|index| (index, emission.uuid)))
+            .collect();
+        for row in &ctx.metadata {
+            let Some(columns) = schemas.get(&(module_name.to_string(), row.table_name.clone())) else {
+                continue;
+            };
+            let values = columns.iter()
+                .zip(&row.values)
+                .map(|(column, value)| {
+                    let value = match value {
+                        Value::SubContentID(index) => Value::String(
+                            child_ids.get(index).map(|uuid| uuid.to_string()).unwrap_or_default(),
+                        ),
+                        value => value.clone(),
+                    };
+                    (column.name.clone(), value)
+                })
+                .collect();
+            rows.entry(ctx.content_uuid).or_default()
+                .entry(row.table_name.clone()).or_default()
+                .push(PublishedRow { module: module_name.to_string(), values });
+        }
+    }
+
+    /// Rows of a table published for a content whose columns equal every
+    /// value in `filter`
+    pub fn query(&self, content_uuid: Uuid, table: &str, filter: &HashMap<String, Value>) -> Vec<PublishedRow> {
+        let inner = self.inner.lock().unwrap();
+        let Some(rows) = inner.rows.get(&content_uuid).and_then(|tables| tables.get(table)) else {
+            return Vec::new();
+        };
+        rows.iter()
+            .filter(|row| {
+                filter.iter().all(|(column, wanted)| {
+                    row.values.get(column) == Some(wanted)
+                })
+            })
+            .cloned()
+            .collect()
+    }
+
+    /// Drop the rows of a finalized content
+    pub fn finish(&self, content_uuid: Uuid) {
+        self.inner.lock().unwrap().rows.remove(&content_uuid);
+    }
+}
@@ -0,0 +1,139 @@
+//! Per-content sub-content quotas.
+//!
+//! Quotas bound what the modules may emit for a single content item: the
+//! number of children, their total size, and the size of any one child. A
+//! child over quota is dropped by the host rather than failing the guest's
+//! write, and reported as a `QuotaExceeded` event.
+
+use serde::Serialize;
+use std::fmt;
+use std::sync::Arc;
+
+/// Limits on the sub-content emitted for one content item, across all modules
+#[derive(Debug, Clone, Copy, Default)]
+pub struct SubcontentQuotas {
+    pub max_children: Option<usize>,
+    pub max_total_bytes: Option<u64>,
+    pub max_child_size: Option<u64>,
+}
+
+impl SubcontentQuotas {
+    /// Whether any quota is set
+    pub fn is_enabled(&self) -> bool {
+        self.max_children.is_some() || self.max_total_bytes.is_some() || self.max_child_size.is_some()
+    }
+}
+
+/// The quota a child ran into
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum QuotaKind {
+    MaxChildren,
+    MaxTotalBytes,
+    MaxChildSize,
+}
+
+impl QuotaKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            QuotaKind::MaxChildren => "max_children",
+            QuotaKind::MaxTotalBytes => "max_total_bytes",
+            QuotaKind::MaxChildSize => "max_child_size",
+        }
+    }
+}
+
+/// A child dropped because it exceeded a quota
+#[derive(Debug, Clone, Serialize)]
+pub struct QuotaExceeded {
+    pub quota: QuotaKind,
+    pub limit: u64,
+    pub module_name: String,
+    pub filename: String,
+    /// Size of the dropped child in bytes
+    pub size: u64,
+}
+
+impl fmt::Display for QuotaExceeded {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "sub-content '{}' ({} bytes) from module '{}' exceeds {} of {}",
+            self.filename,
+            self.size,
+            self.module_name,
+            self.quota.as_str(),
+            self.limit
+        )
+    }
+}
+
+/// What to do after a quota is exceeded
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum QuotaAction {
+    /// Drop the offending child and keep checking the rest
+    #[default]
+    Skip,
+    /// Drop the offending child and every later child of the same content
+    SkipRemaining,
+}
+
+/// Decides the action for each quota-exceeded event
+pub type QuotaPolicy = Arc<dyn Fn(&QuotaExceeded) -> QuotaAction + Send + Sync>;
+
+/// Tracks the sub-content accepted so far for one content item
+#[derive(Debug, Default)]
+pub struct QuotaTracker {
+    quotas: SubcontentQuotas,
+    children: usize,
+    total_bytes: u64,
+    stopped: bool,
+}
+
+impl QuotaTracker {
+    pub fn new(quotas: SubcontentQuotas) -> Self {
+        Self { quotas, ..Default::default() }
+    }
+
+    /// Whether a policy asked to drop all further children
+    pub fn is_stopped(&self) -> bool {
+        self.stopped
+    }
+
+    /// Drop all further children
+    pub fn stop(&mut self) {
+        self.stopped = true;
+    }
+
+    /// Accept a child of `size` bytes, or report the quota it exceeds. A
+    /// rejected child doesn't count towards the quotas.
+    pub fn check(&mut self, module_name: &str, filename: &str, size: u64) -> Result<(), QuotaExceeded> {
+        let exceeded = |quota: QuotaKind, limit: u64| QuotaExceeded {
+            quota,
+            limit,
+            module_name: module_name.to_string(),
+            filename: filename.to_string(),
+            size,
+        };
+
+        if let Some(max) = self.quotas.max_child_size {
+            if size > max {
+                return Err(exceeded(QuotaKind::MaxChildSize, max));
+            }
+        }
+        if let Some(max) = self.quotas.max_children {
+            if self.children >= max {
+                return Err(exceeded(QuotaKind::MaxChildren, max as u64));
+            }
+        }
+        if let Some(max) = self.quotas.max_total_bytes {
+            if self.total_bytes.saturating_add(size) > max {
+                return Err(exceeded(QuotaKind::MaxTotalBytes, max));
+            }
+        }
+
+        self.children += 1;
+        self.total_bytes = self.total_bytes.saturating_add(size);
+        Ok(())
+    }
+}
@@ -0,0 +1,589 @@
+//! Registry of the loaded modules, which can be reloaded while content is
+//! being processed.
+//!
+//! The registry loads every `.wasm` file of a modules directory. `reload`
+//! scans the directory again and compiles new and changed files, replacing
+//! the module set as a whole and bumping its generation. Worker threads
+//! compare generations between content items and recreate their instances
+//! when it changed, so modules are swapped without restarting the run; a
+//! content item already being processed finishes with the modules it started
+//! with. Each instance carries the version of its module, which is stamped
+//! into row provenance, so rows always name the build that produced them.
+
+use anyhow::Result;
+use std::cmp::Ordering;
+use std::collections::HashMap;
+use std::fmt;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering as AtomicOrdering};
+use std::sync::{Arc, RwLock};
+use std::time::{Duration, SystemTime};
+use wasmtime::{Engine, Module};
+use crate::metadata::MetadataStore;
+use crate::wasm::{ModuleInfo, ModuleInstance, ResourceLimits};
+
+/// What identifies a version of a module file on disk
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct FileStamp {
+    len: u64,
+    modified: Option<SystemTime>,
+}
+
+impl FileStamp {
+    fn of(path: &Path) -> Result<Self> {
+        let metadata = std::fs::metadata(path)?;
+        Ok(Self { len: metadata.len(), modified: metadata.modified().ok() })
+    }
+}
+
+#[derive(Default)]
+struct RegistryState {
+    dir: Option<PathBuf>,
+    /// Bumped whenever the module set changes
+    generation: u64,
+    modules: Vec<ModuleInfo>,
+    /// Stamp of the file each module was loaded from, by module name
+    stamps: HashMap<String, FileStamp>,
+    /// Versions each module has been loaded with, oldest first
+    history: HashMap<String, Vec<String>>,
+}
+
+/// A change to the module set made by a reload
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum ModuleChange {
+    Added { name: String, version: String },
+    Updated { name: String, from: String, to: String },
+    Removed { name: String },
+}
+
+impl fmt::Display for ModuleChange {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            ModuleChange::Added { name, version } => write!(f, "added module '{}' version {}", name, version),
+            ModuleChange::Updated { name, from, to } => write!(f, "updated module '{}' from version {} to {}", name, from, to),
+            ModuleChange::Removed { name } => write!(f, "removed module '{}'", name),
+        }
+    }
+}
+
+/// The loaded modules, shared by the runtime and all worker threads
+#[derive(Clone)]
+pub struct ModuleRegistry {
+    engine: Engine,
+    limits: ResourceLimits,
+    state: Arc<RwLock<RegistryState>>,
+}
+
+impl ModuleRegistry {
+    pub fn new(engine: Engine, limits: ResourceLimits) -> Self {
+        Self {
+            engine,
+            limits,
+            state: Arc::new(RwLock::new(RegistryState::default())),
+        }
+    }
+
+    /// Load every module of a directory, replacing any loaded before. Fails
+    /// if a module can't be loaded or the directory has none.
+    pub fn load_dir(&self, dir: &Path) -> Result<()> {
+        let mut loaded = Vec::new();
+        for path in wasm_files(dir)? {
+            let stamp = FileStamp::of(&path)?;
+            loaded.push((load_module_file(&self.engine, &path)?, stamp));
+        }
+        if loaded.is_empty() {
+            anyhow::bail!("No WASM modules found in directory");
+        }
+
+        let mut modules = Vec::with_capacity(loaded.len());
+        let mut stamps = HashMap::new();
+        for (module_info, stamp) in loaded {
+            stamps.insert(module_info.name.clone(), stamp);
+            modules.push(module_info);
+        }
+        let modules = order_by_stage(modules)?;
+
+        let mut state = self.state.write().unwrap();
+        state.dir = Some(dir.to_path_buf());
+        state.generation += 1;
+        state.history = modules.iter()
+            .map(|m| (m.name.clone(), vec![m.version.clone()]))
+            .collect();
+        state.modules = modules;
+        state.stamps = stamps;
+        Ok(())
+    }
+
+    /// Scan the modules directory again, loading new and changed files and
+    /// dropping modules whose file is gone. A file that fails to load is
+    /// skipped with a warning and its previous build, if any, stays loaded.
+    /// If the new set can't be scheduled the old one is kept and an error
+    /// returned. Returns the changes made, empty if nothing changed.
+    pub fn reload(&self) -> Result<Vec<ModuleChange>> {
+        let (dir, current, stamps) = {
+            let state = self.state.read().unwrap();
+            let Some(dir) = state.dir.clone() else {
+                anyhow::bail!("No modules directory loaded");
+            };
+            (dir, state.modules.clone(), state.stamps.clone())
+        };
+
+        let mut modules = Vec::new();
+        let mut new_stamps = HashMap::new();
+        let mut changes = Vec::new();
+        for path in wasm_files(&dir)? {
+            let name = module_name(&path);
+            let stamp = match FileStamp::of(&path) {
+                Ok(stamp) => stamp,
+                Err(e) => {
+                    tracing::warn!("Skipping module file {}: {}", path.display(), e);
+                    continue;
+                }
+            };
+            let previous = current.iter().find(|m| m.name == name);
+            if let (Some(previous), Some(&loaded_stamp)) = (previous, stamps.get(&name)) {
+                if loaded_stamp == stamp {
+                    modules.push(previous.clone());
+                    new_stamps.insert(name, stamp);
+                    continue;
+                }
+            }
+
+            match load_module_file(&self.engine, &path) {
+                Ok(module_info) if previous.is_some_and(|p| p.binary_hash == module_info.binary_hash) => {
+                    // Touched but not rebuilt
+                    modules.push(previous.unwrap().clone());
+                    new_stamps.insert(name, stamp);
+                }
+                Ok(module_info) => {
+                    changes.push(match previous {
+                        Some(previous) => {
+                            check_version_bump(&name, &previous.version, &module_info.version);
+                            ModuleChange::Updated { name: name.clone(), from: previous.version.clone(), to: module_info.version.clone() }
+                        }
+                        None => ModuleChange::Added { name: name.clone(), version: module_info.version.clone() },
+                    });
+                    modules.push(module_info);
+                    new_stamps.insert(name, stamp);
+                }
+                Err(e) => {
+                    tracing::warn!("Failed to load module file {}: {}", path.display(), e);
+                    if let Some(previous) = previous {
+                        modules.push(previous.clone());
+                        if let Some(&loaded_stamp) = stamps.get(&name) {
+                            new_stamps.insert(name, loaded_stamp);
+                        }
+                    }
+                }
+            }
+        }
+        for module_info in &current {
+            if !modules.iter().any(|m| m.name == module_info.name) {
+                changes.push(ModuleChange::Removed { name: module_info.name.clone() });
+            }
+        }
+
+        if changes.is_empty() {
+            // Remember touched files so they aren't compiled again
+            self.state.write().unwrap().stamps = new_stamps;
+            return Ok(changes);
+        }
+        if modules.is_empty() {
+            anyhow::bail!("No WASM modules left in directory; keeping the loaded modules");
+        }
+        let modules = order_by_stage(modules)?;
+
+        let mut state = self.state.write().unwrap();
+        for change in &changes {
+            match change {
+                ModuleChange::Added { name, version } | ModuleChange::Updated { name, to: version, .. } => {
+                    state.history.entry(name.clone()).or_default().push(version.clone());
+                }
+                ModuleChange::Removed { .. } => {}
+            }
+        }
+        state.modules = modules;
+        state.stamps = new_stamps;
+        state.generation += 1;
+        Ok(changes)
+    }
+
+    /// Changes whenever the module set does
+    pub fn generation(&self) -> u64 {
+        self.state.read().unwrap().generation
+    }
+
+    /// The loaded modules, in stage order
+    pub fn modules(&self) -> Vec<ModuleInfo> {
+        self.state.read().unwrap().modules.clone()
+    }
+
+    /// Versions a module has been loaded with during this run, oldest first
+    pub fn versions(&self, name: &str) -> Vec<String> {
+        self.state.read().unwrap().history.get(name).cloned().unwrap_or_default()
+    }
+
+    /// Instantiate the current modules, returning the generation they belong to
+    pub fn create_instances(&self, metadata_store: MetadataStore) -> Result<(u64, Vec<ModuleInstance>)> {
+        let (generation, modules) = {
+            let state = self.state.read().unwrap();
+            (state.generation, state.modules.clone())
+        };
+
+        let mut instances = Vec::new();
+        for module_info in &modules {
+            let mut instance = ModuleInstance::new(
+                &self.engine,
+                &module_info.module,
+                &module_info.name,
+                &self.limits.for_module(&module_info.name),
+                metadata_store.clone(),
+            )?;
+            instance.set_module_info(module_info);
+            instances.push(instance);
+        }
+        Ok((generation, instances))
+    }
+}
+
+/// Reloads a registry periodically on a background thread until dropped
+pub struct ModuleWatcher {
+    stop: Arc<AtomicBool>,
+}
+
+impl ModuleWatcher {
+    pub fn start(registry: ModuleRegistry, interval: Duration) -> Self {
+        let stop = Arc::new(AtomicBool::new(false));
+        let thread_stop = stop.clone();
+        std::thread::spawn(move || {
+            while !thread_stop.load(AtomicOrdering::Relaxed) {
+                std::thread::sleep(interval);
+                if thread_stop.load(AtomicOrdering::Relaxed) {
+                    break;
+                }
+                match registry.reload() {
+                    Ok(changes) => {
+                        for change in changes {
+                            tracing::info!("Module reload: {}", change);
+                        }
+                    }
+                    Err(e) => tracing::warn!("Module reload failed: {}", e),
+                }
+            }
+        });
+        Self { stop }
+    }
+}
+
+impl Drop for ModuleWatcher {
+    fn drop(&mut self) {
+        self.stop.store(true, AtomicOrdering::Relaxed);
+    }
+}
+
+/// A semantic version: MAJOR.MINOR.PATCH with an optional pre-release
+/// (`-rc.1`) and build metadata (`+abc`, ignored in comparisons)
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SemVer {
+    pub major: u64,
+    pub minor: u64,
+    pub patch: u64,
+    pub pre: Vec<String>,
+}
+
+impl SemVer {
+    /// Parse a version, with or without a leading "v"; None if it is not a
+    /// semantic version
+    pub fn parse(version: &str) -> Option<Self> {
+        let version = version.strip_prefix('v').unwrap_or(version);
+        let version = version.split_once('+').map_or(version, |(version, _)| version);
+        let (core, pre) = match version.split_once('-') {
+            Some((core, pre)) => (core, Some(pre)),
+            None => (version, None),
+        };
+
+        let mut numbers = core.split('.').map(|part| {
+            if part.is_empty() || (part.len() > 1 && part.starts_with('0')) {
+                return None;
+            }
+            part.parse::<u64>().ok()
+        });
+        let (major, minor, patch) = (numbers.next()??, numbers.next()??, numbers.next()??);
+        if numbers.next().is_some() {
+            return None;
+        }
+
+        let pre = match pre {
+            Some(pre) => {
+                let identifiers: Vec<String> = pre.split('.').map(str::to_string).collect();
+                let valid = identifiers.iter().all(|id| {
+                    !id.is_empty() && id.bytes().all(|b| b.is_ascii_alphanumeric() || b == b'-')
+                });
+                if !valid {
+                    return None;
+                }
+                identifiers
+            }
+            None => Vec::new(),
+        };
+        Some(Self { major, minor, patch, pre })
+    }
+}
+
+impl PartialOrd for SemVer {
+    fn partial_cmp(&self, other: &Self) -> Option<Ordering> {
+        Some(self.cmp(other))
+    }
+}
+
+impl Ord for SemVer {
+    fn cmp(&self, other: &Self) -> Ordering {
+        (self.major, self.minor, self.patch).cmp(&(other.major, other.minor, other.patch))
+            .then_with(|| match (self.pre.is_empty(), other.pre.is_empty()) {
+                // A pre-release comes before its release
+                (true, true) => Ordering::Equal,
+                (true, false) => Ordering::Greater,
+                (false, true) => Ordering::Less,
+                (false, false) => {
+                    for (a, b) in self.pre.iter().zip(&other.pre) {
+                        let order = match (a.parse::<u64>(), b.parse::<u64>()) {
+                            (Ok(a), Ok(b)) => a.cmp(&b),
+                            (Ok(_), Err(_)) => Ordering::Less,
+                            (Err(_), Ok(_)) => Ordering::Greater,
+                            (Err(_), Err(_)) => a.cmp(b),
+                        };
+                        if order != Ordering::Equal {
+                            return order;
+                        }
+                    }
+                    self.pre.len().cmp(&other.pre.len())
+                }
+            })
+    }
+}
+
+impl fmt::Display for SemVer {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}.{}.{}", self.major, self.minor, self.patch)?;
+        if !self.pre.is_empty() {
+            write!(f, "-{}", self.pre.join("."))?;
+        }
+        Ok(())
+    }
+}
+
+/// Warn when a rebuilt module does not declare a newer version, since its
+/// rows would carry the same version as those of the previous build
+fn check_version_bump(name: &str, from: &str, to: &str) {
+    if let (Some(old), Some(new)) = (SemVer::parse(from), SemVer::parse(to)) {
+        if new <= old {
+            tracing::warn!("Module '{}' was rebuilt with version {}, not newer than {}", name, to, from);
+        }
+    }
+}
+
+/// The `.wasm` files of a directory, sorted by path
+fn wasm_files(dir: &Path) -> Result<Vec<PathBuf>> {
+    let mut paths = Vec::new();
+    for entry in std::fs::read_dir(dir)? {
+        let path = entry?.path();
+        if path.extension().and_then(|s| s.to_str()) == Some("wasm") {
+            paths.push(path);
+        }
+    }
+    paths.sort();
+    Ok(paths)
+}
+
+fn module_name(path: &Path) -> String {
+    path.file_stem()
+        .and_then(|s| s.to_str())
+        .unwrap_or("unknown")
+        .to_string()
+}
+
+/// Compile a module file and read its manifest and version
+fn load_module_file(engine: &Engine, path: &Path) -> Result<ModuleInfo> {
+    let name = module_name(path);
+    let module = crate::precompile::load_module_with_cache(engine, path)?;
+
+    // Validate module exports - must have 'process' function
+    validate_module(&module)?;
+
+    let wasm_bytes = std::fs::read(path)?;
+    let manifest = crate::manifest::read_manifest(&wasm_bytes)
+        .map_err(|e| anyhow::anyhow!("Module {}: {}", name, e))?
+        .map(Arc::new);
+    let version = crate::manifest::module_version(manifest.as_deref(), &wasm_bytes);
+    let binary_hash = crate::hashing::hash_data("sha256", &wasm_bytes)?;
+    if manifest.as_ref().and_then(|m| m.version.as_deref()).is_some_and(|v| SemVer::parse(v).is_none()) {
+        tracing::warn!("Module {} declares version '{}', which is not a semantic version", name, version);
+    }
+    if manifest.is_some() {
+        tracing::info!("Loaded WASM module: {} {} (with trigger manifest)", name, version);
+    } else {
+        tracing::info!("Loaded WASM module: {} {}", name, version);
+    }
+    Ok(ModuleInfo { name, module, manifest, version, binary_hash, stage: 0 })
+}
+
+fn validate_module(module: &Module) -> Result<()> {
+    let has_process = module.exports()
+        .any(|export| export.name() == "process");
+
+    if has_process {
+        Ok(())
+    } else {
+        anyhow::bail!("Module missing required 'process' export. All WADUP modules must export a 'process' function.");
+    }
+}
+
+/// Order the modules by the stage their dependencies put them in
+fn order_by_stage(mut modules: Vec<ModuleInfo>) -> Result<Vec<ModuleInfo>> {
+    let stages = {
+        let entries: Vec<_> = modules.iter()
+            .map(|m| (m.name.as_str(), m.manifest.as_deref()))
+            .collect();
+        crate::schedule::module_stages(&entries)?
+    };
+    for (module_info, stage) in modules.iter_mut().zip(stages) {
+        module_info.stage = stage;
+    }
+    modules.sort_by_key(|m| m.stage);
+    Ok(modules)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// A minimal module exporting `process`, declaring a version in its manifest
+    fn module_bytes(version: &str) -> Vec<u8> {
+        let mut wasm = b"\0asm\x01\0\0\0".to_vec();
+        // Type, function, export and code sections for `process: () -> i32`
+        wasm.extend_from_slice(&[0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f]);
+        wasm.extend_from_slice(&[0x03, 0x02, 0x01, 0x00]);
+        wasm.extend_from_slice(&[0x07, 0x0b, 0x01, 0x07]);
+        wasm.extend_from_slice(b"process");
+        wasm.extend_from_slice(&[0x00, 0x00]);
+        wasm.extend_from_slice(&[0x0a, 0x06, 0x01, 0x04, 0x00, 0x41, 0x00, 0x0b]);
+
+        let manifest = format!(r#"{{"version":"{}"}}"#, version);
+        let name = crate::manifest::MANIFEST_SECTION.as_bytes();
+        wasm.push(0x00);
+        wasm.push((1 + name.len() + manifest.len()) as u8);
+        wasm.push(name.len() as u8);
+        wasm.extend_from_slice(name);
+        wasm.extend_from_slice(manifest.as_bytes());
+        wasm
+    }
+
+    fn registry(dir: &Path) -> ModuleRegistry {
+        let registry = ModuleRegistry::new(Engine::default(), ResourceLimits::default());
+        registry.load_dir(dir).unwrap();
+        registry
+    }
+
+    fn versions(registry: &ModuleRegistry) -> Vec<(String, String)> {
+        registry.modules().into_iter().map(|m| (m.name, m.version)).collect()
+    }
+
+    #[test]
+    fn test_semver_parse() {
+        assert_eq!(SemVer::parse("1.2.3"), Some(SemVer { major: 1, minor: 2, patch: 3, pre: vec![] }));
+        assert_eq!(SemVer::parse("v1.2.3+build.5").unwrap().to_string(), "1.2.3");
+        assert_eq!(SemVer::parse("1.0.0-rc.1").unwrap().pre, ["rc", "1"]);
+        assert_eq!(SemVer::parse("0.0.0-x-y").unwrap().to_string(), "0.0.0-x-y");
+        for invalid in ["", "1", "1.2", "1.2.3.4", "01.2.3", "1.2.x", "1.2.-3", "1.2.3-", "1.2.3-rc..1", "1.2.3-rc_1", "sha256:abc"] {
+            assert_eq!(SemVer::parse(invalid), None, "'{}' should not parse", invalid);
+        }
+    }
+
+    #[test]
+    fn test_semver_order() {
+        // The precedence example of the semantic versioning spec
+        let ordered = [
+            "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+            "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0",
+        ];
+        for pair in ordered.windows(2) {
+            let (a, b) = (SemVer::parse(pair[0]).unwrap(), SemVer::parse(pair[1]).unwrap());
+            assert!(a < b, "{} should come before {}", a, b);
+        }
+        // Build metadata doesn't count
+        assert_eq!(SemVer::parse("1.0.0+a").unwrap().cmp(&SemVer::parse("1.0.0+b").unwrap()), Ordering::Equal);
+    }
+
+    #[test]
+    fn test_reload_tracks_changes() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(dir.path().join("a.wasm"), module_bytes("1.0.0")).unwrap();
+        std::fs::write(dir.path().join("b.wasm"), module_bytes("2.0.0")).unwrap();
+        let registry = registry(dir.path());
+        let generation = registry.generation();
+        assert_eq!(versions(&registry), [("a".to_string(), "1.0.0".to_string()), ("b".to_string(), "2.0.0".to_string())]);
+
+        // Nothing changed
+        assert!(registry.reload().unwrap().is_empty());
+        assert_eq!(registry.generation(), generation);
+
+        // A rebuild, a new module and a removed one are all one change of the set
+        std::fs::write(dir.path().join("a.wasm"), module_bytes("1.10.0")).unwrap();
+        std::fs::write(dir.path().join("c.wasm"), module_bytes("0.1.0")).unwrap();
+        std::fs::remove_file(dir.path().join("b.wasm")).unwrap();
+        let changes = registry.reload().unwrap();
+        assert_eq!(changes, [
+            ModuleChange::Updated { name: "a".to_string(), from: "1.0.0".to_string(), to: "1.10.0".to_string() },
+            ModuleChange::Added { name: "c".to_string(), version: "0.1.0".to_string() },
+            ModuleChange::Removed { name: "b".to_string() },
+        ]);
+        assert_eq!(registry.generation(), generation + 1);
+        assert_eq!(versions(&registry), [("a".to_string(), "1.10.0".to_string()), ("c".to_string(), "0.1.0".to_string())]);
+        assert_eq!(registry.versions("a"), ["1.0.0", "1.10.0"]);
+    }
+
+    #[test]
+    fn test_reload_keeps_modules_that_fail_to_load() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(dir.path().join("a.wasm"), module_bytes("1.0.0")).unwrap();
+        let registry = registry(dir.path());
+        let generation = registry.generation();
+
+        std::fs::write(dir.path().join("a.wasm"), b"not a module").unwrap();
+        std::fs::write(dir.path().join("broken.wasm"), b"not a module either").unwrap();
+        assert!(registry.reload().unwrap().is_empty());
+        assert_eq!(registry.generation(), generation);
+        assert_eq!(versions(&registry), [("a".to_string(), "1.0.0".to_string())]);
+
+        // Removing the last module keeps the loaded set
+        std::fs::remove_file(dir.path().join("a.wasm")).unwrap();
+        std::fs::remove_file(dir.path().join("broken.wasm")).unwrap();
+        assert!(registry.reload().is_err());
+        assert_eq!(versions(&registry), [("a".to_string(), "1.0.0".to_string())]);
+    }
+
+    #[test]
+    fn test_reset_keeps_module_identity() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(dir.path().join("a.wasm"), module_bytes("1.2.0")).unwrap();
+        let registry = registry(dir.path());
+        let (_, mut instances) = registry.create_instances(MetadataStore::new_dummy()).unwrap();
+        let instance = &mut instances[0];
+        let hash = instance.binary_hash().to_string();
+        assert!(!hash.is_empty());
+
+        // Rows written after a trap must still carry the module's provenance
+        instance.reset().unwrap();
+        assert_eq!(instance.name(), "a");
+        assert_eq!(instance.version(), "1.2.0");
+        assert_eq!(instance.binary_hash(), hash);
+    }
+
+    #[test]
+    fn test_reload_needs_a_loaded_directory() {
+        let registry = ModuleRegistry::new(Engine::default(), ResourceLimits::default());
+        assert!(registry.reload().is_err());
+        let dir = tempfile::tempdir().unwrap();
+        assert!(registry.load_dir(dir.path()).is_err());
+    }
+}
@@ -0,0 +1,185 @@
+//! Caps on the rows a module stores per table for one content item.
+//!
+//! A single pathological input can make a parser emit millions of rows, e.g.
+//! every printable run of a large binary. With a cap, the first `head` rows
+//! of each table are kept, followed by a uniform reservoir sample of
+//! `sample` of the remaining rows, and the table is reported as truncated.
+//! Samples are seeded from the content's fingerprint, so re-runs keep the
+//! same rows.
+
+use rand::rngs::StdRng;
+use rand::{Rng, SeedableRng};
+use std::collections::HashMap;
+use std::fmt;
+
+/// Rows kept per table, per module, per content item
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct RowCap {
+    /// Leading rows kept as they are
+    pub head: usize,
+    /// Rows sampled uniformly from those after the head
+    pub sample: usize,
+}
+
+impl RowCap {
+    pub fn new(head: usize, sample: usize) -> Self {
+        Self { head, sample }
+    }
+
+    /// Most rows kept for one table
+    pub fn max_rows(&self) -> usize {
+        self.head.saturating_add(self.sample)
+    }
+}
+
+/// A table whose rows were cut down to the cap
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RowTruncation {
+    pub table: String,
+    /// Rows the module produced
+    pub total_rows: usize,
+    /// Rows kept
+    pub kept_rows: usize,
+}
+
+impl RowTruncation {
+    /// Rows dropped
+    pub fn dropped_rows(&self) -> usize {
+        self.total_rows - self.kept_rows
+    }
+}
+
+impl fmt::Display for RowTruncation {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "table '{}' kept {} of {} rows", self.table, self.kept_rows, self.total_rows)
+    }
+}
+
+/// Apply the cap to rows of several tables, keeping the kept rows in their
+/// original order. Returns the kept rows and the tables that were truncated.
+pub fn cap_rows<T>(
+    rows: Vec<T>,
+    table_of: impl Fn(&T) -> &str,
+    cap: RowCap,
+    seed: u64,
+) -> (Vec<T>, Vec<RowTruncation>) {
+    #[derive(Default)]
+    struct TableState {
+        seen: usize,
+        reservoir: Vec<usize>,
+    }
+
+    let mut rng = StdRng::seed_from_u64(seed);
+    let mut keep = vec![false; rows.len()];
+    let mut tables: HashMap<&str, TableState> = HashMap::new();
+    let mut order = Vec::new();
+
+    for (i, row) in rows.iter().enumerate() {
+        let name = table_of(row);
+        let state = tables.entry(name).or_insert_with(|| {
+            order.push(name);
+            TableState::default()
+        });
+        state.seen += 1;
+        if state.seen <= cap.head {
+            keep[i] = true;
+            continue;
+        }
+
+        // Reservoir sampling over the rows after the head
+        let position = state.seen - cap.head;
+        if state.reservoir.len() < cap.sample {
+            state.reservoir.push(i);
+        } else if cap.sample > 0 {
+            let slot = rng.gen_range(0..position);
+            if slot < cap.sample {
+                state.reservoir[slot] = i;
+            }
+        }
+    }
+
+    let mut truncations = Vec::new();
+    for name in order {
+        let state = &tables[name];
+        for &i in &state.reservoir {
+            keep[i] = true;
+        }
+        if state.seen > cap.max_rows() {
+            truncations.push(RowTruncation {
+                table: name.to_string(),
+                total_rows: state.seen,
+                kept_rows: cap.max_rows(),
+            });
+        }
+    }
+
+    let kept = rows.into_iter()
+        .zip(keep)
+        .filter_map(|(row, keep)| keep.then_some(row))
+        .collect();
+    (kept, truncations)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Rows of two interleaved tables, numbered within their table
+    fn rows(a: usize, b: usize) -> Vec<(&'static str, usize)> {
+        let mut rows: Vec<_> = (0..a).map(|i| ("a", i)).collect();
+        for i in 0..b {
+            rows.insert((2 * i + 1).min(rows.len()), ("b", i));
+        }
+        rows
+    }
+
+    fn kept(rows: &[(&str, usize)], table: &str) -> Vec<usize> {
+        rows.iter().filter(|(t, _)| *t == table).map(|(_, i)| *i).collect()
+    }
+
+    #[test]
+    fn test_tables_within_the_cap_are_untouched() {
+        let input = rows(5, 3);
+        let (output, truncations) = cap_rows(input.clone(), |(t, _)| t, RowCap::new(3, 2), 1);
+        assert_eq!(output, input);
+        assert!(truncations.is_empty());
+    }
+
+    #[test]
+    fn test_head_and_reservoir() {
+        let (output, truncations) = cap_rows(rows(100, 4), |(t, _)| t, RowCap::new(3, 5), 7);
+
+        // The head is kept, followed by a sample of the rest in their original order
+        let a = kept(&output, "a");
+        assert_eq!(a.len(), 8);
+        assert_eq!(a[..3], [0, 1, 2]);
+        assert!(a.windows(2).all(|w| w[0] < w[1]));
+        assert_eq!(kept(&output, "b"), [0, 1, 2, 3]);
+
+        assert_eq!(truncations, [RowTruncation { table: "a".to_string(), total_rows: 100, kept_rows: 8 }]);
+        assert_eq!(truncations[0].dropped_rows(), 92);
+        assert_eq!(truncations[0].to_string(), "table 'a' kept 8 of 100 rows");
+    }
+
+    #[test]
+    fn test_head_only_and_sample_only() {
+        let (output, truncations) = cap_rows(rows(10, 0), |(t, _)| t, RowCap::new(4, 0), 7);
+        assert_eq!(kept(&output, "a"), [0, 1, 2, 3]);
+        assert_eq!(truncations[0].kept_rows, 4);
+
+        let (output, _) = cap_rows(rows(10, 0), |(t, _)| t, RowCap::new(0, 4), 7);
+        assert_eq!(kept(&output, "a").len(), 4);
+    }
+
+    #[test]
+    fn test_sample_follows_the_seed() {
+        let sample = |seed| kept(&cap_rows(rows(1000, 0), |(t, _)| t, RowCap::new(0, 10), seed).0, "a");
+        assert_eq!(sample(42), sample(42));
+        assert_ne!(sample(42), sample(43));
+    }
+
+    #[test]
+    fn test_max_rows_saturates() {
+        assert_eq!(RowCap::new(usize::MAX, 1).max_rows(), usize::MAX);
+    }
+}
@@ -0,0 +1,57 @@
+use anyhow::Result;
+use regex::bytes::Regex;
+use serde::{Deserialize, Serialize};
+
+/// A pattern from a guest's `scan_content` call: a regular expression, or a
+/// literal byte sequence given as hex
+#[derive(Debug, Deserialize)]
+pub struct ScanPattern {
+    #[serde(default)]
+    pub regex: Option<String>,
+    #[serde(default)]
+    pub hex: Option<String>,
+}
+
+/// A match returned to the guest, identifying the pattern by index
+#[derive(Debug, Serialize)]
+pub struct ScanMatch {
+    pub pattern: usize,
+    pub offset: u64,
+    pub length: u64,
+}
+
+/// Compile a pattern. Literal bytes become an escaped regex so both kinds
+/// share one matcher.
+fn compile(pattern: &ScanPattern) -> Result<Regex> {
+    match (&pattern.regex, &pattern.hex) {
+        (Some(regex), _) => Ok(Regex::new(regex)?),
+        (None, Some(literal)) => {
+            let mut escaped = String::from("(?-u)");
+            for byte in hex::decode(literal)? {
+                escaped.push_str(&format!("\\x{:02x}", byte));
+            }
+            Ok(Regex::new(&escaped)?)
+        }
+        (None, None) => anyhow::bail!("Pattern has neither a regex nor bytes"),
+    }
+}
+
+/// Find all non-overlapping, non-empty matches of each pattern in data
+pub fn scan(patterns: &[ScanPattern], data: &[u8]) -> Result<Vec<ScanMatch>> {
+    let compiled = patterns.iter().map(compile).collect::<Result<Vec<_>>>()?;
+
+    let mut matches = Vec::new();
+    for (index, regex) in compiled.iter().enumerate() {
+        for m in regex.find_iter(data) {
+            if m.end() > m.start() {
+                matches.push(ScanMatch {
+                    pattern: index,
+                    offset: m.start() as u64,
+                    length: (m.end() - m.start()) as u64,
+                });
+            }
+        }
+    }
+    matches.sort_by_key(|m| (m.offset, m.pattern));
+    Ok(matches)
+}
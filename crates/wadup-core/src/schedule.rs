@@ -0,0 +1,83 @@
+//! Execution order of modules on each content item.
+//!
+//! Manifests can declare that a module runs `after` other modules, or that it
+//! `requires_tables` written by other modules (those listing the table in
+//! their own `tables`). Modules are grouped into stages: every module runs in
+//! a later stage than the modules it depends on, so it can read their rows
+//! through `query_metadata`. Modules in the same stage may run concurrently.
+
+use crate::manifest::ModuleManifest;
+use anyhow::Result;
+use std::collections::HashMap;
+
+/// Stage of each module, in the order given. Dependencies on modules that
+/// aren't loaded are ignored with a warning; cycles are an error.
+pub fn module_stages(modules: &[(&str, Option<&ModuleManifest>)]) -> Result<Vec<usize>> {
+    let index: HashMap<&str, usize> = modules.iter()
+        .enumerate()
+        .map(|(i, (name, _))| (*name, i))
+        .collect();
+
+    let mut producers: HashMap<&str, Vec<usize>> = HashMap::new();
+    for (i, (_, manifest)) in modules.iter().enumerate() {
+        for table in manifest.iter().flat_map(|m| &m.tables) {
+            producers.entry(table.as_str()).or_default().push(i);
+        }
+    }
+
+    let mut dependencies: Vec<Vec<usize>> = vec![Vec::new(); modules.len()];
+    for (i, (name, manifest)) in modules.iter().enumerate() {
+        let Some(manifest) = manifest else {
+            continue;
+        };
+        for other in &manifest.after {
+            match index.get(other.as_str()) {
+                Some(&j) => dependencies[i].push(j),
+                None => tracing::warn!("Module {} runs after '{}', which is not loaded", name, other),
+            }
+        }
+        for table in &manifest.requires_tables {
+            match producers.get(table.as_str()) {
+                Some(writers) => dependencies[i].extend(writers.iter().filter(|&&j| j != i)),
+                None => tracing::warn!("Module {} requires table '{}', which no loaded module writes", name, table),
+            }
+        }
+    }
+
+    let mut stages = vec![None; modules.len()];
+    for i in 0..modules.len() {
+        stage_of(i, modules, &dependencies, &mut stages, &mut Vec::new())?;
+    }
+    Ok(stages.into_iter().map(|stage| stage.unwrap_or(0)).collect())
+}
+
+/// Stage of a module: one past the latest stage of its dependencies.
+/// `path` holds the modules being resolved, to detect cycles.
+fn stage_of(
+    i: usize,
+    modules: &[(&str, Option<&ModuleManifest>)],
+    dependencies: &[Vec<usize>],
+    stages: &mut [Option<usize>],
+    path: &mut Vec<usize>,
+) -> Result<usize> {
+    if let Some(stage) = stages[i] {
+        return Ok(stage);
+    }
+    if let Some(start) = path.iter().position(|&j| j == i) {
+        let cycle: Vec<&str> = path[start..].iter()
+            .chain(std::iter::once(&i))
+            .map(|&j| modules[j].0)
+            .collect();
+        anyhow::bail!("Module dependency cycle: {}", cycle.join(" -> "));
+    }
+
+    path.push(i);
+    let mut stage = 0;
+    for &j in &dependencies[i] {
+        stage = stage.max(stage_of(j, modules, dependencies, stages, path)? + 1);
+    }
+    path.pop();
+
+    stages[i] = Some(stage);
+    Ok(stage)
+}
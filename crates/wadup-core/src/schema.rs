@@ -0,0 +1,126 @@
+use std::collections::HashMap;
+use std::fmt;
+use crate::bindings_types::{Column, TableSchema};
+
+/// A column of a registered table and the module that introduced it
+#[derive(Debug, Clone)]
+struct RegisteredColumn {
+    column: Column,
+    module: String,
+}
+
+/// Outcome of registering a table definition
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum SchemaChange {
+    /// First definition of the table
+    Created,
+    /// Compatible with the registered schema, nothing added
+    Unchanged,
+    /// New nullable columns were appended to the registered schema
+    Extended(Vec<String>),
+}
+
+/// A table definition that can't be merged with the registered schema
+#[derive(Debug, Clone)]
+pub struct SchemaConflict {
+    pub table: String,
+    pub column: String,
+    /// Module whose definition was rejected
+    pub module: String,
+    /// Module that defined the conflicting column
+    pub other_module: String,
+    pub reason: String,
+}
+
+impl fmt::Display for SchemaConflict {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "Schema conflict in table '{}', column '{}': {} (module '{}' conflicts with module '{}')",
+            self.table, self.column, self.reason, self.module, self.other_module
+        )
+    }
+}
+
+impl std::error::Error for SchemaConflict {}
+
+/// Table schemas shared by all modules of a run.
+///
+/// Modules writing to the same table must agree on it: a column defined by
+/// several modules must have the same type everywhere. A module may add
+/// columns to a table another module created as long as they are nullable,
+/// and may leave out nullable columns, so schemas can evolve additively.
+#[derive(Debug, Default)]
+pub struct SchemaRegistry {
+    tables: HashMap<String, Vec<RegisteredColumn>>,
+}
+
+impl SchemaRegistry {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Merged columns of a registered table, in the order they were registered
+    pub fn columns(&self, table: &str) -> Option<Vec<Column>> {
+        self.tables.get(table).map(|registered| registered.iter().map(|r| r.column.clone()).collect())
+    }
+
+    /// Register a module's definition of a table, merging it into the
+    /// registered schema. Nothing is changed if it conflicts.
+    pub fn register(&mut self, module: &str, schema: &TableSchema) -> Result<SchemaChange, SchemaConflict> {
+        let Some(registered) = self.tables.get_mut(&schema.name) else {
+            let columns = schema.columns.iter()
+                .map(|column| RegisteredColumn { column: column.clone(), module: module.to_string() })
+                .collect();
+            self.tables.insert(schema.name.clone(), columns);
+            return Ok(SchemaChange::Created);
+        };
+
+        let conflict = |column: &str, other_module: &str, reason: String| SchemaConflict {
+            table: schema.name.clone(),
+            column: column.to_string(),
+            module: module.to_string(),
+            other_module: other_module.to_string(),
+            reason,
+        };
+
+        let mut added = Vec::new();
+        for column in &schema.columns {
+            match registered.iter().find(|r| r.column.name == column.name) {
+                Some(existing) if existing.column.data_type != column.data_type => {
+                    return Err(conflict(
+                        &column.name,
+                        &existing.module,
+                        format!("defined as {:?}, already registered as {:?}", column.data_type, existing.column.data_type),
+                    ));
+                }
+                Some(_) => {}
+                None if !column.nullable => {
+                    return Err(conflict(
+                        &column.name,
+                        registered.first().map_or(module, |r| r.module.as_str()),
+                        "new columns of an existing table must be nullable".to_string(),
+                    ));
+                }
+                None => added.push(column),
+            }
+        }
+        if let Some(missing) = registered.iter()
+            .find(|r| !r.column.nullable && !schema.columns.iter().any(|c| c.name == r.column.name))
+        {
+            return Err(conflict(
+                &missing.column.name,
+                &missing.module,
+                "required column is missing from the definition".to_string(),
+            ));
+        }
+
+        if added.is_empty() {
+            return Ok(SchemaChange::Unchanged);
+        }
+        let names = added.iter().map(|c| c.name.clone()).collect();
+        registered.extend(added.into_iter()
+            .map(|column| RegisteredColumn { column: column.clone(), module: module.to_string() }));
+        Ok(SchemaChange::Extended(names))
+    }
+}
@@ -0,0 +1,144 @@
+//! Candidate passwords and keys offered to modules.
+//!
+//! Secrets come from configuration or are harvested from the rows modules
+//! write to credentials tables, so a password found in one file can be tried
+//! on an encrypted archive found later. Modules read them by kind through
+//! the `get_secrets` import.
+
+use crate::bindings_types::{TableSchema, Value};
+use anyhow::{Context, Result};
+use parking_lot::RwLock;
+use std::collections::HashMap;
+use std::path::Path;
+use std::str::FromStr;
+use std::sync::Arc;
+
+/// Most secrets kept per kind; later ones are dropped
+pub const MAX_SECRETS_PER_KIND: usize = 10_000;
+
+/// Kind of secret most parsers ask for
+pub const SECRET_KIND_PASSWORD: &str = "password";
+
+/// A table column whose values are harvested as secrets
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SecretSource {
+    pub table: String,
+    pub column: String,
+    pub kind: String,
+}
+
+impl FromStr for SecretSource {
+    type Err = anyhow::Error;
+
+    /// Parse `TABLE:COLUMN` or `TABLE:COLUMN:KIND` (the kind defaults to
+    /// "password")
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        let mut parts = s.splitn(3, ':');
+        let table = parts.next().unwrap_or_default();
+        let column = parts.next().unwrap_or_default();
+        let kind = parts.next().unwrap_or(SECRET_KIND_PASSWORD);
+        if table.is_empty() || column.is_empty() || kind.is_empty() {
+            anyhow::bail!("Invalid secrets table '{}': expected TABLE:COLUMN[:KIND]", s);
+        }
+        Ok(Self { table: table.to_string(), column: column.to_string(), kind: kind.to_string() })
+    }
+}
+
+#[derive(Default)]
+struct SecretsInner {
+    secrets: HashMap<String, Vec<String>>,
+    sources: Vec<SecretSource>,
+    /// Positions of harvested columns, keyed by (module, table)
+    columns: HashMap<(String, String), Vec<(usize, String)>>,
+}
+
+/// Secrets shared by every module instance in a run. Deliberately not
+/// `Debug`, so secrets don't end up in logs.
+#[derive(Clone, Default)]
+pub struct SecretStore {
+    inner: Arc<RwLock<SecretsInner>>,
+}
+
+impl SecretStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Add a secret of the given kind, ignoring empty values and duplicates
+    pub fn add(&self, kind: &str, value: &str) {
+        self.inner.write().add(kind, value);
+    }
+
+    /// Add the secrets in a JSON file of the form
+    /// `{"password": ["infected", ...], "key": [...]}`
+    pub fn load_file(&self, path: &Path) -> Result<()> {
+        let text = std::fs::read_to_string(path)
+            .with_context(|| format!("Failed to read secrets file {:?}", path))?;
+        let secrets: HashMap<String, Vec<String>> = serde_json::from_str(&text)
+            .with_context(|| format!("Invalid secrets file {:?}", path))?;
+        let mut inner = self.inner.write();
+        for (kind, values) in &secrets {
+            for value in values {
+                inner.add(kind, value);
+            }
+        }
+        Ok(())
+    }
+
+    /// Harvest the values of a table column as secrets
+    pub fn add_source(&self, source: SecretSource) {
+        self.inner.write().sources.push(source);
+    }
+
+    /// Whether any table column is harvested
+    pub fn has_sources(&self) -> bool {
+        !self.inner.read().sources.is_empty()
+    }
+
+    /// Secrets of a kind, in the order they were added
+    pub fn get(&self, kind: &str) -> Vec<String> {
+        self.inner.read().secrets.get(kind).cloned().unwrap_or_default()
+    }
+
+    /// Note where a module's table keeps the harvested columns
+    pub fn observe_schema(&self, module_name: &str, schema: &TableSchema) {
+        let mut inner = self.inner.write();
+        let columns: Vec<(usize, String)> = inner.sources.iter()
+            .filter(|source| source.table == schema.name)
+            .filter_map(|source| {
+                schema.columns.iter()
+                    .position(|column| column.name == source.column)
+                    .map(|position| (position, source.kind.clone()))
+            })
+            .collect();
+        if !columns.is_empty() {
+            inner.columns.insert((module_name.to_string(), schema.name.clone()), columns);
+        }
+    }
+
+    /// Add the harvested columns of a row a module wrote
+    pub fn harvest(&self, module_name: &str, table: &str, values: &[Value]) {
+        let key = (module_name.to_string(), table.to_string());
+        let mut inner = self.inner.write();
+        let Some(columns) = inner.columns.get(&key).cloned() else {
+            return;
+        };
+        for (position, kind) in columns {
+            if let Some(Value::String(value)) = values.get(position) {
+                inner.add(&kind, value);
+            }
+        }
+    }
+}
+
+impl SecretsInner {
+    fn add(&mut self, kind: &str, value: &str) {
+        if value.is_empty() {
+            return;
+        }
+        let values = self.secrets.entry(kind.to_string()).or_default();
+        if values.len() < MAX_SECRETS_PER_KIND && !values.iter().any(|v| v == value) {
+            values.push(value.to_string());
+        }
+    }
+}
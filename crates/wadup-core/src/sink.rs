@@ -0,0 +1,44 @@
+//! Destinations for table definitions and rows besides Elasticsearch.
+//!
+//! The metadata store hands every table it registers and every row it stores
+//! to its sinks as well. A table's definition is passed on with its schema
+//! merged across all modules writing to it, so a sink sees one set of columns
+//! per table even when several modules define it with different subsets.
+
+use anyhow::Result;
+use crate::bindings_types::{Column, Value};
+
+/// A row as handed to a sink, with values in the column order of the module
+/// that wrote it
+#[derive(Debug, Clone, Copy)]
+pub struct SinkRow<'a> {
+    /// Name the table is stored under
+    pub table: &'a str,
+    pub content_uuid: &'a str,
+    pub module_name: &'a str,
+    /// Column names of the writing module's definition of the table
+    pub columns: &'a [String],
+    pub values: &'a [Value],
+}
+
+impl SinkRow<'_> {
+    /// Value of the named column, None if the writing module didn't define it
+    pub fn value(&self, column: &str) -> Option<&Value> {
+        let index = self.columns.iter().position(|name| name == column)?;
+        self.values.get(index)
+    }
+}
+
+/// Receives the tables and rows the metadata store records. Sinks are shared
+/// by all worker threads.
+pub trait MetadataSink: Send + Sync {
+    /// A table was created or extended. `columns` is its merged schema, in the
+    /// order the columns were registered; extensions only append columns.
+    fn define_table(&self, table: &str, columns: &[Column]) -> Result<()>;
+
+    /// Store a row of a table passed to `define_table` before
+    fn insert_row(&self, row: &SinkRow) -> Result<()>;
+
+    /// Write out anything still buffered, once processing has finished
+    fn finish(&self) -> Result<()>;
+}
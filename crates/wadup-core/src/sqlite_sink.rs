@@ -0,0 +1,226 @@
+//! SQLite output for table definitions and rows.
+//!
+//! All tables of a run go into one database file, so small deployments can
+//! query results without running Elasticsearch. Each table is created when
+//! it is first defined, with `_content_id` and `_module` columns in front of
+//! its own, and an index on `_content_id`. Columns added by later
+//! definitions are added with ALTER TABLE. Opening an existing database adds
+//! to its tables.
+//!
+//! Int64 and Duration columns are stored as INTEGER (Durations in
+//! nanoseconds), Float64 as REAL, Boolean as INTEGER 0 or 1, and Bytes as
+//! BLOB. All other types are stored as TEXT in the form the Elasticsearch
+//! row documents use, e.g. RFC 3339 for Timestamps.
+
+use std::collections::HashMap;
+use std::path::Path;
+use std::sync::Mutex;
+use anyhow::{Context, Result};
+use rusqlite::types::Value as SqlValue;
+use rusqlite::Connection;
+use crate::bindings_types::{Column, DataType, Value};
+use crate::metadata::column_text;
+use crate::sink::{MetadataSink, SinkRow};
+
+/// Rows written per transaction
+const ROWS_PER_TRANSACTION: usize = 10_000;
+
+/// Writes all tables of a run into one SQLite database
+pub struct SqliteSink {
+    state: Mutex<SqliteState>,
+}
+
+struct SqliteState {
+    conn: Connection,
+    /// Columns of each table created so far, by table name
+    tables: HashMap<String, Vec<String>>,
+    /// Rows written since the open transaction began
+    pending: usize,
+}
+
+impl SqliteSink {
+    /// Open or create the database at `path`
+    pub fn new(path: &Path) -> Result<Self> {
+        let conn = Connection::open(path)
+            .with_context(|| format!("Failed to open SQLite database {}", path.display()))?;
+        conn.execute_batch("PRAGMA journal_mode = WAL; PRAGMA synchronous = NORMAL; BEGIN;")?;
+        Ok(Self {
+            state: Mutex::new(SqliteState {
+                conn,
+                tables: HashMap::new(),
+                pending: 0,
+            }),
+        })
+    }
+}
+
+impl SqliteState {
+    /// Names of the columns a table already has in the database
+    fn existing_columns(&self, table: &str) -> Result<Vec<String>> {
+        let mut statement = self.conn.prepare(&format!("PRAGMA table_info({})", quote(table)))?;
+        let names = statement.query_map([], |row| row.get::<_, String>(1))?
+            .collect::<rusqlite::Result<Vec<_>>>()?;
+        Ok(names)
+    }
+}
+
+impl MetadataSink for SqliteSink {
+    fn define_table(&self, table: &str, columns: &[Column]) -> Result<()> {
+        let mut state = self.state.lock().unwrap();
+        if !state.tables.contains_key(table) {
+            state.conn.execute_batch(&format!(
+                "CREATE TABLE IF NOT EXISTS {table} (\"_content_id\" TEXT NOT NULL, \"_module\" TEXT NOT NULL);
+                 CREATE INDEX IF NOT EXISTS {index} ON {table} (\"_content_id\");",
+                table = quote(table),
+                index = quote(&format!("{}__content_id", table)),
+            ))?;
+            let existing = state.existing_columns(table)?;
+            state.tables.insert(table.to_string(), existing);
+        }
+
+        let known = &state.tables[table];
+        let added: Vec<&Column> = columns.iter()
+            .filter(|column| !known.contains(&column.name))
+            .collect();
+        for column in &added {
+            state.conn.execute_batch(&format!(
+                "ALTER TABLE {} ADD COLUMN {} {}",
+                quote(table),
+                quote(&column.name),
+                sql_type(&column.data_type),
+            ))?;
+        }
+        state.tables.get_mut(table).unwrap()
+            .extend(added.into_iter().map(|column| column.name.clone()));
+        Ok(())
+    }
+
+    fn insert_row(&self, row: &SinkRow) -> Result<()> {
+        let mut state = self.state.lock().unwrap();
+        if !state.tables.contains_key(row.table) {
+            anyhow::bail!("Table '{}' was not defined for the SQLite sink", row.table);
+        }
+
+        let mut names = vec![quote("_content_id"), quote("_module")];
+        names.extend(row.columns.iter().map(|name| quote(name)));
+        let placeholders = vec!["?"; names.len()].join(", ");
+        let sql = format!("INSERT INTO {} ({}) VALUES ({})", quote(row.table), names.join(", "), placeholders);
+
+        let mut values = vec![
+            SqlValue::Text(row.content_uuid.to_string()),
+            SqlValue::Text(row.module_name.to_string()),
+        ];
+        values.extend(row.values.iter().map(sql_value));
+        state.conn.prepare_cached(&sql)?.execute(rusqlite::params_from_iter(values))?;
+
+        state.pending += 1;
+        if state.pending >= ROWS_PER_TRANSACTION {
+            state.conn.execute_batch("COMMIT; BEGIN;")?;
+            state.pending = 0;
+        }
+        Ok(())
+    }
+
+    fn finish(&self) -> Result<()> {
+        let mut state = self.state.lock().unwrap();
+        state.conn.execute_batch("COMMIT; BEGIN;")?;
+        state.pending = 0;
+        Ok(())
+    }
+}
+
+/// Quote an identifier for use in SQL
+fn quote(name: &str) -> String {
+    format!("\"{}\"", name.replace('"', "\"\""))
+}
+
+/// SQLite type a column is declared with
+fn sql_type(data_type: &DataType) -> &'static str {
+    match data_type {
+        DataType::Int64 | DataType::Duration | DataType::Boolean => "INTEGER",
+        DataType::Float64 => "REAL",
+        DataType::Bytes | DataType::BytesRef => "BLOB",
+        _ => "TEXT",
+    }
+}
+
+/// SQLite value a row value is stored as
+fn sql_value(value: &Value) -> SqlValue {
+    match value {
+        Value::Int64(v) | Value::Duration(v) => SqlValue::Integer(*v),
+        Value::Float64(v) => SqlValue::Real(*v),
+        Value::Boolean(v) => SqlValue::Integer(*v as i64),
+        Value::Bytes(v) => SqlValue::Blob(v.clone()),
+        _ => column_text(value).map_or(SqlValue::Null, SqlValue::Text),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn column(name: &str, data_type: DataType) -> Column {
+        Column {
+            name: name.to_string(),
+            data_type,
+            nullable: true,
+            primary_key: false,
+            unique: false,
+            dictionary: false,
+        }
+    }
+
+    #[test]
+    fn test_quote_escapes_identifiers() {
+        assert_eq!(quote("pe_sections"), "\"pe_sections\"");
+        assert_eq!(quote("a\"b"), "\"a\"\"b\"");
+    }
+
+    #[test]
+    fn test_sql_values_keep_types() {
+        assert_eq!(sql_value(&Value::Int64(7)), SqlValue::Integer(7));
+        assert_eq!(sql_value(&Value::Boolean(true)), SqlValue::Integer(1));
+        assert_eq!(sql_value(&Value::Bytes(vec![1, 2])), SqlValue::Blob(vec![1, 2]));
+        assert_eq!(sql_value(&Value::String("x".to_string())), SqlValue::Text("x".to_string()));
+        assert_eq!(sql_value(&Value::Null), SqlValue::Null);
+    }
+
+    #[test]
+    fn test_tables_are_created_and_extended() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("results.db");
+        let sink = SqliteSink::new(&path).unwrap();
+
+        sink.define_table("files", &[column("name", DataType::String)]).unwrap();
+        let names = vec!["name".to_string()];
+        sink.insert_row(&SinkRow {
+            table: "files",
+            content_uuid: "c1",
+            module_name: "m1",
+            columns: &names,
+            values: &[Value::String("a.txt".to_string())],
+        }).unwrap();
+
+        // A second module adds a column
+        sink.define_table("files", &[column("name", DataType::String), column("size", DataType::Int64)]).unwrap();
+        let names = vec!["size".to_string(), "name".to_string()];
+        sink.insert_row(&SinkRow {
+            table: "files",
+            content_uuid: "c2",
+            module_name: "m2",
+            columns: &names,
+            values: &[Value::Int64(42), Value::String("b.txt".to_string())],
+        }).unwrap();
+        sink.finish().unwrap();
+
+        let conn = Connection::open(&path).unwrap();
+        let rows: Vec<(String, String, Option<i64>)> = conn
+            .prepare("SELECT _content_id, name, size FROM files ORDER BY _content_id").unwrap()
+            .query_map([], |row| Ok((row.get(0)?, row.get(1)?, row.get(2)?))).unwrap()
+            .collect::<rusqlite::Result<_>>().unwrap();
+        assert_eq!(rows, vec![
+            ("c1".to_string(), "a.txt".to_string(), None),
+            ("c2".to_string(), "b.txt".to_string(), Some(42)),
+        ]);
+    }
+}
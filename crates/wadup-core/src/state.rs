@@ -0,0 +1,289 @@
+//! Record of the work done by earlier runs, so a re-run over the same inputs
+//! skips it.
+//!
+//! Each module run that finishes on a content item is recorded as a (content
+//! hash, module, module version) entry in an append-only JSON-lines file,
+//! together with the SHA-256 of the module's binary. Entries only match the
+//! binary that made them: when a module is rebuilt, even without changing its
+//! declared version, its entries are dropped and it runs again on everything.
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::fs::{File, OpenOptions};
+use std::io::{BufRead, BufReader, BufWriter, Write};
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+
+/// One line of the state file: a module finished on a content item
+#[derive(Debug, Clone, Serialize, Deserialize)]
+struct StateEntry {
+    content: String,
+    module: String,
+    version: String,
+    /// SHA-256 of the module binary that ran
+    binary: String,
+    /// The run emitted sub-content
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    emitted: bool,
+}
+
+/// Key of a finished module run
+type DoneKey = (String, String, String);
+
+/// What is known about a finished run besides its key
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct DoneRun {
+    binary: String,
+    emitted: bool,
+}
+
+struct StateInner {
+    file: BufWriter<File>,
+    done: HashMap<DoneKey, DoneRun>,
+}
+
+/// A module's previous run on a content item
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct PreviousRun {
+    /// The run emitted sub-content, so it has to run again for the children
+    /// to be processed by modules that have not seen them
+    pub emitted: bool,
+}
+
+/// Persistent record of finished (content, module, version) runs, shared by
+/// all worker threads.
+#[derive(Clone)]
+pub struct ProcessingState {
+    path: PathBuf,
+    inner: Arc<Mutex<StateInner>>,
+}
+
+impl ProcessingState {
+    /// Open the state file at `path`, creating it if needed, for the given
+    /// modules as (name, binary hash) pairs. Entries made by other binaries of
+    /// these modules are dropped; entries of modules not loaded are kept.
+    /// With `force` all earlier entries are discarded, so everything is
+    /// processed again.
+    ///
+    /// Returns the state and the modules whose entries were invalidated.
+    pub fn open(path: &Path, modules: &[(String, String)], force: bool) -> Result<(Self, Vec<String>)> {
+        let mut done: HashMap<DoneKey, DoneRun> = HashMap::new();
+        if !force && path.exists() {
+            let file = File::open(path)
+                .with_context(|| format!("Failed to open state file {}", path.display()))?;
+            for (number, line) in BufReader::new(file).lines().enumerate() {
+                let line = line?;
+                if line.trim().is_empty() {
+                    continue;
+                }
+                // A line cut short by an interrupted run is ignored
+                let entry: StateEntry = match serde_json::from_str(&line) {
+                    Ok(entry) => entry,
+                    Err(e) => {
+                        tracing::warn!("Ignoring line {} of state file {}: {}", number + 1, path.display(), e);
+                        continue;
+                    }
+                };
+                done.insert(
+                    (entry.content, entry.module, entry.version),
+                    DoneRun { binary: entry.binary, emitted: entry.emitted },
+                );
+            }
+        }
+
+        // Drop the entries made by other builds of the loaded modules
+        let binaries: HashMap<&str, &str> = modules.iter()
+            .map(|(name, binary)| (name.as_str(), binary.as_str()))
+            .collect();
+        let mut invalidated = Vec::new();
+        done.retain(|(_, module, _), run| match binaries.get(module.as_str()) {
+            Some(&binary) if binary != run.binary => {
+                if !invalidated.contains(module) {
+                    invalidated.push(module.clone());
+                }
+                false
+            }
+            _ => true,
+        });
+        invalidated.sort();
+
+        // Rewrite the file compacted
+        let entries: Vec<StateEntry> = done.iter()
+            .map(|((content, module, version), run)| StateEntry {
+                content: content.clone(),
+                module: module.clone(),
+                version: version.clone(),
+                binary: run.binary.clone(),
+                emitted: run.emitted,
+            })
+            .collect();
+        let tmp = path.with_extension("tmp");
+        {
+            let mut writer = BufWriter::new(File::create(&tmp)
+                .with_context(|| format!("Failed to write state file {}", tmp.display()))?);
+            for entry in &entries {
+                serde_json::to_writer(&mut writer, entry)?;
+                writer.write_all(b"\n")?;
+            }
+            writer.flush()?;
+        }
+        std::fs::rename(&tmp, path)?;
+
+        let file = OpenOptions::new().append(true).open(path)?;
+        let state = Self {
+            path: path.to_path_buf(),
+            inner: Arc::new(Mutex::new(StateInner {
+                file: BufWriter::new(file),
+                done,
+            })),
+        };
+        Ok((state, invalidated))
+    }
+
+    pub fn path(&self) -> &Path {
+        &self.path
+    }
+
+    /// Number of finished runs recorded
+    pub fn len(&self) -> usize {
+        self.inner.lock().unwrap().done.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.len() == 0
+    }
+
+    /// The recorded run of a module version and binary on content with the
+    /// given hash, None if it has not finished before. A module reloaded with
+    /// a new binary during the run never matches the entries of the old one.
+    pub fn previous_run(&self, content_hash: &str, module: &str, version: &str, binary: &str) -> Option<PreviousRun> {
+        let key = (content_hash.to_string(), module.to_string(), version.to_string());
+        self.inner.lock().unwrap().done.get(&key)
+            .filter(|run| run.binary == binary)
+            .map(|run| PreviousRun { emitted: run.emitted })
+    }
+
+    /// Record that a module version and binary finished on content with the
+    /// given hash. The entry is written through, so an interrupted run keeps
+    /// its progress.
+    pub fn record_done(&self, content_hash: &str, module: &str, version: &str, binary: &str, emitted: bool) -> Result<()> {
+        let mut inner = self.inner.lock().unwrap();
+        let key = (content_hash.to_string(), module.to_string(), version.to_string());
+        let run = DoneRun { binary: binary.to_string(), emitted };
+        if inner.done.get(&key) == Some(&run) {
+            return Ok(());
+        }
+        inner.done.insert(key, run);
+        let entry = StateEntry {
+            content: content_hash.to_string(),
+            module: module.to_string(),
+            version: version.to_string(),
+            binary: binary.to_string(),
+            emitted,
+        };
+        serde_json::to_writer(&mut inner.file, &entry)?;
+        inner.file.write_all(b"\n")?;
+        inner.file.flush()?;
+        Ok(())
+    }
+}
+
+/// Hash identifying content data in the state file
+pub fn content_hash(data: &[u8]) -> String {
+    use sha2::{Digest, Sha256};
+    hex::encode(Sha256::digest(data))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn modules(list: &[(&str, &str)]) -> Vec<(String, String)> {
+        list.iter().map(|(name, binary)| (name.to_string(), binary.to_string())).collect()
+    }
+
+    #[test]
+    fn test_finished_runs_are_skipped_after_reopening() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("state.jsonl");
+        let loaded = modules(&[("zip", "b1"), ("strings", "b2")]);
+
+        let (state, invalidated) = ProcessingState::open(&path, &loaded, false).unwrap();
+        assert!(invalidated.is_empty());
+        assert!(state.is_empty());
+        state.record_done("h1", "zip", "1.0.0", "b1", true).unwrap();
+        state.record_done("h1", "strings", "1.0.0", "b2", false).unwrap();
+        // Recording the same run again writes nothing
+        state.record_done("h1", "strings", "1.0.0", "b2", false).unwrap();
+        assert_eq!(std::fs::read_to_string(&path).unwrap().lines().count(), 2);
+        drop(state);
+
+        let (state, _) = ProcessingState::open(&path, &loaded, false).unwrap();
+        assert_eq!(state.len(), 2);
+        assert_eq!(state.previous_run("h1", "zip", "1.0.0", "b1"), Some(PreviousRun { emitted: true }));
+        assert_eq!(state.previous_run("h1", "strings", "1.0.0", "b2"), Some(PreviousRun { emitted: false }));
+        assert_eq!(state.previous_run("h2", "zip", "1.0.0", "b1"), None);
+        assert_eq!(state.previous_run("h1", "zip", "1.1.0", "b1"), None);
+        // A module reloaded mid-run doesn't match the old binary's entries
+        assert_eq!(state.previous_run("h1", "zip", "1.0.0", "b9"), None);
+    }
+
+    #[test]
+    fn test_corrupt_and_partial_lines_are_ignored() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("state.jsonl");
+        std::fs::write(&path, concat!(
+            r#"{"content":"h1","module":"zip","version":"1.0.0","binary":"b1"}"#, "\n",
+            "not json\n",
+            "\n",
+            r#"{"content":"h2","module":"zip","version":"1.0.0","binary":"b1","emitted":true}"#, "\n",
+            r#"{"content":"h3","module":"zip","ver"#,
+        )).unwrap();
+
+        let (state, _) = ProcessingState::open(&path, &modules(&[("zip", "b1")]), false).unwrap();
+        assert_eq!(state.len(), 2);
+        assert_eq!(state.previous_run("h1", "zip", "1.0.0", "b1"), Some(PreviousRun { emitted: false }));
+        assert_eq!(state.previous_run("h2", "zip", "1.0.0", "b1"), Some(PreviousRun { emitted: true }));
+        assert_eq!(state.previous_run("h3", "zip", "1.0.0", "b1"), None);
+
+        // The file is rewritten without the bad lines, so appends start on a fresh line
+        state.record_done("h3", "zip", "1.0.0", "b1", false).unwrap();
+        let text = std::fs::read_to_string(&path).unwrap();
+        assert_eq!(text.lines().count(), 3);
+        assert!(text.lines().all(|line| serde_json::from_str::<StateEntry>(line).is_ok()));
+    }
+
+    #[test]
+    fn test_rebuilt_modules_are_invalidated() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("state.jsonl");
+        let (state, _) = ProcessingState::open(&path, &modules(&[("zip", "b1"), ("pe", "b2")]), false).unwrap();
+        state.record_done("h1", "zip", "1.0.0", "b1", false).unwrap();
+        state.record_done("h2", "zip", "1.0.0", "b1", false).unwrap();
+        state.record_done("h1", "pe", "1.0.0", "b2", false).unwrap();
+        state.record_done("h1", "old", "1.0.0", "b3", false).unwrap();
+        drop(state);
+
+        // zip was rebuilt and old isn't loaded: only zip's entries go
+        let (state, invalidated) = ProcessingState::open(&path, &modules(&[("zip", "b4"), ("pe", "b2")]), false).unwrap();
+        assert_eq!(invalidated, ["zip"]);
+        assert_eq!(state.len(), 2);
+        assert_eq!(state.previous_run("h1", "pe", "1.0.0", "b2"), Some(PreviousRun { emitted: false }));
+        assert_eq!(state.previous_run("h1", "old", "1.0.0", "b3"), Some(PreviousRun { emitted: false }));
+    }
+
+    #[test]
+    fn test_force_discards_everything() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("state.jsonl");
+        let loaded = modules(&[("zip", "b1")]);
+        let (state, _) = ProcessingState::open(&path, &loaded, false).unwrap();
+        state.record_done("h1", "zip", "1.0.0", "b1", false).unwrap();
+        drop(state);
+
+        let (state, _) = ProcessingState::open(&path, &loaded, true).unwrap();
+        assert!(state.is_empty());
+        assert_eq!(std::fs::read_to_string(&path).unwrap(), "");
+    }
+}
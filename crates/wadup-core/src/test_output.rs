@@ -4,6 +4,7 @@
 //! for compatibility with WADUP Web.
 
 use serde::Serialize;
+use crate::progress::ProgressReport;
 
 /// Output from running a single module test.
 #[derive(Debug, Serialize)]
@@ -29,6 +30,10 @@ pub struct TestOutput {
 
     /// Extracted subcontent files from /subcontent/.
     pub subcontent: Option<Vec<SubcontentOutput>>,
+
+    /// Last progress reported by the module, if any.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub progress: Option<ProgressReport>,
 }
 
 /// A single extracted subcontent file.
@@ -69,6 +74,7 @@ impl TestOutput {
             exit_code: 0,
             metadata,
             subcontent,
+            progress: None,
         }
     }
 
@@ -88,6 +94,7 @@ impl TestOutput {
             exit_code,
             metadata: None,
             subcontent,
+            progress: None,
         }
     }
 }
@@ -1,3 +1,4 @@
+use crate::bindings_context::{FragmentSpan, SubContentAnnotations};
 use crate::memory_fs::{MemoryFilesystem, MemoryFile, MemoryDirectory};
 use std::collections::HashMap;
 use std::sync::Arc;
@@ -8,6 +9,9 @@ use std::io::{Read, Write, Seek, SeekFrom};
 /// Maximum bytes to capture from stdout/stderr per content (1 MB)
 const MAX_CAPTURE_BYTES: usize = 1024 * 1024;
 
+/// Writable scratch directory, emptied before each content item
+pub const SCRATCH_DIR: &str = "/tmp";
+
 /// File descriptor
 type Fd = u32;
 
@@ -40,6 +44,7 @@ pub enum Errno {
     Isdir = 31,
     Noent = 44,
     Notdir = 54,
+    Nospc = 51,
     Nosys = 52,
 }
 
@@ -54,9 +59,12 @@ enum FileHandle {
 
 /// Sub-content emission data (paired data + metadata files, or slice reference)
 pub struct SubcontentEmission {
+    /// N from the /subcontent/metadata_N.json file name
+    pub index: u64,
     pub filename: String,
     /// The sub-content data - either owned bytes or a slice reference
     pub data: SubcontentEmissionData,
+    pub annotations: SubContentAnnotations,
 }
 
 /// Data for a sub-content emission
@@ -65,11 +73,45 @@ pub enum SubcontentEmissionData {
     Bytes(bytes::Bytes),
     /// Slice of parent content (offset and length)
     Slice { offset: usize, length: usize },
+    /// Slice of an earlier emission of the same run, identified by its index
+    SliceOf { parent: u64, offset: usize, length: usize },
+    /// Ranges of parent content to concatenate
+    Fragments(Vec<FragmentSpan>),
+}
+
+/// Encoding of a /metadata/ file, given by its extension
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum MetadataEncoding {
+    /// output_N.json
+    Json,
+    /// output_N.msgpack, written by guests that negotiated msgpack_metadata
+    MsgPack,
+    /// output_N.arrow, rows of one table written by guests that negotiated
+    /// arrow_ipc_metadata
+    ArrowIpc,
+}
+
+impl MetadataEncoding {
+    /// Encoding of a metadata file path, None if it isn't a metadata file
+    /// (e.g. the side file of a streamed BytesRef value)
+    pub fn from_path(path: &str) -> Option<Self> {
+        if !path.starts_with("/metadata/") {
+            None
+        } else if path.ends_with(".json") {
+            Some(Self::Json)
+        } else if path.ends_with(".msgpack") {
+            Some(Self::MsgPack)
+        } else if path.ends_with(".arrow") {
+            Some(Self::ArrowIpc)
+        } else {
+            None
+        }
+    }
 }
 
 /// Result of closing a file - may contain metadata or subcontent if it was a special file
 pub struct CloseResult {
-    pub metadata_content: Option<Vec<u8>>,
+    pub metadata_content: Option<(MetadataEncoding, Vec<u8>)>,
     pub subcontent_emission: Option<SubcontentEmission>,
 }
 
@@ -88,6 +130,8 @@ pub struct WasiCtx {
     stdout_truncated: AtomicBool,
     /// Whether stderr was truncated due to size limit
     stderr_truncated: AtomicBool,
+    /// Maximum total bytes of the files in the scratch directory
+    scratch_quota: Option<usize>,
 }
 
 impl WasiCtx {
@@ -115,9 +159,35 @@ impl WasiCtx {
             stderr_capture: Mutex::new(Vec::new()),
             stdout_truncated: AtomicBool::new(false),
             stderr_truncated: AtomicBool::new(false),
+            scratch_quota: None,
         }
     }
 
+    /// Limit the total size of the files in the scratch directory. Writes
+    /// beyond it fail with ENOSPC.
+    pub fn set_scratch_quota(&mut self, quota: Option<usize>) {
+        self.scratch_quota = quota;
+    }
+
+    /// Empty the scratch directory, creating it if the guest removed it
+    pub fn reset_scratch(&self) -> std::io::Result<()> {
+        match self.filesystem.get_dir(SCRATCH_DIR) {
+            Ok(dir) => dir.clear(),
+            Err(_) => self.filesystem.create_dir_all(SCRATCH_DIR)?,
+        }
+        Ok(())
+    }
+
+    /// Whether growing a scratch file by `growth` bytes stays within the
+    /// scratch quota
+    fn scratch_allows(&self, growth: usize) -> bool {
+        let Some(quota) = self.scratch_quota else {
+            return true;
+        };
+        let used = self.filesystem.get_dir(SCRATCH_DIR).map_or(0, |dir| dir.size());
+        used.saturating_add(growth) <= quota
+    }
+
     /// Get the number of environment variables and total buffer size needed.
     pub fn environ_sizes(&self) -> (usize, usize) {
         let count = self.env_vars.len();
@@ -141,9 +211,12 @@ impl WasiCtx {
         fd
     }
 
-    /// Check if a path should be tracked for special handling on close
+    /// Check if a path should be tracked for special handling on close, or
+    /// for the scratch quota
     fn should_track_path(path: &str) -> Option<String> {
-        if path.starts_with("/metadata/") && path.ends_with(".json") {
+        if path.starts_with(SCRATCH_DIR) && path[SCRATCH_DIR.len()..].starts_with('/') {
+            Some(path.to_string())
+        } else if MetadataEncoding::from_path(path).is_some() {
             Some(path.to_string())
         } else if path.starts_with("/subcontent/metadata_") && path.ends_with(".json") {
             Some(path.to_string())
@@ -304,7 +377,16 @@ impl WasiCtx {
         };
 
         match handle {
-            FileHandle::File(ref mut file, _) => {
+            FileHandle::File(ref mut file, ref path) => {
+                let is_scratch = path.as_deref().is_some_and(|path| path.starts_with(SCRATCH_DIR));
+                if is_scratch && self.scratch_quota.is_some() {
+                    let len: usize = bufs.iter().map(|buf| buf.len()).sum();
+                    let growth = (file.position() + len).saturating_sub(file.len());
+                    if growth > 0 && !self.scratch_allows(growth) {
+                        return Errno::Nospc;
+                    }
+                }
+
                 let mut total = 0;
                 for buf in bufs {
                     match file.write(buf) {
@@ -394,10 +476,11 @@ impl WasiCtx {
 
         let mut file_table = self.file_table.write();
         match file_table.remove(&fd) {
-            Some(FileHandle::File(_, Some(path))) if path.starts_with("/metadata/") && path.ends_with(".json") => {
+            Some(FileHandle::File(_, Some(path))) if MetadataEncoding::from_path(&path).is_some() => {
                 // This is a metadata file - read its contents and delete it
+                let encoding = MetadataEncoding::from_path(&path).unwrap();
                 let content = match self.filesystem.read_file(&path) {
-                    Ok(data) => Some(data),
+                    Ok(data) => Some((encoding, data)),
                     Err(_) => None,
                 };
 
@@ -431,13 +514,22 @@ impl WasiCtx {
     /// in-memory filesystem is frozen directly into Bytes.
     ///
     /// For slice data: If the metadata contains `offset` and `length` fields, it's treated as a
-    /// slice of the parent content and no data file is expected.
+    /// slice of the parent content and no data file is expected. With a `parent_ref` of the
+    /// form "subcontent:N" as well, it's a slice of the module's earlier emission N instead.
+    ///
+    /// For file data: If the metadata contains a `source_path` field, that file is moved out of
+    /// the filesystem in place of /subcontent/data_N.bin, so a guest that already wrote the
+    /// data to a file doesn't have to read it back and write it again.
+    ///
+    /// If the metadata contains an `encoding` field, the owned data was compressed by the guest
+    /// in that format and is decompressed here.
     fn process_subcontent_metadata(&self, metadata_path: &str) -> Option<SubcontentEmission> {
         // Extract N from /subcontent/metadata_N.json
         let filename = metadata_path.trim_start_matches("/subcontent/");
         let n = filename
             .strip_prefix("metadata_")
             .and_then(|s| s.strip_suffix(".json"))?;
+        let index = n.parse().ok()?;
 
         // Read metadata file to get the target filename and optional slice info
         let metadata_content = self.filesystem.read_file(metadata_path).ok()?;
@@ -446,11 +538,23 @@ impl WasiCtx {
         // Parse JSON to get filename and optional slice info
         // Format: {"filename": "extracted.txt"} for bytes
         // Format: {"filename": "extracted.txt", "offset": 0, "length": 100} for slice
+        // Format: {"filename": "extracted.txt", "offset": 0, "length": 100, "parent_ref": "subcontent:2"}
+        //         for a slice of an earlier emission
+        // Format: {"filename": "extracted.txt", "source_path": "/tmp/member"} for a file
+        // Format: {"filename": "extracted.txt", "encoding": "gzip"} for compressed bytes
+        // Format: {"filename": "stream.bin", "fragments": [{"offset": 0, "length": 100}, ...]}
+        //         without a data file, for content reassembled from ranges of the parent
+        // Any of them may also carry the fields of SubContentAnnotations, e.g. "analyzed_by"
         #[derive(serde::Deserialize)]
         struct SubcontentMetadata {
             filename: String,
             offset: Option<usize>,
             length: Option<usize>,
+            source_path: Option<String>,
+            encoding: Option<String>,
+            parent_ref: Option<String>,
+            #[serde(flatten)]
+            annotations: SubContentAnnotations,
         }
         let metadata: SubcontentMetadata = serde_json::from_str(&metadata_str).ok()?;
 
@@ -461,25 +565,74 @@ impl WasiCtx {
 
         // Check if this is a slice reference (both offset and length present)
         let data = match (metadata.offset, metadata.length) {
+            (Some(offset), Some(length)) if metadata.parent_ref.is_some() => {
+                let parent_ref = metadata.parent_ref.as_deref()?;
+                let Some(parent) = parent_ref.strip_prefix("subcontent:").and_then(|n| n.parse().ok()) else {
+                    tracing::warn!("Sub-content {} refers to unknown parent '{}'", metadata.filename, parent_ref);
+                    return None;
+                };
+                SubcontentEmissionData::SliceOf { parent, offset, length }
+            }
             (Some(offset), Some(length)) => {
                 // Slice reference - no data file expected
                 SubcontentEmissionData::Slice { offset, length }
             }
+            _ if metadata.source_path.is_some() => {
+                // Guest file - take ownership of it as Bytes (zero-copy)
+                let source_path = metadata.source_path.as_deref()?;
+                if !source_path.starts_with('/') {
+                    return None;
+                }
+                let source_path = format!("/{}", crate::naming::normalize_path(source_path));
+                if !Self::can_emit_path(&source_path) {
+                    return None;
+                }
+                let bytes = self.filesystem.take_file_bytes(&source_path).ok()?;
+                SubcontentEmissionData::Bytes(bytes)
+            }
             _ => {
                 // Owned data - take ownership of the data file as Bytes (zero-copy)
                 // This also removes the file from the filesystem
                 let data_path = format!("/subcontent/data_{}.bin", n);
-                let bytes = self.filesystem.take_file_bytes(&data_path).ok()?;
-                SubcontentEmissionData::Bytes(bytes)
+                match self.filesystem.take_file_bytes(&data_path) {
+                    Ok(bytes) => SubcontentEmissionData::Bytes(bytes),
+                    Err(_) if !metadata.annotations.fragments.is_empty() => {
+                        SubcontentEmissionData::Fragments(metadata.annotations.fragments.clone())
+                    }
+                    Err(_) => return None,
+                }
+            }
+        };
+
+        let data = match (data, metadata.encoding.as_deref()) {
+            (SubcontentEmissionData::Bytes(bytes), Some(encoding)) => {
+                match crate::decompress::decompress(encoding, &bytes) {
+                    Ok(output) => SubcontentEmissionData::Bytes(bytes::Bytes::from(output)),
+                    Err(e) => {
+                        tracing::warn!("Failed to decompress sub-content {} ({}): {}", metadata.filename, encoding, e);
+                        return None;
+                    }
+                }
             }
+            (data, _) => data,
         };
 
         Some(SubcontentEmission {
+            index,
             filename: metadata.filename,
             data,
+            annotations: metadata.annotations,
         })
     }
 
+    /// Whether a normalized guest path can be moved out as sub-content. The
+    /// content being processed and the host's protocol files can't.
+    fn can_emit_path(path: &str) -> bool {
+        path != "/data.bin"
+            && !path.starts_with("/metadata/")
+            && !path.starts_with("/subcontent/metadata_")
+    }
+
     /// fd_filestat_get - Get file metadata
     pub fn fd_filestat_get(&self, fd: Fd, filestat: &mut [u8; 64]) -> Errno {
         let file_table = self.file_table.read();
@@ -2,16 +2,111 @@ use wasmtime::*;
 use anyhow::Result;
 use std::path::Path;
 use std::sync::Arc;
-use crate::bindings_context::ProcessingContext;
+use crate::bindings_context::{FragmentSpan, LogMessage, ProcessingContext, SubContentAnnotations, MAX_LOG_MESSAGE_BYTES};
 use crate::metadata::MetadataStore;
 use crate::memory_fs::MemoryFilesystem;
-use crate::wasi_impl::WasiCtx;
-
-#[derive(Clone)]
+use crate::wasi_impl::{MetadataEncoding, WasiCtx};
+use crate::manifest::ModuleManifest;
+use crate::limits::{deadline_ticks, CancelHandle, CancelState, EpochTicker, LimitExceeded, LimitKind, ModuleLimits};
+use crate::progress::{ProgressReport, ProgressTracker};
+use crate::content::{ContentInfo, ANCESTRY_PATH, CONTENT_INFO_PATH, SEED_PATH};
+use crate::archive::{ArchiveFormat, ArchiveIndex, ExtractError, ExtractedEntry};
+use crate::naming::{SubcontentNamer, SubcontentNaming};
+use crate::query::ContentRows;
+use crate::module_config::{ModuleConfig, CONFIG_PATH};
+use crate::secrets::SecretStore;
+use crate::registry::ModuleRegistry;
+use std::collections::HashMap;
+use std::time::Duration;
+
+/// Version of the guest/host interface implemented by this host. Modules
+/// exporting a higher `wadup_abi_version` are rejected at instantiation.
+pub const HOST_ABI_VERSION: i32 = 1;
+
+/// Where a module finds the ID the host knows it by, its module name
+pub const MODULE_ID_PATH: &str = "/wadup/module_id";
+
+/// File guests without the `log_message` import append log messages to, one
+/// JSON object per line
+pub const LOG_PATH: &str = "/log/messages.jsonl";
+
+/// Optional features this host implements, advertised to guests through the
+/// `get_host_capabilities` import. Guests fall back to the JSON file protocol
+/// for anything not listed.
+pub const HOST_FEATURES: &[&str] = &["hash_content", "decompress", "scan_content", "content_type", "memory_limit", "progress", "cancellation", "subcontent_info", "emit_file", "compressed_subcontent", "archive", "secrets", "query_metadata", "scratch", "dictionary_columns", "msgpack_metadata", "arrow_ipc_metadata", "logging", "reassembly"];
+
+#[derive(Clone, Default)]
 pub struct ResourceLimits {
     pub fuel: Option<u64>,
     pub max_memory: Option<usize>,
     pub max_stack: Option<usize>,
+    /// Wall-clock limit per module invocation
+    pub timeout: Option<Duration>,
+    /// Overrides of the fuel, memory and timeout limits, keyed by module name
+    pub modules: HashMap<String, ModuleLimits>,
+    /// Restart the timeout whenever a module reports forward progress, so
+    /// the timeout bounds the time between progress reports
+    pub progress_extends_timeout: bool,
+    /// How sub-content filenames are normalized and uniquified
+    pub subcontent_naming: SubcontentNaming,
+    /// Candidate passwords and keys offered through get_secrets
+    pub secrets: SecretStore,
+    /// Settings mounted at /wadup/config.json, by module
+    pub module_config: ModuleConfig,
+    /// Maximum total bytes of the files a module keeps in /tmp
+    pub scratch_quota: Option<usize>,
+    /// Maximum bytes of metadata a module may write per content item.
+    /// Metadata files beyond it are dropped with a warning; the budget is
+    /// advertised to guests so they can sample or truncate their output.
+    pub metadata_budget: Option<u64>,
+}
+
+impl ResourceLimits {
+    /// The limits for one module, with its overrides applied
+    pub fn for_module(&self, name: &str) -> ResourceLimits {
+        let mut limits = self.clone();
+        if let Some(overrides) = self.modules.get(name) {
+            limits.fuel = overrides.fuel.or(self.fuel);
+            limits.max_memory = overrides.max_memory.or(self.max_memory);
+            limits.timeout = overrides.timeout.or(self.timeout);
+        }
+        limits
+    }
+
+    /// Maximum bytes the host decompresses into sub-content for a module per
+    /// content item: the module's memory limit, since the output is held until
+    /// the run ends, or a default without one
+    pub fn decompress_budget(&self) -> u64 {
+        self.max_memory.map_or(crate::decompress::DEFAULT_DECOMPRESS_BUDGET, |max| max as u64)
+    }
+
+    /// Whether any module has a fuel limit
+    pub fn uses_fuel(&self) -> bool {
+        self.fuel.is_some() || self.modules.values().any(|m| m.fuel.is_some())
+    }
+
+    /// Whether any module has a timeout, requiring epoch interruption
+    pub fn uses_epoch(&self) -> bool {
+        self.timeout.is_some() || self.modules.values().any(|m| m.timeout.is_some())
+    }
+
+    /// Apply the engine-wide settings these limits need
+    pub fn configure(&self, config: &mut Config) {
+        // Configure fuel (CPU) limits if specified
+        if self.uses_fuel() {
+            config.consume_fuel(true);
+        }
+
+        // Configure stack size limit if specified
+        if let Some(max_stack) = self.max_stack {
+            config.max_wasm_stack(max_stack);
+        }
+
+        // Timeouts interrupt modules when the engine epoch passes a deadline
+        if self.uses_epoch() {
+            config.epoch_interruption(true);
+        }
+    }
 }
 
 // Wrapper to combine ProcessingContext with WASI support
@@ -19,17 +114,68 @@ pub struct StoreData {
     pub processing_ctx: ProcessingContext,
     pub wasi_ctx: WasiCtx,
     resource_limiter: Option<ResourceLimiterImpl>,
+    progress: ProgressTracker,
+    /// Module name, for progress logging
+    module_name: String,
+    /// Timeout restarted on forward progress, if enabled
+    progress_timeout: Option<Duration>,
+    cancel: CancelState,
+    /// Final filenames of the sub-content emitted by the current invocation
+    namer: SubcontentNamer,
+    /// Index of the current content as an archive, built on first use
+    archive: Option<Arc<ArchiveIndex>>,
+    secrets: SecretStore,
+    /// Rows other modules produced for the current content
+    content_rows: ContentRows,
+    /// Maximum bytes of metadata per content item
+    metadata_budget: Option<u64>,
+    /// Bytes of metadata accepted for the current content item
+    metadata_bytes: u64,
+    /// Maximum bytes the host decompresses into sub-content per content item
+    decompress_budget: u64,
+    /// Bytes decompressed for the current content item
+    decompressed_bytes: u64,
+}
+
+impl StoreData {
+    /// The archive index of the current content, or None if it isn't an
+    /// archive the host can read
+    fn archive_index(&mut self) -> Option<Arc<ArchiveIndex>> {
+        if self.archive.is_none() {
+            let content = self.processing_ctx.content_data.clone();
+            let format = ArchiveFormat::from_content_type(crate::magic::detect_content_type(content.as_slice()))?;
+            match ArchiveIndex::build(format, content.as_slice()) {
+                Ok(index) => self.archive = Some(Arc::new(index)),
+                Err(e) => {
+                    tracing::debug!("Failed to index {:?} archive: {}", format, e);
+                    return None;
+                }
+            }
+        }
+        self.archive.clone()
+    }
 }
 
 pub struct WasmRuntime {
     engine: Engine,
-    modules: Vec<ModuleInfo>,
+    registry: ModuleRegistry,
     limits: ResourceLimits,
+    /// Advances the engine epoch while timeouts are configured
+    _epoch_ticker: Option<EpochTicker>,
 }
 
+#[derive(Clone)]
 pub struct ModuleInfo {
     pub name: String,
     pub module: Module,
+    /// Trigger manifest embedded in the module, if any
+    pub manifest: Option<Arc<ModuleManifest>>,
+    /// Version from the manifest, or a digest of the module file
+    pub version: String,
+    /// SHA-256 of the module file, which changes with any rebuild
+    pub binary_hash: String,
+    /// Modules run in stages; a module only sees rows of earlier stages
+    pub stage: usize,
 }
 
 impl WasmRuntime {
@@ -37,89 +183,47 @@ impl WasmRuntime {
         let mut config = Config::new();
         config.wasm_multi_memory(true);
         config.async_support(false);
-
-        // Configure fuel (CPU) limits if specified
-        if limits.fuel.is_some() {
-            config.consume_fuel(true);
-        }
-
-        // Configure stack size limit if specified
-        if let Some(max_stack) = limits.max_stack {
-            config.max_wasm_stack(max_stack);
-        }
+        limits.configure(&mut config);
 
         let engine = Engine::new(&config)?;
+        let epoch_ticker = limits.uses_epoch().then(|| EpochTicker::start(&engine));
 
         Ok(Self {
+            registry: ModuleRegistry::new(engine.clone(), limits.clone()),
             engine,
-            modules: Vec::new(),
             limits,
+            _epoch_ticker: epoch_ticker,
         })
     }
 
     pub fn load_modules(&mut self, dir: &Path) -> Result<()> {
-        for entry in std::fs::read_dir(dir)? {
-            let entry = entry?;
-            let path = entry.path();
-
-            if path.extension().and_then(|s| s.to_str()) == Some("wasm") {
-                let name = path.file_stem()
-                    .and_then(|s| s.to_str())
-                    .unwrap_or("unknown")
-                    .to_string();
-
-                let module = crate::precompile::load_module_with_cache(&self.engine, &path)?;
-
-                // Validate module exports - must have 'process' function
-                self.validate_module(&module)?;
-
-                tracing::info!("Loaded WASM module: {}", name);
-                self.modules.push(ModuleInfo { name, module });
-            }
-        }
-
-        if self.modules.is_empty() {
-            anyhow::bail!("No WASM modules found in directory");
-        }
-
-        Ok(())
-    }
-
-    fn validate_module(&self, module: &Module) -> Result<()> {
-        let has_process = module.exports()
-            .any(|export| export.name() == "process");
-
-        if has_process {
-            Ok(())
-        } else {
-            anyhow::bail!("Module missing required 'process' export. All WADUP modules must export a 'process' function.");
-        }
+        self.registry.load_dir(dir)
     }
 
     pub fn create_instances(
         &self,
         metadata_store: MetadataStore,
     ) -> Result<Vec<ModuleInstance>> {
-        let mut instances = Vec::new();
-
-        for module_info in &self.modules {
-            let instance = ModuleInstance::new(
-                &self.engine,
-                &module_info.module,
-                &module_info.name,
-                &self.limits,
-                metadata_store.clone(),
-            )?;
-            instances.push(instance);
-        }
-
+        let (_, instances) = self.registry.create_instances(metadata_store)?;
         Ok(instances)
     }
 
+    /// The loaded modules, which can be reloaded while content is processed
+    pub fn registry(&self) -> &ModuleRegistry {
+        &self.registry
+    }
+
     pub fn engine(&self) -> &Engine {
         &self.engine
     }
 
+    /// Name and binary hash of each loaded module
+    pub fn module_binaries(&self) -> Vec<(String, String)> {
+        self.registry.modules().iter()
+            .map(|m| (m.name.clone(), m.binary_hash.clone()))
+            .collect()
+    }
+
     pub fn limits(&self) -> &ResourceLimits {
         &self.limits
     }
@@ -127,11 +231,15 @@ impl WasmRuntime {
 
 struct ResourceLimiterImpl {
     max_memory: usize,
+    /// Set when a memory growth request was refused during the current call
+    denied: bool,
 }
 
 impl ResourceLimiter for ResourceLimiterImpl {
     fn memory_growing(&mut self, _current: usize, desired: usize, _maximum: Option<usize>) -> Result<bool> {
-        Ok(desired <= self.max_memory)
+        let allowed = desired <= self.max_memory;
+        self.denied |= !allowed;
+        Ok(allowed)
     }
 
     fn table_growing(&mut self, _current: usize, _desired: usize, _maximum: Option<usize>) -> Result<bool> {
@@ -144,7 +252,25 @@ pub struct ModuleInstance {
     instance: Instance,
     name: String,
     fuel_limit: Option<u64>,
+    /// Wall-clock limit per invocation
+    timeout: Option<Duration>,
+    /// Whether the engine interrupts modules at epoch deadlines
+    epoch_deadlines: bool,
     metadata_store: MetadataStore,
+    /// Kept to re-instantiate the module after a trap
+    engine: Engine,
+    module: Module,
+    limits: ResourceLimits,
+    /// Set when the module trapped; its state can no longer be trusted
+    poisoned: bool,
+    /// Trigger manifest restricting which content the module is offered
+    manifest: Option<Arc<ModuleManifest>>,
+    /// Module version recorded in row provenance
+    version: String,
+    /// SHA-256 of the module binary
+    binary_hash: String,
+    /// Stage the module runs in on each content item
+    stage: usize,
 }
 
 impl ModuleInstance {
@@ -172,18 +298,33 @@ impl ModuleInstance {
         // Create empty /data.bin file
         filesystem.create_file("/data.bin", Vec::new())?;
 
+        Self::mount_config(&filesystem, limits, name)?;
+
         // Create WASI context with our in-memory filesystem
-        let wasi_ctx = WasiCtx::new(filesystem);
+        let mut wasi_ctx = WasiCtx::new(filesystem);
+        wasi_ctx.set_scratch_quota(limits.scratch_quota);
 
         // Create resource limiter if memory limit is specified
         let resource_limiter = limits.max_memory.map(|max_memory| {
-            ResourceLimiterImpl { max_memory }
+            ResourceLimiterImpl { max_memory, denied: false }
         });
 
         let store_data = StoreData {
             processing_ctx: dummy_ctx,
             wasi_ctx,
             resource_limiter,
+            progress: ProgressTracker::default(),
+            module_name: name.to_string(),
+            progress_timeout: limits.timeout.filter(|_| limits.progress_extends_timeout),
+            cancel: CancelState::default(),
+            namer: SubcontentNamer::new(limits.subcontent_naming),
+            archive: None,
+            secrets: limits.secrets.clone(),
+            content_rows: ContentRows::new(),
+            metadata_budget: limits.metadata_budget,
+            metadata_bytes: 0,
+            decompress_budget: limits.decompress_budget(),
+            decompressed_bytes: 0,
         };
 
         let mut store = Store::new(engine, store_data);
@@ -193,6 +334,17 @@ impl ModuleInstance {
             store.set_fuel(fuel)?;
         }
 
+        // Initialization is not subject to the timeout. Invocations with a
+        // timeout get a cancellation request at the soft deadline and are
+        // interrupted at the hard one.
+        if limits.uses_epoch() {
+            store.set_epoch_deadline(deadline_ticks(None));
+            store.epoch_deadline_callback(|mut ctx| match ctx.data_mut().cancel.on_deadline() {
+                Some(grace) => Ok(UpdateDeadline::Continue(grace)),
+                None => Err(Trap::Interrupt.into()),
+            });
+        }
+
         // Set memory limits if specified
         if store.data().resource_limiter.is_some() {
             store.limiter(|data| data.resource_limiter.as_mut().unwrap());
@@ -202,6 +354,10 @@ impl ModuleInstance {
 
         // Add WASI Preview1 functions
         Self::add_wasi_functions(&mut linker)?;
+        Self::add_wadup_functions(&mut linker)?;
+        // Imports from newer interface versions trap only if called, so
+        // guests that check capabilities first can still run here
+        linker.define_unknown_imports_as_traps(module)?;
 
         let instance = linker.instantiate(&mut store, module)?;
 
@@ -211,12 +367,24 @@ impl ModuleInstance {
             let _ = start_func.call(&mut store, ());
         }
 
+        Self::check_abi_version(&instance, &mut store, name)?;
+
         Ok(Self {
             store,
             instance,
             name: name.to_string(),
             fuel_limit: limits.fuel,
+            timeout: limits.timeout,
+            epoch_deadlines: limits.uses_epoch(),
             metadata_store,
+            engine: engine.clone(),
+            module: module.clone(),
+            limits: limits.clone(),
+            poisoned: false,
+            manifest: None,
+            version: String::new(),
+            binary_hash: String::new(),
+            stage: 0,
         })
     }
 
@@ -245,18 +413,33 @@ impl ModuleInstance {
         // Create empty /data.bin file
         filesystem.create_file("/data.bin", Vec::new())?;
 
+        Self::mount_config(&filesystem, limits, name)?;
+
         // Create WASI context with our in-memory filesystem and env vars
-        let wasi_ctx = WasiCtx::with_env_vars(filesystem, env_vars);
+        let mut wasi_ctx = WasiCtx::with_env_vars(filesystem, env_vars);
+        wasi_ctx.set_scratch_quota(limits.scratch_quota);
 
         // Create resource limiter if memory limit is specified
         let resource_limiter = limits.max_memory.map(|max_memory| {
-            ResourceLimiterImpl { max_memory }
+            ResourceLimiterImpl { max_memory, denied: false }
         });
 
         let store_data = StoreData {
             processing_ctx: dummy_ctx,
             wasi_ctx,
             resource_limiter,
+            progress: ProgressTracker::default(),
+            module_name: name.to_string(),
+            progress_timeout: limits.timeout.filter(|_| limits.progress_extends_timeout),
+            cancel: CancelState::default(),
+            namer: SubcontentNamer::new(limits.subcontent_naming),
+            archive: None,
+            secrets: limits.secrets.clone(),
+            content_rows: ContentRows::new(),
+            metadata_budget: limits.metadata_budget,
+            metadata_bytes: 0,
+            decompress_budget: limits.decompress_budget(),
+            decompressed_bytes: 0,
         };
 
         let mut store = Store::new(engine, store_data);
@@ -266,6 +449,17 @@ impl ModuleInstance {
             store.set_fuel(fuel)?;
         }
 
+        // Initialization is not subject to the timeout. Invocations with a
+        // timeout get a cancellation request at the soft deadline and are
+        // interrupted at the hard one.
+        if limits.uses_epoch() {
+            store.set_epoch_deadline(deadline_ticks(None));
+            store.epoch_deadline_callback(|mut ctx| match ctx.data_mut().cancel.on_deadline() {
+                Some(grace) => Ok(UpdateDeadline::Continue(grace)),
+                None => Err(Trap::Interrupt.into()),
+            });
+        }
+
         // Set memory limits if specified
         if store.data().resource_limiter.is_some() {
             store.limiter(|data| data.resource_limiter.as_mut().unwrap());
@@ -275,6 +469,10 @@ impl ModuleInstance {
 
         // Add WASI Preview1 functions
         Self::add_wasi_functions(&mut linker)?;
+        Self::add_wadup_functions(&mut linker)?;
+        // Imports from newer interface versions trap only if called, so
+        // guests that check capabilities first can still run here
+        linker.define_unknown_imports_as_traps(module)?;
 
         let instance = linker.instantiate(&mut store, module)?;
 
@@ -284,6 +482,8 @@ impl ModuleInstance {
             let _ = start_func.call(&mut store, ());
         }
 
+        Self::check_abi_version(&instance, &mut store, name)?;
+
         // Use a dummy metadata store for test mode (not used)
         let metadata_store = MetadataStore::new_dummy();
 
@@ -292,10 +492,550 @@ impl ModuleInstance {
             instance,
             name: name.to_string(),
             fuel_limit: limits.fuel,
+            timeout: limits.timeout,
+            epoch_deadlines: limits.uses_epoch(),
             metadata_store,
+            engine: engine.clone(),
+            module: module.clone(),
+            limits: limits.clone(),
+            poisoned: false,
+            manifest: None,
+            version: String::new(),
+            binary_hash: String::new(),
+            stage: 0,
         })
     }
 
+    /// Write the module's ID to /wadup/module_id and its settings, if it has
+    /// any, to /wadup/config.json
+    fn mount_config(filesystem: &MemoryFilesystem, limits: &ResourceLimits, name: &str) -> Result<()> {
+        filesystem.create_dir_all("/wadup")?;
+        filesystem.create_file(MODULE_ID_PATH, name.as_bytes().to_vec())?;
+        if let Some(settings) = limits.module_config.get(name) {
+            filesystem.create_file(CONFIG_PATH, serde_json::to_vec(settings)?)?;
+        }
+        Ok(())
+    }
+
+    /// Describe the content about to be processed at /wadup/content.json,
+    /// write its seed to /wadup/seed and, for sub-content, the modules that
+    /// analyzed its ancestors to /wadup/ancestry.json
+    fn mount_content_info(filesystem: &MemoryFilesystem, info: &ContentInfo) -> Result<()> {
+        filesystem.create_dir_all("/wadup")?;
+        filesystem.replace_file(CONTENT_INFO_PATH, serde_json::to_vec(info)?)?;
+        filesystem.replace_file(SEED_PATH, info.seed().to_string().into_bytes())?;
+        if info.ancestry.is_empty() {
+            let _ = filesystem.remove_file(ANCESTRY_PATH);
+        } else {
+            filesystem.replace_file(ANCESTRY_PATH, serde_json::to_vec(&info.ancestry)?)?;
+        }
+        Ok(())
+    }
+
+    /// Reject modules built against a newer guest/host interface. Modules
+    /// without a `wadup_abi_version` export predate the handshake and are
+    /// treated as version 1.
+    fn check_abi_version(instance: &Instance, store: &mut Store<StoreData>, name: &str) -> Result<()> {
+        if let Ok(version_func) = instance.get_typed_func::<(), i32>(&mut *store, "wadup_abi_version") {
+            let version = version_func.call(&mut *store, ())?;
+            if version > HOST_ABI_VERSION {
+                anyhow::bail!(
+                    "Module '{}' requires ABI version {}, host supports up to {}",
+                    name,
+                    version,
+                    HOST_ABI_VERSION
+                );
+            }
+        }
+        Ok(())
+    }
+
+    /// JSON document returned by `get_host_capabilities`
+    fn host_capabilities_json(metadata_budget: Option<u64>) -> String {
+        let mut capabilities = serde_json::json!({
+            "abi_version": HOST_ABI_VERSION,
+            "features": HOST_FEATURES,
+        });
+        if let Some(budget) = metadata_budget {
+            capabilities["metadata_budget"] = budget.into();
+        }
+        capabilities.to_string()
+    }
+
+    fn add_wadup_functions(linker: &mut Linker<StoreData>) -> Result<()> {
+        // get_host_capabilities - Copy the capabilities JSON into a guest
+        // buffer. Returns the document's length; if the buffer is too small
+        // nothing is written and the guest retries with a larger one.
+        linker.func_wrap(
+            "wadup",
+            "get_host_capabilities",
+            |mut caller: Caller<StoreData>, buf_ptr: i32, buf_len: i32| -> Result<i32> {
+                let capabilities = Self::host_capabilities_json(caller.data().metadata_budget);
+                let len = capabilities.len() as i32;
+                if buf_ptr < 0 || buf_len < len {
+                    return Ok(len);
+                }
+
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+                memory.write(&mut caller, buf_ptr as usize, capabilities.as_bytes())?;
+                Ok(len)
+            },
+        )?;
+
+        // hash_content - Hash a range of the content natively and copy the
+        // digest text into a guest buffer. A negative length hashes to the
+        // end. Returns the digest length (writing nothing if the buffer is
+        // too small), -1 for an unknown algorithm or -2 for a range outside
+        // the content.
+        linker.func_wrap(
+            "wadup",
+            "hash_content",
+            |mut caller: Caller<StoreData>,
+             algo_ptr: i32,
+             algo_len: i32,
+             offset: i64,
+             length: i64,
+             out_ptr: i32,
+             out_len: i32|
+             -> Result<i32> {
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                if algo_ptr < 0 || algo_len < 0 {
+                    anyhow::bail!("Invalid pointer or length");
+                }
+                let mut algo = vec![0u8; algo_len as usize];
+                memory.read(&caller, algo_ptr as usize, &mut algo)?;
+                let algo = String::from_utf8(algo)?;
+
+                let content = caller.data().processing_ctx.content_data.clone();
+                let length = if length < 0 {
+                    match (content.len() as i64).checked_sub(offset) {
+                        Some(rest) if offset >= 0 => rest,
+                        _ => return Ok(-2),
+                    }
+                } else {
+                    length
+                };
+                let Some(range) = content_range(offset, length, content.len()) else {
+                    return Ok(-2);
+                };
+
+                let digest = match crate::hashing::hash_data(&algo, &content.as_slice()[range]) {
+                    Ok(digest) => digest,
+                    Err(_) => return Ok(-1),
+                };
+                let len = digest.len() as i32;
+                if out_ptr >= 0 && out_len >= len {
+                    memory.write(&mut caller, out_ptr as usize, digest.as_bytes())?;
+                }
+                Ok(len)
+            },
+        )?;
+
+        // decompress_slice - Decompress a range of the content and emit the
+        // output as sub-content, without copying it through guest memory.
+        // Returns 0 on success, -1 for an unknown algorithm, -2 for a range
+        // outside the content or -3 for invalid data or output beyond what is
+        // left of the content item's decompression budget.
+        linker.func_wrap(
+            "wadup",
+            "decompress_slice",
+            |mut caller: Caller<StoreData>,
+             algo_ptr: i32,
+             algo_len: i32,
+             offset: i64,
+             length: i64,
+             name_ptr: i32,
+             name_len: i32|
+             -> Result<i32> {
+                use crate::bindings_context::{SubContentData, SubContentEmission};
+
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                if algo_ptr < 0 || algo_len < 0 || name_ptr < 0 || name_len < 0 {
+                    anyhow::bail!("Invalid pointer or length");
+                }
+                let mut algo = vec![0u8; algo_len as usize];
+                memory.read(&caller, algo_ptr as usize, &mut algo)?;
+                let algo = String::from_utf8(algo)?;
+                let mut filename = vec![0u8; name_len as usize];
+                memory.read(&caller, name_ptr as usize, &mut filename)?;
+                let filename = String::from_utf8(filename)?;
+
+                if !crate::decompress::is_supported(&algo) {
+                    return Ok(-1);
+                }
+                let content = caller.data().processing_ctx.content_data.clone();
+                let Some(range) = content_range(offset, length, content.len()) else {
+                    return Ok(-2);
+                };
+
+                let budget = caller.data().decompress_budget.saturating_sub(caller.data().decompressed_bytes);
+                let output = match crate::decompress::decompress_limited(&algo, &content.as_slice()[range], budget) {
+                    Ok(output) => output,
+                    Err(e) => {
+                        tracing::debug!("Failed to decompress {} ({}): {}", filename, algo, e);
+                        return Ok(-3);
+                    }
+                };
+                caller.data_mut().decompressed_bytes += output.len() as u64;
+
+                let filename = caller.data_mut().namer.assign(&filename);
+                caller.data_mut().processing_ctx.subcontent.push(SubContentEmission {
+                    data: SubContentData::Bytes(bytes::Bytes::from(output)),
+                    filename,
+                    index: None,
+                    uuid: uuid::Uuid::new_v4(),
+                    annotations: SubContentAnnotations::with_method(format!("decompressed: {}", algo)),
+                });
+                Ok(0)
+            },
+        )?;
+
+        // scan_content - Run JSON-encoded patterns over the content and copy
+        // the JSON-encoded matches into a guest buffer. Returns the length of
+        // the matches document (writing nothing if the buffer is too small)
+        // or -1 for invalid patterns.
+        linker.func_wrap(
+            "wadup",
+            "scan_content",
+            |mut caller: Caller<StoreData>,
+             patterns_ptr: i32,
+             patterns_len: i32,
+             out_ptr: i32,
+             out_len: i32|
+             -> Result<i32> {
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                if patterns_ptr < 0 || patterns_len < 0 {
+                    anyhow::bail!("Invalid pointer or length");
+                }
+                let mut request = vec![0u8; patterns_len as usize];
+                memory.read(&caller, patterns_ptr as usize, &mut request)?;
+                let patterns: Vec<crate::scan::ScanPattern> = match serde_json::from_slice(&request) {
+                    Ok(patterns) => patterns,
+                    Err(_) => return Ok(-1),
+                };
+
+                let content = caller.data().processing_ctx.content_data.clone();
+                let matches = match crate::scan::scan(&patterns, content.as_slice()) {
+                    Ok(matches) => matches,
+                    Err(e) => {
+                        tracing::debug!("Rejected scan patterns: {}", e);
+                        return Ok(-1);
+                    }
+                };
+
+                let response = serde_json::to_vec(&matches)?;
+                let len = response.len() as i32;
+                if out_ptr >= 0 && out_len >= len {
+                    memory.write(&mut caller, out_ptr as usize, &response)?;
+                }
+                Ok(len)
+            },
+        )?;
+
+        // memory_available - Bytes the module's memory may still grow by under
+        // the host's memory limit, or -1 if there is no limit
+        linker.func_wrap(
+            "wadup",
+            "memory_available",
+            |mut caller: Caller<StoreData>| -> Result<i64> {
+                let Some(max_memory) = caller.data().resource_limiter.as_ref().map(|l| l.max_memory) else {
+                    return Ok(-1);
+                };
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+                let used = memory.data_size(&caller);
+                Ok(max_memory.saturating_sub(used) as i64)
+            },
+        )?;
+
+        // report_progress - Record how much of its work the module has done.
+        // A negative total means the total is unknown.
+        linker.func_wrap(
+            "wadup",
+            "report_progress",
+            |mut caller: Caller<StoreData>, done: i64, total: i64| -> Result<()> {
+                let report = ProgressReport {
+                    done: done.max(0),
+                    total: (total >= 0).then_some(total),
+                };
+                let data = caller.data_mut();
+                let (advanced, log) = data.progress.record(report);
+                if log {
+                    tracing::info!(
+                        "Module '{}' progress on {}: {}",
+                        data.module_name,
+                        data.processing_ctx.content_uuid,
+                        report
+                    );
+                }
+
+                if let (true, Some(timeout)) = (advanced, data.progress_timeout) {
+                    let deadline = data.cancel.restart(Some(timeout));
+                    caller.as_context_mut().set_epoch_deadline(deadline);
+                }
+                Ok(())
+            },
+        )?;
+
+        // log_message - Record a message the module logged while processing
+        // the current content. Levels are 0 (debug) to 3 (error).
+        linker.func_wrap(
+            "wadup",
+            "log_message",
+            |mut caller: Caller<StoreData>, level: i32, msg_ptr: i32, msg_len: i32| -> Result<()> {
+                if msg_ptr < 0 || msg_len < 0 {
+                    anyhow::bail!("Invalid log message pointer or length");
+                }
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+                // Longer messages are cut anyway, so don't copy more
+                let mut message = vec![0u8; (msg_len as usize).min(MAX_LOG_MESSAGE_BYTES)];
+                memory.read(&caller, msg_ptr as usize, &mut message)?;
+
+                caller.data_mut().processing_ctx.push_log(LogMessage {
+                    level: LogMessage::level_name(level).to_string(),
+                    message: String::from_utf8_lossy(&message).into_owned(),
+                    time: chrono::Utc::now(),
+                });
+                Ok(())
+            },
+        )?;
+
+        // cancelled - Whether the host asked the module to stop working on the
+        // current content (1) or not (0)
+        linker.func_wrap("wadup", "cancelled", |caller: Caller<StoreData>| -> Result<i32> {
+            Ok(caller.data().cancel.is_requested() as i32)
+        })?;
+
+        // content_type - Copy the MIME type detected from the content's
+        // leading bytes into a guest buffer. Returns the length of the type
+        // (writing nothing if the buffer is too small).
+        linker.func_wrap(
+            "wadup",
+            "content_type",
+            |mut caller: Caller<StoreData>, out_ptr: i32, out_len: i32| -> Result<i32> {
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                let content = caller.data().processing_ctx.content_data.clone();
+                let mime = crate::magic::detect_content_type(content.as_slice());
+                let len = mime.len() as i32;
+                if out_ptr >= 0 && out_len >= len {
+                    memory.write(&mut caller, out_ptr as usize, mime.as_bytes())?;
+                }
+                Ok(len)
+            },
+        )?;
+
+        // subcontent_info - Copy {"filename": ..., "content_id": ...} for the
+        // sub-content the guest emitted as /subcontent/metadata_N.json, with
+        // the filename the naming policy assigned, into a guest buffer.
+        // Returns the length of the JSON (writing nothing if the buffer is too
+        // small), or -1 if no such sub-content was emitted in this invocation.
+        linker.func_wrap(
+            "wadup",
+            "subcontent_info",
+            |mut caller: Caller<StoreData>, index: i64, out_ptr: i32, out_len: i32| -> Result<i32> {
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                let info = caller.data().processing_ctx.subcontent.iter()
+                    .find(|emission| index >= 0 && emission.index == Some(index as u64))
+                    .map(|emission| serde_json::json!({
+                        "filename": emission.filename,
+                        "content_id": emission.uuid.to_string(),
+                    }));
+                let Some(info) = info else {
+                    return Ok(-1);
+                };
+                let json = info.to_string();
+                let len = json.len() as i32;
+                if out_ptr >= 0 && out_len >= len {
+                    memory.write(&mut caller, out_ptr as usize, json.as_bytes())?;
+                }
+                Ok(len)
+            },
+        )?;
+
+        // get_secrets - Copy the JSON array of candidate secrets of a kind
+        // (e.g. "password") into a guest buffer. Returns the length of the
+        // JSON (writing nothing if the buffer is too small).
+        linker.func_wrap(
+            "wadup",
+            "get_secrets",
+            |mut caller: Caller<StoreData>, kind_ptr: i32, kind_len: i32, out_ptr: i32, out_len: i32| -> Result<i32> {
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                if kind_ptr < 0 || kind_len < 0 {
+                    anyhow::bail!("Invalid pointer or length");
+                }
+                let mut kind = vec![0u8; kind_len as usize];
+                memory.read(&caller, kind_ptr as usize, &mut kind)?;
+                let kind = String::from_utf8(kind)?;
+
+                let json = serde_json::to_string(&caller.data().secrets.get(&kind))?;
+                let len = json.len() as i32;
+                if out_ptr >= 0 && out_len >= len {
+                    memory.write(&mut caller, out_ptr as usize, json.as_bytes())?;
+                }
+                Ok(len)
+            },
+        )?;
+
+        // query_metadata - Copy {"rows": [{"module": ..., "values": {...}}]}
+        // with the rows of a table that other modules produced for the
+        // current content and whose columns equal every value of the
+        // JSON-encoded filter, into a guest buffer. Returns the length of the
+        // JSON (writing nothing if the buffer is too small), or -1 for an
+        // invalid filter.
+        linker.func_wrap(
+            "wadup",
+            "query_metadata",
+            |mut caller: Caller<StoreData>,
+             table_ptr: i32,
+             table_len: i32,
+             filter_ptr: i32,
+             filter_len: i32,
+             out_ptr: i32,
+             out_len: i32|
+             -> Result<i32> {
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                if table_ptr < 0 || table_len < 0 || filter_ptr < 0 || filter_len < 0 {
+                    anyhow::bail!("Invalid pointer or length");
+                }
+                let mut table = vec![0u8; table_len as usize];
+                memory.read(&caller, table_ptr as usize, &mut table)?;
+                let table = String::from_utf8(table)?;
+                let mut filter = vec![0u8; filter_len as usize];
+                memory.read(&caller, filter_ptr as usize, &mut filter)?;
+                let filter: HashMap<String, crate::bindings_types::Value> = if filter.is_empty() {
+                    HashMap::new()
+                } else {
+                    match serde_json::from_slice(&filter) {
+                        Ok(filter) => filter,
+                        Err(_) => return Ok(-1),
+                    }
+                };
+
+                let data = caller.data();
+                let rows = data.content_rows.query(data.processing_ctx.content_uuid, &table, &filter);
+                let json = serde_json::json!({ "rows": rows }).to_string();
+                let len = json.len() as i32;
+                if out_ptr >= 0 && out_len >= len {
+                    memory.write(&mut caller, out_ptr as usize, json.as_bytes())?;
+                }
+                Ok(len)
+            },
+        )?;
+
+        // archive_list - Copy {"format": ..., "entries": [...]} listing the
+        // content as a zip, tar or 7z archive into a guest buffer. Returns the
+        // length of the JSON (writing nothing if the buffer is too small), or
+        // -1 if the content isn't an archive the host can read.
+        linker.func_wrap(
+            "wadup",
+            "archive_list",
+            |mut caller: Caller<StoreData>, out_ptr: i32, out_len: i32| -> Result<i32> {
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                let Some(index) = caller.data_mut().archive_index() else {
+                    return Ok(-1);
+                };
+                let json = serde_json::to_string(&*index)?;
+                let len = json.len() as i32;
+                if out_ptr >= 0 && out_len >= len {
+                    memory.write(&mut caller, out_ptr as usize, json.as_bytes())?;
+                }
+                Ok(len)
+            },
+        )?;
+
+        // archive_extract_entry - Emit entry `index` of the content archive as
+        // sub-content named by the guest (the entry name if empty). Stored
+        // entries become zero-copy slices of the content; compressed ones are
+        // decompressed on the host, within the decompression budget. Returns
+        // 0, -1 if the content isn't an archive, -2 for a missing or directory
+        // entry, -3 if the entry can't be decompressed, or -4 if it is
+        // encrypted.
+        linker.func_wrap(
+            "wadup",
+            "archive_extract_entry",
+            |mut caller: Caller<StoreData>, index: i64, name_ptr: i32, name_len: i32| -> Result<i32> {
+                use crate::bindings_context::{SubContentData, SubContentEmission};
+
+                let memory = caller.get_export("memory")
+                    .and_then(|e| e.into_memory())
+                    .ok_or_else(|| anyhow::anyhow!("No memory export found"))?;
+
+                if name_ptr < 0 || name_len < 0 {
+                    anyhow::bail!("Invalid pointer or length");
+                }
+                let mut filename = vec![0u8; name_len as usize];
+                memory.read(&caller, name_ptr as usize, &mut filename)?;
+                let filename = String::from_utf8(filename)?;
+
+                let Some(archive) = caller.data_mut().archive_index() else {
+                    return Ok(-1);
+                };
+                let Some(entry) = usize::try_from(index).ok().and_then(|i| archive.entries.get(i)) else {
+                    return Ok(-2);
+                };
+                let content = caller.data().processing_ctx.content_data.clone();
+                let budget = caller.data().decompress_budget.saturating_sub(caller.data().decompressed_bytes);
+                let (data, method) = match archive.extract(content.as_slice(), entry.index, budget) {
+                    Ok(ExtractedEntry::Slice { offset, length }) => {
+                        (SubContentData::Slice { offset, length }, "archive member".to_string())
+                    }
+                    Ok(ExtractedEntry::Bytes(bytes)) => {
+                        caller.data_mut().decompressed_bytes += bytes.len() as u64;
+                        (SubContentData::Bytes(bytes::Bytes::from(bytes)), format!("decompressed: {}", entry.method))
+                    }
+                    Err(ExtractError::NoEntry) => return Ok(-2),
+                    Err(ExtractError::Corrupt(e)) => {
+                        tracing::debug!("Failed to extract archive entry {}: {}", entry.name, e);
+                        return Ok(-3);
+                    }
+                    Err(ExtractError::Encrypted) => return Ok(-4),
+                };
+
+                let requested = if filename.is_empty() { &entry.name } else { &filename };
+                let filename = caller.data_mut().namer.assign(requested);
+                caller.data_mut().processing_ctx.subcontent.push(SubContentEmission {
+                    data,
+                    filename,
+                    index: None,
+                    uuid: uuid::Uuid::new_v4(),
+                    annotations: SubContentAnnotations::with_method(method),
+                });
+                Ok(0)
+            },
+        )?;
+
+        Ok(())
+    }
+
     fn add_wasi_functions(linker: &mut Linker<StoreData>) -> Result<()> {
         use crate::wasi_impl::Errno;
 
@@ -514,9 +1254,9 @@ impl ModuleInstance {
                 let (errno, close_result) = caller.data().wasi_ctx.fd_close(fd as u32);
 
                 // If this was a metadata file, process it immediately
-                if let Some(content) = close_result.metadata_content {
+                if let Some((encoding, content)) = close_result.metadata_content {
                     tracing::debug!("Processing metadata on fd_close ({} bytes)", content.len());
-                    if let Err(e) = Self::process_metadata_content(&content, caller.data_mut()) {
+                    if let Err(e) = Self::process_metadata_content(&content, encoding, caller.data_mut()) {
                         tracing::warn!("Failed to process metadata on close: {}", e);
                     }
                 }
@@ -531,6 +1271,12 @@ impl ModuleInstance {
                         SubcontentEmissionData::Slice { offset, length } => {
                             tracing::debug!("Processing subcontent slice on fd_close: {} (offset={}, length={})", emission.filename, offset, length);
                         }
+                        SubcontentEmissionData::SliceOf { parent, offset, length } => {
+                            tracing::debug!("Processing subcontent slice on fd_close: {} (parent={}, offset={}, length={})", emission.filename, parent, offset, length);
+                        }
+                        SubcontentEmissionData::Fragments(spans) => {
+                            tracing::debug!("Processing reassembled subcontent on fd_close: {} ({} fragments)", emission.filename, spans.len());
+                        }
                     }
                     Self::process_subcontent_emission(emission, caller.data_mut());
                 }
@@ -1134,23 +1880,57 @@ impl ModuleInstance {
         Ok(())
     }
 
+    /// Whether the instance trapped and must be reset before it is reused.
+    ///
+    /// Instances are otherwise reused across content items; guests reset
+    /// their own per-content state at the start of each `process` call.
+    pub fn needs_reset(&self) -> bool {
+        self.poisoned
+    }
+
+    /// Replace the instance with a freshly instantiated copy of its module
+    pub fn reset(&mut self) -> Result<()> {
+        let mut fresh = Self::new(
+            &self.engine,
+            &self.module,
+            &self.name,
+            &self.limits,
+            self.metadata_store.clone(),
+        )?;
+        fresh.manifest = self.manifest.take();
+        fresh.version = std::mem::take(&mut self.version);
+        fresh.binary_hash = std::mem::take(&mut self.binary_hash);
+        fresh.stage = self.stage;
+        fresh.set_content_rows(self.store.data().content_rows.clone());
+        *self = fresh;
+        Ok(())
+    }
+
     pub fn process_content(
         &mut self,
-        content_uuid: uuid::Uuid,
+        info: &ContentInfo,
         content_data: crate::shared_buffer::SharedBuffer,
     ) -> Result<ProcessingContext> {
         // Update /data.bin in the in-memory filesystem (zero-copy)
         let filesystem = &self.store.data().wasi_ctx.filesystem;
         filesystem.set_data_bin(content_data.to_bytes())?;
+        Self::mount_content_info(filesystem, info)?;
+
+        // Start each content with an empty scratch directory and fresh
+        // metadata and decompression budgets
+        self.store.data().wasi_ctx.reset_scratch()?;
+        self.store.data_mut().metadata_bytes = 0;
+        self.store.data_mut().decompressed_bytes = 0;
 
         // Set up new context
-        let ctx = ProcessingContext::new(content_uuid, content_data);
+        let ctx = ProcessingContext::new(info.content_id, content_data);
         self.store.data_mut().processing_ctx = ctx;
 
-        // Replenish fuel
+        // Replenish fuel and start the clock
         if let Some(fuel) = self.fuel_limit {
             self.store.set_fuel(fuel)?;
         }
+        self.start_invocation();
 
         // Call the process function - try () -> i32 first, then () -> () for compatibility
         let result = if let Ok(process_func) = self.instance
@@ -1171,10 +1951,15 @@ impl ModuleInstance {
         // Capture stdout/stderr before checking result (capture even on error)
         let (stdout, stdout_truncated) = self.store.data().wasi_ctx.take_stdout();
         let (stderr, stderr_truncated) = self.store.data().wasi_ctx.take_stderr();
+        Self::take_log_file(&filesystem, &mut self.store.data_mut().processing_ctx);
 
         // Check result
         match result {
             Ok(0) => {
+                if self.store.data().cancel.is_requested() {
+                    tracing::info!("Module '{}' finished after a cancellation request", self.name);
+                }
+
                 // Process any remaining metadata files that weren't closed before process() returned
                 Self::process_remaining_metadata_files(&filesystem, &mut self.store)?;
 
@@ -1186,10 +1971,15 @@ impl ModuleInstance {
                     subcontent: std::mem::take(&mut ctx.subcontent),
                     metadata: std::mem::take(&mut ctx.metadata),
                     table_schemas: std::mem::take(&mut ctx.table_schemas),
+                    scan_status: ctx.scan_status.take(),
+                    aggregates: std::mem::take(&mut ctx.aggregates),
+                    attributes: std::mem::take(&mut ctx.attributes),
                     stdout: if stdout.is_empty() { None } else { Some(stdout) },
                     stderr: if stderr.is_empty() { None } else { Some(stderr) },
                     stdout_truncated,
                     stderr_truncated,
+                    log: std::mem::take(&mut ctx.log),
+                    log_truncated: ctx.log_truncated,
                 };
                 Ok(extracted)
             }
@@ -1202,18 +1992,30 @@ impl ModuleInstance {
                     subcontent: std::mem::take(&mut ctx.subcontent),
                     metadata: std::mem::take(&mut ctx.metadata),
                     table_schemas: std::mem::take(&mut ctx.table_schemas),
+                    scan_status: ctx.scan_status.take(),
+                    aggregates: std::mem::take(&mut ctx.aggregates),
+                    attributes: std::mem::take(&mut ctx.attributes),
                     stdout: if stdout.is_empty() { None } else { Some(stdout) },
                     stderr: if stderr.is_empty() { None } else { Some(stderr) },
                     stdout_truncated,
                     stderr_truncated,
+                    log: std::mem::take(&mut ctx.log),
+                    log_truncated: ctx.log_truncated,
                 };
                 // Log stderr if present for debugging
                 if let Some(ref stderr_content) = extracted.stderr {
                     tracing::warn!("Module '{}' stderr: {}", self.name, stderr_content);
                 }
+                // Guests typically exit with an error after an allocation fails
+                if self.memory_denied() {
+                    return Err(self.limit_exceeded(LimitKind::Memory).into());
+                }
                 anyhow::bail!("Module '{}' returned error code: {}", self.name, code)
             }
             Err(e) => {
+                // A trap can leave the guest runtime in an inconsistent state
+                self.poisoned = true;
+
                 // Log stdout/stderr if present for debugging (before error classification)
                 if !stdout.is_empty() {
                     tracing::info!("Module '{}' stdout: {}", self.name, stdout);
@@ -1222,24 +2024,21 @@ impl ModuleInstance {
                     tracing::warn!("Module '{}' stderr: {}", self.name, stderr);
                 }
 
-                let error_msg = e.to_string();
-                if error_msg.contains("fuel") || error_msg.contains("out of fuel") {
-                    anyhow::bail!("Module '{}' exceeded fuel limit (CPU limit)", self.name)
-                } else if error_msg.contains("stack overflow") {
-                    anyhow::bail!("Module '{}' stack overflow", self.name)
-                } else if error_msg.contains("memory") {
-                    anyhow::bail!("Module '{}' memory limit exceeded", self.name)
-                } else {
-                    Err(e.into())
+                match crate::limits::classify_error(&e) {
+                    Some(limit) => Err(self.limit_exceeded(limit).into()),
+                    None if self.memory_denied() => Err(self.limit_exceeded(LimitKind::Memory).into()),
+                    None => Err(e),
                 }
             }
         }
     }
 
-    /// Process raw metadata content (JSON bytes) and add to store data.
+    /// Process raw metadata content (JSON or MessagePack bytes) and add to store data.
     ///
-    /// This is called immediately when a /metadata/*.json file is closed,
-    /// allowing real-time processing of metadata as files are written.
+    /// This is called immediately when a /metadata/*.json or *.msgpack file is
+    /// closed, allowing real-time processing of metadata as files are written.
+    /// MessagePack files have the same structure, with Bytes values stored raw.
+    /// *.arrow files only hold rows of one table (see `arrow_ipc`).
     ///
     /// Format:
     /// ```json
@@ -1249,25 +2048,60 @@ impl ModuleInstance {
     ///   ],
     ///   "rows": [
     ///     { "table_name": "table_name", "values": [{ "Int64": 42 }] }
+    ///   ],
+    ///   "dictionaries": [
+    ///     { "table": "table_name", "column": 1, "values": ["pe", "elf"] }
+    ///   ],
+    ///   "scan_status": { "status": "partial", "reason": "size limit" },
+    ///   "aggregates": [
+    ///     { "table": "table_name", "column": "col", "function": "sum", "value": { "Int64": 42 } }
+    ///   ],
+    ///   "attributes": [
+    ///     { "key": "zip.entry_count", "value": { "Int64": 3 } }
     ///   ]
     /// }
     /// ```
-    fn process_metadata_content(content: &[u8], store_data: &mut StoreData) -> Result<()> {
-        use crate::bindings_context::MetadataRow;
+    ///
+    /// Values in a dictionary column are Int64 codes indexing the file's
+    /// dictionary for that column and are stored as the strings they stand for.
+    /// Aggregates are running totals, so a table's aggregates in a later file
+    /// replace those of earlier files. Likewise an attribute set again in a
+    /// later file replaces the earlier value.
+    fn process_metadata_content(content: &[u8], encoding: MetadataEncoding, store_data: &mut StoreData) -> Result<()> {
+        use crate::bindings_context::{Aggregate, Attribute, MetadataRow, ScanStatus};
         use crate::bindings_types::{Column, Value, TableSchema};
 
-        #[derive(serde::Deserialize)]
+        #[derive(serde::Deserialize, Default)]
         struct MetadataFile {
             #[serde(default)]
             tables: Vec<TableDef>,
             #[serde(default)]
             rows: Vec<RowDef>,
+            #[serde(default)]
+            dictionaries: Vec<DictionaryDef>,
+            #[serde(default)]
+            scan_status: Option<ScanStatus>,
+            #[serde(default)]
+            aggregates: Vec<Aggregate>,
+            #[serde(default)]
+            attributes: Vec<Attribute>,
+        }
+
+        #[derive(serde::Deserialize)]
+        struct DictionaryDef {
+            table: String,
+            column: usize,
+            values: Vec<String>,
         }
 
         #[derive(serde::Deserialize)]
         struct TableDef {
             name: String,
             columns: Vec<Column>,
+            #[serde(default)]
+            no_provenance: bool,
+            #[serde(default)]
+            shared: bool,
         }
 
         #[derive(serde::Deserialize)]
@@ -1276,12 +2110,65 @@ impl ModuleInstance {
             values: Vec<Value>,
         }
 
-        // Parse as JSON
-        let json_str = String::from_utf8(content.to_vec())
-            .map_err(|e| anyhow::anyhow!("Metadata is not valid UTF-8: {}", e))?;
+        if let Some(budget) = store_data.metadata_budget {
+            let total = store_data.metadata_bytes + content.len() as u64;
+            if total > budget {
+                anyhow::bail!(
+                    "Metadata budget of {} bytes exceeded ({} bytes written), dropping {} bytes",
+                    budget, store_data.metadata_bytes, content.len()
+                );
+            }
+        }
+        store_data.metadata_bytes += content.len() as u64;
+
+        let mut metadata: MetadataFile = match encoding {
+            MetadataEncoding::Json => {
+                let json_str = String::from_utf8(content.to_vec())
+                    .map_err(|e| anyhow::anyhow!("Metadata is not valid UTF-8: {}", e))?;
+                serde_json::from_str(&json_str)
+                    .map_err(|e| anyhow::anyhow!("Failed to parse metadata JSON: {}", e))?
+            }
+            MetadataEncoding::MsgPack => {
+                let document = crate::msgpack::to_json(content)
+                    .map_err(|e| anyhow::anyhow!("Failed to parse metadata MessagePack: {}", e))?;
+                serde_json::from_value(document)
+                    .map_err(|e| anyhow::anyhow!("Failed to parse metadata MessagePack: {}", e))?
+            }
+            MetadataEncoding::ArrowIpc => MetadataFile {
+                rows: crate::arrow_ipc::read_rows(content)?
+                    .into_iter()
+                    .map(|row| RowDef { table_name: row.table_name, values: row.values })
+                    .collect(),
+                ..Default::default()
+            },
+        };
 
-        let metadata: MetadataFile = serde_json::from_str(&json_str)
-            .map_err(|e| anyhow::anyhow!("Failed to parse metadata JSON: {}", e))?;
+        // Decode dictionary columns before anything is stored, so a bad code
+        // rejects the whole file
+        for dictionary in &metadata.dictionaries {
+            for row in metadata.rows.iter_mut().filter(|row| row.table_name == dictionary.table) {
+                let Some(value) = row.values.get_mut(dictionary.column) else {
+                    continue;
+                };
+                if let Value::Int64(code) = *value {
+                    let decoded = usize::try_from(code).ok()
+                        .and_then(|code| dictionary.values.get(code))
+                        .ok_or_else(|| anyhow::anyhow!(
+                            "Row of table '{}' uses dictionary code {} in column {}, but the dictionary has {} values",
+                            dictionary.table, code, dictionary.column, dictionary.values.len()))?;
+                    *value = Value::String(decoded.clone());
+                }
+            }
+        }
+
+        // Replace streamed values with the contents of their side files,
+        // which are removed as they are read
+        let filesystem = store_data.wasi_ctx.filesystem.clone();
+        for value in metadata.rows.iter_mut().flat_map(|row| row.values.iter_mut()) {
+            if let Value::BytesRef { path, size } = value {
+                *value = Value::Bytes(Self::take_blob(&filesystem, path, *size)?);
+            }
+        }
 
         let ctx = &mut store_data.processing_ctx;
 
@@ -1290,9 +2177,27 @@ impl ModuleInstance {
             ctx.table_schemas.push(TableSchema {
                 name: table.name,
                 columns: table.columns,
+                provenance: !table.no_provenance,
+                shared: table.shared,
             });
         }
 
+        // A later scan status replaces an earlier one
+        if metadata.scan_status.is_some() {
+            ctx.scan_status = metadata.scan_status;
+        }
+
+        // Keep only the latest snapshot of each table's aggregates
+        ctx.aggregates.retain(|old| !metadata.aggregates.iter().any(|new| new.table == old.table));
+        ctx.aggregates.extend(metadata.aggregates);
+
+        for attribute in metadata.attributes {
+            match ctx.attributes.iter_mut().find(|old| old.key == attribute.key) {
+                Some(old) => old.value = attribute.value,
+                None => ctx.attributes.push(attribute),
+            }
+        }
+
         // Process row insertions
         for row in metadata.rows {
             ctx.metadata.push(MetadataRow {
@@ -1305,6 +2210,19 @@ impl ModuleInstance {
         Ok(())
     }
 
+    /// Read and remove the side file of a streamed BytesRef value
+    fn take_blob(filesystem: &MemoryFilesystem, path: &str, size: u64) -> Result<Vec<u8>> {
+        if !path.starts_with("/metadata/") || MetadataEncoding::from_path(path).is_some() {
+            anyhow::bail!("BytesRef value points outside the metadata side files: {}", path);
+        }
+        let data = filesystem.take_file_bytes(path)
+            .map_err(|e| anyhow::anyhow!("Failed to read BytesRef side file {}: {}", path, e))?;
+        if data.len() as u64 != size {
+            anyhow::bail!("BytesRef side file {} has {} bytes, expected {}", path, data.len(), size);
+        }
+        Ok(data.to_vec())
+    }
+
     /// Process a subcontent emission (paired data+metadata files or slice reference) and add to store data.
     ///
     /// This is called immediately when a /subcontent/metadata_N.json file is closed.
@@ -1313,6 +2231,9 @@ impl ModuleInstance {
     /// (zero-copy: the BytesMut from the in-memory filesystem is frozen directly into Bytes).
     ///
     /// For slice data: If the metadata contains offset and length, it's a slice of parent content.
+    ///
+    /// For a slice of an earlier emission: the range is taken from that child as the host holds
+    /// it, sharing its bytes or narrowing its slice of the parent content, so nothing is copied.
     fn process_subcontent_emission(emission: crate::wasi_impl::SubcontentEmission, store_data: &mut StoreData) {
         use crate::bindings_context::{SubContentEmission, SubContentData};
         use crate::wasi_impl::SubcontentEmissionData;
@@ -1322,14 +2243,65 @@ impl ModuleInstance {
         let data = match emission.data {
             SubcontentEmissionData::Bytes(bytes) => SubContentData::Bytes(bytes),
             SubcontentEmissionData::Slice { offset, length } => SubContentData::Slice { offset, length },
+            SubcontentEmissionData::SliceOf { parent, offset, length } => {
+                let Some(source) = store_data.processing_ctx.subcontent.iter()
+                    .find(|earlier| earlier.index == Some(parent))
+                else {
+                    tracing::warn!("Sub-content {} is a slice of sub-content {}, which was not emitted", emission.filename, parent);
+                    return;
+                };
+                let (base, size) = match &source.data {
+                    SubContentData::Bytes(bytes) => (0, bytes.len()),
+                    SubContentData::Slice { offset, length } => (*offset, *length),
+                };
+                if offset.checked_add(length).map_or(true, |end| end > size) {
+                    tracing::warn!(
+                        "Sub-content {} (offset {}, length {}) lies outside sub-content {} of {} bytes",
+                        emission.filename, offset, length, parent, size
+                    );
+                    return;
+                }
+                match &source.data {
+                    SubContentData::Bytes(bytes) => SubContentData::Bytes(bytes.slice(offset..offset + length)),
+                    SubContentData::Slice { .. } => SubContentData::Slice { offset: base + offset, length },
+                }
+            }
+            SubcontentEmissionData::Fragments(spans) => {
+                match reassemble(store_data.processing_ctx.content_data.as_slice(), &spans) {
+                    Ok(data) => SubContentData::Bytes(bytes::Bytes::from(data)),
+                    Err(e) => {
+                        tracing::warn!("Sub-content {} can't be reassembled: {}", emission.filename, e);
+                        return;
+                    }
+                }
+            }
         };
 
+        let filename = store_data.namer.assign(&emission.filename);
         store_data.processing_ctx.subcontent.push(SubContentEmission {
             data,
-            filename: emission.filename,
+            filename,
+            index: Some(emission.index),
+            uuid: uuid::Uuid::new_v4(),
+            annotations: emission.annotations,
         });
     }
 
+    /// Collect the messages a module appended to /log/messages.jsonl, for
+    /// guests logging without the `log_message` import, and remove the file.
+    /// Lines that aren't log messages are skipped.
+    fn take_log_file(filesystem: &MemoryFilesystem, ctx: &mut ProcessingContext) {
+        let Ok(data) = filesystem.take_file_bytes(LOG_PATH) else {
+            return;
+        };
+        for line in data.split(|&b| b == b'\n').filter(|line| !line.is_empty()) {
+            match serde_json::from_slice::<LogMessage>(line) {
+                Ok(entry) => ctx.push_log(entry),
+                Err(e) => tracing::debug!("Skipping malformed line in {}: {}", LOG_PATH, e),
+            }
+        }
+    }
+
     /// Process any remaining metadata files after _start or process() completes.
     ///
     /// This is a fallback for files that weren't closed before the module function returned.
@@ -1348,12 +2320,12 @@ impl ModuleInstance {
         let entries = metadata_dir.list();
 
         for (name, is_dir) in entries {
-            if is_dir || !name.ends_with(".json") {
+            let path = format!("/metadata/{}", name);
+            let Some(encoding) = MetadataEncoding::from_path(&path).filter(|_| !is_dir) else {
                 continue;
-            }
+            };
 
             // Read the file
-            let path = format!("/metadata/{}", name);
             let contents = match filesystem.read_file(&path) {
                 Ok(c) => c,
                 Err(e) => {
@@ -1363,7 +2335,7 @@ impl ModuleInstance {
             };
 
             // Process the content
-            if let Err(e) = Self::process_metadata_content(&contents, store.data_mut()) {
+            if let Err(e) = Self::process_metadata_content(&contents, encoding, store.data_mut()) {
                 tracing::warn!("Failed to process metadata file {}: {}", path, e);
             } else {
                 tracing::debug!("Processed remaining metadata file: {}", path);
@@ -1373,6 +2345,15 @@ impl ModuleInstance {
             let _ = metadata_dir.remove(&name);
         }
 
+        // Side files of streamed values no row claimed would otherwise be
+        // left for the next content
+        for (name, is_dir) in metadata_dir.list() {
+            if !is_dir {
+                tracing::debug!("Removing unclaimed metadata side file /metadata/{}", name);
+                let _ = metadata_dir.remove(&name);
+            }
+        }
+
         Ok(())
     }
 
@@ -1380,6 +2361,77 @@ impl ModuleInstance {
         &self.name
     }
 
+    /// Reset the per-invocation state and start the clock
+    fn start_invocation(&mut self) {
+        let timeout = self.timeout;
+        let data = self.store.data_mut();
+        if let Some(limiter) = data.resource_limiter.as_mut() {
+            limiter.denied = false;
+        }
+        data.progress.reset();
+        data.namer.reset();
+        data.archive = None;
+        let deadline = data.cancel.start(timeout);
+        if self.epoch_deadlines {
+            self.store.set_epoch_deadline(deadline);
+        }
+    }
+
+    /// Handle for cooperatively cancelling the module's current invocation.
+    /// The module sees the request through `wadup.cancelled`; the handle stays
+    /// valid until the instance is reset.
+    pub fn cancel_handle(&self) -> CancelHandle {
+        self.store.data().cancel.handle()
+    }
+
+    /// Whether the memory limit refused a growth request during the last call
+    fn memory_denied(&self) -> bool {
+        self.store.data().resource_limiter.as_ref().is_some_and(|limiter| limiter.denied)
+    }
+
+    fn limit_exceeded(&self, limit: LimitKind) -> LimitExceeded {
+        LimitExceeded {
+            module: self.name.clone(),
+            limit,
+            timeout: self.timeout,
+        }
+    }
+
+    /// The module's trigger manifest, None if it handles all content
+    pub fn manifest(&self) -> Option<&ModuleManifest> {
+        self.manifest.as_deref()
+    }
+
+    /// The module's version, as recorded in row provenance
+    pub fn version(&self) -> &str {
+        &self.version
+    }
+
+    /// SHA-256 of the module binary the instance was created from
+    pub fn binary_hash(&self) -> &str {
+        &self.binary_hash
+    }
+
+    /// Take the manifest, version and stage of the module the instance was
+    /// created from
+    pub(crate) fn set_module_info(&mut self, module_info: &ModuleInfo) {
+        self.manifest = module_info.manifest.clone();
+        self.version = module_info.version.clone();
+        self.binary_hash = module_info.binary_hash.clone();
+        self.stage = module_info.stage;
+    }
+
+    /// Stage the module runs in; modules of later stages see its rows
+    pub fn stage(&self) -> usize {
+        self.stage
+    }
+
+    /// Share the rows other modules publish for each content, so this
+    /// module can query them
+    pub fn set_content_rows(&mut self, rows: ContentRows) {
+        self.store.data_mut().content_rows = rows;
+    }
+
     pub fn metadata_store(&self) -> &MetadataStore {
         &self.metadata_store
     }
@@ -1392,6 +2444,7 @@ impl ModuleInstance {
     /// - Always returns a result (even on failure)
     pub fn process_content_for_test(
         &mut self,
+        filename: &str,
         content_data: crate::shared_buffer::SharedBuffer,
     ) -> crate::test_output::TestOutput {
         use crate::test_output::{TestOutput, SubcontentOutput};
@@ -1405,18 +2458,22 @@ impl ModuleInstance {
         if let Err(e) = filesystem.set_data_bin(content_data.to_bytes()) {
             return TestOutput::failure(format!("Failed to set data.bin: {}", e), 1, String::new(), String::new(), None);
         }
+        let info = ContentInfo::describe(uuid::Uuid::new_v4(), filename, None, content_data.as_slice());
+        if let Err(e) = Self::mount_content_info(filesystem, &info) {
+            return TestOutput::failure(format!("Failed to write content info: {}", e), 1, String::new(), String::new(), None);
+        }
 
         // Set up new context
-        let content_uuid = uuid::Uuid::new_v4();
-        let ctx = ProcessingContext::new(content_uuid, content_data.clone());
+        let ctx = ProcessingContext::new(info.content_id, content_data.clone());
         self.store.data_mut().processing_ctx = ctx;
 
-        // Replenish fuel
+        // Replenish fuel and start the clock
         if let Some(fuel) = self.fuel_limit {
             if let Err(e) = self.store.set_fuel(fuel) {
                 return TestOutput::failure(format!("Failed to set fuel: {}", e), 1, String::new(), String::new(), None);
             }
         }
+        self.start_invocation();
 
         // Call the process function
         let result = if let Ok(process_func) = self.instance
@@ -1444,19 +2501,13 @@ impl ModuleInstance {
         // Determine exit code and success
         let (exit_code, error) = match &result {
             Ok(0) => (0, None),
+            Ok(code) if self.memory_denied() => (*code, Some(self.limit_exceeded(LimitKind::Memory).to_string())),
             Ok(code) => (*code, Some(format!("Module returned error code: {}", code))),
-            Err(e) => {
-                let error_msg = e.to_string();
-                if error_msg.contains("fuel") || error_msg.contains("out of fuel") {
-                    (1, Some("Module exceeded fuel limit (CPU limit)".to_string()))
-                } else if error_msg.contains("stack overflow") {
-                    (1, Some("Module stack overflow".to_string()))
-                } else if error_msg.contains("memory") {
-                    (1, Some("Module memory limit exceeded".to_string()))
-                } else {
-                    (1, Some(error_msg))
-                }
-            }
+            Err(e) => match crate::limits::classify_error(e) {
+                Some(limit) => (1, Some(self.limit_exceeded(limit).to_string())),
+                None if self.memory_denied() => (1, Some(self.limit_exceeded(LimitKind::Memory).to_string())),
+                None => (1, Some(e.to_string())),
+            },
         };
 
         // Process any remaining metadata files
@@ -1541,7 +2592,7 @@ impl ModuleInstance {
         ctx.metadata.clear();
         ctx.table_schemas.clear();
 
-        if exit_code == 0 {
+        let mut output = if exit_code == 0 {
             TestOutput::success(stdout, stderr, metadata_json, subcontent)
         } else {
             TestOutput::failure(
@@ -1551,6 +2602,85 @@ impl ModuleInstance {
                 stderr,
                 subcontent,
             )
+        };
+        output.progress = self.store.data().progress.last;
+        output
+    }
+}
+
+/// The range of content of `len` bytes a guest asked for with an offset and
+/// length, or None if either is negative or the range ends past the content
+fn content_range(offset: i64, length: i64, len: usize) -> Option<std::ops::Range<usize>> {
+    if offset < 0 || length < 0 {
+        return None;
+    }
+    let end = offset.checked_add(length)?;
+    (end as u64 <= len as u64).then_some(offset as usize..end as usize)
+}
+
+/// Concatenate ranges of the content into reassembled sub-content. Every
+/// range must lie within the content, and the result can't be larger than the
+/// content, so overlapping ranges can't inflate it.
+fn reassemble(content: &[u8], spans: &[FragmentSpan]) -> Result<Vec<u8>> {
+    let mut data = Vec::new();
+    for (i, span) in spans.iter().enumerate() {
+        let fragment = span.offset.checked_add(span.length)
+            .and_then(|end| content.get(span.offset..end))
+            .ok_or_else(|| anyhow::anyhow!(
+                "fragment {} (offset {}, length {}) lies outside the content of {} bytes",
+                i, span.offset, span.length, content.len()))?;
+        if data.len() + fragment.len() > content.len() {
+            anyhow::bail!("fragments add up to more than the content's {} bytes", content.len());
         }
+        data.extend_from_slice(fragment);
+    }
+    Ok(data)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_context_files_identify_module_and_content() {
+        let filesystem = MemoryFilesystem::new();
+        ModuleInstance::mount_config(&filesystem, &ResourceLimits::default(), "pe_parser").unwrap();
+        let parent = uuid::Uuid::new_v4();
+        let child = ContentInfo::describe(uuid::Uuid::new_v4(), "a.exe", Some(parent), b"MZ")
+            .with_ancestry(&["zip".to_string()]);
+        ModuleInstance::mount_content_info(&filesystem, &child).unwrap();
+
+        // What ReportError fills its module and content_id columns from
+        assert_eq!(filesystem.read_file(MODULE_ID_PATH).unwrap(), b"pe_parser");
+        let described: serde_json::Value = serde_json::from_slice(&filesystem.read_file(CONTENT_INFO_PATH).unwrap()).unwrap();
+        assert_eq!(described["content_id"], child.content_id.to_string());
+        assert_eq!(filesystem.read_file(ANCESTRY_PATH).unwrap(), br#"["zip"]"#);
+
+        // The next content item is top-level, so the ancestry goes away
+        let root = ContentInfo::describe(uuid::Uuid::new_v4(), "b.zip", None, b"PK");
+        ModuleInstance::mount_content_info(&filesystem, &root).unwrap();
+        assert!(filesystem.read_file(ANCESTRY_PATH).is_err());
+    }
+
+    #[test]
+    fn test_content_range() {
+        assert_eq!(content_range(2, 3, 5), Some(2..5));
+        assert_eq!(content_range(5, 0, 5), Some(5..5));
+        assert_eq!(content_range(2, 4, 5), None);
+        assert_eq!(content_range(-1, 1, 5), None);
+        assert_eq!(content_range(0, -1, 5), None);
+        // Ranges whose end overflows are rejected rather than wrapping
+        assert_eq!(content_range(1, i64::MAX, 5), None);
+        assert_eq!(content_range(i64::MAX, i64::MAX, 5), None);
+    }
+
+    #[test]
+    fn test_reassemble_fragments() {
+        let span = |offset, length| FragmentSpan { offset, length };
+        assert_eq!(reassemble(b"abcdef", &[span(4, 2), span(0, 2)]).unwrap(), b"efab");
+        assert!(reassemble(b"abcdef", &[span(4, 3)]).is_err());
+        assert!(reassemble(b"abcdef", &[span(usize::MAX, 2)]).is_err());
+        // Overlapping ranges can't make more than the content
+        assert!(reassemble(b"abcdef", &[span(0, 6), span(0, 1)]).is_err());
     }
 }
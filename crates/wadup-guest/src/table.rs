@@ -14,6 +14,8 @@ impl Table {
             .map(|(n, t)| Column {
                 name: n.to_string(),
                 data_type: t,
+                primary_key: false,
+                unique: false,
             })
             .collect();
 
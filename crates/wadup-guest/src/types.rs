@@ -11,6 +11,12 @@ pub enum DataType {
 pub struct Column {
     pub name: String,
     pub data_type: DataType,
+    /// Column is part of the table's primary key
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub primary_key: bool,
+    /// Column values must be unique within the table
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub unique: bool,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -1,6 +1,8 @@
 #!/bin/bash
 # WADUP Go Module Build Script
-# Uses standard Go with wasip1 target
+# Uses standard Go with wasip1 target, or TinyGo for a component build when
+# WADUP_TARGET=wasip2. The guest library picks its transport from the target,
+# so the same source builds either way.
 set -e
 
 echo "=== WADUP Go Build ==="
@@ -24,13 +26,34 @@ if grep -q '=> ../../guest/go' go.mod 2>/dev/null; then
     go mod edit -replace github.com/tordynnar/wadup2/guest/go=/wadup/guest/go
 fi
 
+# Migrate projects created against the old standalone guest package path,
+# which no longer matches the module path of the guest library
+LEGACY_MODULE=github.com/user/wadup-guest-go
+if grep -q "$LEGACY_MODULE" go.mod 2>/dev/null; then
+    echo "Migrating $LEGACY_MODULE to github.com/tordynnar/wadup2/guest/go..."
+    go mod edit -droprequire "$LEGACY_MODULE" -dropreplace "$LEGACY_MODULE"
+    go mod edit -require github.com/tordynnar/wadup2/guest/go@v0.0.0 \
+        -replace github.com/tordynnar/wadup2/guest/go=/wadup/guest/go
+    grep -rl --include='*.go' "$LEGACY_MODULE" . | xargs -r sed -i "s#$LEGACY_MODULE#github.com/tordynnar/wadup2/guest/go#g"
+fi
+
 # Download dependencies
 echo "Downloading dependencies..."
 go mod download || true
 
-# Build for wasip1 using standard Go
-echo "Compiling to wasip1 with Go..."
-GOOS=wasip1 GOARCH=wasm go build -o /build/output/module.wasm .
+if [ "${WADUP_TARGET:-wasip1}" = "wasip2" ]; then
+    if ! command -v tinygo >/dev/null; then
+        echo "ERROR: WADUP_TARGET=wasip2 needs tinygo on PATH"
+        exit 1
+    fi
+    echo "Compiling to a wasip2 component with TinyGo..."
+    tinygo build -target=wasip2 --wit-package /wadup/guest/wit --wit-world processor \
+        -o /build/output/module.wasm .
+else
+    # Build for wasip1 using standard Go
+    echo "Compiling to wasip1 with Go..."
+    GOOS=wasip1 GOARCH=wasm go build -o /build/output/module.wasm .
+fi
 
 # Show file size
 if [ -f "/build/output/module.wasm" ]; then
@@ -3,15 +3,12 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"os"
 
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
 	"github.com/tordynnar/wadup2/guest/go"
 )
 
-const ContentPath = "/data.bin"
-
 // TableStat holds statistics about a single table
 type TableStat struct {
 	TableName string
@@ -23,11 +20,7 @@ type TableStat struct {
 //
 //go:wasmexport process
 func process() int32 {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 1
-	}
-	return 0
+	return wadup.Main(run)
 }
 
 func main() {
@@ -35,20 +28,20 @@ func main() {
 	// Go runtime initializes on module load, process() is called repeatedly
 }
 
-func run() error {
+func run(ctx wadup.Context) error {
 	// Check if file is SQLite database
-	isSQLite, err := isSQLiteDatabase()
+	contentType, err := wadup.ContentType()
 	if err != nil {
 		return err
 	}
-	if !isSQLite {
+	if contentType != "application/vnd.sqlite3" {
 		// Not a SQLite database, silently skip
 		return nil
 	}
 
 	// Open database using database/sql with pure Go SQLite driver
 	// Use file URI with immutable and read-only mode for WASI compatibility
-	db, err := sql.Open("sqlite3", "file:"+ContentPath+"?mode=ro&immutable=1")
+	db, err := sql.Open("sqlite3", "file:"+wadup.ContentFile()+"?mode=ro&immutable=1")
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
@@ -80,25 +73,8 @@ func run() error {
 		}
 	}
 
-	// Flush metadata to file for WADUP to process
-	return wadup.Flush()
-}
-
-func isSQLiteDatabase() (bool, error) {
-	f, err := os.Open(ContentPath)
-	if err != nil {
-		return false, err
-	}
-	defer f.Close()
-
-	header := make([]byte, 16)
-	n, err := f.Read(header)
-	if err != nil || n < 16 {
-		return false, nil
-	}
-
-	expected := "SQLite format 3\x00"
-	return string(header) == expected, nil
+	// Metadata is flushed by wadup.Main when run returns
+	return nil
 }
 
 func executeQueries(db *sql.DB) ([]TableStat, error) {
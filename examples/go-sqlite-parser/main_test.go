@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tordynnar/wadup2/guest/go/wadtest"
+)
+
+// sampleDatabase builds a database with two tables and returns its bytes
+func sampleDatabase(t *testing.T) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sample.db")
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE users (name TEXT)",
+		"CREATE TABLE empty (id INTEGER)",
+		"INSERT INTO users VALUES ('alice'), ('bob'), ('carol')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestTableStats(t *testing.T) {
+	r := wadtest.Run(t, sampleDatabase(t), run)
+	if r.Code != 0 {
+		t.Fatalf("Code = %d, want 0", r.Code)
+	}
+	got := map[string]string{}
+	for _, row := range r.Rows["db_table_stats"] {
+		got[row[0].String()] = row[1].String()
+	}
+	if len(got) != 2 || got["users"] != "3" || got["empty"] != "0" {
+		t.Errorf("row counts = %v, want users: 3, empty: 0", got)
+	}
+}
+
+func TestOtherContentIsSkipped(t *testing.T) {
+	r := wadtest.Run(t, []byte("not a database"), run)
+	if r.Code != 0 || len(r.Tables) != 0 {
+		t.Errorf("Code = %d with %d tables, want 0 and none", r.Code, len(r.Tables))
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// queryHostCapabilities reports that no host import is available, so
+// capabilities come from the capabilities file only
+func queryHostCapabilities() ([]byte, bool) {
+	return nil, false
+}
@@ -0,0 +1,30 @@
+//go:build wasip1
+
+package wadup
+
+import "unsafe"
+
+// wadupABIVersion tells the host which interface version the module speaks
+//
+//go:wasmexport wadup_abi_version
+func wadupABIVersion() int32 {
+	return ABIVersion
+}
+
+// getHostCapabilities copies the host's capabilities JSON into buf and
+// returns its length; nothing is copied if buf is too small
+//
+//go:wasmimport wadup get_host_capabilities
+func getHostCapabilities(buf unsafe.Pointer, bufLen uint32) uint32
+
+// queryHostCapabilities returns the capabilities document from the host
+func queryHostCapabilities() ([]byte, bool) {
+	buf := make([]byte, 256)
+	for {
+		n := getHostCapabilities(unsafe.Pointer(unsafe.SliceData(buf)), uint32(len(buf)))
+		if int(n) <= len(buf) {
+			return buf[:n], n > 0
+		}
+		buf = make([]byte, n)
+	}
+}
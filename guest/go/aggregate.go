@@ -0,0 +1,259 @@
+package wadup
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// AggFunc is an aggregate function computed by the guest during insertion
+type AggFunc string
+
+const (
+	AggSum           AggFunc = "sum"
+	AggCount         AggFunc = "count"
+	AggMin           AggFunc = "min"
+	AggMax           AggFunc = "max"
+	AggCountDistinct AggFunc = "count_distinct"
+)
+
+// aggregateSpec is an aggregate requested through TableBuilder
+type aggregateSpec struct {
+	column  string
+	fn      AggFunc
+	groupBy string
+}
+
+// aggregateDef represents one aggregate result for serialization
+type aggregateDef struct {
+	Table    string  `json:"table"`
+	Column   string  `json:"column"`
+	Function AggFunc `json:"function"`
+	GroupBy  string  `json:"group_by,omitempty"`
+	Group    *Value  `json:"group,omitempty"`
+	Value    Value   `json:"value"`
+}
+
+// aggregator accumulates one aggregate for a table
+type aggregator struct {
+	table    string
+	column   string
+	colIndex int
+	fn       AggFunc
+	groupBy  string
+	grpIndex int
+	groups   map[interface{}]*aggState
+	order    []interface{}
+}
+
+// aggState is the running state of an aggregate for one group
+type aggState struct {
+	group    Value
+	count    int64
+	sumInt   int64
+	sumFloat float64
+	isFloat  bool
+	min      Value
+	max      Value
+	distinct map[interface{}]struct{}
+}
+
+// newAggregators validates aggregate specs against the table columns
+func newAggregators(table string, columns []Column, specs []aggregateSpec) ([]*aggregator, error) {
+	aggregates := make([]*aggregator, 0, len(specs))
+	for _, spec := range specs {
+		colIndex := columnIndex(columns, spec.column)
+		if colIndex < 0 {
+			return nil, fmt.Errorf("aggregate column '%s' not found in table '%s'", spec.column, table)
+		}
+		switch spec.fn {
+		case AggSum:
+			if dt := columns[colIndex].DataType; dt != Int64 && dt != Float64 {
+				return nil, fmt.Errorf("cannot sum column '%s' of type %s in table '%s'", spec.column, dt, table)
+			}
+		case AggMin, AggMax:
+			if dt := columns[colIndex].DataType; !orderedType(dt) {
+				return nil, fmt.Errorf("cannot take %s of column '%s' of type %s in table '%s'", spec.fn, spec.column, dt, table)
+			}
+		case AggCount, AggCountDistinct:
+		default:
+			return nil, fmt.Errorf("unknown aggregate function '%s'", spec.fn)
+		}
+		grpIndex := -1
+		if spec.groupBy != "" {
+			grpIndex = columnIndex(columns, spec.groupBy)
+			if grpIndex < 0 {
+				return nil, fmt.Errorf("group-by column '%s' not found in table '%s'", spec.groupBy, table)
+			}
+		}
+		aggregates = append(aggregates, &aggregator{
+			table:    table,
+			column:   spec.column,
+			colIndex: colIndex,
+			fn:       spec.fn,
+			groupBy:  spec.groupBy,
+			grpIndex: grpIndex,
+			groups:   make(map[interface{}]*aggState),
+		})
+	}
+	return aggregates, nil
+}
+
+// columnIndex returns the position of the named column, or -1
+func columnIndex(columns []Column, name string) int {
+	for i, c := range columns {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// reset drops the totals, for a new content item
+func (a *aggregator) reset() {
+	a.groups = make(map[interface{}]*aggState)
+	a.order = nil
+}
+
+// add folds a row into the aggregate. Rows too short to contain the
+// aggregated or group-by column are ignored.
+func (a *aggregator) add(values []Value) {
+	if a.colIndex >= len(values) || a.grpIndex >= len(values) {
+		return
+	}
+
+	var groupKey interface{}
+	var group Value
+	if a.grpIndex >= 0 {
+		group = values[a.grpIndex]
+		groupKey = group.key()
+	}
+	state, ok := a.groups[groupKey]
+	if !ok {
+		state = &aggState{group: group}
+		a.groups[groupKey] = state
+		a.order = append(a.order, groupKey)
+	}
+
+	v := values[a.colIndex]
+	if v.IsNull() {
+		// NULLs are ignored, as in SQL aggregates
+		return
+	}
+	state.count++
+	switch a.fn {
+	case AggSum:
+		switch val := v.data.(type) {
+		case int64:
+			state.sumInt += val
+		case float64:
+			state.sumFloat += val
+			state.isFloat = true
+		}
+	case AggMin:
+		if state.count == 1 || compareValues(v, state.min) < 0 {
+			state.min = v
+		}
+	case AggMax:
+		if state.count == 1 || compareValues(v, state.max) > 0 {
+			state.max = v
+		}
+	case AggCountDistinct:
+		if state.distinct == nil {
+			state.distinct = make(map[interface{}]struct{})
+		}
+		state.distinct[v.key()] = struct{}{}
+	}
+}
+
+// results returns the current value of the aggregate for every group
+func (a *aggregator) results() []aggregateDef {
+	defs := make([]aggregateDef, 0, len(a.order))
+	for _, key := range a.order {
+		state := a.groups[key]
+		def := aggregateDef{
+			Table:    a.table,
+			Column:   a.column,
+			Function: a.fn,
+			GroupBy:  a.groupBy,
+		}
+		if a.grpIndex >= 0 {
+			group := state.group
+			def.Group = &group
+		}
+		switch a.fn {
+		case AggSum:
+			if state.isFloat {
+				def.Value = NewFloat64(state.sumFloat + float64(state.sumInt))
+			} else {
+				def.Value = NewInt64(state.sumInt)
+			}
+		case AggCount:
+			def.Value = NewInt64(state.count)
+		case AggMin:
+			def.Value = state.min
+		case AggMax:
+			def.Value = state.max
+		case AggCountDistinct:
+			def.Value = NewInt64(int64(len(state.distinct)))
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// orderedType reports whether min and max are defined for a column type
+func orderedType(dt DataType) bool {
+	switch dt {
+	case Int64, Float64, String, Timestamp, Duration:
+		return true
+	}
+	return false
+}
+
+// compareValues orders two values of an ordered type; mismatched kinds compare equal
+func compareValues(a, b Value) int {
+	switch av := a.data.(type) {
+	case int64:
+		if bv, ok := b.data.(int64); ok {
+			return cmp.Compare(av, bv)
+		}
+	case float64:
+		if bv, ok := b.data.(float64); ok {
+			return cmp.Compare(av, bv)
+		}
+	case string:
+		if bv, ok := b.data.(string); ok {
+			return cmp.Compare(av, bv)
+		}
+	case time.Time:
+		if bv, ok := b.data.(time.Time); ok {
+			return av.Compare(bv)
+		}
+	case time.Duration:
+		if bv, ok := b.data.(time.Duration); ok {
+			return cmp.Compare(av, bv)
+		}
+	}
+	return 0
+}
+
+// snapshotAggregates collects the running totals of all registered aggregates.
+// Every Flush carries the cumulative values, so the last snapshot for a table
+// is authoritative. Caller must hold metadataMu.
+func snapshotAggregates() []aggregateDef {
+	tables := make([]string, 0, len(tableAggregates))
+	for name := range tableAggregates {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+
+	var defs []aggregateDef
+	for _, name := range tables {
+		for _, agg := range tableAggregates[name] {
+			defs = append(defs, agg.results()...)
+		}
+	}
+	return defs
+}
@@ -0,0 +1,109 @@
+package wadup
+
+import "testing"
+
+// aggregateResults returns the current aggregates of a table, keyed by
+// function and group
+func aggregateResults(table string) map[string]string {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	results := make(map[string]string)
+	for _, def := range snapshotAggregates() {
+		if def.Table != table {
+			continue
+		}
+		key := string(def.Function)
+		if def.Group != nil {
+			key += "/" + def.Group.String()
+		}
+		results[key] = def.Value.String()
+	}
+	return results
+}
+
+func TestAggregates(t *testing.T) {
+	useRecordingTransport(t)
+	table, err := NewTableBuilder("aggregated_sections").
+		Column("kind", String).
+		Column("size", Int64).
+		Column("entropy", Float64).
+		Column("name", String).
+		Nullable().
+		Aggregate("size", AggSum).
+		Aggregate("size", AggMin).
+		Aggregate("entropy", AggMax).
+		Aggregate("name", AggCountDistinct).
+		AggregateBy("size", AggCount, "kind").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][]Value{
+		{NewString("code"), NewInt64(40), NewFloat64(6.5), NewString(".text")},
+		{NewString("data"), NewInt64(10), NewFloat64(2.25), NewString(".data")},
+		{NewString("code"), NewInt64(25), NewFloat64(7.75), NewString(".text")},
+		// NULLs are left out, as in SQL aggregates
+		{NewString("data"), NewInt64(5), NewFloat64(0), Null()},
+	}
+	if err := table.InsertRows(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := drainTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"sum":            "80",
+		"min":            "5",
+		"max":            "7.75",
+		"count_distinct": "2",
+		"count/code":     "2",
+		"count/data":     "2",
+	}
+	got := aggregateResults("aggregated_sections")
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got aggregates %v, want %v", got, want)
+	}
+
+	// The next content item starts from empty totals
+	resetTableState(false)
+	if got := aggregateResults("aggregated_sections"); len(got) != 0 {
+		t.Errorf("aggregates after reset = %v, want none", got)
+	}
+	if err := table.InsertRow(rows[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := drainTables(); err != nil {
+		t.Fatal(err)
+	}
+	if got := aggregateResults("aggregated_sections"); got["sum"] != "40" || got["count/code"] != "1" {
+		t.Errorf("aggregates of the next content item = %v", got)
+	}
+}
+
+func TestAggregateSpecsAreChecked(t *testing.T) {
+	columns := []Column{
+		{Name: "name", DataType: String},
+		{Name: "flag", DataType: Bool},
+	}
+	for _, spec := range []aggregateSpec{
+		{column: "missing", fn: AggCount},
+		{column: "name", fn: AggSum},
+		{column: "flag", fn: AggMin},
+		{column: "name", fn: "median"},
+		{column: "name", fn: AggCount, groupBy: "missing"},
+	} {
+		if _, err := newAggregators("checked", columns, []aggregateSpec{spec}); err == nil {
+			t.Errorf("spec %+v was accepted", spec)
+		}
+	}
+	if _, err := newAggregators("checked", columns, []aggregateSpec{{column: "name", fn: AggMax}}); err != nil {
+		t.Errorf("max of a String column rejected: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package wadup
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Host-provided files describing where the current content came from.
+//
+// AncestryPath holds a JSON array of the module IDs that analyzed the
+// content's ancestors, outermost first (the analyzed_by chain recorded when
+// the content was emitted), and is absent for top-level content.
+// ModuleIDPath holds the ID of the running module, its name on the host.
+// Both are absent on older hosts.
+const (
+	AncestryPath = "/wadup/ancestry.json"
+	ModuleIDPath = "/wadup/module_id"
+)
+
+// ModuleID returns the host-assigned ID of the running module, or "" if the
+// host does not provide one.
+func ModuleID() string {
+	data, err := os.ReadFile(ModuleIDPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Ancestry returns the module IDs that analyzed the ancestors of the current
+// content, outermost first.
+func Ancestry() []string {
+	data, err := os.ReadFile(AncestryPath)
+	if err != nil {
+		return nil
+	}
+	var chain []string
+	if err := json.Unmarshal(data, &chain); err != nil {
+		return nil
+	}
+	return chain
+}
+
+// AlreadyAnalyzedBy reports whether moduleID appears in the ancestry of the
+// current content, letting a module skip content it (or an equivalent module)
+// already handled upstream and so break cross-module processing loops.
+func AlreadyAnalyzedBy(moduleID string) bool {
+	return slices.Contains(Ancestry(), moduleID)
+}
+
+// analyzedByChain returns the chain recorded on emitted sub-content:
+// the current ancestry followed by the running module's ID
+func analyzedByChain() []string {
+	chain := Ancestry()
+	if id := ModuleID(); id != "" {
+		chain = append(chain, id)
+	}
+	return chain
+}
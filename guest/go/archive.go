@@ -0,0 +1,162 @@
+package wadup
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotArchive is returned when the content is not an archive that can be
+// listed
+var ErrNotArchive = errors.New("content is not a supported archive")
+
+// ErrEncryptedEntry is returned when extracting an encrypted archive entry
+var ErrEncryptedEntry = errors.New("archive entry is encrypted")
+
+// ArchiveEntry is one entry of the archive being processed
+type ArchiveEntry struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	// Size is the uncompressed size in bytes
+	Size int64 `json:"size"`
+	// CompressedSize is the size of the entry's data in the archive, or 0
+	// if the format doesn't record it per entry
+	CompressedSize int64 `json:"compressed_size,omitempty"`
+	// Method is "stored", "deflate", "bzip2", "zstd", "xz", "7z" or
+	// "method-N"
+	Method    string `json:"method"`
+	IsDir     bool   `json:"is_dir"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// ArchiveListing lists the entries of the archive being processed
+type ArchiveListing struct {
+	// Format is "zip", "tar" or "sevenzip"
+	Format  string         `json:"format"`
+	Entries []ArchiveEntry `json:"entries"`
+}
+
+// ListArchive lists the entries of the content as a zip, tar or 7z archive,
+// without reading the entries themselves into module memory.
+//
+// If the host advertises FeatureArchive the archive is indexed by the host.
+// Otherwise only zip archives can be listed, by the guest.
+func ListArchive() (ArchiveListing, error) {
+	listing, ok, err := hostListArchive()
+	if ok {
+		return listing, err
+	}
+
+	zr, closeContent, err := openContentZip()
+	if err != nil {
+		return ArchiveListing{}, err
+	}
+	defer closeContent()
+
+	listing = ArchiveListing{Format: "zip"}
+	for i, f := range zr.File {
+		listing.Entries = append(listing.Entries, ArchiveEntry{
+			Index:          i,
+			Name:           f.Name,
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			Method:         zipMethodName(f.Method),
+			IsDir:          f.FileInfo().IsDir(),
+			Encrypted:      f.Flags&1 != 0,
+		})
+	}
+	return listing, nil
+}
+
+// ExtractArchiveEntry emits entry index of the archive being processed (see
+// ListArchive) as sub-content named filename, or under the entry's own name
+// if filename is empty.
+//
+// If the host advertises FeatureArchive the entry never passes through
+// module memory: stored entries become zero-copy slices of the content and
+// compressed ones are decompressed by the host. Otherwise zip entries are
+// extracted by the guest, stored ones still as slices. Returns ErrNotArchive
+// for content that is not an archive and ErrEncryptedEntry for encrypted
+// entries.
+func ExtractArchiveEntry(index int, filename string) error {
+	if ok, err := hostExtractArchiveEntry(index, filename); ok {
+		return err
+	}
+
+	zr, closeContent, err := openContentZip()
+	if err != nil {
+		return err
+	}
+	defer closeContent()
+
+	if index < 0 || index >= len(zr.File) || zr.File[index].FileInfo().IsDir() {
+		return fmt.Errorf("archive has no file entry %d", index)
+	}
+	f := zr.File[index]
+	if f.Flags&1 != 0 {
+		return fmt.Errorf("%w: '%s'", ErrEncryptedEntry, f.Name)
+	}
+	if filename == "" {
+		filename = f.Name
+	}
+
+	if f.Method == zip.Store {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return fmt.Errorf("failed to locate archive entry '%s': %w", f.Name, err)
+		}
+		_, err = EmitSliceWithOptions(offset, int64(f.CompressedSize64), filename, EmitOptions{Method: MethodArchiveMember})
+		return err
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open archive entry '%s': %w", f.Name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to extract archive entry '%s': %w", f.Name, err)
+	}
+	_, err = EmitBytesWithMethod(data, filename, MethodDecompressed+": "+zipMethodName(f.Method))
+	return err
+}
+
+// openContentZip opens the content as a zip archive for the guest fallback
+func openContentZip() (*zip.Reader, func(), error) {
+	size, err := contentSize()
+	if err != nil {
+		return nil, nil, err
+	}
+	path := contentPath()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open content '%s': %w", path, err)
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%w: %v", ErrNotArchive, err)
+	}
+	return zr, func() { f.Close() }, nil
+}
+
+// zipMethodName names a zip compression method as the host does
+func zipMethodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "stored"
+	case zip.Deflate:
+		return "deflate"
+	case 12:
+		return "bzip2"
+	case 93:
+		return "zstd"
+	case 95:
+		return "xz"
+	default:
+		return fmt.Sprintf("method-%d", method)
+	}
+}
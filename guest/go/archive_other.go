@@ -0,0 +1,16 @@
+//go:build !wasip1
+
+package wadup
+
+// hostListArchive reports that the host cannot list archives, since there is
+// no archive_list import outside preview1 builds
+func hostListArchive() (ArchiveListing, bool, error) {
+	return ArchiveListing{}, false, nil
+}
+
+// hostExtractArchiveEntry reports that the host cannot extract archive
+// entries, since there is no archive_extract_entry import outside preview1
+// builds
+func hostExtractArchiveEntry(index int, filename string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,76 @@
+//go:build wasip1
+
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+// Error codes returned by the archive imports
+const (
+	archiveErrNotArchive = -1
+	archiveErrNoEntry    = -2
+	archiveErrCorrupt    = -3
+	archiveErrEncrypted  = -4
+)
+
+// archiveList copies the JSON listing of the content archive into out,
+// returning its length. Nothing is copied if out is too small.
+//
+//go:wasmimport wadup archive_list
+func archiveList(out unsafe.Pointer, outLen uint32) int32
+
+// archiveExtractEntry emits an entry of the content archive as sub-content,
+// returning 0 or a negative error code
+//
+//go:wasmimport wadup archive_extract_entry
+func archiveExtractEntry(index int64, name unsafe.Pointer, nameLen uint32) int32
+
+// hostListArchive asks the host to list the content archive if it supports
+// it. ok is false if the host cannot, and the guest must list it itself.
+func hostListArchive() (listing ArchiveListing, ok bool, err error) {
+	if !HostSupports(FeatureArchive) {
+		return ArchiveListing{}, false, nil
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n := archiveList(unsafe.Pointer(unsafe.SliceData(buf)), uint32(len(buf)))
+		if n < 0 {
+			return ArchiveListing{}, true, ErrNotArchive
+		}
+		if int(n) <= len(buf) {
+			if err := json.Unmarshal(buf[:n], &listing); err != nil {
+				return ArchiveListing{}, true, fmt.Errorf("failed to parse archive listing: %w", err)
+			}
+			return listing, true, nil
+		}
+		buf = make([]byte, n)
+	}
+}
+
+// hostExtractArchiveEntry has the host emit an archive entry if it supports
+// it. ok is false if the host cannot, and the guest must extract it itself.
+func hostExtractArchiveEntry(index int, filename string) (ok bool, err error) {
+	if !HostSupports(FeatureArchive) {
+		return false, nil
+	}
+
+	rc := archiveExtractEntry(int64(index), unsafe.Pointer(unsafe.StringData(filename)), uint32(len(filename)))
+	switch rc {
+	case 0:
+		return true, nil
+	case archiveErrNotArchive:
+		return true, ErrNotArchive
+	case archiveErrNoEntry:
+		return true, fmt.Errorf("archive has no file entry %d", index)
+	case archiveErrCorrupt:
+		return true, fmt.Errorf("failed to extract archive entry %d: invalid or unsupported data", index)
+	case archiveErrEncrypted:
+		return true, fmt.Errorf("%w: entry %d", ErrEncryptedEntry, index)
+	default:
+		return true, fmt.Errorf("failed to extract archive entry %d: error %d", index, rc)
+	}
+}
@@ -0,0 +1,344 @@
+package wadup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"time"
+)
+
+// OutputFormat selects how table rows are handed to the host
+type OutputFormat int
+
+const (
+	// FormatJSON writes rows inside the metadata file (the default)
+	FormatJSON OutputFormat = iota
+	// FormatArrowIPC writes the rows of each table as an Arrow IPC stream
+	// holding one record batch, in /metadata/output_N.arrow
+	FormatArrowIPC
+)
+
+// Host feature required for FormatArrowIPC
+const FeatureArrowIPCMetadata = "arrow_ipc_metadata"
+
+// Arrow schema metadata keys identifying the WADUP table, column types and
+// column constraints
+const (
+	arrowTableKey      = "wadup.table"
+	arrowDataTypeKey   = "wadup.data_type"
+	arrowPrimaryKeyKey = "wadup.primary_key"
+	arrowUniqueKey     = "wadup.unique"
+)
+
+// Arrow flatbuffer enum values, from Schema.fbs and Message.fbs
+const (
+	arrowMetadataV5      = 4
+	arrowHeaderSchema    = 1
+	arrowHeaderBatch     = 3
+	arrowTypeInt         = 2
+	arrowTypeFloat       = 3
+	arrowTypeBinary      = 4
+	arrowTypeUtf8        = 5
+	arrowTypeBool        = 6
+	arrowTypeTimestamp   = 10
+	arrowTypeDuration    = 18
+	arrowPrecisionDouble = 2
+	arrowUnitMicrosecond = 2
+	arrowUnitNanosecond  = 3
+)
+
+var (
+	outputFormat       = FormatJSON
+	outputNegotiated   bool
+	outputFormatActive = FormatJSON
+)
+
+// SetOutputFormat selects how table rows are written. With FormatArrowIPC,
+// each table's buffered rows are written as an Arrow record batch the host
+// can load directly into columnar sinks, while table definitions, scan status,
+// aggregates and attributes stay in the regular metadata file.
+//
+// Arrow output is only used if the host advertises support for it (see
+// HostSupports); otherwise rows are written as usual. Tables whose
+// pending rows carry truncation markers or streamed bytes (see
+// InsertRowStreaming) are also written as usual, since Arrow batches have no
+// place for either.
+func SetOutputFormat(format OutputFormat) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	outputFormat = format
+	outputNegotiated = false
+}
+
+// negotiatedOutputFormat returns the output format to use, checking the
+// host's capabilities once per requested format. Caller must hold metadataMu.
+func negotiatedOutputFormat() OutputFormat {
+	if !outputNegotiated {
+		outputFormatActive = FormatJSON
+		if outputFormat == FormatArrowIPC && HostSupports(FeatureArrowIPCMetadata) {
+			outputFormatActive = FormatArrowIPC
+		}
+		outputNegotiated = true
+	}
+	return outputFormatActive
+}
+
+// arrowBatch holds the rows of one table destined for an Arrow stream
+type arrowBatch struct {
+	schema TableSchema
+	rows   [][]Value
+}
+
+// splitArrowRows separates the rows that can be written as Arrow batches,
+// grouped by table in order of first appearance, from the rows that must
+// stay in the metadata file. Caller must hold metadataMu.
+func splitArrowRows(rows []rowDef) ([]*arrowBatch, []rowDef) {
+	schemas := make(map[string]TableSchema, len(registeredTables))
+	for _, t := range registeredTables {
+		schemas[t.Name] = t
+	}
+
+	eligible := make(map[string]bool)
+	for _, row := range rows {
+		ok, seen := eligible[row.TableName]
+		if seen && !ok {
+			continue
+		}
+		schema, defined := schemas[row.TableName]
+		eligible[row.TableName] = defined && arrowEncodable(schema, row)
+	}
+
+	var batches []*arrowBatch
+	var remaining []rowDef
+	byTable := make(map[string]*arrowBatch)
+	for _, row := range rows {
+		if !eligible[row.TableName] {
+			remaining = append(remaining, row)
+			continue
+		}
+		batch := byTable[row.TableName]
+		if batch == nil {
+			batch = &arrowBatch{schema: schemas[row.TableName]}
+			byTable[row.TableName] = batch
+			batches = append(batches, batch)
+		}
+		batch.rows = append(batch.rows, row.Values)
+	}
+	return batches, remaining
+}
+
+// arrowEncodable reports whether a row fits its table's Arrow schema
+func arrowEncodable(schema TableSchema, row rowDef) bool {
+	if row.Truncated || len(row.Values) != len(schema.Columns) {
+		return false
+	}
+	for i, v := range row.Values {
+		if v.IsNull() {
+			continue
+		}
+		if v.dataType() != schema.Columns[i].DataType {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeArrowBatch encodes a batch as an Arrow IPC stream: a schema message,
+// one record batch message and the end-of-stream marker
+func encodeArrowBatch(batch *arrowBatch) ([]byte, error) {
+	var out []byte
+	out = appendArrowMessage(out, arrowHeaderSchema, arrowSchema(batch.schema), nil)
+
+	recordBatch, body, err := arrowRecordBatch(batch)
+	if err != nil {
+		return nil, err
+	}
+	out = appendArrowMessage(out, arrowHeaderBatch, recordBatch, body)
+
+	// End of stream: continuation marker followed by a zero length
+	out = binary.LittleEndian.AppendUint32(out, 0xFFFFFFFF)
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	return out, nil
+}
+
+// appendArrowMessage appends an encapsulated IPC message: continuation
+// marker, metadata length, flatbuffer Message padded to 8 bytes, and body
+func appendArrowMessage(out []byte, headerType uint8, header fbTable, body []byte) []byte {
+	message := fbTable{
+		fbInt16(0, arrowMetadataV5),
+		fbInt8(1, headerType),
+		fbChild(2, header),
+		fbInt64(3, int64(len(body))),
+	}
+	b := &fbBuilder{}
+	meta := b.finish(message)
+
+	out = binary.LittleEndian.AppendUint32(out, 0xFFFFFFFF)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(meta)))
+	out = append(out, meta...)
+	return append(out, body...)
+}
+
+// arrowKeyValue builds a KeyValue table
+func arrowKeyValue(key, value string) fbTable {
+	return fbTable{fbChild(0, fbString(key)), fbChild(1, fbString(value))}
+}
+
+// arrowSchema builds the Schema table for a WADUP table
+func arrowSchema(schema TableSchema) fbTable {
+	fields := make(fbVector, len(schema.Columns))
+	for i, col := range schema.Columns {
+		typeID, typ := arrowType(col.DataType)
+		metadata := fbVector{arrowKeyValue(arrowDataTypeKey, string(col.DataType))}
+		if col.PrimaryKey {
+			metadata = append(metadata, arrowKeyValue(arrowPrimaryKeyKey, "true"))
+		}
+		if col.Unique {
+			metadata = append(metadata, arrowKeyValue(arrowUniqueKey, "true"))
+		}
+		fields[i] = fbTable{
+			fbChild(0, fbString(col.Name)),
+			fbBool(1, col.Nullable),
+			fbInt8(2, typeID),
+			fbChild(3, typ),
+			fbChild(5, fbVector{}),
+			fbChild(6, metadata),
+		}
+	}
+	return fbTable{
+		fbInt16(0, 0), // little endian
+		fbChild(1, fields),
+		fbChild(2, fbVector{arrowKeyValue(arrowTableKey, schema.Name)}),
+	}
+}
+
+// arrowType returns the Arrow type union member for a column type. Types
+// without a direct Arrow equivalent are written in their text form.
+func arrowType(dt DataType) (uint8, fbTable) {
+	switch dt {
+	case Int64:
+		return arrowTypeInt, fbTable{fbInt32(0, 64), fbBool(1, true)}
+	case Float64:
+		return arrowTypeFloat, fbTable{fbInt16(0, arrowPrecisionDouble)}
+	case Bool:
+		return arrowTypeBool, fbTable{}
+	case Bytes:
+		return arrowTypeBinary, fbTable{}
+	case Timestamp:
+		return arrowTypeTimestamp, fbTable{fbInt16(0, arrowUnitMicrosecond), fbChild(1, fbString("UTC"))}
+	case Duration:
+		return arrowTypeDuration, fbTable{fbInt16(0, arrowUnitNanosecond)}
+	default:
+		return arrowTypeUtf8, fbTable{}
+	}
+}
+
+// arrowBody accumulates the buffers of a record batch body
+type arrowBody struct {
+	data    []byte
+	buffers []byte
+	nodes   []byte
+}
+
+// addBuffer appends a buffer, padded to 8 bytes, and records its location
+func (a *arrowBody) addBuffer(buf []byte) {
+	a.buffers = binary.LittleEndian.AppendUint64(a.buffers, uint64(len(a.data)))
+	a.buffers = binary.LittleEndian.AppendUint64(a.buffers, uint64(len(buf)))
+	a.data = append(a.data, buf...)
+	for len(a.data)%8 != 0 {
+		a.data = append(a.data, 0)
+	}
+}
+
+// arrowRecordBatch builds the RecordBatch table and its body
+func arrowRecordBatch(batch *arrowBatch) (fbTable, []byte, error) {
+	n := len(batch.rows)
+	body := &arrowBody{}
+	for i, col := range batch.schema.Columns {
+		validity := make([]byte, (n+7)/8)
+		nulls := 0
+		for r, row := range batch.rows {
+			if row[i].IsNull() {
+				nulls++
+			} else {
+				validity[r/8] |= 1 << (r % 8)
+			}
+		}
+		body.nodes = binary.LittleEndian.AppendUint64(body.nodes, uint64(n))
+		body.nodes = binary.LittleEndian.AppendUint64(body.nodes, uint64(nulls))
+		if nulls == 0 {
+			validity = nil
+		}
+		body.addBuffer(validity)
+
+		switch col.DataType {
+		case Int64, Float64, Timestamp, Duration:
+			values := make([]byte, 0, 8*n)
+			for _, row := range batch.rows {
+				values = binary.LittleEndian.AppendUint64(values, arrowFixedValue(row[i]))
+			}
+			body.addBuffer(values)
+		case Bool:
+			values := make([]byte, (n+7)/8)
+			for r, row := range batch.rows {
+				if v, ok := row[i].data.(bool); ok && v {
+					values[r/8] |= 1 << (r % 8)
+				}
+			}
+			body.addBuffer(values)
+		default:
+			offsets := make([]byte, 0, 4*(n+1))
+			var data []byte
+			offsets = binary.LittleEndian.AppendUint32(offsets, 0)
+			for _, row := range batch.rows {
+				data = append(data, arrowVariableValue(row[i])...)
+				if len(data) > math.MaxInt32 {
+					return nil, nil, fmt.Errorf("column '%s' of table '%s' exceeds the Arrow size limit", col.Name, batch.schema.Name)
+				}
+				offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+			}
+			body.addBuffer(offsets)
+			body.addBuffer(data)
+		}
+	}
+
+	recordBatch := fbTable{
+		fbInt64(0, int64(n)),
+		fbChild(1, fbStructVector{count: len(body.nodes) / 16, align: 8, data: body.nodes}),
+		fbChild(2, fbStructVector{count: len(body.buffers) / 16, align: 8, data: body.buffers}),
+	}
+	return recordBatch, body.data, nil
+}
+
+// arrowFixedValue returns the 8-byte little-endian representation of a
+// fixed-width value. NULL slots are written as zero.
+func arrowFixedValue(v Value) uint64 {
+	switch val := v.data.(type) {
+	case int64:
+		return uint64(val)
+	case float64:
+		return math.Float64bits(val)
+	case time.Time:
+		return uint64(val.UnixMicro())
+	case time.Duration:
+		return uint64(val)
+	default:
+		return 0
+	}
+}
+
+// arrowVariableValue returns the bytes of a variable-width value. NULL slots
+// are empty.
+func arrowVariableValue(v Value) []byte {
+	switch val := v.data.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return val
+	case netip.Addr:
+		return []byte(val.String())
+	default:
+		return []byte(v.String())
+	}
+}
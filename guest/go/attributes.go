@@ -0,0 +1,33 @@
+package wadup
+
+import "fmt"
+
+// attributeDef represents a key-value fact for serialization
+type attributeDef struct {
+	Key   string `json:"key"`
+	Value Value  `json:"value"`
+}
+
+// SetAttribute records a single scalar fact about the content (e.g.
+// "pe.compile_timestamp", "zip.entry_count") without defining a table.
+//
+// Attributes are written to a reserved section of the metadata file on the
+// next Flush, and the host indexes a module's attributes for a content as one
+// attributes document. Setting a key again replaces its value; use dotted keys
+// prefixed with the format name to avoid collisions.
+func SetAttribute(key string, value Value) error {
+	if key == "" {
+		return fmt.Errorf("attribute key must not be empty")
+	}
+
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	for i, attr := range accumulatedAttrs {
+		if attr.Key == key {
+			accumulatedAttrs[i].Value = value
+			return nil
+		}
+	}
+	accumulatedAttrs = append(accumulatedAttrs, attributeDef{Key: key, Value: value})
+	return nil
+}
@@ -0,0 +1,56 @@
+package wadup
+
+// TableStats describes the output of a table, so modules can sample or
+// truncate before reaching the host's MetadataBudget
+type TableStats struct {
+	// Rows is the number of rows inserted for the current content item
+	Rows int
+	// BufferedBytes estimates the encoded size of the rows not yet handed
+	// to the host
+	BufferedBytes int
+	// Flushes is the number of times the table's rows were written out
+	Flushes int
+}
+
+// Stats returns the table's row count, buffered bytes and flush count
+func (t *Table) Stats() TableStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := 0
+	for _, row := range t.pending {
+		pending += estimateRowSize(row)
+	}
+
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return TableStats{
+		Rows:          tableRowCounts[t.name] + len(t.pending),
+		BufferedBytes: tableBufferedBytes[t.name] + pending,
+		Flushes:       tableFlushes[t.name],
+	}
+}
+
+// MetadataBudget returns the most bytes of metadata the host accepts from
+// the module per content item, and false if the host sets no cap. The host
+// drops metadata files beyond the budget, so modules that can produce many
+// rows should compare it with MetadataWritten and Table.Stats and sample or
+// truncate their output rather than lose it.
+func MetadataBudget() (int64, bool) {
+	caps := loadCapabilities()
+	return caps.MetadataBudget, caps.MetadataBudget > 0
+}
+
+// MetadataWritten returns the bytes of metadata files written since the
+// current content item began
+func MetadataWritten() int64 {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return metadataWritten
+}
+
+// resetMetadataWritten starts counting the metadata of a new content item
+func resetMetadataWritten() {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	metadataWritten = 0
+}
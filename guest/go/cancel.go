@@ -0,0 +1,20 @@
+package wadup
+
+import "errors"
+
+// ErrCancelled is returned by long-running work that stopped because the
+// host asked it to (see Cancelled). When run returns it, Main keeps the
+// results gathered so far and marks the content as partially scanned
+// instead of failing.
+var ErrCancelled = errors.New("processing cancelled by host")
+
+// Cancelled reports whether the host has asked the module to stop working on
+// the current content, for example because its time limit is nearly used up.
+// Long-running loops should poll it and return ErrCancelled, so the work done
+// so far is kept rather than lost when the host interrupts the module.
+//
+// Polling is cheap. On hosts without FeatureCancellation it always reports
+// false.
+func Cancelled() bool {
+	return hostCancelled()
+}
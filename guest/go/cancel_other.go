@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostCancelled reports no cancellation, since there is no cancelled import
+// outside preview1 builds
+func hostCancelled() bool {
+	return false
+}
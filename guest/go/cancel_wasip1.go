@@ -0,0 +1,13 @@
+//go:build wasip1
+
+package wadup
+
+// cancelledImport returns 1 if the host requested cancellation, 0 otherwise
+//
+//go:wasmimport wadup cancelled
+func cancelledImport() int32
+
+// hostCancelled polls the host for a cancellation request if it supports it
+func hostCancelled() bool {
+	return HostSupports(FeatureCancellation) && cancelledImport() != 0
+}
@@ -0,0 +1,115 @@
+package wadup
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+)
+
+// ABIVersion is the version of the guest/host interface this library speaks.
+// It is exported to the host as wadup_abi_version, and hosts reject modules
+// newer than they support.
+const ABIVersion = 1
+
+// CapabilitiesPath is where hosts without the get_host_capabilities import
+// advertise the optional features they support
+const CapabilitiesPath = "/wadup/capabilities.json"
+
+// Host features a guest can negotiate
+const (
+	// FeatureMsgPackMetadata means the host accepts MessagePack metadata files
+	FeatureMsgPackMetadata = "msgpack_metadata"
+	// FeatureStreamingSubContent means the host accepts sub-content data
+	// written incrementally through a SubContentWriter
+	FeatureStreamingSubContent = "streaming_subcontent"
+	// FeatureLogging means the host collects messages written with Logf
+	FeatureLogging = "logging"
+	// FeatureHashContent means the host provides the hash_content import
+	// used by HashContent
+	FeatureHashContent = "hash_content"
+	// FeatureDecompress means the host provides the decompress_slice import
+	// used by DecompressSlice
+	FeatureDecompress = "decompress"
+	// FeatureScanContent means the host provides the scan_content import used
+	// by ScanContent
+	FeatureScanContent = "scan_content"
+	// FeatureContentType means the host provides the content_type import
+	// used by ContentType
+	FeatureContentType = "content_type"
+	// FeatureMemoryLimit means the host provides the memory_available import
+	// used by AvailableMemory
+	FeatureMemoryLimit = "memory_limit"
+	// FeatureProgress means the host provides the report_progress import
+	// used by ReportProgress
+	FeatureProgress = "progress"
+	// FeatureCancellation means the host provides the cancelled import used
+	// by Cancelled
+	FeatureCancellation = "cancellation"
+	// FeatureSubContentInfo means the host provides the subcontent_info
+	// import used by SubContentRef.Info
+	FeatureSubContentInfo = "subcontent_info"
+	// FeatureEmitFile means the host can take sub-content directly from a
+	// guest file for EmitFile
+	FeatureEmitFile = "emit_file"
+	// FeatureCompressedSubContent means the host decompresses sub-content
+	// written by EmitBytesCompressed
+	FeatureCompressedSubContent = "compressed_subcontent"
+	// FeatureArchive means the host provides the archive_list and
+	// archive_extract_entry imports used by ListArchive and
+	// ExtractArchiveEntry
+	FeatureArchive = "archive"
+	// FeatureSecrets means the host provides the get_secrets import used by
+	// GetSecrets
+	FeatureSecrets = "secrets"
+	// FeatureQueryMetadata means the host provides the query_metadata import
+	// used by QueryMetadata
+	FeatureQueryMetadata = "query_metadata"
+	// FeatureScratch means the host provides a writable ScratchDir that is
+	// emptied before each content item
+	FeatureScratch = "scratch"
+	// FeatureDictionaryColumns means the host decodes dictionary columns
+	// sent as codes into their strings
+	FeatureDictionaryColumns = "dictionary_columns"
+	// FeatureReassembly means the host reassembles sub-content emitted by
+	// EmitFragments from ranges of the content
+	FeatureReassembly = "reassembly"
+)
+
+// hostCapabilities is the document returned by get_host_capabilities and
+// stored in the capabilities file
+type hostCapabilities struct {
+	ABIVersion int      `json:"abi_version"`
+	Features   []string `json:"features"`
+	// MetadataBudget is the host's cap on metadata bytes per content item
+	MetadataBudget int64 `json:"metadata_budget,omitempty"`
+}
+
+// loadCapabilities asks the host for its capabilities, falling back to the
+// capabilities file. Hosts offering neither support none of the optional
+// features.
+func loadCapabilities() hostCapabilities {
+	data, ok := queryHostCapabilities()
+	if !ok {
+		var err error
+		if data, err = os.ReadFile(CapabilitiesPath); err != nil {
+			return hostCapabilities{}
+		}
+	}
+	var caps hostCapabilities
+	if err := json.Unmarshal(data, &caps); err != nil {
+		return hostCapabilities{}
+	}
+	return caps
+}
+
+// HostSupports reports whether the host advertises an optional feature, so
+// modules can degrade gracefully on older hosts
+func HostSupports(feature string) bool {
+	return slices.Contains(loadCapabilities().Features, feature)
+}
+
+// HostABIVersion returns the interface version the host implements, or 0 if
+// the host does not say
+func HostABIVersion() int {
+	return loadCapabilities().ABIVersion
+}
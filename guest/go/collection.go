@@ -0,0 +1,95 @@
+package wadup
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CollectionID identifies a logical group of emitted children
+type CollectionID int
+
+// collectionRef represents a collection in sub-content metadata
+type collectionRef struct {
+	ID       CollectionID  `json:"id"`
+	Name     string        `json:"name"`
+	ParentID *CollectionID `json:"parent_id,omitempty"`
+}
+
+var (
+	collectionsMu sync.Mutex
+	collections                = make(map[CollectionID]collectionRef)
+	nextCollID    CollectionID = 1
+)
+
+// BeginCollection starts a collection grouping related children (all frames
+// of a video, all sheets of a workbook) so the host can present them together.
+//
+// Collections are only reported to the host through their members' metadata,
+// so a collection that never receives a member leaves no trace and needs no
+// cleanup. IDs are unique for the lifetime of the module instance.
+func BeginCollection(name string) CollectionID {
+	return beginCollection(name, nil)
+}
+
+// BeginNestedCollection starts a collection inside parent. Members carry the
+// parent's ID so the host can rebuild the hierarchy; nesting may be arbitrarily
+// deep. An unknown parent is reported when a member is emitted.
+func BeginNestedCollection(parent CollectionID, name string) CollectionID {
+	return beginCollection(name, &parent)
+}
+
+func beginCollection(name string, parent *CollectionID) CollectionID {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+	id := nextCollID
+	nextCollID++
+	collections[id] = collectionRef{ID: id, Name: name, ParentID: parent}
+	return id
+}
+
+// EndCollection releases a collection. Emitting into it (or into a collection
+// nested in it) afterwards is an error. Ending an unknown collection is a no-op.
+func EndCollection(id CollectionID) {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+	delete(collections, id)
+}
+
+// EmitBytesInCollection emits sub-content bytes as a member of collection id.
+// The collection and every collection it is nested in must not have ended.
+func EmitBytesInCollection(id CollectionID, data []byte, filename string) (SubContentRef, error) {
+	collectionsMu.Lock()
+	ref, err := liveCollection(id)
+	collectionsMu.Unlock()
+	if err != nil {
+		return SubContentRef{}, err
+	}
+
+	return emitBytes(data, subContentMetadata{
+		Filename:   filename,
+		Collection: &ref,
+	})
+}
+
+// liveCollection returns collection id after checking that it and all its
+// ancestors are still open. collectionsMu must be held.
+func liveCollection(id CollectionID) (collectionRef, error) {
+	ref, ok := collections[id]
+	if !ok {
+		return collectionRef{}, fmt.Errorf("unknown collection %d", id)
+	}
+	// A parent named before it was begun can close a loop, so the walk is
+	// bounded by the number of open collections
+	current := ref
+	for steps := 0; current.ParentID != nil; steps++ {
+		if steps == len(collections) {
+			return collectionRef{}, fmt.Errorf("collection %d is nested in itself", id)
+		}
+		parent, ok := collections[*current.ParentID]
+		if !ok {
+			return collectionRef{}, fmt.Errorf("collection %d is nested in unknown collection %d", id, *current.ParentID)
+		}
+		current = parent
+	}
+	return ref, nil
+}
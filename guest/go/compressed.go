@@ -0,0 +1,59 @@
+package wadup
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// guestCompressors are the formats EmitBytesCompressed can produce
+var guestCompressors = map[Compression]func(io.Writer) (io.WriteCloser, error){
+	CompressionDeflate: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.BestSpeed) },
+	CompressionGzip:    func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, gzip.BestSpeed) },
+	CompressionZlib:    func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriterLevel(w, zlib.BestSpeed) },
+}
+
+// EmitBytesCompressed emits sub-content like EmitBytes, but compresses it in
+// the module with codec before handing it to the host, which decompresses it
+// on ingest. For highly compressible data such as logs or XML this cuts the
+// bytes copied out of module memory; the child is stored uncompressed either
+// way.
+//
+// codec must be CompressionDeflate, CompressionGzip or CompressionZlib. If
+// the host does not advertise FeatureCompressedSubContent, or a custom
+// transport is installed, the data is emitted uncompressed.
+func EmitBytesCompressed(data []byte, filename string, codec Compression) (SubContentRef, error) {
+	if _, ok := guestCompressors[codec]; !ok {
+		return SubContentRef{}, fmt.Errorf("compression '%s' is not supported for emission", codec)
+	}
+
+	metadata := subContentMetadata{Filename: filename}
+	if HostSupports(FeatureCompressedSubContent) {
+		metadata.Encoding = codec
+	}
+	return emitBytes(data, metadata)
+}
+
+// compressPayload compresses sub-content data for transfer to the host
+func compressPayload(data []byte, codec Compression) ([]byte, error) {
+	newWriter, ok := guestCompressors[codec]
+	if !ok {
+		return nil, fmt.Errorf("compression '%s' is not supported for emission", codec)
+	}
+
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s compressor: %w", codec, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress sub-content: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress sub-content: %w", err)
+	}
+	return buf.Bytes(), nil
+}
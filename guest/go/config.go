@@ -0,0 +1,52 @@
+package wadup
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// ConfigPath is where the host mounts the module's configuration, a JSON
+// object of string values
+const ConfigPath = "/wadup/config.json"
+
+// ConfigEnvPrefix prefixes environment variables carrying configuration, for
+// hosts that pass it in the environment instead of ConfigPath
+const ConfigEnvPrefix = "WADUP_CONFIG_"
+
+// Config returns the module's configuration, so parsers can be parameterized
+// (e.g. a maximum string length or a list of languages) without rebuilding
+// the module. Values come from ConfigEnvPrefix environment variables, keyed
+// by the rest of the variable name, and from ConfigPath, which takes
+// precedence. Without either the configuration is empty.
+func Config() map[string]string {
+	config := make(map[string]string)
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if key, ok := strings.CutPrefix(name, ConfigEnvPrefix); ok && key != "" {
+			config[key] = value
+		}
+	}
+
+	data, err := os.ReadFile(ConfigPath)
+	if err != nil {
+		return config
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		Logf(Warn, "ignoring invalid configuration in %s: %v", ConfigPath, err)
+		return config
+	}
+	for key, value := range values {
+		config[key] = value
+	}
+	return config
+}
+
+// ConfigValue returns a configuration value, or def if it is not set
+func ConfigValue(key, def string) string {
+	if value, ok := Config()[key]; ok {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,28 @@
+package wadup
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ContentFile returns the path of the file holding the content being
+// processed, for libraries that open files by name, such as database drivers.
+// Prefer OpenContent where a reader will do.
+func ContentFile() string {
+	return contentPath()
+}
+
+// OpenContent opens the content being processed for streaming reads with
+// seek support.
+//
+// Parser code should use it instead of hardcoding /data.bin so it stays
+// portable across guest environments. The caller must close the reader.
+func OpenContent() (io.ReadSeekCloser, error) {
+	path := contentPath()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open content '%s': %w", path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,52 @@
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ContentInfoPath is where the host describes the content being processed
+const ContentInfoPath = "/wadup/content.json"
+
+// ContentProperties describes the content being processed
+type ContentProperties struct {
+	// Size is the content size in bytes
+	Size int64 `json:"size"`
+	// Filename is the original (or emitted) filename of the content
+	Filename string `json:"filename"`
+	// MimeType is the host's MIME guess, empty if unknown
+	MimeType string `json:"mime_type,omitempty"`
+	// SHA256 is the lowercase hex SHA-256 of the content, empty if unknown
+	SHA256 string `json:"sha256,omitempty"`
+	// ContentID is the host's identifier for the content
+	ContentID string `json:"content_id,omitempty"`
+	// ParentID is the identifier of the parent content, empty for top-level content
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+// ContentInfo returns what the host knows about the content being processed,
+// read from /wadup/content.json, so parsers can short-circuit (wrong type,
+// too small) without opening /data.bin.
+//
+// Hosts that do not provide the file yield only the size, taken from a stat of
+// /data.bin; the other fields are empty.
+func ContentInfo() (ContentProperties, error) {
+	data, err := os.ReadFile(ContentInfoPath)
+	if os.IsNotExist(err) {
+		size, err := contentSize()
+		if err != nil {
+			return ContentProperties{}, err
+		}
+		return ContentProperties{Size: size}, nil
+	}
+	if err != nil {
+		return ContentProperties{}, fmt.Errorf("failed to read content info '%s': %w", ContentInfoPath, err)
+	}
+
+	var info ContentProperties
+	if err := json.Unmarshal(data, &info); err != nil {
+		return ContentProperties{}, fmt.Errorf("failed to parse content info '%s': %w", ContentInfoPath, err)
+	}
+	return info, nil
+}
@@ -0,0 +1,79 @@
+package wadup
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ContentPath is the path of the content being processed
+const ContentPath = "/data.bin"
+
+// ContentMap is a read-only, random-access view of the content being processed.
+//
+// Bytes returns the whole content as a slice so helpers can bounds-check and
+// scan without a syscall per access. The slice must not be modified and must
+// not be used after Close.
+type ContentMap interface {
+	// Bytes returns the full content
+	Bytes() []byte
+	// Len returns the content size in bytes
+	Len() int64
+	// Slice returns the content range [offset, offset+length)
+	Slice(offset, length int64) ([]byte, error)
+	// Close releases the view
+	Close() error
+}
+
+// MapContent returns a view of /data.bin.
+//
+// WASI preview 1 has no mmap, so on wasip1 the view is backed by a single
+// buffered read of the whole file (the host already serves /data.bin from
+// memory, so this is one copy rather than one syscall per access). Builds with
+// mmap support use a zero-copy mapping instead. Either way the view reflects
+// the content at the time of the call; create a new view for each content item.
+func MapContent() (ContentMap, error) {
+	path := contentPath()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open content '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	if m, ok := mmapFile(f); ok {
+		return m, nil
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content '%s': %w", path, err)
+	}
+	return &bufferedContentMap{data: data}, nil
+}
+
+// bufferedContentMap is the fallback view holding a copy of the content
+type bufferedContentMap struct {
+	data []byte
+}
+
+func (m *bufferedContentMap) Bytes() []byte { return m.data }
+
+func (m *bufferedContentMap) Len() int64 { return int64(len(m.data)) }
+
+func (m *bufferedContentMap) Slice(offset, length int64) ([]byte, error) {
+	return sliceBytes(m.data, offset, length)
+}
+
+func (m *bufferedContentMap) Close() error {
+	m.data = nil
+	return nil
+}
+
+// sliceBytes returns data[offset:offset+length] after checking the bounds
+func sliceBytes(data []byte, offset, length int64) ([]byte, error) {
+	size := int64(len(data))
+	if offset < 0 || length < 0 || offset > size || length > size-offset {
+		return nil, fmt.Errorf("range [%d, %d+%d) is outside content of %d bytes", offset, offset, length, size)
+	}
+	return data[offset : offset+length], nil
+}
@@ -0,0 +1,43 @@
+//go:build unix
+
+package wadup
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapContentMap is a zero-copy view backed by a read-only memory mapping
+type mmapContentMap struct {
+	data []byte
+}
+
+// mmapFile maps f read-only, reporting false if mapping is not possible
+func mmapFile(f *os.File) (ContentMap, bool) {
+	info, err := f.Stat()
+	if err != nil || info.Size() <= 0 || int64(int(info.Size())) != info.Size() {
+		return nil, false
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, false
+	}
+	return &mmapContentMap{data: data}, true
+}
+
+func (m *mmapContentMap) Bytes() []byte { return m.data }
+
+func (m *mmapContentMap) Len() int64 { return int64(len(m.data)) }
+
+func (m *mmapContentMap) Slice(offset, length int64) ([]byte, error) {
+	return sliceBytes(m.data, offset, length)
+}
+
+func (m *mmapContentMap) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(m.data)
+	m.data = nil
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package wadup
+
+import "os"
+
+// mmapFile reports that memory mapping is unavailable (e.g. on wasip1)
+func mmapFile(f *os.File) (ContentMap, bool) {
+	return nil, false
+}
@@ -0,0 +1,135 @@
+package wadup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// contentSignature is a file signature: every check must match
+type contentSignature struct {
+	checks []signatureCheck
+	mime   string
+}
+
+type signatureCheck struct {
+	offset int
+	magic  string
+}
+
+// contentSignatures mirrors the host's detector, most specific first
+var contentSignatures = []contentSignature{
+	{[]signatureCheck{{0, "SQLite format 3\x00"}}, "application/vnd.sqlite3"},
+	{[]signatureCheck{{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"}}, "application/x-ole-storage"},
+	{[]signatureCheck{{0, "\x89PNG\r\n\x1a\n"}}, "image/png"},
+	{[]signatureCheck{{0, "7z\xbc\xaf\x27\x1c"}}, "application/x-7z-compressed"},
+	{[]signatureCheck{{0, "\xfd7zXZ\x00"}}, "application/x-xz"},
+	{[]signatureCheck{{0, "Rar!\x1a\x07"}}, "application/vnd.rar"},
+	{[]signatureCheck{{0, "GIF87a"}}, "image/gif"},
+	{[]signatureCheck{{0, "GIF89a"}}, "image/gif"},
+	{[]signatureCheck{{0, "-----BEGIN "}}, "application/x-pem-file"},
+	{[]signatureCheck{{0, "%PDF-"}}, "application/pdf"},
+	{[]signatureCheck{{0, "{\\rtf"}}, "application/rtf"},
+	{[]signatureCheck{{0, "<?xml"}}, "application/xml"},
+	{[]signatureCheck{{257, "ustar"}}, "application/x-tar"},
+	{[]signatureCheck{{0, "RIFF"}, {8, "WAVE"}}, "audio/wav"},
+	{[]signatureCheck{{0, "RIFF"}, {8, "WEBP"}}, "image/webp"},
+	{[]signatureCheck{{0, "RIFF"}, {8, "AVI "}}, "video/x-msvideo"},
+	{[]signatureCheck{{4, "ftyp"}}, "video/mp4"},
+	{[]signatureCheck{{0, "PK\x03\x04"}}, "application/zip"},
+	{[]signatureCheck{{0, "PK\x05\x06"}}, "application/zip"},
+	{[]signatureCheck{{0, "\x28\xb5\x2f\xfd"}}, "application/zstd"},
+	{[]signatureCheck{{0, "\x7fELF"}}, "application/x-elf"},
+	{[]signatureCheck{{0, "\xcf\xfa\xed\xfe"}}, "application/x-mach-binary"},
+	{[]signatureCheck{{0, "\xce\xfa\xed\xfe"}}, "application/x-mach-binary"},
+	{[]signatureCheck{{0, "\x00asm"}}, "application/wasm"},
+	{[]signatureCheck{{0, "OggS"}}, "audio/ogg"},
+	{[]signatureCheck{{0, "II*\x00"}}, "image/tiff"},
+	{[]signatureCheck{{0, "MM\x00*"}}, "image/tiff"},
+	{[]signatureCheck{{0, "BZh"}}, "application/x-bzip2"},
+	{[]signatureCheck{{0, "ID3"}}, "audio/mpeg"},
+	{[]signatureCheck{{0, "\xff\xd8\xff"}}, "image/jpeg"},
+	{[]signatureCheck{{0, "\x1f\x8b"}}, "application/gzip"},
+	{[]signatureCheck{{0, "MZ"}}, "application/vnd.microsoft.portable-executable"},
+	{[]signatureCheck{{0, "BM"}}, "image/bmp"},
+}
+
+// Number of leading bytes examined when sniffing the content type
+const contentSniffLen = 512
+
+// ContentType returns the MIME type of the content being processed, detected
+// from its leading bytes (for example "application/vnd.sqlite3" or
+// "application/zip"), so parsers can check what they were given without
+// sniffing headers themselves. Unrecognised content is "text/plain" if it
+// looks like UTF-8 text and "application/octet-stream" otherwise.
+//
+// If the host advertises FeatureContentType the type comes from the host's
+// detector, which is also what it records for routing. Otherwise the guest
+// uses the host's guess from ContentInfo, or sniffs the content itself with
+// the same signatures.
+func ContentType() (string, error) {
+	mime, ok, err := hostContentType()
+	if ok {
+		return mime, err
+	}
+
+	info, err := ContentInfo()
+	if err != nil {
+		return "", err
+	}
+	if info.MimeType != "" {
+		return info.MimeType, nil
+	}
+
+	r, err := OpenContent()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	head := make([]byte, contentSniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read content: %w", err)
+	}
+	return detectContentType(head[:n]), nil
+}
+
+// detectContentType sniffs the MIME type from the leading bytes of content
+func detectContentType(head []byte) string {
+	if len(head) == 0 {
+		return "application/x-empty"
+	}
+
+	for _, sig := range contentSignatures {
+		matched := true
+		for _, c := range sig.checks {
+			end := c.offset + len(c.magic)
+			if end > len(head) || string(head[c.offset:end]) != c.magic {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return sig.mime
+		}
+	}
+
+	if bytes.IndexByte(head, 0) < 0 && validUTF8Prefix(head) {
+		return "text/plain"
+	}
+	return "application/octet-stream"
+}
+
+// validUTF8Prefix reports whether data is valid UTF-8, allowing a character
+// cut off at the end
+func validUTF8Prefix(data []byte) bool {
+	for i := 0; i < utf8.UTFMax && i < len(data); i++ {
+		if utf8.Valid(data[:len(data)-i]) {
+			return i == 0 || !utf8.FullRune(data[len(data)-i:])
+		}
+	}
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostContentType reports that the host cannot detect the content type, since
+// there is no content_type import outside preview1 builds
+func hostContentType() (string, bool, error) {
+	return "", false, nil
+}
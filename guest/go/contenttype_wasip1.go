@@ -0,0 +1,31 @@
+//go:build wasip1
+
+package wadup
+
+import "unsafe"
+
+// contentTypeImport copies the MIME type detected by the host into out,
+// returning its length. Nothing is copied if out is too small.
+//
+//go:wasmimport wadup content_type
+func contentTypeImport(out unsafe.Pointer, outLen uint32) int32
+
+// hostContentType asks the host for the detected content type if it supports
+// it. ok is false if the host cannot, and the guest must detect it itself.
+func hostContentType() (mime string, ok bool, err error) {
+	if !HostSupports(FeatureContentType) {
+		return "", false, nil
+	}
+
+	buf := make([]byte, 64)
+	for {
+		n := contentTypeImport(unsafe.Pointer(unsafe.SliceData(buf)), uint32(len(buf)))
+		if n < 0 {
+			return "", false, nil
+		}
+		if int(n) <= len(buf) {
+			return string(buf[:n]), true, nil
+		}
+		buf = make([]byte, n)
+	}
+}
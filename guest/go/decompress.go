@@ -0,0 +1,76 @@
+package wadup
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Compression names a compression format understood by DecompressSlice
+type Compression string
+
+const (
+	// CompressionDeflate is raw DEFLATE without a header
+	CompressionDeflate Compression = "deflate"
+	CompressionGzip    Compression = "gzip"
+	CompressionZlib    Compression = "zlib"
+	CompressionBzip2   Compression = "bzip2"
+	// CompressionXZ and CompressionZstd are only available from hosts
+	// advertising FeatureDecompress
+	CompressionXZ   Compression = "xz"
+	CompressionZstd Compression = "zstd"
+)
+
+// guestDecompressors are the formats DecompressSlice can handle without the
+// host
+var guestDecompressors = map[Compression]func(io.Reader) (io.Reader, error){
+	CompressionDeflate: func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil },
+	CompressionGzip:    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	CompressionZlib:    func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	CompressionBzip2:   func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+}
+
+// DecompressSlice decompresses length bytes of the content starting at
+// offset and emits the result as new sub-content named filename.
+//
+// If the host advertises FeatureDecompress the data is decompressed natively
+// by the host and never passes through module memory, which is much faster
+// than a pure-Go decompressor in WASM. Otherwise DEFLATE, gzip, zlib and
+// bzip2 are decompressed in the guest and emitted with EmitBytesWithMethod.
+func DecompressSlice(offset, length int64, algo Compression, filename string) error {
+	if offset < 0 || length < 0 {
+		return fmt.Errorf("invalid decompression range: offset %d, length %d", offset, length)
+	}
+	if ok, err := hostDecompressSlice(offset, length, algo, filename); ok {
+		return err
+	}
+
+	newReader, ok := guestDecompressors[algo]
+	if !ok {
+		return fmt.Errorf("compression '%s' is not supported by this host", algo)
+	}
+
+	content, err := OpenContent()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if _, err := content.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek content: %w", err)
+	}
+	r, err := newReader(io.LimitReader(content, length))
+	if err != nil {
+		return fmt.Errorf("failed to decompress '%s': %w", filename, err)
+	}
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, r); err != nil {
+		return fmt.Errorf("failed to decompress '%s': %w", filename, err)
+	}
+	_, err = EmitBytesWithMethod(out.Bytes(), filename, MethodDecompressed+": "+string(algo))
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostDecompressSlice reports that the host cannot decompress content, since
+// there is no decompress_slice import outside preview1 builds
+func hostDecompressSlice(offset, length int64, algo Compression, filename string) (bool, error) {
+	return false, nil
+}
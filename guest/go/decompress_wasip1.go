@@ -0,0 +1,45 @@
+//go:build wasip1
+
+package wadup
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Error codes returned by the decompress_slice import
+const (
+	decompressErrUnknownAlgorithm = -1
+	decompressErrOutOfRange       = -2
+	decompressErrCorrupt          = -3
+)
+
+// decompressSlice decompresses a range of the content on the host and emits
+// the result as sub-content, returning 0 or a negative error code
+//
+//go:wasmimport wadup decompress_slice
+func decompressSlice(algo unsafe.Pointer, algoLen uint32, offset int64, length int64, name unsafe.Pointer, nameLen uint32) int32
+
+// hostDecompressSlice decompresses a range natively if the host supports it.
+// ok is false if the host cannot, and the guest must decompress it itself.
+func hostDecompressSlice(offset, length int64, algo Compression, filename string) (ok bool, err error) {
+	if !HostSupports(FeatureDecompress) {
+		return false, nil
+	}
+
+	name := string(algo)
+	rc := decompressSlice(unsafe.Pointer(unsafe.StringData(name)), uint32(len(name)), offset, length,
+		unsafe.Pointer(unsafe.StringData(filename)), uint32(len(filename)))
+	switch rc {
+	case 0:
+		return true, nil
+	case decompressErrUnknownAlgorithm:
+		return true, fmt.Errorf("compression '%s' is not supported by this host", algo)
+	case decompressErrOutOfRange:
+		return true, fmt.Errorf("decompression range %d+%d is outside the content", offset, length)
+	case decompressErrCorrupt:
+		return true, fmt.Errorf("failed to decompress '%s': invalid or oversized %s data", filename, algo)
+	default:
+		return true, fmt.Errorf("failed to decompress '%s': error %d", filename, rc)
+	}
+}
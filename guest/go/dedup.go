@@ -0,0 +1,196 @@
+package wadup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash/crc32"
+	"sync"
+)
+
+// Default bounds for the weak deduplication set
+const (
+	DefaultWeakDedupMaxEntries = 65536
+	DefaultWeakDedupMaxBytes   = 16 << 20
+)
+
+// weakDedupMaxCandidates caps the payloads kept per weak key, so many
+// distinct payloads colliding on one key can't make every lookup slow. The
+// oldest candidate is dropped first.
+const weakDedupMaxCandidates = 8
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// weakKey is the cheap pre-filter key: payload length plus CRC32-C
+type weakKey struct {
+	length int
+	crc    uint32
+}
+
+// weakCandidate is a previously emitted payload sharing a weak key.
+// Small payloads are retained verbatim (within the byte budget) so a weak
+// hit can be confirmed with a byte comparison; larger payloads only keep
+// their SHA-256 digest.
+type weakCandidate struct {
+	id     uint64
+	data   []byte
+	digest [sha256.Size]byte
+}
+
+var (
+	weakDedupMu         sync.Mutex
+	weakDedupSet        = make(map[weakKey][]weakCandidate)
+	weakDedupOrder      []weakKey
+	weakDedupBytes      int
+	weakDedupNextID     uint64
+	weakDedupMaxEntries = DefaultWeakDedupMaxEntries
+	weakDedupMaxBytes   = DefaultWeakDedupMaxBytes
+)
+
+// SetWeakDedupLimits bounds the memory used by EmitBytesWeakDedup.
+//
+// maxEntries caps the number of distinct weak keys tracked; maxBytes caps the
+// payload bytes retained for exact comparison. When the entry limit is reached
+// the oldest keys are evicted, so a duplicate of an evicted payload is emitted
+// again. Non-positive values restore the defaults.
+func SetWeakDedupLimits(maxEntries, maxBytes int) {
+	weakDedupMu.Lock()
+	defer weakDedupMu.Unlock()
+	if maxEntries <= 0 {
+		maxEntries = DefaultWeakDedupMaxEntries
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultWeakDedupMaxBytes
+	}
+	weakDedupMaxEntries = maxEntries
+	weakDedupMaxBytes = maxBytes
+	for len(weakDedupOrder) > weakDedupMaxEntries {
+		evictOldestWeakKey()
+	}
+}
+
+// resetWeakDedup forgets the payloads emitted for the previous content, so
+// the same bytes are emitted again as children of the next one
+func resetWeakDedup() {
+	weakDedupMu.Lock()
+	defer weakDedupMu.Unlock()
+	clear(weakDedupSet)
+	weakDedupOrder = nil
+	weakDedupBytes = 0
+}
+
+// EmitBytesWeakDedup emits sub-content bytes unless identical bytes were
+// already emitted by this module for the current content.
+//
+// Each payload is keyed by its length and CRC32-C, which is far cheaper than a
+// cryptographic hash. Only when that weak key matches a previous emission is
+// the payload compared in full (byte-for-byte when the earlier payload was
+// retained, by SHA-256 otherwise), so distinct content is never dropped.
+//
+// Returns true if the data was emitted, false if it was skipped as a duplicate.
+func EmitBytesWeakDedup(data []byte, filename string) (bool, error) {
+	key := weakKey{length: len(data), crc: crc32.Checksum(data, crc32cTable)}
+
+	// Check and record the payload under one lock, so concurrent callers
+	// emitting the same bytes can't both see them as new
+	weakDedupMu.Lock()
+	if weakDuplicate(weakDedupSet[key], data) {
+		weakDedupMu.Unlock()
+		return false, nil
+	}
+	id := addWeakCandidate(key, data)
+	weakDedupMu.Unlock()
+
+	if _, err := EmitBytes(data, filename); err != nil {
+		// Forget the payload so a later attempt emits it
+		weakDedupMu.Lock()
+		removeWeakCandidate(key, id)
+		weakDedupMu.Unlock()
+		return false, err
+	}
+	return true, nil
+}
+
+// addWeakCandidate records an emitted payload under its weak key and returns
+// the candidate's ID. Caller must hold weakDedupMu.
+func addWeakCandidate(key weakKey, data []byte) uint64 {
+	weakDedupNextID++
+	candidate := weakCandidate{id: weakDedupNextID}
+	if weakDedupBytes+len(data) <= weakDedupMaxBytes {
+		candidate.data = append(make([]byte, 0, len(data)), data...)
+		weakDedupBytes += len(data)
+	} else {
+		candidate.digest = sha256.Sum256(data)
+	}
+
+	candidates, ok := weakDedupSet[key]
+	if !ok {
+		weakDedupOrder = append(weakDedupOrder, key)
+	}
+	if len(candidates) >= weakDedupMaxCandidates {
+		weakDedupBytes -= len(candidates[0].data)
+		candidates = candidates[1:]
+	}
+	weakDedupSet[key] = append(candidates, candidate)
+	for len(weakDedupOrder) > weakDedupMaxEntries {
+		evictOldestWeakKey()
+	}
+	return candidate.id
+}
+
+// removeWeakCandidate drops a candidate recorded by addWeakCandidate, if it
+// hasn't been evicted since. Caller must hold weakDedupMu.
+func removeWeakCandidate(key weakKey, id uint64) {
+	candidates := weakDedupSet[key]
+	for i, c := range candidates {
+		if c.id != id {
+			continue
+		}
+		weakDedupBytes -= len(c.data)
+		candidates = append(candidates[:i:i], candidates[i+1:]...)
+		if len(candidates) > 0 {
+			weakDedupSet[key] = candidates
+			return
+		}
+		delete(weakDedupSet, key)
+		for j, k := range weakDedupOrder {
+			if k == key {
+				weakDedupOrder = append(weakDedupOrder[:j:j], weakDedupOrder[j+1:]...)
+				break
+			}
+		}
+		return
+	}
+}
+
+// weakDuplicate performs the full comparison after a weak key hit.
+// Caller must hold weakDedupMu.
+func weakDuplicate(candidates []weakCandidate, data []byte) bool {
+	var digest [sha256.Size]byte
+	hashed := false
+	for _, c := range candidates {
+		if c.data != nil {
+			if bytes.Equal(c.data, data) {
+				return true
+			}
+			continue
+		}
+		if !hashed {
+			digest = sha256.Sum256(data)
+			hashed = true
+		}
+		if c.digest == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// evictOldestWeakKey drops the oldest tracked key. Caller must hold weakDedupMu.
+func evictOldestWeakKey() {
+	key := weakDedupOrder[0]
+	weakDedupOrder = weakDedupOrder[1:]
+	for _, c := range weakDedupSet[key] {
+		weakDedupBytes -= len(c.data)
+	}
+	delete(weakDedupSet, key)
+}
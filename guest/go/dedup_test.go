@@ -0,0 +1,112 @@
+package wadup
+
+import "testing"
+
+// resetWeakDedupForTest restores the default limits and forgets tracked
+// payloads when the test ends
+func resetWeakDedupForTest(t *testing.T) {
+	t.Cleanup(func() {
+		SetWeakDedupLimits(0, 0)
+		resetWeakDedup()
+	})
+	resetWeakDedup()
+}
+
+func TestWeakDedupConfirmsRetainedPayloads(t *testing.T) {
+	resetWeakDedupForTest(t)
+
+	// Different bytes sharing a weak key, as in a CRC32-C collision
+	key := weakKey{length: 4, crc: 1}
+	weakDedupMu.Lock()
+	defer weakDedupMu.Unlock()
+	addWeakCandidate(key, []byte("abcd"))
+	if weakDuplicate(weakDedupSet[key], []byte("abce")) {
+		t.Errorf("a weak key collision was taken for a duplicate")
+	}
+	if !weakDuplicate(weakDedupSet[key], []byte("abcd")) {
+		t.Errorf("identical retained bytes were not recognized")
+	}
+
+	// A second payload on the key is compared too
+	addWeakCandidate(key, []byte("wxyz"))
+	if !weakDuplicate(weakDedupSet[key], []byte("wxyz")) {
+		t.Errorf("the second candidate was not compared")
+	}
+	if weakDedupBytes != 8 {
+		t.Errorf("retained %d bytes, want 8", weakDedupBytes)
+	}
+}
+
+func TestWeakDedupConfirmsDigests(t *testing.T) {
+	resetWeakDedupForTest(t)
+	// Too small a budget to retain the payload, so only its digest is kept
+	SetWeakDedupLimits(0, 1)
+
+	key := weakKey{length: 4, crc: 1}
+	weakDedupMu.Lock()
+	defer weakDedupMu.Unlock()
+	addWeakCandidate(key, []byte("abcd"))
+	if c := weakDedupSet[key][0]; c.data != nil {
+		t.Fatalf("payload retained despite the byte budget")
+	}
+	if weakDuplicate(weakDedupSet[key], []byte("abce")) {
+		t.Errorf("a weak key collision was taken for a duplicate")
+	}
+	if !weakDuplicate(weakDedupSet[key], []byte("abcd")) {
+		t.Errorf("identical bytes were not recognized by digest")
+	}
+}
+
+func TestWeakDedupRemoveCandidate(t *testing.T) {
+	resetWeakDedupForTest(t)
+
+	key := weakKey{length: 4, crc: 1}
+	weakDedupMu.Lock()
+	defer weakDedupMu.Unlock()
+	first := addWeakCandidate(key, []byte("abcd"))
+	second := addWeakCandidate(key, []byte("wxyz"))
+
+	// A failed emission forgets only its own payload
+	removeWeakCandidate(key, first)
+	if weakDuplicate(weakDedupSet[key], []byte("abcd")) {
+		t.Errorf("removed payload still recognized")
+	}
+	if !weakDuplicate(weakDedupSet[key], []byte("wxyz")) {
+		t.Errorf("remaining payload forgotten")
+	}
+	removeWeakCandidate(key, second)
+	if _, ok := weakDedupSet[key]; ok || len(weakDedupOrder) != 0 || weakDedupBytes != 0 {
+		t.Errorf("key still tracked after removing every candidate")
+	}
+}
+
+func TestEmitBytesWeakDedup(t *testing.T) {
+	resetWeakDedupForTest(t)
+	r := useRecordingTransport(t)
+
+	for _, tt := range []struct {
+		data string
+		want bool
+	}{
+		{"payload", true},
+		{"payload", false},
+		{"payload!", true},
+	} {
+		emitted, err := EmitBytesWeakDedup([]byte(tt.data), "child.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if emitted != tt.want {
+			t.Errorf("EmitBytesWeakDedup(%q) = %v, want %v", tt.data, emitted, tt.want)
+		}
+	}
+	if len(r.subContent) != 2 {
+		t.Errorf("transport got %d emissions, want 2", len(r.subContent))
+	}
+
+	// The next content item emits the same bytes again
+	resetWeakDedup()
+	if emitted, err := EmitBytesWeakDedup([]byte("payload"), "child.bin"); err != nil || !emitted {
+		t.Errorf("EmitBytesWeakDedup after reset = %v, %v; want true, nil", emitted, err)
+	}
+}
@@ -0,0 +1,129 @@
+package wadup
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Conventional tables written by EmitDependencies
+const (
+	ImportsTable           = "imports"
+	ImportedFunctionsTable = "imported_functions"
+)
+
+// dependencyLibrary is a library written to the imports table for the
+// current content, with the functions written for it
+type dependencyLibrary struct {
+	id        int64
+	functions map[string]struct{}
+}
+
+var (
+	dependencyMu sync.Mutex
+	// dependencyLibraries maps lowercased library names to the libraries
+	// written for the current content, so ids stay unique across calls
+	dependencyLibraries = make(map[string]*dependencyLibrary)
+)
+
+// resetDependencies forgets the libraries written for the previous content,
+// so library ids start from zero again
+func resetDependencies() {
+	dependencyMu.Lock()
+	defer dependencyMu.Unlock()
+	clear(dependencyLibraries)
+}
+
+// Dependency is a library imported by a binary and the functions it uses.
+//
+// Ordinal-only imports are written as "#" followed by the ordinal number
+// (e.g. "#12").
+type Dependency struct {
+	Name      string
+	Functions []string
+}
+
+// EmitDependencies inserts a binary's import dependencies into the imports and
+// imported_functions tables.
+//
+// Each distinct library gets one imports row (library_id, library); libraries
+// are deduplicated case-insensitively, keeping the first spelling, and their
+// function lists are merged. Each distinct function gets one imported_functions
+// row (library_id, function, ordinal) where library_id references the imports
+// row. Ordinal-only imports have an empty function name and a positive
+// ordinal; imports by name have ordinal 0.
+//
+// Library ids are allocated per content item, so calling EmitDependencies
+// several times for one content (e.g. once per binary section) keeps them
+// unique, and a library already written reuses its imports row.
+func EmitDependencies(deps []Dependency) error {
+	imports, err := NewTableBuilder(ImportsTable).
+		Column("library_id", Int64).
+		Column("library", String).
+		Build()
+	if err != nil {
+		return err
+	}
+	functions, err := NewTableBuilder(ImportedFunctionsTable).
+		Column("library_id", Int64).
+		Column("function", String).
+		Column("ordinal", Int64).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	dependencyMu.Lock()
+	defer dependencyMu.Unlock()
+	for _, dep := range deps {
+		name := strings.TrimSpace(dep.Name)
+		if name == "" {
+			continue
+		}
+		key := strings.ToLower(name)
+		lib, ok := dependencyLibraries[key]
+		if !ok {
+			lib = &dependencyLibrary{id: int64(len(dependencyLibraries)), functions: make(map[string]struct{})}
+			err := imports.InsertRow([]Value{NewInt64(lib.id), NewString(name)})
+			if err != nil {
+				return err
+			}
+			dependencyLibraries[key] = lib
+		}
+
+		for _, fn := range dep.Functions {
+			fn = strings.TrimSpace(fn)
+			if fn == "" {
+				continue
+			}
+			if _, ok := lib.functions[fn]; ok {
+				continue
+			}
+			lib.functions[fn] = struct{}{}
+
+			function, ordinal := fn, int64(0)
+			if n, ok := parseOrdinal(fn); ok {
+				function, ordinal = "", n
+			}
+			err := functions.InsertRow([]Value{NewInt64(lib.id), NewString(function), NewInt64(ordinal)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseOrdinal parses an ordinal-only import of the form "#N"
+func parseOrdinal(fn string) (int64, bool) {
+	digits, ok := strings.CutPrefix(fn, "#")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
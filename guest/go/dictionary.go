@@ -0,0 +1,106 @@
+package wadup
+
+// dictionaryDef is the dictionary of one column within a metadata file. Rows
+// in the same file carry Int64 codes indexing Values in place of the strings.
+type dictionaryDef struct {
+	Table  string   `json:"table"`
+	Column int      `json:"column"`
+	Values []string `json:"values"`
+}
+
+// dictionaryKey identifies a dictionary column
+type dictionaryKey struct {
+	table  string
+	column int
+}
+
+var (
+	// dictionaryColumns holds the indices of each table's dictionary
+	// columns. Guarded by metadataMu.
+	dictionaryColumns = make(map[string][]int)
+	// dictionaryNegotiated and dictionarySupported cache whether the host
+	// decodes dictionary columns. Guarded by metadataMu.
+	dictionaryNegotiated bool
+	dictionarySupported  bool
+)
+
+// setDictionaryColumns records the dictionary columns of a table. Caller must
+// hold metadataMu.
+func setDictionaryColumns(name string, columns []Column) {
+	var indices []int
+	for i, col := range columns {
+		if col.Dictionary {
+			indices = append(indices, i)
+		}
+	}
+	if indices == nil {
+		delete(dictionaryColumns, name)
+		return
+	}
+	dictionaryColumns[name] = indices
+}
+
+// dictionaryEncoding reports whether dictionary columns are sent as codes,
+// which needs the host to decode them. The host is asked once. Caller must
+// hold metadataMu.
+func dictionaryEncoding() bool {
+	if len(dictionaryColumns) == 0 {
+		return false
+	}
+	if !dictionaryNegotiated {
+		dictionarySupported = HostSupports(FeatureDictionaryColumns)
+		dictionaryNegotiated = true
+	}
+	return dictionarySupported
+}
+
+// fileDictionaries builds the dictionaries of one metadata file. Each file
+// carries its own, so the host can decode every file on its own.
+type fileDictionaries struct {
+	defs  []dictionaryDef
+	index map[dictionaryKey]int
+	codes map[dictionaryKey]map[string]int64
+}
+
+// encode returns the row with the strings in its dictionary columns replaced
+// by codes, adding new strings to the file's dictionaries. The row's values
+// are copied, not modified. Caller must hold metadataMu.
+func (d *fileDictionaries) encode(row rowDef) rowDef {
+	columns := dictionaryColumns[row.TableName]
+	if len(columns) == 0 {
+		return row
+	}
+	if d.index == nil {
+		d.index = make(map[dictionaryKey]int)
+		d.codes = make(map[dictionaryKey]map[string]int64)
+	}
+
+	values := append([]Value(nil), row.Values...)
+	for _, col := range columns {
+		if col >= len(values) {
+			continue
+		}
+		s, ok := values[col].data.(string)
+		if !ok {
+			continue
+		}
+		key := dictionaryKey{row.TableName, col}
+		codes, ok := d.codes[key]
+		if !ok {
+			codes = make(map[string]int64)
+			d.codes[key] = codes
+			d.index[key] = len(d.defs)
+			d.defs = append(d.defs, dictionaryDef{Table: row.TableName, Column: col})
+		}
+		code, ok := codes[s]
+		if !ok {
+			def := &d.defs[d.index[key]]
+			code = int64(len(def.Values))
+			def.Values = append(def.Values, s)
+			codes[s] = code
+		}
+		values[col] = NewInt64(code)
+	}
+	row.Values = values
+	return row
+}
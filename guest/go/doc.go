@@ -0,0 +1,15 @@
+// Package wadup is the guest library for WADUP modules written in Go.
+//
+// It is the single Go guest package: the examples, the docker build image and
+// the wadup-web project templates all build against this module, imported as
+// github.com/tordynnar/wadup2/guest/go. Module code only talks to the host
+// through this package, and the build picks the Transport: preview1 modules
+// exchange files in their virtual filesystem (content in /data.bin, metadata
+// in /metadata, sub-content in /subcontent), and component builds with the
+// wasip2 tag call the wadup:guest/host imports. The same source builds for
+// either.
+package wadup
+
+// The component bindings in ffi_bindings.go are generated from the WIT
+// definitions shared by all guest languages.
+//go:generate go run ./internal/witgen -wit ../wit/wadup.wit -o ffi_bindings.go
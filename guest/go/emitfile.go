@@ -0,0 +1,68 @@
+package wadup
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// EmitFile emits a file the module has already written, such as an extracted
+// archive member, as sub-content named filename.
+//
+// The file is moved, not copied: it no longer exists once EmitFile returns,
+// and must be closed before the call. If the host advertises FeatureEmitFile
+// it takes the file's bytes directly, so they are never read back into
+// module memory. Otherwise the file is read and emitted with EmitBytes. The
+// content being processed can't be emitted this way; use EmitSlice instead.
+//
+// When the host takes the file directly, the emit gate receives nil data, as
+// for slices. A file the gate rejects is left in place.
+func EmitFile(filePath string, filename string) (SubContentRef, error) {
+	abs := path.Clean(filePath)
+	if !path.IsAbs(abs) {
+		return SubContentRef{}, fmt.Errorf("sub-content file path '%s' must be absolute", filePath)
+	}
+	if abs == ContentPath {
+		return SubContentRef{}, fmt.Errorf("cannot emit the content being processed as a file; use EmitSlice")
+	}
+
+	if currentTransport() != nil || !HostSupports(FeatureEmitFile) {
+		return emitFileCopy(abs, filename)
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return SubContentRef{}, fmt.Errorf("failed to stat sub-content file '%s': %w", abs, err)
+	}
+	if !info.Mode().IsRegular() {
+		return SubContentRef{}, fmt.Errorf("sub-content file '%s' is not a regular file", abs)
+	}
+
+	if !passesGate(nil, filename) {
+		return SubContentRef{}, nil
+	}
+
+	n := nextSubContentIndex()
+	metadata := subContentMetadata{Filename: filename, SourcePath: abs}
+	if err := writeBytesMetadata(n, metadata, info.Size()); err != nil {
+		return SubContentRef{}, err
+	}
+	return SubContentRef{index: n, emitted: true, filename: filename}, nil
+}
+
+// emitFileCopy reads the file into memory, emits it with EmitBytes, and
+// removes it once emitted
+func emitFileCopy(filePath string, filename string) (SubContentRef, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return SubContentRef{}, fmt.Errorf("failed to read sub-content file '%s': %w", filePath, err)
+	}
+	ref, err := EmitBytes(data, filename)
+	if err != nil || !ref.Emitted() {
+		return ref, err
+	}
+	if err := os.Remove(filePath); err != nil {
+		return ref, fmt.Errorf("failed to remove sub-content file '%s': %w", filePath, err)
+	}
+	return ref, nil
+}
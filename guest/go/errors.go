@@ -0,0 +1,44 @@
+package wadup
+
+import "fmt"
+
+// ErrorsTable is the dedicated table written by ReportError
+const ErrorsTable = "errors"
+
+// errorColumns is the schema of the errors table
+var errorColumns = []Column{
+	{Name: "module", DataType: String},
+	{Name: "content_id", DataType: String},
+	{Name: "error_class", DataType: String},
+	{Name: "message", DataType: String},
+}
+
+// ReportError records a (typically partial) parse failure in the errors
+// table so it is queryable instead of only visible on stderr.
+//
+// code classifies the error (e.g. "truncated_header", "bad_checksum"); the
+// module and content IDs are filled in from the host-provided context when
+// available. The module should keep going where it can and report the
+// content as partially scanned (see SetScanStatus).
+func ReportError(code string, err error) error {
+	if code == "" {
+		return fmt.Errorf("error code must not be empty")
+	}
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	contentID := ""
+	if info, infoErr := ContentInfo(); infoErr == nil {
+		contentID = info.ContentID
+	}
+
+	ensureTable(ErrorsTable, errorColumns)
+	table := &Table{name: ErrorsTable, columns: errorColumns}
+	return table.InsertRow([]Value{
+		NewString(ModuleID()),
+		NewString(contentID),
+		NewString(code),
+		NewString(message),
+	})
+}
@@ -0,0 +1,274 @@
+//go:build wasip2
+
+package wadup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Transport for modules built as components against the processor world in
+// guest/wit/wadup.wit:
+//
+//	tinygo build -target=wasip2 --wit-package ../wit --wit-world processor
+//
+// The module talks to the host through the wadup:guest/host imports, bound in
+// ffi_bindings.go, instead of the /metadata, /subcontent and /log
+// directories. The preview1 build is unaffected.
+
+// cmDataTypes maps column types to WIT data types
+var cmDataTypes = map[DataType]cmDataType{
+	Int64:     cmDataTypeInt64,
+	Float64:   cmDataTypeFloat64,
+	String:    cmDataTypeString,
+	Bool:      cmDataTypeBoolean,
+	Bytes:     cmDataTypeBytes,
+	BytesRef:  cmDataTypeBytes,
+	Timestamp: cmDataTypeTimestamp,
+	Duration:  cmDataTypeDuration,
+	IPAddress: cmDataTypeIPAddress,
+	IPv4:      cmDataTypeIpv4,
+	IPv6:      cmDataTypeIpv6,
+	UUID:      cmDataTypeUUID,
+	Json:      cmDataTypeJSON,
+
+	StringArray: cmDataTypeStringArray,
+	Int64Array:  cmDataTypeInt64Array,
+	BytesArray:  cmDataTypeBytesArray,
+
+	Decimal: cmDataTypeDecimal,
+	BigInt:  cmDataTypeBigInt,
+
+	SubContentID: cmDataTypeSubcontentID,
+}
+
+// hostTransport is the transport of component builds
+var hostTransport = &componentTransport{}
+
+// defaultTransport returns the transport of component builds
+func defaultTransport() Transport {
+	return hostTransport
+}
+
+// componentTransport delivers output through the host interface imports
+type componentTransport struct {
+	contentOnce sync.Once
+	contentFile string
+}
+
+// lift returns the error case of a result
+func lift(r cmResultErrString) error {
+	if !r.isErr() {
+		return nil
+	}
+	return errors.New(r.errValue().value())
+}
+
+func (t *componentTransport) DefineTable(schema TableSchema) error {
+	columns := make([]cmColumn, len(schema.Columns))
+	for i, col := range schema.Columns {
+		dataType, ok := cmDataTypes[col.DataType]
+		if !ok {
+			return fmt.Errorf("unsupported column type '%s' in table '%s'", col.DataType, schema.Name)
+		}
+		columns[i] = cmColumn{
+			name:       cmStringOf(col.Name),
+			dataType:   dataType,
+			nullable:   col.Nullable,
+			primaryKey: col.PrimaryKey,
+			unique:     col.Unique,
+		}
+	}
+
+	result := hostDefineTable(cmStringOf(schema.Name), cmListOf(columns))
+	runtime.KeepAlive(schema)
+	if err := lift(result); err != nil {
+		return fmt.Errorf("failed to define table '%s': %w", schema.Name, err)
+	}
+	return nil
+}
+
+func (t *componentTransport) InsertRows(table string, rows [][]Value) error {
+	for _, row := range rows {
+		values := make([]cmValue, len(row))
+		// keep holds converted strings and blobs until the call returns
+		keep := make([]interface{}, 0, len(row))
+		for i, v := range row {
+			value, held, err := lowerValue(v)
+			if err != nil {
+				return fmt.Errorf("failed to insert row into '%s': %w", table, err)
+			}
+			values[i] = value
+			keep = append(keep, held)
+		}
+
+		result := hostInsertRow(cmStringOf(table), cmListOf(values))
+		runtime.KeepAlive(row)
+		runtime.KeepAlive(keep)
+		if err := lift(result); err != nil {
+			return fmt.Errorf("failed to insert row into '%s': %w", table, err)
+		}
+	}
+	return nil
+}
+
+// lowerValue converts a value to the WIT value variant, returning any memory
+// the payload points into
+func lowerValue(v Value) (cmValue, interface{}, error) {
+	switch val := v.data.(type) {
+	case nil:
+		return cmValueNull(), nil, nil
+	case int64:
+		return cmValueInt64(val), nil, nil
+	case float64:
+		return cmValueFloat64(val), nil, nil
+	case string:
+		return cmValueString(cmStringOf(val)), val, nil
+	case bool:
+		return cmValueBoolean(val), nil, nil
+	case []byte:
+		return cmValueBytes(cmListOf(val)), val, nil
+	case bytesRef:
+		data, err := os.ReadFile(val.Path)
+		if err != nil {
+			return cmValue{}, nil, fmt.Errorf("failed to read blob '%s': %w", val.Path, err)
+		}
+		return cmValueBytes(cmListOf(data)), data, nil
+	case time.Time:
+		s := val.Format(time.RFC3339Nano)
+		return cmValueTimestamp(cmStringOf(s)), s, nil
+	case time.Duration:
+		return cmValueDuration(int64(val)), nil, nil
+	case netip.Addr:
+		s := val.String()
+		return cmValueIPAddress(cmStringOf(s)), s, nil
+	case ipv4Addr:
+		s := v.String()
+		return cmValueIpv4(cmStringOf(s)), s, nil
+	case ipv6Addr:
+		s := v.String()
+		return cmValueIpv6(cmStringOf(s)), s, nil
+	case uuidValue:
+		s := val.String()
+		return cmValueUUID(cmStringOf(s)), s, nil
+	case jsonDoc:
+		s := string(val)
+		return cmValueJSON(cmStringOf(s)), s, nil
+	case stringArray:
+		elems := cmStringsOf(val)
+		return cmValueStringArray(cmListOf(elems)), elems, nil
+	case int64Array:
+		return cmValueInt64Array(cmListOf([]int64(val))), val, nil
+	case bytesArray:
+		elems := make([]cmList[uint8], len(val))
+		for i, b := range val {
+			elems[i] = cmListOf(b)
+		}
+		return cmValueBytesArray(cmListOf(elems)), []interface{}{elems, val}, nil
+	case decimalText:
+		s := string(val)
+		return cmValueDecimal(cmStringOf(s)), s, nil
+	case bigIntText:
+		s := string(val)
+		return cmValueBigInt(cmStringOf(s)), s, nil
+	case SubContentRef:
+		return cmValueSubcontentID(uint64(val.index)), nil, nil
+	default:
+		return cmValue{}, nil, fmt.Errorf("unsupported value type: %T", val)
+	}
+}
+
+func (t *componentTransport) EmitSubContent(sc SubContent) error {
+	if sc.ParentRef != "" {
+		return fmt.Errorf("failed to emit '%s': slices of sub-content are not supported by the component host", sc.Filename)
+	}
+
+	item := cmSubcontent{
+		index:            uint64(sc.Index),
+		filename:         cmStringOf(sc.Filename),
+		tags:             cmListOf(cmStringsOf(sc.Options.Tags)),
+		suggestedParsers: cmListOf(cmStringsOf(sc.Options.SuggestedParsers)),
+	}
+	if sc.Slice {
+		item.data = cmSubcontentDataSlice(cmByteRange{offset: uint64(sc.Offset), length: uint64(sc.Length)})
+	} else {
+		item.data = cmSubcontentDataBytes(cmListOf(sc.Data))
+	}
+	if sc.Options.Relationship != "" {
+		item.relationship = cmSome(cmStringOf(sc.Options.Relationship))
+	}
+
+	result := hostEmitSubcontent(item)
+	runtime.KeepAlive(sc)
+	if err := lift(result); err != nil {
+		return fmt.Errorf("failed to emit '%s': %w", sc.Filename, err)
+	}
+	return nil
+}
+
+// cmStringsOf converts a string slice for a list<string> argument
+func cmStringsOf(values []string) []cmString {
+	out := make([]cmString, len(values))
+	for i, v := range values {
+		out[i] = cmStringOf(v)
+	}
+	return out
+}
+
+func (t *componentTransport) Log(level LogLevel, message string) error {
+	hostLog(cmLogLevel(level), cmStringOf(message))
+	return nil
+}
+
+// ContentFile returns /data.bin when the host also exposes the content
+// through wasi:filesystem, otherwise a copy fetched with read-content
+func (t *componentTransport) ContentFile() string {
+	if _, err := os.Stat(ContentPath); err == nil {
+		return ContentPath
+	}
+	t.contentOnce.Do(func() {
+		t.contentFile = ContentPath
+		file, err := os.CreateTemp("", "content-*.bin")
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		if _, err := io.Copy(file, &hostContentReader{size: hostContentSize()}); err != nil {
+			return
+		}
+		t.contentFile = file.Name()
+	})
+	return t.contentFile
+}
+
+// hostContentReadSize is the chunk size used when copying content
+const hostContentReadSize = 1 << 20
+
+// hostContentReader reads the content through read-content
+type hostContentReader struct {
+	offset uint64
+	size   uint64
+}
+
+func (r *hostContentReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	length := uint64(len(p))
+	if length > hostContentReadSize {
+		length = hostContentReadSize
+	}
+	data := hostReadContent(r.offset, length).slice()
+	if len(data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, data)
+	r.offset += uint64(n)
+	return n, nil
+}
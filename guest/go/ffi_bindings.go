@@ -0,0 +1,400 @@
+// Code generated by witgen from wadup.wit. DO NOT EDIT.
+
+//go:build wasip2
+
+package wadup
+
+import (
+	"unsafe"
+)
+
+// cmString is a string in linear memory
+type cmString struct {
+	ptr *byte
+	len uint32
+}
+
+// cmList is a list in linear memory
+type cmList[T any] struct {
+	ptr *T
+	len uint32
+}
+
+// cmOption is an option; value is only set if isSome is true
+type cmOption[T any] struct {
+	isSome bool
+	value  T
+}
+
+func cmStringOf(s string) cmString {
+	return cmString{ptr: unsafe.StringData(s), len: uint32(len(s))}
+}
+
+func (s cmString) value() string {
+	return unsafe.String(s.ptr, s.len)
+}
+
+func cmListOf[T any](s []T) cmList[T] {
+	return cmList[T]{ptr: unsafe.SliceData(s), len: uint32(len(s))}
+}
+
+func (l cmList[T]) slice() []T {
+	return unsafe.Slice(l.ptr, l.len)
+}
+
+func cmSome[T any](v T) cmOption[T] {
+	return cmOption[T]{isSome: true, value: v}
+}
+
+// cmDataType is the enum data-type
+//
+// Column types, matching the data_type of the file protocol
+type cmDataType uint8
+
+const (
+	cmDataTypeInt64 cmDataType = iota
+	cmDataTypeFloat64
+	cmDataTypeString
+	cmDataTypeBoolean
+	cmDataTypeBytes
+	cmDataTypeTimestamp
+	cmDataTypeIPAddress
+	cmDataTypeJSON
+	cmDataTypeStringArray
+	cmDataTypeInt64Array
+	cmDataTypeBytesArray
+	cmDataTypeDecimal
+	cmDataTypeBigInt
+	cmDataTypeDuration
+	cmDataTypeUUID
+	cmDataTypeIpv4
+	cmDataTypeIpv6
+	cmDataTypeSubcontentID
+)
+
+// cmColumn is the record column
+type cmColumn struct {
+	name       cmString
+	dataType   cmDataType
+	nullable   bool
+	primaryKey bool
+	unique     bool
+}
+
+// cmValue is the variant value
+//
+// A cell value. Timestamps are RFC 3339, in UTC unless the original
+// offset was kept, IP addresses are in canonical text form and JSON
+// documents are encoded text. Decimals and big integers are exact
+// base-10 text, e.g. "-12.50" and
+// "170141183460469231731687303715884105727". Durations are nanoseconds.
+// UUIDs are lowercase hyphenated text.
+type cmValue struct {
+	tag     uint8
+	payload [1]uint64
+}
+
+func cmValueNull() cmValue {
+	return cmValue{tag: 0}
+}
+
+func cmValueInt64(v int64) cmValue {
+	r := cmValue{tag: 1}
+	*(*int64)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueFloat64(v float64) cmValue {
+	r := cmValue{tag: 2}
+	*(*float64)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueString(v cmString) cmValue {
+	r := cmValue{tag: 3}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueBoolean(v bool) cmValue {
+	r := cmValue{tag: 4}
+	*(*bool)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueBytes(v cmList[uint8]) cmValue {
+	r := cmValue{tag: 5}
+	*(*cmList[uint8])(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueTimestamp(v cmString) cmValue {
+	r := cmValue{tag: 6}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueIPAddress(v cmString) cmValue {
+	r := cmValue{tag: 7}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueJSON(v cmString) cmValue {
+	r := cmValue{tag: 8}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueStringArray(v cmList[cmString]) cmValue {
+	r := cmValue{tag: 9}
+	*(*cmList[cmString])(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueInt64Array(v cmList[int64]) cmValue {
+	r := cmValue{tag: 10}
+	*(*cmList[int64])(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueBytesArray(v cmList[cmList[uint8]]) cmValue {
+	r := cmValue{tag: 11}
+	*(*cmList[cmList[uint8]])(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueDecimal(v cmString) cmValue {
+	r := cmValue{tag: 12}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueBigInt(v cmString) cmValue {
+	r := cmValue{tag: 13}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueDuration(v int64) cmValue {
+	r := cmValue{tag: 14}
+	*(*int64)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueUUID(v cmString) cmValue {
+	r := cmValue{tag: 15}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueIpv4(v cmString) cmValue {
+	r := cmValue{tag: 16}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueIpv6(v cmString) cmValue {
+	r := cmValue{tag: 17}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmValueSubcontentID(v uint64) cmValue {
+	r := cmValue{tag: 18}
+	*(*uint64)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+// cmSubcontentData is the variant subcontent-data
+//
+// Where a sub-content item's bytes come from
+type cmSubcontentData struct {
+	tag     uint8
+	payload [2]uint64
+}
+
+func cmSubcontentDataBytes(v cmList[uint8]) cmSubcontentData {
+	r := cmSubcontentData{tag: 0}
+	*(*cmList[uint8])(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func cmSubcontentDataSlice(v cmByteRange) cmSubcontentData {
+	r := cmSubcontentData{tag: 1}
+	*(*cmByteRange)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+// cmByteRange is the record byte-range
+type cmByteRange struct {
+	offset uint64
+	length uint64
+}
+
+// cmSubcontent is the record subcontent
+type cmSubcontent struct {
+	index            uint64
+	filename         cmString
+	data             cmSubcontentData
+	tags             cmList[cmString]
+	relationship     cmOption[cmString]
+	suggestedParsers cmList[cmString]
+}
+
+// cmLogLevel is the enum log-level
+type cmLogLevel uint8
+
+const (
+	cmLogLevelDebug cmLogLevel = iota
+	cmLogLevelInfo
+	cmLogLevelWarn
+	cmLogLevelError
+)
+
+//go:wasmimport wadup:guest/host@0.1.0 define-table
+func hostDefineTableImport(f0 uint32, f1 uint32, f2 uint32, f3 uint32, ret uint32)
+
+// hostDefineTable calls define-table.
+//
+// Declare a table; redefining a table with the same columns is allowed
+func hostDefineTable(name cmString, columns cmList[cmColumn]) (result cmResultErrString) {
+	var f0 uint32
+	var f1 uint32
+	var f2 uint32
+	var f3 uint32
+	f0 = *(*uint32)(unsafe.Add(unsafe.Pointer(&name), 0))
+	f1 = *(*uint32)(unsafe.Add(unsafe.Pointer(&name), 4))
+	f2 = *(*uint32)(unsafe.Add(unsafe.Pointer(&columns), 0))
+	f3 = *(*uint32)(unsafe.Add(unsafe.Pointer(&columns), 4))
+	hostDefineTableImport(f0, f1, f2, f3, uint32(uintptr(unsafe.Pointer(&result))))
+	return
+}
+
+//go:wasmimport wadup:guest/host@0.1.0 insert-row
+func hostInsertRowImport(f0 uint32, f1 uint32, f2 uint32, f3 uint32, ret uint32)
+
+// hostInsertRow calls insert-row.
+//
+// Insert one row into a defined table
+func hostInsertRow(table cmString, values cmList[cmValue]) (result cmResultErrString) {
+	var f0 uint32
+	var f1 uint32
+	var f2 uint32
+	var f3 uint32
+	f0 = *(*uint32)(unsafe.Add(unsafe.Pointer(&table), 0))
+	f1 = *(*uint32)(unsafe.Add(unsafe.Pointer(&table), 4))
+	f2 = *(*uint32)(unsafe.Add(unsafe.Pointer(&values), 0))
+	f3 = *(*uint32)(unsafe.Add(unsafe.Pointer(&values), 4))
+	hostInsertRowImport(f0, f1, f2, f3, uint32(uintptr(unsafe.Pointer(&result))))
+	return
+}
+
+//go:wasmimport wadup:guest/host@0.1.0 emit-subcontent
+func hostEmitSubcontentImport(f0 uint64, f1 uint32, f2 uint32, f3 uint32, f4 uint64, f5 uint64, f6 uint32, f7 uint32, f8 uint32, f9 uint32, f10 uint32, f11 uint32, f12 uint32, ret uint32)
+
+// hostEmitSubcontent calls emit-subcontent.
+//
+// Emit a sub-content item for recursive processing
+func hostEmitSubcontent(item cmSubcontent) (result cmResultErrString) {
+	var f0 uint64
+	var f1 uint32
+	var f2 uint32
+	var f3 uint32
+	var f4 uint64
+	var f5 uint64
+	var f6 uint32
+	var f7 uint32
+	var f8 uint32
+	var f9 uint32
+	var f10 uint32
+	var f11 uint32
+	var f12 uint32
+	f0 = *(*uint64)(unsafe.Add(unsafe.Pointer(&item), 0))
+	f1 = *(*uint32)(unsafe.Add(unsafe.Pointer(&item), 8))
+	f2 = *(*uint32)(unsafe.Add(unsafe.Pointer(&item), 12))
+	f3 = uint32(*(*uint8)(unsafe.Add(unsafe.Pointer(&item), 16)))
+	switch f3 {
+	case 0:
+		f4 = uint64(*(*uint32)(unsafe.Add(unsafe.Pointer(&item), 24)))
+		f5 = uint64(*(*uint32)(unsafe.Add(unsafe.Pointer(&item), 28)))
+	case 1:
+		f4 = *(*uint64)(unsafe.Add(unsafe.Pointer(&item), 24))
+		f5 = *(*uint64)(unsafe.Add(unsafe.Pointer(&item), 32))
+	}
+	f6 = *(*uint32)(unsafe.Add(unsafe.Pointer(&item), 40))
+	f7 = *(*uint32)(unsafe.Add(unsafe.Pointer(&item), 44))
+	f8 = uint32(*(*uint8)(unsafe.Add(unsafe.Pointer(&item), 48)))
+	switch f8 {
+	case 1:
+		f9 = *(*uint32)(unsafe.Add(unsafe.Pointer(&item), 52))
+		f10 = *(*uint32)(unsafe.Add(unsafe.Pointer(&item), 56))
+	}
+	f11 = *(*uint32)(unsafe.Add(unsafe.Pointer(&item), 60))
+	f12 = *(*uint32)(unsafe.Add(unsafe.Pointer(&item), 64))
+	hostEmitSubcontentImport(f0, f1, f2, f3, f4, f5, f6, f7, f8, f9, f10, f11, f12, uint32(uintptr(unsafe.Pointer(&result))))
+	return
+}
+
+//go:wasmimport wadup:guest/host@0.1.0 read-content
+func hostReadContentImport(f0 uint64, f1 uint64, ret uint32)
+
+// hostReadContent calls read-content.
+//
+// Read part of the content being processed; reads past the end are short
+func hostReadContent(offset uint64, length uint64) (result cmList[uint8]) {
+	var f0 uint64
+	var f1 uint64
+	f0 = *(*uint64)(unsafe.Add(unsafe.Pointer(&offset), 0))
+	f1 = *(*uint64)(unsafe.Add(unsafe.Pointer(&length), 0))
+	hostReadContentImport(f0, f1, uint32(uintptr(unsafe.Pointer(&result))))
+	return
+}
+
+//go:wasmimport wadup:guest/host@0.1.0 content-size
+func hostContentSizeImport() uint64
+
+// hostContentSize calls content-size.
+//
+// Size of the content being processed in bytes
+func hostContentSize() (result uint64) {
+	return uint64(hostContentSizeImport())
+}
+
+//go:wasmimport wadup:guest/host@0.1.0 log
+func hostLogImport(f0 uint32, f1 uint32, f2 uint32)
+
+// hostLog calls log.
+func hostLog(level cmLogLevel, message cmString) {
+	var f0 uint32
+	var f1 uint32
+	var f2 uint32
+	f0 = uint32(*(*uint8)(unsafe.Add(unsafe.Pointer(&level), 0)))
+	f1 = *(*uint32)(unsafe.Add(unsafe.Pointer(&message), 0))
+	f2 = *(*uint32)(unsafe.Add(unsafe.Pointer(&message), 4))
+	hostLogImport(f0, f1, f2)
+}
+
+// cmResultErrString is a result type
+type cmResultErrString struct {
+	tag     uint8
+	payload [2]uint32
+}
+
+func cmResultErrStringOk() cmResultErrString {
+	return cmResultErrString{tag: 0}
+}
+
+func cmResultErrStringErr(v cmString) cmResultErrString {
+	r := cmResultErrString{tag: 1}
+	*(*cmString)(unsafe.Pointer(&r.payload)) = v
+	return r
+}
+
+func (r *cmResultErrString) isErr() bool {
+	return r.tag == 1
+}
+
+func (r *cmResultErrString) errValue() *cmString {
+	return (*cmString)(unsafe.Pointer(&r.payload))
+}
@@ -0,0 +1,24 @@
+package wadup
+
+// ContentFlags describes properties of emitted content that the parent
+// parser already knows, so the host can skip re-detection.
+type ContentFlags struct {
+	// Encrypted marks content the host cannot analyze without a key
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Compressed marks content that is still compressed
+	Compressed bool `json:"compressed,omitempty"`
+	// Compression names the compression method (e.g. "deflate", "lzma")
+	Compression string `json:"compression,omitempty"`
+}
+
+// EmitBytesFlagged emits sub-content bytes along with encryption and
+// compression flags recorded in the sub-content metadata.
+//
+// Zero-valued flags are omitted, so the result is identical to EmitBytes.
+func EmitBytesFlagged(data []byte, filename string, flags ContentFlags) (SubContentRef, error) {
+	metadata := subContentMetadata{Filename: filename}
+	if flags != (ContentFlags{}) {
+		metadata.Flags = &flags
+	}
+	return emitBytes(data, metadata)
+}
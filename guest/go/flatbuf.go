@@ -0,0 +1,167 @@
+package wadup
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// fbBuilder is a minimal FlatBuffers writer, sufficient for the Arrow IPC
+// messages produced by this package. Unlike the reference builder it lays
+// the buffer out front to back: every object is written before its children,
+// so all offsets point forward as the format requires.
+type fbBuilder struct {
+	buf []byte
+}
+
+// fbNode is an object that can be referenced by offset
+type fbNode interface {
+	// writeTo appends the object and returns the position offsets refer to
+	writeTo(b *fbBuilder) int
+}
+
+// fbField is a table field: either an inline scalar or an offset to a child
+type fbField struct {
+	slot  int
+	size  int
+	value uint64
+	child fbNode
+}
+
+// fbTable is a FlatBuffers table
+type fbTable []fbField
+
+// fbString is a FlatBuffers string
+type fbString string
+
+// fbVector is a vector of offsets to tables or strings
+type fbVector []fbNode
+
+// fbStructVector is a vector of fixed-size structs, given as raw bytes
+type fbStructVector struct {
+	count int
+	align int
+	data  []byte
+}
+
+func fbInt8(slot int, v uint8) fbField { return fbField{slot: slot, size: 1, value: uint64(v)} }
+func fbInt16(slot int, v int16) fbField {
+	return fbField{slot: slot, size: 2, value: uint64(uint16(v))}
+}
+func fbInt32(slot int, v int32) fbField {
+	return fbField{slot: slot, size: 4, value: uint64(uint32(v))}
+}
+func fbInt64(slot int, v int64) fbField  { return fbField{slot: slot, size: 8, value: uint64(v)} }
+func fbChild(slot int, n fbNode) fbField { return fbField{slot: slot, size: 4, child: n} }
+
+func fbBool(slot int, v bool) fbField {
+	if v {
+		return fbInt8(slot, 1)
+	}
+	return fbInt8(slot, 0)
+}
+
+// finish writes the root table and returns the finished buffer
+func (b *fbBuilder) finish(root fbNode) []byte {
+	b.buf = append(b.buf[:0], 0, 0, 0, 0)
+	pos := root.writeTo(b)
+	b.patch(0, pos)
+	b.pad(8)
+	return b.buf
+}
+
+// pad appends zero bytes until the length is a multiple of n
+func (b *fbBuilder) pad(n int) {
+	for len(b.buf)%n != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// patch stores the forward offset from at to target
+func (b *fbBuilder) patch(at, target int) {
+	binary.LittleEndian.PutUint32(b.buf[at:], uint32(target-at))
+}
+
+func (t fbTable) writeTo(b *fbBuilder) int {
+	// Lay out the inline fields largest first to avoid padding
+	fields := append(fbTable(nil), t...)
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].size > fields[j].size })
+	slots := 0
+	maxAlign := 4
+	offsets := make([]int, len(fields))
+	size := 4
+	for i, f := range fields {
+		slots = max(slots, f.slot+1)
+		maxAlign = max(maxAlign, f.size)
+		size = (size + f.size - 1) / f.size * f.size
+		offsets[i] = size
+		size += f.size
+	}
+
+	// The vtable precedes the table, so the table's vtable offset is positive
+	b.pad(2)
+	vtable := len(b.buf)
+	vt := make([]byte, 4+2*slots)
+	binary.LittleEndian.PutUint16(vt[0:], uint16(len(vt)))
+	binary.LittleEndian.PutUint16(vt[2:], uint16(size))
+	for i, f := range fields {
+		binary.LittleEndian.PutUint16(vt[4+2*f.slot:], uint16(offsets[i]))
+	}
+	b.buf = append(b.buf, vt...)
+
+	b.pad(maxAlign)
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(int32(pos-vtable)))
+	for i, f := range fields {
+		at := b.buf[pos+offsets[i]:]
+		switch f.size {
+		case 1:
+			at[0] = byte(f.value)
+		case 2:
+			binary.LittleEndian.PutUint16(at, uint16(f.value))
+		case 4:
+			binary.LittleEndian.PutUint32(at, uint32(f.value))
+		case 8:
+			binary.LittleEndian.PutUint64(at, f.value)
+		}
+	}
+
+	for i, f := range fields {
+		if f.child != nil {
+			b.patch(pos+offsets[i], f.child.writeTo(b))
+		}
+	}
+	return pos
+}
+
+func (s fbString) writeTo(b *fbBuilder) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+func (v fbVector) writeTo(b *fbBuilder) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+	b.buf = append(b.buf, make([]byte, 4*len(v))...)
+	for i, n := range v {
+		b.patch(pos+4+4*i, n.writeTo(b))
+	}
+	return pos
+}
+
+func (v fbStructVector) writeTo(b *fbBuilder) int {
+	// The elements, not the length prefix, must be aligned
+	b.pad(4)
+	for (len(b.buf)+4)%v.align != 0 {
+		b.buf = append(b.buf, 0, 0, 0, 0)
+	}
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(v.count))
+	b.buf = append(b.buf, v.data...)
+	return pos
+}
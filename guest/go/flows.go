@@ -0,0 +1,89 @@
+package wadup
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// FlowsTable is the conventional table written by EmitFlows
+const FlowsTable = "flows"
+
+// Flow is a network flow record identified by its 5-tuple
+type Flow struct {
+	SrcIP   net.IP
+	DstIP   net.IP
+	SrcPort uint16
+	DstPort uint16
+	// Proto is the transport protocol name (e.g. "tcp", "udp", "icmp")
+	Proto   string
+	Bytes   int64
+	Packets int64
+	Start   time.Time
+	End     time.Time
+}
+
+// EmitFlows inserts flow records into the flows table.
+//
+// Addresses are normalized (IPv4-mapped IPv6 becomes IPv4) and protocol names
+// are lowercased. A flow is rejected if an address is invalid, a TCP, UDP or
+// SCTP flow has a zero port, its counters are negative, or it ends before it
+// starts. Rows inserted before an invalid flow are kept.
+func EmitFlows(flows []Flow) error {
+	table, err := NewTableBuilder(FlowsTable).
+		Column("src_ip", IPAddress).
+		Column("src_port", Int64).
+		Column("dst_ip", IPAddress).
+		Column("dst_port", Int64).
+		Column("proto", String).
+		Column("bytes", Int64).
+		Column("packets", Int64).
+		Column("start", Timestamp).
+		Column("end", Timestamp).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	for i, f := range flows {
+		src, ok := netip.AddrFromSlice(f.SrcIP)
+		if !ok {
+			return fmt.Errorf("flow %d: invalid source address %v", i, f.SrcIP)
+		}
+		dst, ok := netip.AddrFromSlice(f.DstIP)
+		if !ok {
+			return fmt.Errorf("flow %d: invalid destination address %v", i, f.DstIP)
+		}
+		proto := strings.ToLower(strings.TrimSpace(f.Proto))
+		switch proto {
+		case "tcp", "udp", "sctp":
+			if f.SrcPort == 0 || f.DstPort == 0 {
+				return fmt.Errorf("flow %d: %s flow requires non-zero ports (got %d -> %d)", i, proto, f.SrcPort, f.DstPort)
+			}
+		}
+		if f.Bytes < 0 || f.Packets < 0 {
+			return fmt.Errorf("flow %d: negative byte or packet count", i)
+		}
+		if f.End.Before(f.Start) {
+			return fmt.Errorf("flow %d: ends before it starts", i)
+		}
+
+		err := table.InsertRow([]Value{
+			NewIPAddress(src),
+			NewInt64(int64(f.SrcPort)),
+			NewIPAddress(dst),
+			NewInt64(int64(f.DstPort)),
+			NewString(proto),
+			NewInt64(f.Bytes),
+			NewInt64(f.Packets),
+			NewTimestamp(f.Start),
+			NewTimestamp(f.End),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
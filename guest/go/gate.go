@@ -0,0 +1,68 @@
+package wadup
+
+import "sync"
+
+// EmitGate decides whether content may be emitted. It returns false and a
+// reason to skip the emission.
+type EmitGate func(data []byte, filename string) (bool, string)
+
+// SkippedEmission records an emission rejected by the emit gate
+type SkippedEmission struct {
+	Filename string
+	Reason   string
+}
+
+var (
+	gateMu  sync.Mutex
+	gate    EmitGate
+	skipped []SkippedEmission
+)
+
+// SetEmitGate registers a policy consulted by every emit function before any
+// file is written, centralizing checks such as "too big" or "wrong type". A
+// rejected emission is skipped without error and recorded with its reason
+// (see SkippedEmissions). Pass nil to remove the gate.
+//
+// For slice emissions the gate receives nil data, since slices are not
+// copied into the module. The gate runs first: content it rejects never
+// reaches any other emission limit, and content it accepts is still subject
+// to them.
+func SetEmitGate(g EmitGate) {
+	gateMu.Lock()
+	defer gateMu.Unlock()
+	gate = g
+}
+
+// SkippedEmissions returns the emissions rejected by the gate since the last
+// call to ResetSkippedEmissions.
+func SkippedEmissions() []SkippedEmission {
+	gateMu.Lock()
+	defer gateMu.Unlock()
+	return append([]SkippedEmission(nil), skipped...)
+}
+
+// ResetSkippedEmissions clears the recorded skipped emissions
+func ResetSkippedEmissions() {
+	gateMu.Lock()
+	defer gateMu.Unlock()
+	skipped = nil
+}
+
+// passesGate consults the emit gate, recording the emission if it is rejected
+func passesGate(data []byte, filename string) bool {
+	gateMu.Lock()
+	g := gate
+	gateMu.Unlock()
+	if g == nil {
+		return true
+	}
+
+	ok, reason := g(data, filename)
+	if ok {
+		return true
+	}
+	gateMu.Lock()
+	defer gateMu.Unlock()
+	skipped = append(skipped, SkippedEmission{Filename: filename, Reason: reason})
+	return false
+}
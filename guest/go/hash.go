@@ -0,0 +1,80 @@
+package wadup
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// HashAlgorithm names a digest computed by HashContent
+type HashAlgorithm string
+
+const (
+	HashMD5    HashAlgorithm = "md5"
+	HashSHA1   HashAlgorithm = "sha1"
+	HashSHA256 HashAlgorithm = "sha256"
+	HashSHA512 HashAlgorithm = "sha512"
+	// HashSSDeep is the ssdeep fuzzy hash; it is only available from hosts
+	// advertising FeatureHashContent
+	HashSSDeep HashAlgorithm = "ssdeep"
+)
+
+// guestHashes are the algorithms HashContent can compute without the host
+var guestHashes = map[HashAlgorithm]func() hash.Hash{
+	HashMD5:    md5.New,
+	HashSHA1:   sha1.New,
+	HashSHA256: sha256.New,
+	HashSHA512: sha512.New,
+}
+
+// HashContent returns the digest of length bytes of the content starting at
+// offset; a negative length hashes to the end. Digests are lowercase hex,
+// except ssdeep which uses its usual "blocksize:hash:hash" form.
+//
+// If the host advertises FeatureHashContent the content is hashed natively by
+// the host, which is far faster than hashing in the module. Otherwise the
+// cryptographic digests are computed in the guest.
+func HashContent(algo HashAlgorithm, offset, length int64) (string, error) {
+	if offset < 0 {
+		return "", fmt.Errorf("invalid hash range: offset %d", offset)
+	}
+	if digest, ok, err := hostHashContent(algo, offset, length); ok {
+		return digest, err
+	}
+
+	newHash, ok := guestHashes[algo]
+	if !ok {
+		return "", fmt.Errorf("hash algorithm '%s' is not supported by this host", algo)
+	}
+
+	content, err := OpenContent()
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", fmt.Errorf("failed to seek content: %w", err)
+	}
+	if length < 0 {
+		length = size - offset
+	}
+	if offset+length > size || length < 0 {
+		return "", fmt.Errorf("hash range %d+%d is outside the content (%d bytes)", offset, length, size)
+	}
+	if _, err := content.Seek(offset, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to seek content: %w", err)
+	}
+
+	h := newHash()
+	if _, err := io.CopyN(h, content, length); err != nil {
+		return "", fmt.Errorf("failed to hash content: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostHashContent reports that the host cannot hash content, since there is
+// no hash_content import outside preview1 builds
+func hostHashContent(algo HashAlgorithm, offset, length int64) (string, bool, error) {
+	return "", false, nil
+}
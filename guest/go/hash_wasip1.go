@@ -0,0 +1,47 @@
+//go:build wasip1
+
+package wadup
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Error codes returned by the hash_content import
+const (
+	hashErrUnknownAlgorithm = -1
+	hashErrOutOfRange       = -2
+)
+
+// hashContent hashes a range of the content on the host and copies the
+// digest text into out, returning its length or a negative error code.
+// Nothing is copied if out is too small.
+//
+//go:wasmimport wadup hash_content
+func hashContent(algo unsafe.Pointer, algoLen uint32, offset int64, length int64, out unsafe.Pointer, outLen uint32) int32
+
+// hostHashContent hashes a range natively if the host supports it. ok is
+// false if the host cannot, and the guest must hash the content itself.
+func hostHashContent(algo HashAlgorithm, offset, length int64) (digest string, ok bool, err error) {
+	if !HostSupports(FeatureHashContent) {
+		return "", false, nil
+	}
+
+	name := string(algo)
+	buf := make([]byte, 160)
+	for {
+		n := hashContent(unsafe.Pointer(unsafe.StringData(name)), uint32(len(name)),
+			offset, length, unsafe.Pointer(unsafe.SliceData(buf)), uint32(len(buf)))
+		switch {
+		case n == hashErrUnknownAlgorithm:
+			return "", true, fmt.Errorf("hash algorithm '%s' is not supported by this host", algo)
+		case n == hashErrOutOfRange:
+			return "", true, fmt.Errorf("hash range %d+%d is outside the content", offset, length)
+		case n < 0:
+			return "", true, fmt.Errorf("failed to hash content: error %d", n)
+		case int(n) <= len(buf):
+			return string(buf[:n]), true, nil
+		}
+		buf = make([]byte, n)
+	}
+}
@@ -0,0 +1,71 @@
+package wadup
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ByteHistogramTable is the conventional table written by EmitByteHistogram
+const ByteHistogramTable = "byte_histogram"
+
+// histogramChunkSize bounds the memory used while streaming the content
+const histogramChunkSize = 64 * 1024
+
+// EmitByteHistogram counts the occurrences of each byte value in /data.bin and
+// inserts 256 rows (byte, count, frequency) into the byte_histogram table.
+//
+// The content is streamed in fixed-size chunks, so memory use is constant
+// regardless of content size. Frequency is count divided by the content size,
+// or zero for empty content.
+func EmitByteHistogram() error {
+	path := contentPath()
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open content '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	var counts [256]int64
+	var total int64
+	buf := make([]byte, histogramChunkSize)
+	for {
+		n, err := f.Read(buf)
+		for _, b := range buf[:n] {
+			counts[b]++
+		}
+		total += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read content '%s': %w", path, err)
+		}
+	}
+
+	table, err := NewTableBuilder(ByteHistogramTable).
+		Column("byte", Int64).
+		Column("count", Int64).
+		Column("frequency", Float64).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	for b, count := range counts {
+		frequency := 0.0
+		if total > 0 {
+			frequency = float64(count) / float64(total)
+		}
+		err := table.InsertRow([]Value{
+			NewInt64(int64(b)),
+			NewInt64(count),
+			NewFloat64(frequency),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import "fmt"
+
+// Canonical ABI layout and flattening for wasm32, following
+// https://github.com/WebAssembly/component-model/blob/main/design/mvp/CanonicalABI.md
+
+// primSizes holds the size (and alignment) of each primitive type
+var primSizes = map[string]int{
+	"bool": 1, "u8": 1, "s8": 1,
+	"u16": 2, "s16": 2,
+	"u32": 4, "s32": 4, "f32": 4, "char": 4,
+	"u64": 8, "s64": 8, "f64": 8,
+}
+
+// primFlat holds the core wasm type each primitive flattens to
+var primFlat = map[string]string{
+	"bool": "i32", "u8": "i32", "s8": "i32", "u16": "i32", "s16": "i32",
+	"u32": "i32", "s32": "i32", "char": "i32",
+	"u64": "i64", "s64": "i64", "f32": "f32", "f64": "f64",
+}
+
+func alignTo(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// discSize returns the size of the discriminant of a variant with n cases
+func discSize(n int) int {
+	switch {
+	case n <= 1<<8:
+		return 1
+	case n <= 1<<16:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// cases returns the cases of a variant-like type: variants, options and
+// results. A nil case type has no payload.
+func (d *document) cases(t *witType) ([]*witType, bool) {
+	switch t.name {
+	case "option":
+		return []*witType{nil, t.elem}, true
+	case "result":
+		return []*witType{t.ok, t.err}, true
+	}
+	if def := d.byName[t.name]; def != nil && def.kind == "variant" {
+		out := make([]*witType, len(def.cases))
+		for i, c := range def.cases {
+			out[i] = c.typ
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// resolve returns the definition of a named type
+func (d *document) resolve(t *witType) (*typeDef, error) {
+	def := d.byName[t.name]
+	if def == nil {
+		return nil, fmt.Errorf("unknown type '%s'", t.name)
+	}
+	return def, nil
+}
+
+func (d *document) align(t *witType) (int, error) {
+	if n, ok := primSizes[t.name]; ok {
+		return n, nil
+	}
+	switch t.name {
+	case "string", "list":
+		return 4, nil
+	}
+	if cases, ok := d.cases(t); ok {
+		align := discSize(len(cases))
+		for _, c := range cases {
+			if c == nil {
+				continue
+			}
+			a, err := d.align(c)
+			if err != nil {
+				return 0, err
+			}
+			align = max(align, a)
+		}
+		return align, nil
+	}
+	def, err := d.resolve(t)
+	if err != nil {
+		return 0, err
+	}
+	if def.kind == "enum" {
+		return discSize(len(def.cases)), nil
+	}
+	align := 1
+	for _, f := range def.fields {
+		a, err := d.align(f.typ)
+		if err != nil {
+			return 0, err
+		}
+		align = max(align, a)
+	}
+	return align, nil
+}
+
+func (d *document) size(t *witType) (int, error) {
+	if n, ok := primSizes[t.name]; ok {
+		return n, nil
+	}
+	switch t.name {
+	case "string", "list":
+		return 8, nil
+	}
+	align, err := d.align(t)
+	if err != nil {
+		return 0, err
+	}
+	if cases, ok := d.cases(t); ok {
+		off, payload, _, err := d.payloadLayout(cases)
+		if err != nil {
+			return 0, err
+		}
+		return alignTo(off+payload, align), nil
+	}
+	def, err := d.resolve(t)
+	if err != nil {
+		return 0, err
+	}
+	if def.kind == "enum" {
+		return discSize(len(def.cases)), nil
+	}
+	_, end, err := d.fieldOffsets(def.fields)
+	if err != nil {
+		return 0, err
+	}
+	return alignTo(end, align), nil
+}
+
+// payloadLayout returns the offset, size and alignment of a variant's payload
+func (d *document) payloadLayout(cases []*witType) (int, int, int, error) {
+	payloadAlign, payloadSize := 1, 0
+	for _, c := range cases {
+		if c == nil {
+			continue
+		}
+		a, err := d.align(c)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		s, err := d.size(c)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		payloadAlign = max(payloadAlign, a)
+		payloadSize = max(payloadSize, s)
+	}
+	return alignTo(discSize(len(cases)), payloadAlign), payloadSize, payloadAlign, nil
+}
+
+// fieldOffsets returns the offset of each record field and the end of the
+// last one
+func (d *document) fieldOffsets(fields []field) ([]int, int, error) {
+	offsets := make([]int, len(fields))
+	off := 0
+	for i, f := range fields {
+		a, err := d.align(f.typ)
+		if err != nil {
+			return nil, 0, err
+		}
+		s, err := d.size(f.typ)
+		if err != nil {
+			return nil, 0, err
+		}
+		off = alignTo(off, a)
+		offsets[i] = off
+		off += s
+	}
+	return offsets, off, nil
+}
+
+// flat returns the core wasm types a value flattens to
+func (d *document) flat(t *witType) ([]string, error) {
+	if f, ok := primFlat[t.name]; ok {
+		return []string{f}, nil
+	}
+	switch t.name {
+	case "string", "list":
+		return []string{"i32", "i32"}, nil
+	}
+	if cases, ok := d.cases(t); ok {
+		var joined []string
+		for _, c := range cases {
+			if c == nil {
+				continue
+			}
+			f, err := d.flat(c)
+			if err != nil {
+				return nil, err
+			}
+			for i, ft := range f {
+				if i < len(joined) {
+					joined[i] = join(joined[i], ft)
+				} else {
+					joined = append(joined, ft)
+				}
+			}
+		}
+		return append([]string{"i32"}, joined...), nil
+	}
+	def, err := d.resolve(t)
+	if err != nil {
+		return nil, err
+	}
+	if def.kind == "enum" {
+		return []string{"i32"}, nil
+	}
+	var out []string
+	for _, f := range def.fields {
+		ft, err := d.flat(f.typ)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ft...)
+	}
+	return out, nil
+}
+
+// join returns the core type able to hold both a and b
+func join(a, b string) string {
+	switch {
+	case a == b:
+		return a
+	case (a == "i32" && b == "f32") || (a == "f32" && b == "i32"):
+		return "i32"
+	default:
+		return "i64"
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strings"
+)
+
+// initialisms are written in upper case in Go names
+var initialisms = map[string]string{
+	"abi": "ABI", "id": "ID", "ip": "IP", "json": "JSON", "url": "URL", "uuid": "UUID",
+}
+
+// camel converts a kebab-case WIT name to CamelCase
+func camel(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "-") {
+		if up, ok := initialisms[part]; ok {
+			b.WriteString(up)
+		} else if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// lowerCamel converts a kebab-case WIT name to a Go parameter name
+func lowerCamel(name string) string {
+	s := camel(name)
+	n := 1
+	for n < len(s) && s[n] >= 'A' && s[n] <= 'Z' && (n+1 == len(s) || s[n+1] < 'a' || s[n+1] > 'z') {
+		n++
+	}
+	s = strings.ToLower(s[:n]) + s[n:]
+	if token.IsKeyword(s) || s == "result" {
+		s += "_"
+	}
+	return s
+}
+
+// primGo holds the Go type of each primitive
+var primGo = map[string]string{
+	"bool": "bool", "u8": "uint8", "s8": "int8", "u16": "uint16", "s16": "int16",
+	"u32": "uint32", "s32": "int32", "u64": "uint64", "s64": "int64",
+	"f32": "float32", "f64": "float64", "char": "rune",
+}
+
+// coreGo holds the Go type of each core wasm type
+var coreGo = map[string]string{"i32": "uint32", "i64": "uint64", "f32": "float32", "f64": "float64"}
+
+// generator writes Go bindings for a WIT document
+type generator struct {
+	doc      *document
+	out      bytes.Buffer
+	results  map[string]*witType
+	order    []string
+	usesMath bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.out, format, args...)
+}
+
+// typeName returns a name fragment for a type, used to name results
+func typeName(t *witType) string {
+	if t == nil {
+		return ""
+	}
+	switch t.name {
+	case "list", "option":
+		return camel(t.name) + typeName(t.elem)
+	case "result":
+		return resultName(t)
+	}
+	return camel(t.name)
+}
+
+// resultName names the Go type generated for a result type
+func resultName(t *witType) string {
+	name := "cmResult" + typeName(t.ok)
+	if t.err != nil {
+		name += "Err" + typeName(t.err)
+	}
+	return name
+}
+
+// goType returns the Go type representing a WIT type in linear memory
+func (g *generator) goType(t *witType) string {
+	if p, ok := primGo[t.name]; ok {
+		return p
+	}
+	switch t.name {
+	case "string":
+		return "cmString"
+	case "list":
+		return "cmList[" + g.goType(t.elem) + "]"
+	case "option":
+		return "cmOption[" + g.goType(t.elem) + "]"
+	case "result":
+		name := resultName(t)
+		if _, ok := g.results[name]; !ok {
+			g.results[name] = t
+			g.order = append(g.order, name)
+		}
+		return name
+	}
+	return "cm" + camel(t.name)
+}
+
+// writeDoc writes a Go comment starting with summary followed by WIT docs
+func (g *generator) writeDoc(summary string, doc []string) {
+	g.printf("// %s\n", summary)
+	if len(doc) > 0 {
+		g.printf("//\n")
+		for _, line := range doc {
+			g.printf("// %s\n", line)
+		}
+	}
+}
+
+const preamble = `
+// cmString is a string in linear memory
+type cmString struct {
+	ptr *byte
+	len uint32
+}
+
+// cmList is a list in linear memory
+type cmList[T any] struct {
+	ptr *T
+	len uint32
+}
+
+// cmOption is an option; value is only set if isSome is true
+type cmOption[T any] struct {
+	isSome bool
+	value  T
+}
+
+func cmStringOf(s string) cmString {
+	return cmString{ptr: unsafe.StringData(s), len: uint32(len(s))}
+}
+
+func (s cmString) value() string {
+	return unsafe.String(s.ptr, s.len)
+}
+
+func cmListOf[T any](s []T) cmList[T] {
+	return cmList[T]{ptr: unsafe.SliceData(s), len: uint32(len(s))}
+}
+
+func (l cmList[T]) slice() []T {
+	return unsafe.Slice(l.ptr, l.len)
+}
+
+func cmSome[T any](v T) cmOption[T] {
+	return cmOption[T]{isSome: true, value: v}
+}
+`
+
+// generate returns gofmt-ed Go source for the document's types and the
+// imports of every interface
+func generate(doc *document, pkg, buildTag, source string) ([]byte, error) {
+	g := &generator{doc: doc, results: make(map[string]*witType)}
+	g.printf("// Code generated by witgen from %s. DO NOT EDIT.\n\n", source)
+	if buildTag != "" {
+		g.printf("//go:build %s\n\n", buildTag)
+	}
+	g.printf("package %s\n\nimport (\n\"unsafe\"\n)\n", pkg)
+	g.printf("%s", preamble)
+
+	for _, def := range doc.types {
+		if err := g.writeTypeDef(def); err != nil {
+			return nil, err
+		}
+	}
+
+	ns, version, _ := strings.Cut(doc.pkg, "@")
+	if version != "" {
+		version = "@" + version
+	}
+	for _, ifc := range doc.ifaces {
+		for _, fn := range ifc.funcs {
+			module := ns + "/" + ifc.name + version
+			if err := g.writeFunc(module, ifc.name, fn); err != nil {
+				return nil, fmt.Errorf("function '%s': %w", fn.name, err)
+			}
+		}
+	}
+
+	// Results are collected while writing functions
+	for i := 0; i < len(g.order); i++ {
+		if err := g.writeVariant(g.order[i], "result", nil, g.results[g.order[i]]); err != nil {
+			return nil, err
+		}
+	}
+
+	src := g.out.Bytes()
+	if g.usesMath {
+		src = bytes.Replace(src, []byte("import (\n"), []byte("import (\n\"math\"\n"), 1)
+	}
+	formatted, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return formatted, nil
+}
+
+func (g *generator) writeTypeDef(def *typeDef) error {
+	name := "cm" + camel(def.name)
+	switch def.kind {
+	case "enum":
+		g.writeDoc(fmt.Sprintf("%s is the enum %s", name, def.name), def.doc)
+		g.printf("type %s uint%d\n\nconst (\n", name, 8*discSize(len(def.cases)))
+		for i, c := range def.cases {
+			if i == 0 {
+				g.printf("%s%s %s = iota\n", name, camel(c.name), name)
+			} else {
+				g.printf("%s%s\n", name, camel(c.name))
+			}
+		}
+		g.printf(")\n\n")
+	case "record":
+		g.writeDoc(fmt.Sprintf("%s is the record %s", name, def.name), def.doc)
+		g.printf("type %s struct {\n", name)
+		for _, f := range def.fields {
+			g.printf("%s %s\n", lowerCamel(f.name), g.goType(f.typ))
+		}
+		g.printf("}\n\n")
+	case "variant":
+		return g.writeVariant(name, "variant", def, &witType{name: def.name})
+	}
+	return nil
+}
+
+// writeVariant writes a variant or result type as a discriminant followed
+// by a payload sized for the largest case
+func (g *generator) writeVariant(name, kind string, def *typeDef, t *witType) error {
+	cases, _ := g.doc.cases(t)
+	_, payloadSize, payloadAlign, err := g.doc.payloadLayout(cases)
+	if err != nil {
+		return err
+	}
+	disc := discSize(len(cases))
+
+	if def != nil {
+		g.writeDoc(fmt.Sprintf("%s is the %s %s", name, kind, def.name), def.doc)
+	} else {
+		g.printf("// %s is a result type\n", name)
+	}
+	g.printf("type %s struct {\ntag uint%d\n", name, 8*disc)
+	if payloadSize > 0 {
+		g.printf("payload [%d]uint%d\n", (payloadSize+payloadAlign-1)/payloadAlign, 8*payloadAlign)
+	}
+	g.printf("}\n\n")
+
+	caseNames := []string{"Ok", "Err"}
+	if def != nil {
+		caseNames = caseNames[:0]
+		for _, c := range def.cases {
+			caseNames = append(caseNames, camel(c.name))
+		}
+	}
+	for i, c := range cases {
+		ctor := name + caseNames[i]
+		if c == nil {
+			g.printf("func %s() %s {\nreturn %s{tag: %d}\n}\n\n", ctor, name, name, i)
+			continue
+		}
+		goT := g.goType(c)
+		g.printf("func %s(v %s) %s {\nr := %s{tag: %d}\n*(*%s)(unsafe.Pointer(&r.payload)) = v\nreturn r\n}\n\n",
+			ctor, goT, name, name, i, goT)
+	}
+
+	if def == nil {
+		g.printf("func (r *%s) isErr() bool {\nreturn r.tag == 1\n}\n\n", name)
+		if t.ok != nil {
+			g.printf("func (r *%s) okValue() *%s {\nreturn (*%s)(unsafe.Pointer(&r.payload))\n}\n\n", name, g.goType(t.ok), g.goType(t.ok))
+		}
+		if t.err != nil {
+			g.printf("func (r *%s) errValue() *%s {\nreturn (*%s)(unsafe.Pointer(&r.payload))\n}\n\n", name, g.goType(t.err), g.goType(t.err))
+		}
+	}
+	return nil
+}
+
+// maxFlatParams is the canonical ABI limit on flattened parameters
+const maxFlatParams = 16
+
+func (g *generator) writeFunc(module, ifcName string, fn function) error {
+	wrapper := lowerCamel(ifcName) + camel(fn.name)
+	if strings.HasSuffix(wrapper, "_") {
+		wrapper = strings.TrimSuffix(wrapper, "_")
+	}
+	raw := wrapper + "Import"
+
+	var slots []string
+	for _, p := range fn.params {
+		f, err := g.doc.flat(p.typ)
+		if err != nil {
+			return err
+		}
+		slots = append(slots, f...)
+	}
+	if len(slots) > maxFlatParams {
+		return fmt.Errorf("%d flattened parameters exceed the limit of %d", len(slots), maxFlatParams)
+	}
+
+	var results []string
+	if fn.result != nil {
+		f, err := g.doc.flat(fn.result)
+		if err != nil {
+			return err
+		}
+		results = f
+	}
+	retptr := len(results) > 1
+	if len(results) == 1 {
+		if _, isPrim := primGo[fn.result.name]; !isPrim {
+			if def := g.doc.byName[fn.result.name]; def == nil || def.kind != "enum" {
+				retptr = true
+			}
+		}
+	}
+
+	// Raw import taking core wasm values
+	var rawParams []string
+	for i, s := range slots {
+		rawParams = append(rawParams, fmt.Sprintf("f%d %s", i, coreGo[s]))
+	}
+	if retptr {
+		rawParams = append(rawParams, "ret uint32")
+	}
+	rawResult := ""
+	if len(results) == 1 && !retptr {
+		rawResult = " " + coreGo[results[0]]
+	}
+	g.printf("//go:wasmimport %s %s\nfunc %s(%s)%s\n\n", module, fn.name, raw, strings.Join(rawParams, ", "), rawResult)
+
+	// Typed wrapper lowering its arguments from memory
+	var params []string
+	for _, p := range fn.params {
+		params = append(params, fmt.Sprintf("%s %s", lowerCamel(p.name), g.goType(p.typ)))
+	}
+	result := ""
+	if fn.result != nil {
+		result = fmt.Sprintf(" (result %s)", g.goType(fn.result))
+	}
+	g.writeDoc(fmt.Sprintf("%s calls %s.", wrapper, fn.name), fn.doc)
+	g.printf("func %s(%s)%s {\n", wrapper, strings.Join(params, ", "), result)
+
+	var args []string
+	for i, s := range slots {
+		g.printf("var f%d %s\n", i, coreGo[s])
+		args = append(args, fmt.Sprintf("f%d", i))
+	}
+	next := 0
+	for _, p := range fn.params {
+		f, _ := g.doc.flat(p.typ)
+		if err := g.lower(p.typ, "unsafe.Pointer(&"+lowerCamel(p.name)+")", 0, slots[next:next+len(f)], args[next:next+len(f)]); err != nil {
+			return err
+		}
+		next += len(f)
+	}
+
+	call := fmt.Sprintf("%s(%s", raw, strings.Join(args, ", "))
+	if retptr {
+		if len(args) > 0 {
+			call += ", "
+		}
+		call += "uint32(uintptr(unsafe.Pointer(&result)))"
+	}
+	call += ")"
+
+	switch {
+	case fn.result == nil:
+		g.printf("%s\n}\n\n", call)
+	case retptr:
+		g.printf("%s\nreturn\n}\n\n", call)
+	case fn.result.name == "bool":
+		g.printf("return %s != 0\n}\n\n", call)
+	default:
+		g.printf("return %s(%s)\n}\n\n", g.goType(fn.result), call)
+	}
+	return nil
+}
+
+// load returns an expression reading a primitive from base+off as its core
+// wasm type
+func load(prim, base string, off int) string {
+	addr := fmt.Sprintf("unsafe.Add(%s, %d)", base, off)
+	switch prim {
+	case "u32", "char":
+		return fmt.Sprintf("*(*uint32)(%s)", addr)
+	case "bool", "u8", "u16":
+		memType := map[string]string{"bool": "uint8", "u8": "uint8", "u16": "uint16"}[prim]
+		return fmt.Sprintf("uint32(*(*%s)(%s))", memType, addr)
+	case "s8", "s16", "s32":
+		return fmt.Sprintf("uint32(int32(*(*%s)(%s)))", primGo[prim], addr)
+	case "u64", "s64":
+		return fmt.Sprintf("*(*uint64)(%s)", addr)
+	default:
+		return fmt.Sprintf("*(*%s)(%s)", primGo[prim], addr)
+	}
+}
+
+// coerce converts a core value to the (possibly wider) joined slot type
+func (g *generator) coerce(expr, from, to string) string {
+	switch {
+	case from == to:
+		return expr
+	case from == "i32" && to == "i64":
+		return "uint64(" + expr + ")"
+	case from == "f32" && to == "i32":
+		g.usesMath = true
+		return "math.Float32bits(" + expr + ")"
+	case from == "f32" && to == "i64":
+		g.usesMath = true
+		return "uint64(math.Float32bits(" + expr + "))"
+	default:
+		g.usesMath = true
+		return "math.Float64bits(" + expr + ")"
+	}
+}
+
+// lower writes statements assigning the flattened value at base+off to the
+// slot variables
+func (g *generator) lower(t *witType, base string, off int, slots, vars []string) error {
+	if f, ok := primFlat[t.name]; ok {
+		g.printf("%s = %s\n", vars[0], g.coerce(load(t.name, base, off), f, slots[0]))
+		return nil
+	}
+	switch t.name {
+	case "string", "list":
+		g.printf("%s = %s\n", vars[0], g.coerce(load("u32", base, off), "i32", slots[0]))
+		g.printf("%s = %s\n", vars[1], g.coerce(load("u32", base, off+4), "i32", slots[1]))
+		return nil
+	}
+
+	if cases, ok := g.doc.cases(t); ok {
+		payloadOff, _, _, err := g.doc.payloadLayout(cases)
+		if err != nil {
+			return err
+		}
+		tagLoad := load(map[int]string{1: "u8", 2: "u16", 4: "u32"}[discSize(len(cases))], base, off)
+		g.printf("%s = %s\n", vars[0], tagLoad)
+		g.printf("switch %s {\n", vars[0])
+		for i, c := range cases {
+			if c == nil {
+				continue
+			}
+			f, err := g.doc.flat(c)
+			if err != nil {
+				return err
+			}
+			g.printf("case %d:\n", i)
+			if err := g.lower(c, base, off+payloadOff, slots[1:1+len(f)], vars[1:1+len(f)]); err != nil {
+				return err
+			}
+		}
+		g.printf("}\n")
+		return nil
+	}
+
+	def, err := g.doc.resolve(t)
+	if err != nil {
+		return err
+	}
+	if def.kind == "enum" {
+		prim := map[int]string{1: "u8", 2: "u16", 4: "u32"}[discSize(len(def.cases))]
+		g.printf("%s = %s\n", vars[0], load(prim, base, off))
+		return nil
+	}
+	offsets, _, err := g.doc.fieldOffsets(def.fields)
+	if err != nil {
+		return err
+	}
+	next := 0
+	for i, f := range def.fields {
+		ft, err := g.doc.flat(f.typ)
+		if err != nil {
+			return err
+		}
+		if err := g.lower(f.typ, base, off+offsets[i], slots[next:next+len(ft)], vars[next:next+len(ft)]); err != nil {
+			return err
+		}
+		next += len(ft)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Command witgen generates the Go guest's component bindings from the WIT
+// definitions in guest/wit.
+//
+// It supports the subset of WIT the wadup interfaces use: enums, records,
+// variants, options, results, lists and strings. Each interface function
+// becomes a //go:wasmimport declaration taking core wasm values and a typed
+// wrapper that lowers its arguments following the canonical ABI for wasm32.
+//
+// Usage:
+//
+//	go run ./internal/witgen -wit ../wit/wadup.wit -o ffi_bindings.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	witPath := flag.String("wit", "", "WIT file to generate bindings for")
+	outPath := flag.String("o", "", "output Go file")
+	pkg := flag.String("package", "wadup", "package name of the generated file")
+	buildTag := flag.String("tags", "wasip2", "build constraint of the generated file")
+	flag.Parse()
+
+	if *witPath == "" || *outPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*witPath, *outPath, *pkg, *buildTag); err != nil {
+		fmt.Fprintf(os.Stderr, "witgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(witPath, outPath, pkg, buildTag string) error {
+	src, err := os.ReadFile(witPath)
+	if err != nil {
+		return fmt.Errorf("failed to read '%s': %w", witPath, err)
+	}
+	doc, err := parse(string(src))
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", witPath, err)
+	}
+	out, err := generate(doc, pkg, buildTag, filepath.Base(witPath))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, out, 0o644); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", outPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// witToken is a WIT token; doc holds the /// comment lines preceding it
+type witToken struct {
+	text string
+	doc  []string
+	line int
+}
+
+// lex splits WIT source into tokens. Identifiers may contain hyphens and a
+// leading % escape, which is dropped.
+func lex(src string) ([]witToken, error) {
+	var toks []witToken
+	var doc []string
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			if strings.HasPrefix(src[i:], "///") {
+				doc = append(doc, strings.TrimSpace(src[i+3:i+end]))
+			}
+			i += end
+		case strings.HasPrefix(src[i:], "->"):
+			toks = append(toks, witToken{text: "->", line: line})
+			i += 2
+		case strings.ContainsRune("{}()<>,:;.@/=*", rune(c)):
+			toks = append(toks, witToken{text: string(c), line: line})
+			i++
+		case c == '%' || c == '_' || c == '-' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '-' || src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, witToken{text: strings.TrimPrefix(src[i:j], "%"), doc: doc, line: line})
+			doc = nil
+			i = j
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+	}
+	return toks, nil
+}
+
+// witType is a type expression
+type witType struct {
+	// name is a primitive, "string", "list", "option", "result" or the name
+	// of a type definition
+	name string
+	elem *witType
+	// ok and err are the result payloads, nil when absent
+	ok, err *witType
+}
+
+// typeDef is an enum, record or variant definition
+type typeDef struct {
+	kind   string
+	name   string
+	doc    []string
+	fields []field
+	cases  []variantCase
+}
+
+type field struct {
+	name string
+	typ  *witType
+}
+
+type variantCase struct {
+	name string
+	typ  *witType
+}
+
+// function is a function of an interface
+type function struct {
+	name   string
+	doc    []string
+	params []field
+	result *witType
+}
+
+type iface struct {
+	name  string
+	funcs []function
+}
+
+// document is a parsed WIT package
+type document struct {
+	pkg    string
+	types  []*typeDef
+	byName map[string]*typeDef
+	ifaces []iface
+}
+
+type parser struct {
+	toks []witToken
+	pos  int
+}
+
+func (p *parser) peek() witToken {
+	if p.pos >= len(p.toks) {
+		return witToken{}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() witToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(text string) error {
+	if t := p.next(); t.text != text {
+		return fmt.Errorf("line %d: expected '%s', found '%s'", t.line, text, t.text)
+	}
+	return nil
+}
+
+// skipPast consumes tokens up to and including text
+func (p *parser) skipPast(text string) string {
+	var b strings.Builder
+	for p.pos < len(p.toks) {
+		t := p.next()
+		if t.text == text {
+			break
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// parse parses the subset of WIT used by the wadup interfaces
+func parse(src string) (*document, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	doc := &document{byName: make(map[string]*typeDef)}
+
+	for p.pos < len(p.toks) {
+		t := p.next()
+		switch t.text {
+		case "package":
+			doc.pkg = p.skipPast(";")
+		case "world":
+			// Worlds only reference interfaces, which are generated on their own
+			p.skipPast("{")
+			for depth := 1; depth > 0 && p.pos < len(p.toks); {
+				switch p.next().text {
+				case "{":
+					depth++
+				case "}":
+					depth--
+				}
+			}
+		case "interface":
+			ifc, err := p.parseInterface(doc)
+			if err != nil {
+				return nil, err
+			}
+			doc.ifaces = append(doc.ifaces, ifc)
+		default:
+			return nil, fmt.Errorf("line %d: unexpected '%s'", t.line, t.text)
+		}
+	}
+	if doc.pkg == "" {
+		return nil, fmt.Errorf("missing package declaration")
+	}
+	return doc, nil
+}
+
+func (p *parser) parseInterface(doc *document) (iface, error) {
+	ifc := iface{name: p.next().text}
+	if err := p.expect("{"); err != nil {
+		return ifc, err
+	}
+	for {
+		t := p.next()
+		switch t.text {
+		case "}":
+			return ifc, nil
+		case "use":
+			p.skipPast(";")
+		case "enum", "record", "variant":
+			def, err := p.parseTypeDef(t)
+			if err != nil {
+				return ifc, err
+			}
+			if _, dup := doc.byName[def.name]; dup {
+				return ifc, fmt.Errorf("line %d: type '%s' defined twice", t.line, def.name)
+			}
+			doc.types = append(doc.types, def)
+			doc.byName[def.name] = def
+		case "":
+			return ifc, fmt.Errorf("unterminated interface '%s'", ifc.name)
+		default:
+			fn, err := p.parseFunc(t)
+			if err != nil {
+				return ifc, err
+			}
+			ifc.funcs = append(ifc.funcs, fn)
+		}
+	}
+}
+
+func (p *parser) parseTypeDef(kw witToken) (*typeDef, error) {
+	def := &typeDef{kind: kw.text, doc: kw.doc}
+	def.name = p.next().text
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for p.peek().text != "}" {
+		name := p.next().text
+		switch kw.text {
+		case "enum":
+			def.cases = append(def.cases, variantCase{name: name})
+		case "record":
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			typ, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			def.fields = append(def.fields, field{name: name, typ: typ})
+		case "variant":
+			c := variantCase{name: name}
+			if p.peek().text == "(" {
+				p.next()
+				typ, err := p.parseType()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				c.typ = typ
+			}
+			def.cases = append(def.cases, c)
+		}
+		if p.peek().text == "," {
+			p.next()
+		}
+	}
+	p.next()
+	return def, nil
+}
+
+func (p *parser) parseFunc(name witToken) (function, error) {
+	fn := function{name: name.text, doc: name.doc}
+	for _, text := range []string{":", "func", "("} {
+		if err := p.expect(text); err != nil {
+			return fn, err
+		}
+	}
+	for p.peek().text != ")" {
+		param := field{name: p.next().text}
+		if err := p.expect(":"); err != nil {
+			return fn, err
+		}
+		typ, err := p.parseType()
+		if err != nil {
+			return fn, err
+		}
+		param.typ = typ
+		fn.params = append(fn.params, param)
+		if p.peek().text == "," {
+			p.next()
+		}
+	}
+	p.next()
+	if p.peek().text == "->" {
+		p.next()
+		typ, err := p.parseType()
+		if err != nil {
+			return fn, err
+		}
+		fn.result = typ
+	}
+	return fn, p.expect(";")
+}
+
+func (p *parser) parseType() (*witType, error) {
+	t := p.next()
+	typ := &witType{name: t.text}
+	switch t.text {
+	case "list", "option":
+		if err := p.expect("<"); err != nil {
+			return nil, err
+		}
+		elem, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		typ.elem = elem
+		return typ, p.expect(">")
+	case "result":
+		if p.peek().text != "<" {
+			return typ, nil
+		}
+		p.next()
+		if p.peek().text == "_" {
+			p.next()
+		} else {
+			ok, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			typ.ok = ok
+		}
+		if p.peek().text == "," {
+			p.next()
+			e, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			typ.err = e
+		}
+		return typ, p.expect(">")
+	case "tuple", "borrow", "own", "flags", "resource", "":
+		return nil, fmt.Errorf("line %d: unsupported type '%s'", t.line, t.text)
+	}
+	return typ, nil
+}
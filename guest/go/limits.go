@@ -0,0 +1,74 @@
+package wadup
+
+import (
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	limitsMu             sync.Mutex
+	maxStringValueLength int
+	strictStringLength   bool
+)
+
+// SetMaxStringValueLength caps the length in bytes of String values inserted
+// into tables. Over-long values are truncated (at a UTF-8 boundary) and the
+// row is marked "truncated" with the affected column indices, preserving a
+// preview while protecting the host from pathological row sizes.
+//
+// The default, 0, means no limit.
+func SetMaxStringValueLength(n int) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	maxStringValueLength = n
+}
+
+// SetStrictStringLength makes InsertRow return an error instead of truncating
+// when a String value exceeds the limit set by SetMaxStringValueLength.
+func SetStrictStringLength(strict bool) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	strictStringLength = strict
+}
+
+// applyStringLimit enforces the string length limit on a row of the table
+// with the given columns. It returns the values to store (a copy when
+// anything was truncated) and the indices of the truncated columns.
+func applyStringLimit(table string, columns []Column, values []Value) ([]Value, []int, error) {
+	limitsMu.Lock()
+	limit, strict := maxStringValueLength, strictStringLength
+	limitsMu.Unlock()
+	if limit == 0 {
+		return values, nil, nil
+	}
+
+	var truncated []int
+	out := values
+	for i, v := range values {
+		s, ok := v.data.(string)
+		if !ok || len(s) <= limit {
+			continue
+		}
+		if strict {
+			return nil, nil, fmt.Errorf("table '%s': column '%s' value is %d bytes, exceeding the limit of %d", table, columns[i].Name, len(s), limit)
+		}
+		if truncated == nil {
+			out = append([]Value(nil), values...)
+		}
+		out[i] = NewString(truncateUTF8(s, limit))
+		truncated = append(truncated, i)
+	}
+	return out, truncated, nil
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a rune
+func truncateUTF8(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
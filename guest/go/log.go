@@ -0,0 +1,96 @@
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogPath is the file log messages are appended to, one JSON object per line,
+// when the host has no log_message import. The host reads it after each run
+// and attributes the messages to the module and content being processed.
+const LogPath = "/log/messages.jsonl"
+
+// LogLevel is the severity of a log message
+type LogLevel int
+
+const (
+	Debug LogLevel = iota
+	Info
+	Warn
+	Error
+)
+
+// String returns the level name used on the wire
+func (l LogLevel) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// logEntry represents one log message for serialization
+type logEntry struct {
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+var (
+	logMu    sync.Mutex
+	minLevel = Info
+)
+
+// SetLogLevel sets the minimum level written by Logf. The default is Info.
+func SetLogLevel(level LogLevel) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	minLevel = level
+}
+
+// Logf writes a log message at the given level.
+//
+// Messages go to the transport. With FileTransport they go to the host's
+// log_message import if it advertises FeatureLogging, and are otherwise
+// appended to /log/messages.jsonl rather than interleaved on stderr. If the
+// transport fails, the message goes to stderr so it is not lost.
+func Logf(level LogLevel, format string, args ...interface{}) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if level < minLevel {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+	if err := activeTransport().Log(level, message); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", level, message)
+	}
+}
+
+// appendLogEntry appends one JSON line to the log file. Caller must hold logMu.
+func appendLogEntry(entry logEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll("/log", 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
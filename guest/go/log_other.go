@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostLogMessage reports that the host cannot take log messages, since there
+// is no log_message import outside preview1 builds
+func hostLogMessage(level LogLevel, message string) bool {
+	return false
+}
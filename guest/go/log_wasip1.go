@@ -0,0 +1,21 @@
+//go:build wasip1
+
+package wadup
+
+import "unsafe"
+
+// logMessage hands a log message to the host
+//
+//go:wasmimport wadup log_message
+func logMessage(level int32, msg unsafe.Pointer, msgLen uint32)
+
+// hostLogMessage passes a message to the host's log_message import. ok is
+// false if the host does not support it, and the message must be written to
+// LogPath instead.
+func hostLogMessage(level LogLevel, message string) (ok bool) {
+	if !HostSupports(FeatureLogging) {
+		return false
+	}
+	logMessage(int32(level), unsafe.Pointer(unsafe.StringData(message)), uint32(len(message)))
+	return true
+}
@@ -0,0 +1,76 @@
+package wadup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Context is passed to the run function of Main. It carries what the host
+// knows about the content being processed.
+type Context struct {
+	context.Context
+	// Info describes the content; fields the host does not provide are empty
+	Info ContentProperties
+}
+
+// PanicErrorCode is the error class recorded when run panics
+const PanicErrorCode = "panic"
+
+// Main runs one processing pass and always writes the accumulated metadata
+// afterwards, so tables and rows are not lost if run forgets to Flush or
+// returns early with an error.
+//
+// Before run, Main clears the per-content tracking of emitted sub-content,
+// skipped emissions, slice ranges and unique keys, and calls the OnContent
+// hooks, since the instance may already have processed other content.
+//
+// If run returns ErrCancelled (possibly wrapped), the content is marked as
+// partially scanned and the results gathered so far are kept.
+//
+// A panic in run is recovered and recorded with ReportError under
+// PanicErrorCode, so one bad input does not take down the module instance.
+// Main returns the status code expected from the exported process function:
+// 0 on success, 1 if run failed or panicked or the final flush failed (the
+// error is printed to stderr). Typical use:
+//
+//	//go:wasmexport process
+//	func process() int32 {
+//		return wadup.Main(run)
+//	}
+func Main(run func(ctx Context) error) int32 {
+	ctx := Context{Context: context.Background()}
+	if info, err := ContentInfo(); err == nil {
+		ctx.Info = info
+	}
+
+	err := beginContent()
+	if err == nil {
+		err = runRecovered(run, ctx)
+	}
+	if errors.Is(err, ErrCancelled) {
+		err = SetScanStatus(ScanPartial, "cancelled by host")
+	}
+	if flushErr := Finish(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runRecovered calls run, converting a panic into a reported error
+func runRecovered(run func(ctx Context) error, ctx Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			if reportErr := ReportError(PanicErrorCode, err); reportErr != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to report panic: %v\n", reportErr)
+			}
+		}
+	}()
+	return run(ctx)
+}
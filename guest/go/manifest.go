@@ -0,0 +1,204 @@
+package wadup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ManifestSection is the custom WASM section holding a module's manifest
+const ManifestSection = "wadup.manifest"
+
+// Manifest declares which content a module handles, so the host only invokes
+// the module for matching content.
+//
+// Content is offered to the module if it is within the size bounds, matches
+// at least one trigger (Magic, Extension or MIMEType) when any are given, and
+// its parent's filename matches a ParentFilename glob when any are given.
+// Globs use '*' for any run of characters and '?' for any single character.
+//
+// Go cannot emit custom sections from source, so the manifest is embedded in
+// the compiled module as a build step (see Embed and EmbedFile):
+//
+//	wadup.NewManifest().
+//		Magic(0, []byte("SQLite format 3\x00")).
+//		Extension("db", "sqlite").
+//		EmbedFile("sqlite-parser.wasm")
+type Manifest struct {
+	doc manifestDoc
+}
+
+// manifestDoc is the JSON document stored in the manifest section
+type manifestDoc struct {
+	Magic           []manifestMagic `json:"magic,omitempty"`
+	Extensions      []string        `json:"extensions,omitempty"`
+	MIMETypes       []string        `json:"mime_types,omitempty"`
+	ParentFilenames []string        `json:"parent_filenames,omitempty"`
+	MinSize         *int64          `json:"min_size,omitempty"`
+	MaxSize         *int64          `json:"max_size,omitempty"`
+	Version         string          `json:"version,omitempty"`
+	After           []string        `json:"after,omitempty"`
+	RequiresTables  []string        `json:"requires_tables,omitempty"`
+	Tables          []string        `json:"tables,omitempty"`
+}
+
+type manifestMagic struct {
+	Offset int    `json:"offset"`
+	Hex    string `json:"hex"`
+}
+
+// NewManifest creates an empty manifest, which matches all content
+func NewManifest() *Manifest {
+	return &Manifest{}
+}
+
+// Magic matches content with the given bytes at offset
+func (m *Manifest) Magic(offset int, prefix []byte) *Manifest {
+	m.doc.Magic = append(m.doc.Magic, manifestMagic{Offset: offset, Hex: hex.EncodeToString(prefix)})
+	return m
+}
+
+// Extension matches content whose filename has one of the extensions,
+// compared case-insensitively and given without the dot
+func (m *Manifest) Extension(exts ...string) *Manifest {
+	m.doc.Extensions = append(m.doc.Extensions, exts...)
+	return m
+}
+
+// MIMEType matches content whose detected type (see ContentType) matches one
+// of the globs, such as "image/*"
+func (m *Manifest) MIMEType(globs ...string) *Manifest {
+	m.doc.MIMETypes = append(m.doc.MIMETypes, globs...)
+	return m
+}
+
+// ParentFilename restricts the module to sub-content whose parent's filename
+// matches one of the globs
+func (m *Manifest) ParentFilename(globs ...string) *Manifest {
+	m.doc.ParentFilenames = append(m.doc.ParentFilenames, globs...)
+	return m
+}
+
+// MinSize skips content smaller than size bytes
+func (m *Manifest) MinSize(size int64) *Manifest {
+	m.doc.MinSize = &size
+	return m
+}
+
+// MaxSize skips content larger than size bytes
+func (m *Manifest) MaxSize(size int64) *Manifest {
+	m.doc.MaxSize = &size
+	return m
+}
+
+// Version sets the module version the host records in row provenance.
+// Without it the host uses a digest of the module file.
+func (m *Manifest) Version(version string) *Manifest {
+	m.doc.Version = version
+	return m
+}
+
+// After makes the host run the module on each content item only once the
+// named modules have finished, so QueryMetadata sees their rows
+func (m *Manifest) After(modules ...string) *Manifest {
+	m.doc.After = append(m.doc.After, modules...)
+	return m
+}
+
+// RequiresTable makes the host run the module after every module that
+// declares one of the tables with Tables
+func (m *Manifest) RequiresTable(tables ...string) *Manifest {
+	m.doc.RequiresTables = append(m.doc.RequiresTables, tables...)
+	return m
+}
+
+// Tables declares the tables the module writes, for modules that require
+// them with RequiresTable
+func (m *Manifest) Tables(tables ...string) *Manifest {
+	m.doc.Tables = append(m.doc.Tables, tables...)
+	return m
+}
+
+// Encode returns the manifest as stored in the custom section
+func (m *Manifest) Encode() ([]byte, error) {
+	if m.doc.MinSize != nil && m.doc.MaxSize != nil && *m.doc.MinSize > *m.doc.MaxSize {
+		return nil, fmt.Errorf("manifest minimum size %d exceeds maximum size %d", *m.doc.MinSize, *m.doc.MaxSize)
+	}
+	for _, magic := range m.doc.Magic {
+		if magic.Offset < 0 || magic.Hex == "" {
+			return nil, fmt.Errorf("manifest magic at offset %d is invalid", magic.Offset)
+		}
+	}
+	data, err := json.Marshal(m.doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize manifest: %w", err)
+	}
+	return data, nil
+}
+
+// Embed returns a copy of a WASM binary carrying the manifest in its
+// ManifestSection, replacing any manifest it already has
+func (m *Manifest) Embed(wasm []byte) ([]byte, error) {
+	payload, err := m.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if len(wasm) < 8 || !bytes.Equal(wasm[:4], []byte("\x00asm")) {
+		return nil, fmt.Errorf("not a WASM binary")
+	}
+
+	out := append([]byte(nil), wasm[:8]...)
+	for pos := 8; pos < len(wasm); {
+		start := pos
+		pos++
+		size, n := binary.Uvarint(wasm[pos:])
+		if n <= 0 || size > uint64(len(wasm)-pos-n) {
+			return nil, fmt.Errorf("truncated WASM section at offset %d", start)
+		}
+		pos += n
+		end := pos + int(size)
+
+		if wasm[start] == 0 && customSectionName(wasm[pos:end]) == ManifestSection {
+			pos = end
+			continue
+		}
+		out = append(out, wasm[start:end]...)
+		pos = end
+	}
+
+	var section []byte
+	section = binary.AppendUvarint(section, uint64(len(ManifestSection)))
+	section = append(section, ManifestSection...)
+	section = append(section, payload...)
+	out = append(out, 0)
+	out = binary.AppendUvarint(out, uint64(len(section)))
+	return append(out, section...), nil
+}
+
+// EmbedFile embeds the manifest in the WASM module at path, in place
+func (m *Manifest) EmbedFile(path string) error {
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read module '%s': %w", path, err)
+	}
+	out, err := m.Embed(wasm)
+	if err != nil {
+		return fmt.Errorf("failed to embed manifest in '%s': %w", path, err)
+	}
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		return fmt.Errorf("failed to write module '%s': %w", path, err)
+	}
+	return nil
+}
+
+// customSectionName returns the name of a custom section, empty if malformed
+func customSectionName(body []byte) string {
+	size, n := binary.Uvarint(body)
+	if n <= 0 || size > uint64(len(body)-n) {
+		return ""
+	}
+	return string(body[n : n+int(size)])
+}
@@ -0,0 +1,121 @@
+package wadup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Chunk sizes chosen by ChunkedReader when the caller passes 0
+const (
+	minChunkSize     = 4 * 1024
+	defaultChunkSize = 1024 * 1024
+)
+
+// AvailableMemory returns how many bytes the module's memory may still grow
+// by under the host's memory limit, or -1 if the host enforces no limit or
+// cannot say. Parsers that would otherwise load a large structure at once
+// can check it first and fall back to streaming (see ChunkedReader) instead
+// of failing an allocation, which aborts the module.
+//
+// The figure covers growth of linear memory only; memory the Go runtime has
+// already reserved but not used comes on top.
+func AvailableMemory() int64 {
+	if avail, ok := hostMemoryAvailable(); ok {
+		return avail
+	}
+	return -1
+}
+
+// ChunkReader reads the content in fixed-size chunks through one reused
+// buffer, so memory use stays bounded however large the content is.
+//
+//	chunks, err := wadup.ChunkedReader(0)
+//	if err != nil {
+//		return err
+//	}
+//	defer chunks.Close()
+//	for chunks.Next() {
+//		process(chunks.Offset(), chunks.Chunk())
+//	}
+//	if err := chunks.Err(); err != nil {
+//		return err
+//	}
+type ChunkReader struct {
+	r      io.ReadSeekCloser
+	buf    []byte
+	chunk  []byte
+	offset int64
+	next   int64
+	err    error
+}
+
+// ChunkedReader opens the content for reading in chunks of chunkSize bytes.
+// With chunkSize 0 the size is picked from AvailableMemory: 1 MiB, reduced
+// to a quarter of the available memory when that is tighter, but never
+// below 4 KiB.
+func ChunkedReader(chunkSize int) (*ChunkReader, error) {
+	if chunkSize < 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+		if avail := AvailableMemory(); avail >= 0 && avail/4 < int64(chunkSize) {
+			chunkSize = max(int(avail/4), minChunkSize)
+		}
+	}
+
+	r, err := OpenContent()
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkReader{r: r, buf: make([]byte, chunkSize)}, nil
+}
+
+// Next reads the next chunk, returning false at the end of the content or on
+// error. Every chunk but the last is full.
+func (c *ChunkReader) Next() bool {
+	if c.err != nil {
+		return false
+	}
+	n, err := io.ReadFull(c.r, c.buf)
+	if n > 0 {
+		c.chunk = c.buf[:n]
+		c.offset = c.next
+		c.next += int64(n)
+	}
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		c.err = io.EOF
+		return n > 0
+	default:
+		c.err = fmt.Errorf("failed to read content at offset %d: %w", c.next, err)
+		return false
+	}
+}
+
+// Chunk returns the current chunk. It is only valid until the next call to
+// Next.
+func (c *ChunkReader) Chunk() []byte {
+	return c.chunk
+}
+
+// Offset returns the content offset of the current chunk
+func (c *ChunkReader) Offset() int64 {
+	return c.offset
+}
+
+// Err returns the error that stopped iteration, nil at the end of the content
+func (c *ChunkReader) Err() error {
+	if errors.Is(c.err, io.EOF) {
+		return nil
+	}
+	return c.err
+}
+
+// Close closes the content
+func (c *ChunkReader) Close() error {
+	return c.r.Close()
+}
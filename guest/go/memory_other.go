@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostMemoryAvailable reports that the host cannot report its memory limit,
+// since there is no memory_available import outside preview1 builds
+func hostMemoryAvailable() (int64, bool) {
+	return 0, false
+}
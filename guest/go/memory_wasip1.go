@@ -0,0 +1,18 @@
+//go:build wasip1
+
+package wadup
+
+// memoryAvailable returns the bytes linear memory may still grow by under the
+// host's memory limit, or -1 if there is none
+//
+//go:wasmimport wadup memory_available
+func memoryAvailable() int64
+
+// hostMemoryAvailable asks the host for the memory left under its limit. ok
+// is false if the host cannot report it.
+func hostMemoryAvailable() (avail int64, ok bool) {
+	if !HostSupports(FeatureMemoryLimit) {
+		return 0, false
+	}
+	return memoryAvailable(), true
+}
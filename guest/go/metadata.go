@@ -1,83 +1,460 @@
 package wadup
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"sync"
+	"time"
 )
 
 // tableDef represents a table definition for serialization
 type tableDef struct {
-	Name    string   `json:"name"`
-	Columns []Column `json:"columns"`
+	Name         string   `json:"name"`
+	Columns      []Column `json:"columns"`
+	NoProvenance bool     `json:"no_provenance,omitempty"`
+	Shared       bool     `json:"shared,omitempty"`
 }
 
 // rowDef represents a row for serialization
 type rowDef struct {
-	TableName string  `json:"table_name"`
-	Values    []Value `json:"values"`
+	TableName        string  `json:"table_name"`
+	Values           []Value `json:"values"`
+	Truncated        bool    `json:"truncated,omitempty"`
+	TruncatedColumns []int   `json:"truncated_columns,omitempty"`
 }
 
 // metadataFile represents the complete metadata file structure
 type metadataFile struct {
-	Tables []tableDef `json:"tables"`
-	Rows   []rowDef   `json:"rows"`
+	Tables     []tableDef     `json:"tables"`
+	Rows       []rowDef       `json:"rows"`
+	ScanStatus *scanStatus    `json:"scan_status,omitempty"`
+	Aggregates []aggregateDef `json:"aggregates,omitempty"`
+	Attributes []attributeDef `json:"attributes,omitempty"`
+	// Dictionaries decode the dictionary columns of Rows
+	Dictionaries []dictionaryDef `json:"dictionaries,omitempty"`
 }
 
+// DefaultBatchBytes is the estimated encoded size of buffered rows at which
+// metadata is written automatically, unless changed with SetBatchBytes
+const DefaultBatchBytes = 8 << 20
+
 var (
-	metadataMu      sync.Mutex
-	accumulatedTabs []tableDef
-	accumulatedRows []rowDef
-	fileCounter     int
+	metadataMu        sync.Mutex
+	accumulatedTabs   []tableDef
+	accumulatedRows   []rowDef
+	accumulatedBytes  int
+	accumulatedStatus *scanStatus
+	accumulatedAttrs  []attributeDef
+	fileCounter       int
+	tableRowCounts    = make(map[string]int)
+	// tableBufferedBytes estimates the size of each table's rows that are
+	// buffered or in an open file, and tableFlushes counts how often each
+	// table's rows were written out
+	tableBufferedBytes = make(map[string]int)
+	tableFlushes       = make(map[string]int)
+	// metadataWritten counts the bytes written for the current content
+	metadataWritten  int64
+	tableAggregates  = make(map[string][]*aggregator)
+	noProvenance     = make(map[string]bool)
+	sharedTables     = make(map[string]bool)
+	registeredTables []TableSchema
+	batchSize        int
+	batchBytes       = DefaultBatchBytes
+	flushInterval    time.Duration
+	lastFlush        time.Time
+	aggregatesDirty  bool
 )
 
-// addTable adds a table definition to the accumulated metadata
+// addTable adds a table definition to the accumulated metadata. Redefining a
+// table keeps its row count, so row indices stay those the host assigns.
 func addTable(name string, columns []Column) {
 	metadataMu.Lock()
 	defer metadataMu.Unlock()
-	accumulatedTabs = append(accumulatedTabs, tableDef{
-		Name:    name,
-		Columns: columns,
-	})
+	accumulatedTabs = append(accumulatedTabs, newTableDef(name, columns))
+	if _, ok := tableRowCounts[name]; !ok {
+		tableRowCounts[name] = 0
+	}
+	tableFlushes[name] = 0
+	delete(tableAggregates, name)
+	setDictionaryColumns(name, columns)
+	registerTable(name, columns)
+}
+
+// ensureTable makes sure a table definition is part of the pending metadata,
+// so rows inserted by helpers are always accompanied by their schema. Unlike
+// addTable it does not redefine a table already pending.
+func ensureTable(name string, columns []Column) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	for _, t := range accumulatedTabs {
+		if t.Name == name {
+			return
+		}
+	}
+	accumulatedTabs = append(accumulatedTabs, newTableDef(name, columns))
+	if _, ok := tableRowCounts[name]; !ok {
+		tableRowCounts[name] = 0
+		setDictionaryColumns(name, columns)
+		registerTable(name, columns)
+	}
+}
+
+// newTableDef builds the definition of a table with its per-table options.
+// The caller holds metadataMu.
+func newTableDef(name string, columns []Column) tableDef {
+	return tableDef{
+		Name:         name,
+		Columns:      columns,
+		NoProvenance: noProvenance[name],
+		Shared:       sharedTables[name],
+	}
+}
+
+// markShared keeps the host from prefixing the table's name with the module
+// name (see SharedTable)
+func markShared(table string) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	sharedTables[table] = true
+}
+
+// DisableProvenance stops the host from adding its provenance fields
+// (content ID, parent ID, module name and version) to the rows of a table,
+// for tables whose rows carry their own identity or must match an external
+// schema exactly. Call it before defining the table.
+func DisableProvenance(table string) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	noProvenance[table] = true
+	for i := range accumulatedTabs {
+		if accumulatedTabs[i].Name == table {
+			accumulatedTabs[i].NoProvenance = true
+		}
+	}
+}
+
+// setTableAggregates registers the aggregators for a freshly defined table
+func setTableAggregates(name string, aggregates []*aggregator) {
+	if len(aggregates) == 0 {
+		return
+	}
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	tableAggregates[name] = aggregates
+	aggregatesDirty = true
+}
+
+// addRows adds rows to the accumulated metadata, writing a batch whenever
+// the batch size or batch bytes are reached. With JSON output the rows are
+// written to the output file straight away instead of being buffered (see
+// canStreamRows).
+//
+// Returns the number of rows taken. After an error the rows from that index
+// on were not added and stay with the caller. Rows taken are written by the
+// next flush, unless they were streamed to a file whose write failed, which
+// is abandoned.
+func addRows(rows []rowDef) (int, error) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	stream := canStreamRows()
+	for i, row := range rows {
+		var full bool
+		if stream {
+			if err := streamRow(row); err != nil {
+				return i, err
+			}
+			full = batchFull(activeStream.rows, activeStream.bytes)
+		} else {
+			accumulatedRows = append(accumulatedRows, row)
+			accumulatedBytes += estimateRowSize(row)
+			full = batchFull(len(accumulatedRows), accumulatedBytes)
+		}
+		tableRowCounts[row.TableName]++
+		tableBufferedBytes[row.TableName] += estimateRowSize(row)
+		for _, agg := range tableAggregates[row.TableName] {
+			agg.add(row.Values)
+			aggregatesDirty = true
+		}
+		if full {
+			if err := flushLocked(); err != nil {
+				return i + 1, err
+			}
+		}
+	}
+	return len(rows), nil
+}
+
+// SetBatchSize makes the guest write a metadata file automatically every n
+// buffered rows, bounding memory for modules producing many rows. Remaining
+// rows are written by Flush, Finish or Table.Close. Zero (the default)
+// disables batching by row count.
+func SetBatchSize(n int) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	batchSize = n
+}
+
+// SetBatchBytes makes the guest write a metadata file automatically once the
+// buffered rows would take about n bytes to encode (DefaultBatchBytes unless
+// changed), and splits larger flushes into files of about n bytes, so a
+// single Flush never builds one huge buffer. Zero disables batching by size.
+func SetBatchBytes(n int) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	batchBytes = n
+}
+
+// batchFull reports whether a batch of rows reached the batch size or batch
+// bytes. Caller must hold metadataMu.
+func batchFull(rows, bytes int) bool {
+	return (batchSize > 0 && rows >= batchSize) || (batchBytes > 0 && bytes >= batchBytes)
+}
+
+// estimateRowSize approximates the encoded size of a row in JSON, the larger
+// of the wire formats
+func estimateRowSize(row rowDef) int {
+	size := len(row.TableName) + 32
+	for _, v := range row.Values {
+		switch val := v.data.(type) {
+		case string:
+			size += len(val) + 16
+		case decimalText:
+			size += len(val) + 16
+		case bigIntText:
+			size += len(val) + 16
+		case []byte:
+			size += len(val)*4/3 + 16
+		case jsonDoc:
+			size += len(val) + 16
+		case stringArray:
+			for _, s := range val {
+				size += len(s) + 3
+			}
+			size += 24
+		case int64Array:
+			size += len(val)*21 + 24
+		case bytesArray:
+			for _, b := range val {
+				size += len(b)*4/3 + 3
+			}
+			size += 24
+		default:
+			size += 32
+		}
+	}
+	return size
+}
+
+// chunkRows splits rows into batches within the batch size and batch bytes,
+// so each is written to its own file. Caller must hold metadataMu.
+func chunkRows(rows []rowDef) [][]rowDef {
+	var chunks [][]rowDef
+	start, bytes := 0, 0
+	for i, row := range rows {
+		bytes += estimateRowSize(row)
+		if batchFull(i-start+1, bytes) {
+			chunks = append(chunks, rows[start:i+1])
+			start, bytes = i+1, 0
+		}
+	}
+	if start < len(rows) || len(chunks) == 0 {
+		chunks = append(chunks, rows[start:])
+	}
+	return chunks
+}
+
+// resetTableState clears the per-content state of the tables for a new
+// content item. Rows are numbered from zero again, as the host does when
+// linking rows to sub-content, and aggregates start from empty totals.
+// Tables stay defined; with dropPending, definitions a failed flush of the
+// previous content item left pending are dropped too.
+func resetTableState(dropPending bool) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	for name := range tableRowCounts {
+		tableRowCounts[name] = 0
+	}
+	for _, aggregates := range tableAggregates {
+		for _, agg := range aggregates {
+			agg.reset()
+		}
+	}
+	aggregatesDirty = false
+	if dropPending {
+		accumulatedTabs = nil
+	}
 }
 
-// addRow adds a row to the accumulated metadata
-func addRow(tableName string, values []Value) {
+// tableRowCount returns the number of rows inserted into a table for the
+// current content item, and whether the table is defined at all
+func tableRowCount(tableName string) (int, bool) {
+	// Rows a failed write didn't take stay buffered for the next flush, and
+	// the count only covers rows taken
+	_ = drainTables()
 	metadataMu.Lock()
 	defer metadataMu.Unlock()
-	accumulatedRows = append(accumulatedRows, rowDef{
-		TableName: tableName,
-		Values:    values,
-	})
+	n, ok := tableRowCounts[tableName]
+	return n, ok
 }
 
 // Flush writes all accumulated metadata to a file.
 //
-// Writes to /metadata/output_N.json where N is an incrementing counter.
-// The file is closed after writing, which triggers WADUP to read and process it.
+// Writes to /metadata/output_N.json where N is an incrementing counter
+// (output_N.msgpack if the MessagePack wire format was negotiated). JSON rows
+// are written to the file as they are inserted, and Flush completes it with
+// the table definitions and other metadata. With FormatArrowIPC, table rows
+// go to output_N.arrow files instead. Rows beyond the batch size or batch
+// bytes (see SetBatchSize and SetBatchBytes) are split over several files,
+// each encoded separately.
+// Each file is closed after writing, which triggers WADUP to read and process it.
+// Flush only writes what was buffered since the last write, so calling it
+// repeatedly is safe. Rows inserted by other goroutines are included once
+// their InsertRow call has returned.
+// If a flush interval is set (see SetFlushInterval), calls within the interval
+// are coalesced and the data stays buffered until a later Flush or Finish.
 //
 // Returns nil if successful or if there's nothing to flush.
 func Flush() error {
+	if err := drainTables(); err != nil {
+		return err
+	}
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	if flushInterval > 0 && !lastFlush.IsZero() && time.Since(lastFlush) < flushInterval {
+		return nil
+	}
+	return flushLocked()
+}
+
+// Finish writes all accumulated metadata immediately, ignoring any flush
+// interval. Call it at the end of every run so no buffered data is lost.
+func Finish() error {
+	if err := drainTables(); err != nil {
+		return err
+	}
 	metadataMu.Lock()
 	defer metadataMu.Unlock()
+	return flushLocked()
+}
+
+// SetFlushInterval coalesces Flush calls so that metadata is written at most
+// once per interval, reducing the number of output files for modules that
+// flush per record. Zero (the default) writes on every Flush. With a non-zero
+// interval, Finish must be called at the end of each run to write the
+// remainder.
+func SetFlushInterval(d time.Duration) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	if d < 0 {
+		d = 0
+	}
+	flushInterval = d
+}
+
+// flushLocked writes the accumulated metadata. Caller must hold metadataMu.
+func flushLocked() error {
+	if activeStream != nil {
+		if err := closeStream(); err != nil {
+			return err
+		}
+		lastFlush = time.Now()
+	}
 
 	// Nothing to flush
-	if len(accumulatedTabs) == 0 && len(accumulatedRows) == 0 {
+	if len(accumulatedTabs) == 0 && len(accumulatedRows) == 0 && len(accumulatedAttrs) == 0 &&
+		accumulatedStatus == nil && !aggregatesDirty {
 		return nil
 	}
 
-	filename := fmt.Sprintf("/metadata/output_%d.json", fileCounter)
-	fileCounter++
+	if t := currentTransport(); t != nil {
+		if err := flushToTransport(t); err != nil {
+			return err
+		}
+		lastFlush = time.Now()
+		clearAccumulated()
+		return nil
+	}
 
-	metadata := metadataFile{
-		Tables: accumulatedTabs,
-		Rows:   accumulatedRows,
+	rows := accumulatedRows
+	var batches []*arrowBatch
+	if negotiatedOutputFormat() == FormatArrowIPC {
+		batches, rows = splitArrowRows(rows)
 	}
 
-	jsonData, err := json.Marshal(metadata)
-	if err != nil {
-		return fmt.Errorf("failed to serialize metadata: %w", err)
+	// Table definitions go with the first chunk, before any other chunk or
+	// Arrow batch holding their rows
+	encodeDictionaries := dictionaryEncoding()
+	for i, chunk := range chunkRows(rows) {
+		var dicts fileDictionaries
+		if encodeDictionaries {
+			encoded := make([]rowDef, len(chunk))
+			for j, row := range chunk {
+				encoded[j] = dicts.encode(row)
+			}
+			chunk = encoded
+		}
+		metadata := metadataFile{
+			Tables: []tableDef{},
+			Rows:   chunk,
+		}
+		if i == 0 {
+			if accumulatedTabs != nil {
+				metadata.Tables = accumulatedTabs
+			}
+			metadata.ScanStatus = accumulatedStatus
+			metadata.Attributes = accumulatedAttrs
+			if aggregatesDirty {
+				metadata.Aggregates = snapshotAggregates()
+			}
+		}
+		if metadata.Rows == nil {
+			metadata.Rows = []rowDef{}
+		}
+		metadata.Dictionaries = dicts.defs
+
+		if len(metadata.Tables) == 0 && len(metadata.Rows) == 0 && len(metadata.Attributes) == 0 &&
+			metadata.ScanStatus == nil && len(metadata.Aggregates) == 0 {
+			continue
+		}
+		payload, ext, err := encodeMetadata(metadata)
+		if err != nil {
+			return err
+		}
+		if err := writeMetadataPayload(payload, ext); err != nil {
+			return err
+		}
+	}
+
+	for _, batch := range batches {
+		payload, err := encodeArrowBatch(batch)
+		if err != nil {
+			return err
+		}
+		if err := writeMetadataPayload(payload, "arrow"); err != nil {
+			return err
+		}
+	}
+
+	lastFlush = time.Now()
+	clearAccumulated()
+
+	return nil
+}
+
+// writeMetadataPayload writes a serialized payload to the next metadata
+// output file. Caller must hold metadataMu.
+func writeMetadataPayload(payload []byte, ext string) error {
+	filename, exists := outputFilename(payload, ext)
+	if exists {
+		// Identical payload already written and not yet consumed
+		return nil
 	}
 
 	file, err := os.Create(filename)
@@ -86,13 +463,31 @@ func Flush() error {
 	}
 	defer file.Close()
 
-	if _, err := file.Write(jsonData); err != nil {
+	if _, err := file.Write(payload); err != nil {
 		return fmt.Errorf("failed to write metadata file '%s': %w", filename, err)
 	}
+	metadataWritten += int64(len(payload))
+	return nil
+}
 
-	// Clear accumulated data
+// markTablesFlushed counts a write of every table with buffered rows. Caller
+// must hold metadataMu.
+func markTablesFlushed() {
+	for name, bytes := range tableBufferedBytes {
+		if bytes > 0 {
+			tableFlushes[name]++
+		}
+	}
+	clear(tableBufferedBytes)
+}
+
+// clearAccumulated discards flushed data. Caller must hold metadataMu.
+func clearAccumulated() {
+	markTablesFlushed()
 	accumulatedTabs = nil
 	accumulatedRows = nil
-
-	return nil
+	accumulatedBytes = 0
+	accumulatedStatus = nil
+	accumulatedAttrs = nil
+	aggregatesDirty = false
 }
@@ -0,0 +1,76 @@
+package wadup
+
+import (
+	"slices"
+	"testing"
+)
+
+// chunkSizes splits n rows with the current batch settings and returns the
+// size of each chunk
+func chunkSizes(n int) []int {
+	rows := make([]rowDef, n)
+	for i := range rows {
+		rows[i] = rowDef{TableName: "chunked", Values: []Value{NewInt64(int64(i))}}
+	}
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	var sizes []int
+	for _, chunk := range chunkRows(rows) {
+		sizes = append(sizes, len(chunk))
+	}
+	return sizes
+}
+
+func TestChunkRowsBatchSize(t *testing.T) {
+	t.Cleanup(func() { SetBatchSize(0); SetBatchBytes(DefaultBatchBytes) })
+	SetBatchBytes(0)
+	SetBatchSize(3)
+
+	tests := []struct {
+		rows int
+		want []int
+	}{
+		// An empty flush still writes one file, for the other metadata
+		{0, []int{0}},
+		{1, []int{1}},
+		{2, []int{2}},
+		// A full last chunk is not followed by an empty one
+		{3, []int{3}},
+		{4, []int{3, 1}},
+		{6, []int{3, 3}},
+		{7, []int{3, 3, 1}},
+	}
+	for _, tt := range tests {
+		if got := chunkSizes(tt.rows); !slices.Equal(got, tt.want) {
+			t.Errorf("%d rows: chunks %v, want %v", tt.rows, got, tt.want)
+		}
+	}
+}
+
+func TestChunkRowsBatchBytes(t *testing.T) {
+	t.Cleanup(func() { SetBatchSize(0); SetBatchBytes(DefaultBatchBytes) })
+	size := estimateRowSize(rowDef{TableName: "chunked", Values: []Value{NewInt64(0)}})
+
+	// A chunk ends with the row that reaches the byte limit
+	SetBatchBytes(2 * size)
+	if got, want := chunkSizes(5), []int{2, 2, 1}; !slices.Equal(got, want) {
+		t.Errorf("limit of 2 rows: chunks %v, want %v", got, want)
+	}
+	SetBatchBytes(2*size - 1)
+	if got, want := chunkSizes(4), []int{2, 2}; !slices.Equal(got, want) {
+		t.Errorf("limit just under 2 rows: chunks %v, want %v", got, want)
+	}
+
+	// Whichever limit is reached first ends the chunk
+	SetBatchSize(1)
+	if got, want := chunkSizes(3), []int{1, 1, 1}; !slices.Equal(got, want) {
+		t.Errorf("batch size 1: chunks %v, want %v", got, want)
+	}
+
+	// Without limits everything goes in one chunk
+	SetBatchSize(0)
+	SetBatchBytes(0)
+	if got, want := chunkSizes(100), []int{100}; !slices.Equal(got, want) {
+		t.Errorf("no limits: chunks %v, want %v", got, want)
+	}
+}
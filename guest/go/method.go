@@ -0,0 +1,23 @@
+package wadup
+
+// Conventional extraction methods. Methods are free-form text; these values
+// are encouraged so provenance trees read consistently across modules, and
+// may be followed by details (e.g. "carved: PK signature at 0x1000").
+const (
+	MethodArchiveMember = "archive member"
+	MethodCarved        = "carved"
+	MethodDecompressed  = "decompressed"
+	MethodDecrypted     = "decrypted"
+	MethodDecoded       = "decoded"
+	MethodReassembled   = "reassembled"
+)
+
+// EmitBytesWithMethod emits sub-content bytes with a note describing how they
+// were obtained, recorded in the sub-content metadata so the host can show it
+// in the provenance tree.
+func EmitBytesWithMethod(data []byte, filename, method string) (SubContentRef, error) {
+	return emitBytes(data, subContentMetadata{
+		Filename: filename,
+		Method:   method,
+	})
+}
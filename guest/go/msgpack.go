@@ -0,0 +1,389 @@
+package wadup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/netip"
+	"time"
+)
+
+// msgpackWriter appends MessagePack-encoded data to a buffer
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) writeNil() {
+	w.buf = append(w.buf, 0xc0)
+}
+
+func (w *msgpackWriter) writeBool(v bool) {
+	if v {
+		w.buf = append(w.buf, 0xc3)
+	} else {
+		w.buf = append(w.buf, 0xc2)
+	}
+}
+
+func (w *msgpackWriter) writeInt(v int64) {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		w.buf = append(w.buf, byte(v))
+	case v < 0 && v >= -32:
+		w.buf = append(w.buf, byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		w.buf = append(w.buf, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		w.buf = append(w.buf, 0xd1, byte(v>>8), byte(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		w.buf = append(w.buf, 0xd2, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		w.buf = append(w.buf, 0xd3)
+		w.writeUint64(uint64(v))
+	}
+}
+
+func (w *msgpackWriter) writeUint64(v uint64) {
+	w.buf = append(w.buf, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *msgpackWriter) writeFloat(v float64) {
+	w.buf = append(w.buf, 0xcb)
+	w.writeUint64(math.Float64bits(v))
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		w.buf = append(w.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xda, byte(n>>8), byte(n))
+	default:
+		w.buf = append(w.buf, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) writeBinary(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xc5, byte(n>>8), byte(n))
+	default:
+		w.buf = append(w.buf, 0xc6, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	w.buf = append(w.buf, b...)
+}
+
+func (w *msgpackWriter) writeArrayHeader(n int) {
+	switch {
+	case n <= 15:
+		w.buf = append(w.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xdc, byte(n>>8), byte(n))
+	default:
+		w.buf = append(w.buf, 0xdd, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func (w *msgpackWriter) writeMapHeader(n int) {
+	switch {
+	case n <= 15:
+		w.buf = append(w.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xde, byte(n>>8), byte(n))
+	default:
+		w.buf = append(w.buf, 0xdf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// writeValue encodes a Value as the same tagged union used in JSON
+func (w *msgpackWriter) writeValue(v Value) error {
+	if v.data == nil {
+		w.writeString("Null")
+		return nil
+	}
+	w.writeMapHeader(1)
+	w.writeString(string(v.dataType()))
+	switch val := v.data.(type) {
+	case int64:
+		w.writeInt(val)
+	case float64:
+		w.writeFloat(val)
+	case string:
+		w.writeString(val)
+	case bool:
+		w.writeBool(val)
+	case []byte:
+		w.writeBinary(val)
+	case bytesRef:
+		w.writeMapHeader(2)
+		w.writeString("path")
+		w.writeString(val.Path)
+		w.writeString("size")
+		w.writeInt(val.Size)
+	case time.Time:
+		w.writeString(val.Format(time.RFC3339Nano))
+	case time.Duration:
+		w.writeInt(int64(val))
+	case netip.Addr:
+		w.writeString(val.String())
+	case ipv4Addr, ipv6Addr, uuidValue:
+		w.writeString(v.String())
+	case jsonDoc:
+		dec := json.NewDecoder(bytes.NewReader(val))
+		dec.UseNumber()
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			return fmt.Errorf("invalid JSON value: %w", err)
+		}
+		w.writeAny(doc)
+	case stringArray:
+		w.writeArrayHeader(len(val))
+		for _, s := range val {
+			w.writeString(s)
+		}
+	case int64Array:
+		w.writeArrayHeader(len(val))
+		for _, n := range val {
+			w.writeInt(n)
+		}
+	case bytesArray:
+		w.writeArrayHeader(len(val))
+		for _, b := range val {
+			w.writeBinary(b)
+		}
+	case decimalText:
+		w.writeString(string(val))
+	case bigIntText:
+		w.writeString(string(val))
+	case SubContentRef:
+		w.writeInt(int64(val.index))
+	default:
+		return fmt.Errorf("unsupported value type: %T", val)
+	}
+	return nil
+}
+
+// writeAny encodes a document decoded by encoding/json with UseNumber
+func (w *msgpackWriter) writeAny(doc interface{}) {
+	switch val := doc.(type) {
+	case nil:
+		w.writeNil()
+	case bool:
+		w.writeBool(val)
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			w.writeInt(i)
+		} else if f, err := val.Float64(); err == nil {
+			w.writeFloat(f)
+		} else {
+			w.writeString(val.String())
+		}
+	case string:
+		w.writeString(val)
+	case []interface{}:
+		w.writeArrayHeader(len(val))
+		for _, item := range val {
+			w.writeAny(item)
+		}
+	case map[string]interface{}:
+		w.writeMapHeader(len(val))
+		for k, item := range val {
+			w.writeString(k)
+			w.writeAny(item)
+		}
+	}
+}
+
+// encodeMsgPack encodes a metadata file with the same field names as its
+// JSON form, so the host can decode either encoding into the same structures
+func encodeMsgPack(m metadataFile) ([]byte, error) {
+	w := &msgpackWriter{}
+
+	fields := 2
+	if m.ScanStatus != nil {
+		fields++
+	}
+	if len(m.Aggregates) > 0 {
+		fields++
+	}
+	if len(m.Attributes) > 0 {
+		fields++
+	}
+	if len(m.Dictionaries) > 0 {
+		fields++
+	}
+	w.writeMapHeader(fields)
+
+	w.writeString("tables")
+	w.writeArrayHeader(len(m.Tables))
+	for _, t := range m.Tables {
+		n := 2
+		if t.NoProvenance {
+			n++
+		}
+		if t.Shared {
+			n++
+		}
+		w.writeMapHeader(n)
+		w.writeString("name")
+		w.writeString(t.Name)
+		w.writeString("columns")
+		w.writeArrayHeader(len(t.Columns))
+		for _, c := range t.Columns {
+			flags := []struct {
+				key string
+				set bool
+			}{
+				{"nullable", c.Nullable},
+				{"primary_key", c.PrimaryKey},
+				{"unique", c.Unique},
+				{"dictionary", c.Dictionary},
+			}
+			n := 2
+			for _, f := range flags {
+				if f.set {
+					n++
+				}
+			}
+			w.writeMapHeader(n)
+			w.writeString("name")
+			w.writeString(c.Name)
+			w.writeString("data_type")
+			w.writeString(string(c.DataType))
+			for _, f := range flags {
+				if f.set {
+					w.writeString(f.key)
+					w.writeBool(true)
+				}
+			}
+		}
+		if t.NoProvenance {
+			w.writeString("no_provenance")
+			w.writeBool(true)
+		}
+		if t.Shared {
+			w.writeString("shared")
+			w.writeBool(true)
+		}
+	}
+
+	w.writeString("rows")
+	w.writeArrayHeader(len(m.Rows))
+	for _, r := range m.Rows {
+		n := 2
+		if r.Truncated {
+			n += 2
+		}
+		w.writeMapHeader(n)
+		w.writeString("table_name")
+		w.writeString(r.TableName)
+		w.writeString("values")
+		w.writeArrayHeader(len(r.Values))
+		for _, v := range r.Values {
+			if err := w.writeValue(v); err != nil {
+				return nil, err
+			}
+		}
+		if r.Truncated {
+			w.writeString("truncated")
+			w.writeBool(true)
+			w.writeString("truncated_columns")
+			w.writeArrayHeader(len(r.TruncatedColumns))
+			for _, c := range r.TruncatedColumns {
+				w.writeInt(int64(c))
+			}
+		}
+	}
+
+	if m.ScanStatus != nil {
+		w.writeString("scan_status")
+		n := 1
+		if m.ScanStatus.Reason != "" {
+			n++
+		}
+		w.writeMapHeader(n)
+		w.writeString("status")
+		w.writeString(m.ScanStatus.Status)
+		if m.ScanStatus.Reason != "" {
+			w.writeString("reason")
+			w.writeString(m.ScanStatus.Reason)
+		}
+	}
+
+	if len(m.Aggregates) > 0 {
+		w.writeString("aggregates")
+		w.writeArrayHeader(len(m.Aggregates))
+		for _, a := range m.Aggregates {
+			n := 4
+			if a.GroupBy != "" {
+				n++
+			}
+			if a.Group != nil {
+				n++
+			}
+			w.writeMapHeader(n)
+			w.writeString("table")
+			w.writeString(a.Table)
+			w.writeString("column")
+			w.writeString(a.Column)
+			w.writeString("function")
+			w.writeString(string(a.Function))
+			if a.GroupBy != "" {
+				w.writeString("group_by")
+				w.writeString(a.GroupBy)
+			}
+			if a.Group != nil {
+				w.writeString("group")
+				if err := w.writeValue(*a.Group); err != nil {
+					return nil, err
+				}
+			}
+			w.writeString("value")
+			if err := w.writeValue(a.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(m.Attributes) > 0 {
+		w.writeString("attributes")
+		w.writeArrayHeader(len(m.Attributes))
+		for _, a := range m.Attributes {
+			w.writeMapHeader(2)
+			w.writeString("key")
+			w.writeString(a.Key)
+			w.writeString("value")
+			if err := w.writeValue(a.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(m.Dictionaries) > 0 {
+		w.writeString("dictionaries")
+		w.writeArrayHeader(len(m.Dictionaries))
+		for _, d := range m.Dictionaries {
+			w.writeMapHeader(3)
+			w.writeString("table")
+			w.writeString(d.Table)
+			w.writeString("column")
+			w.writeInt(int64(d.Column))
+			w.writeString("values")
+			w.writeArrayHeader(len(d.Values))
+			for _, v := range d.Values {
+				w.writeString(v)
+			}
+		}
+	}
+
+	return w.buf, nil
+}
@@ -0,0 +1,56 @@
+package wadup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// OutputNaming selects how Flush names metadata output files
+type OutputNaming int
+
+const (
+	// Sequential names files output_N.json with an incrementing counter
+	Sequential OutputNaming = iota
+	// ContentAddressed names files output_<hash>.json after the SHA-256 of
+	// the serialized payload, so identical outputs get identical names
+	ContentAddressed
+)
+
+// contentAddressPrefix is the number of hex digits of the hash used in names
+const contentAddressPrefix = 16
+
+var outputNaming = Sequential
+
+// metadataDir is the directory the host collects metadata files from
+var metadataDir = "/metadata"
+
+// SetOutputNaming selects how metadata output files are named. The default is
+// Sequential.
+//
+// With ContentAddressed, identical payloads produce identical file names,
+// which lets the host cache and deduplicate outputs. If a file with the same
+// name is still present (written but not yet consumed by the host), it
+// already holds the same content and Flush skips the rewrite.
+func SetOutputNaming(naming OutputNaming) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	outputNaming = naming
+}
+
+// outputFilename returns the file name for a serialized payload with the
+// given extension and whether an identical file already exists. Caller must
+// hold metadataMu.
+func outputFilename(payload []byte, ext string) (string, bool) {
+	if outputNaming != ContentAddressed {
+		filename := fmt.Sprintf("%s/output_%d.%s", metadataDir, fileCounter, ext)
+		fileCounter++
+		return filename, false
+	}
+
+	sum := sha256.Sum256(payload)
+	filename := fmt.Sprintf("%s/output_%s.%s", metadataDir, hex.EncodeToString(sum[:])[:contentAddressPrefix], ext)
+	_, err := os.Stat(filename)
+	return filename, err == nil
+}
@@ -0,0 +1,83 @@
+package wadup
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NormalizeOpts selects the normalization steps applied by NormalizeStrings.
+// Each step is independent; the zero value leaves strings unchanged.
+type NormalizeOpts struct {
+	// TrimNUL removes trailing NUL bytes left by fixed-width string fields
+	TrimNUL bool
+	// TrimSpace removes leading and trailing whitespace
+	TrimSpace bool
+	// CollapseWhitespace replaces each run of whitespace with a single space
+	CollapseWhitespace bool
+	// Lowercase converts strings to lower case
+	Lowercase bool
+	// DropEmpty removes strings that are empty after normalization
+	DropEmpty bool
+	// Dedupe keeps only the first occurrence of each normalized string
+	Dedupe bool
+}
+
+// NormalizeStrings applies the selected normalization steps to each string
+// and returns the result in input order.
+//
+// Steps run in the order TrimNUL, TrimSpace, CollapseWhitespace, Lowercase,
+// so deduplication compares fully normalized values. The input slice is not
+// modified.
+func NormalizeStrings(in []string, opts NormalizeOpts) []string {
+	out := make([]string, 0, len(in))
+	var seen map[string]struct{}
+	if opts.Dedupe {
+		seen = make(map[string]struct{}, len(in))
+	}
+
+	for _, s := range in {
+		if opts.TrimNUL {
+			s = strings.TrimRight(s, "\x00")
+		}
+		if opts.TrimSpace {
+			s = strings.TrimSpace(s)
+		}
+		if opts.CollapseWhitespace {
+			s = collapseWhitespace(s)
+		}
+		if opts.Lowercase {
+			s = strings.ToLower(s)
+		}
+		if opts.DropEmpty && s == "" {
+			continue
+		}
+		if opts.Dedupe {
+			if _, ok := seen[s]; ok {
+				continue
+			}
+			seen[s] = struct{}{}
+		}
+		out = append(out, s)
+	}
+
+	return out
+}
+
+// collapseWhitespace replaces runs of whitespace with a single space
+func collapseWhitespace(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	inSpace := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			if !inSpace {
+				b.WriteByte(' ')
+			}
+			inSpace = true
+			continue
+		}
+		inSpace = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
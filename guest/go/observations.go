@@ -0,0 +1,49 @@
+package wadup
+
+import (
+	"fmt"
+	"math"
+)
+
+// ObservationsTable is the conventional table written by Observe
+const ObservationsTable = "observations"
+
+// observationColumns is the schema of the observations table
+var observationColumns = []Column{
+	{Name: "category", DataType: String},
+	{Name: "key", DataType: String},
+	{Name: "value", DataType: String},
+	{Name: "confidence", DataType: Float64},
+	{Name: "offset", DataType: Int64},
+}
+
+// Observe records a generic finding in the observations table without
+// defining a custom table. The value is stored in text form; confidence must
+// be between 0 and 1. The offset column is -1; use ObserveAt to record where
+// in the content the finding was made.
+func Observe(category, key string, value Value, confidence float64) error {
+	return ObserveAt(category, key, value, confidence, -1)
+}
+
+// ObserveAt records a generic finding located at offset within the content
+func ObserveAt(category, key string, value Value, confidence float64, offset int64) error {
+	if category == "" || key == "" {
+		return fmt.Errorf("observation category and key must not be empty")
+	}
+	if math.IsNaN(confidence) || confidence < 0 || confidence > 1 {
+		return fmt.Errorf("observation confidence %v is outside [0, 1]", confidence)
+	}
+	if offset < -1 {
+		return fmt.Errorf("invalid observation offset %d", offset)
+	}
+
+	ensureTable(ObservationsTable, observationColumns)
+	table := &Table{name: ObservationsTable, columns: observationColumns}
+	return table.InsertRow([]Value{
+		NewString(category),
+		NewString(key),
+		NewString(value.String()),
+		NewFloat64(confidence),
+		NewInt64(offset),
+	})
+}
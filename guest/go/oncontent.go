@@ -0,0 +1,57 @@
+package wadup
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	onContentMu    sync.Mutex
+	onContentHooks []func() error
+	// contentBegun is set once the first content item has begun; tables
+	// defined before then are part of the first item's metadata
+	contentBegun bool
+)
+
+// OnContent registers fn to run at the start of every content item processed
+// through Main, before the run function.
+//
+// The host keeps module instances warm and reuses them across files, so
+// package-level state survives from one content item to the next. Reactor
+// modules use OnContent to reset such state instead of relying on a fresh
+// instance. Hooks run in registration order; if one fails, the run is skipped
+// and Main reports the error.
+func OnContent(fn func() error) {
+	onContentMu.Lock()
+	defer onContentMu.Unlock()
+	onContentHooks = append(onContentHooks, fn)
+}
+
+// beginContent clears the library's per-content tracking and runs the
+// OnContent hooks
+func beginContent() error {
+	ResetEmittedSubContent()
+	resetEmittedRefs()
+	resetWeakDedup()
+	ResetSkippedEmissions()
+	resetSliceRanges()
+	resetUniqueKeys()
+	resetDependencies()
+	resetMetadataWritten()
+	onContentMu.Lock()
+	dropPending := contentBegun
+	contentBegun = true
+	onContentMu.Unlock()
+	resetTableState(dropPending)
+
+	onContentMu.Lock()
+	hooks := append([]func() error(nil), onContentHooks...)
+	onContentMu.Unlock()
+
+	for _, fn := range hooks {
+		if err := fn(); err != nil {
+			return fmt.Errorf("content hook failed: %w", err)
+		}
+	}
+	return nil
+}
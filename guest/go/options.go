@@ -0,0 +1,67 @@
+package wadup
+
+// Conventional sub-content tags
+const (
+	TagEmbedded   = "embedded"
+	TagDecrypted  = "decrypted"
+	TagCarved     = "carved"
+	TagAttachment = "attachment"
+)
+
+// EmitOptions carries optional attributes recorded in sub-content metadata.
+// The zero value adds nothing, matching EmitBytes and EmitSlice.
+type EmitOptions struct {
+	// Tags are free-form labels such as TagEmbedded or TagDecrypted
+	Tags []string
+	// Relationship labels the child relative to its parent
+	// (e.g. "attachment", RelationshipResource)
+	Relationship string
+	// SuggestedParsers names the modules that should parse the child, in
+	// order of preference. The host runs them on it even if their trigger
+	// manifests wouldn't select it.
+	SuggestedParsers []string
+	// Flags records known encryption and compression state
+	Flags ContentFlags
+	// FormatVersion is the detected format version
+	FormatVersion string
+	// Method describes how the child was obtained
+	Method string
+}
+
+// EmitBytesWithOptions emits sub-content bytes with the given attributes
+func EmitBytesWithOptions(data []byte, filename string, opts EmitOptions) (SubContentRef, error) {
+	metadata := subContentMetadata{
+		Filename:      filename,
+		Relationship:  opts.Relationship,
+		FormatVersion: opts.FormatVersion,
+		Method:        opts.Method,
+		Tags:          opts.Tags,
+		Parsers:       opts.SuggestedParsers,
+	}
+	if opts.Flags != (ContentFlags{}) {
+		metadata.Flags = &opts.Flags
+	}
+	return emitBytes(data, metadata)
+}
+
+// EmitSliceWithOptions emits a slice of the input content with the given
+// attributes. The range is bounds-checked as in EmitSlice.
+func EmitSliceWithOptions(offset, length int64, filename string, opts EmitOptions) (SubContentRef, error) {
+	if err := checkSliceBounds(offset, length); err != nil {
+		return SubContentRef{}, err
+	}
+	metadata := subContentSliceMetadata{
+		Filename:      filename,
+		Offset:        offset,
+		Length:        length,
+		Relationship:  opts.Relationship,
+		FormatVersion: opts.FormatVersion,
+		Method:        opts.Method,
+		Tags:          opts.Tags,
+		Parsers:       opts.SuggestedParsers,
+	}
+	if opts.Flags != (ContentFlags{}) {
+		metadata.Flags = &opts.Flags
+	}
+	return emitSlice(metadata)
+}
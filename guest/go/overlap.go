@@ -0,0 +1,85 @@
+package wadup
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SliceOverlapError reports an EmitSlice range overlapping an earlier one
+type SliceOverlapError struct {
+	Offset         int64
+	Length         int64
+	ConflictOffset int64
+	ConflictLength int64
+}
+
+func (e *SliceOverlapError) Error() string {
+	return fmt.Sprintf("slice [%d, %d) overlaps previously emitted slice [%d, %d)",
+		e.Offset, e.Offset+e.Length, e.ConflictOffset, e.ConflictOffset+e.ConflictLength)
+}
+
+// sliceRange is an emitted slice, ordered by offset
+type sliceRange struct {
+	offset int64
+	length int64
+}
+
+var (
+	overlapMu     sync.Mutex
+	overlapCheck  bool
+	emittedRanges []sliceRange
+)
+
+// SetSliceOverlapCheck enables or disables detection of overlapping slices.
+//
+// When enabled, EmitSlice returns a *SliceOverlapError instead of emitting a
+// range that overlaps a slice emitted since the check was enabled, which
+// catches carver bugs that would produce duplicated children. Overlap is
+// legitimate for some content (polyglots), so the check is off by default.
+// Every call clears the tracked ranges; call it at the start of each run.
+func SetSliceOverlapCheck(enabled bool) {
+	overlapMu.Lock()
+	defer overlapMu.Unlock()
+	overlapCheck = enabled
+	emittedRanges = nil
+}
+
+// resetSliceRanges forgets the tracked ranges, keeping the check setting
+func resetSliceRanges() {
+	overlapMu.Lock()
+	defer overlapMu.Unlock()
+	emittedRanges = nil
+}
+
+// claimSliceRange records a slice range, failing if overlap checking is
+// enabled and the range overlaps one already claimed
+func claimSliceRange(offset, length int64) error {
+	overlapMu.Lock()
+	defer overlapMu.Unlock()
+	if !overlapCheck || length <= 0 {
+		return nil
+	}
+
+	// First range starting at or after the end of the new one
+	i := sort.Search(len(emittedRanges), func(i int) bool {
+		return emittedRanges[i].offset >= offset+length
+	})
+	// Ranges are disjoint, so only the predecessor can overlap
+	if i > 0 {
+		prev := emittedRanges[i-1]
+		if prev.offset+prev.length > offset {
+			return &SliceOverlapError{
+				Offset:         offset,
+				Length:         length,
+				ConflictOffset: prev.offset,
+				ConflictLength: prev.length,
+			}
+		}
+	}
+
+	emittedRanges = append(emittedRanges, sliceRange{})
+	copy(emittedRanges[i+1:], emittedRanges[i:])
+	emittedRanges[i] = sliceRange{offset: offset, length: length}
+	return nil
+}
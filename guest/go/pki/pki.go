@@ -0,0 +1,129 @@
+// Package pki provides a conventional table for certificate information
+// extracted by WADUP modules (PE authenticode, TLS, S/MIME).
+//
+// It lives in a subpackage so the core wadup package does not depend on
+// crypto/x509.
+package pki
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+
+	wadup "github.com/tordynnar/wadup2/guest/go"
+)
+
+// TableName is the conventional table certificates are inserted into
+const TableName = "certificates"
+
+// certificateColumns is the schema of the certificates table
+var certificateColumns = []wadup.Column{
+	{Name: "subject", DataType: wadup.String},
+	{Name: "issuer", DataType: wadup.String},
+	{Name: "serial", DataType: wadup.String},
+	{Name: "not_before", DataType: wadup.String},
+	{Name: "not_after", DataType: wadup.String},
+	{Name: "sha1_fingerprint", DataType: wadup.String},
+	{Name: "sha256_fingerprint", DataType: wadup.String},
+	{Name: "parse_error", DataType: wadup.String},
+}
+
+// EmitCertificates inserts one row per certificate into the certificates table.
+//
+// Nil entries are skipped. Validity times are formatted as RFC3339 in UTC and
+// fingerprints are lowercase hex digests of the raw DER encoding.
+func EmitCertificates(certs []*x509.Certificate) error {
+	table, err := wadup.DefineTable(TableName, certificateColumns)
+	if err != nil {
+		return err
+	}
+
+	for _, cert := range certs {
+		if cert == nil {
+			continue
+		}
+		if err := table.InsertRow(certificateRow(cert)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EmitCertificatesDER parses each DER-encoded certificate and inserts it into
+// the certificates table.
+//
+// Malformed certificates do not abort the emission: they are recorded with
+// their fingerprints and the parse error so the failure remains queryable.
+func EmitCertificatesDER(ders [][]byte) error {
+	table, err := wadup.DefineTable(TableName, certificateColumns)
+	if err != nil {
+		return err
+	}
+
+	for _, der := range ders {
+		var values []wadup.Value
+		if cert, err := x509.ParseCertificate(der); err != nil {
+			values = malformedRow(der, err)
+		} else {
+			values = certificateRow(cert)
+		}
+		if err := table.InsertRow(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// certificateRow builds the row values for a parsed certificate
+func certificateRow(cert *x509.Certificate) []wadup.Value {
+	serial := ""
+	if cert.SerialNumber != nil {
+		serial = hex.EncodeToString(cert.SerialNumber.Bytes())
+	}
+	sha1Sum, sha256Sum := fingerprints(cert.Raw)
+	return []wadup.Value{
+		wadup.NewString(cert.Subject.String()),
+		wadup.NewString(cert.Issuer.String()),
+		wadup.NewString(serial),
+		wadup.NewString(formatTime(cert.NotBefore)),
+		wadup.NewString(formatTime(cert.NotAfter)),
+		wadup.NewString(sha1Sum),
+		wadup.NewString(sha256Sum),
+		wadup.NewString(""),
+	}
+}
+
+// malformedRow builds the row values for a certificate that failed to parse
+func malformedRow(der []byte, parseErr error) []wadup.Value {
+	sha1Sum, sha256Sum := fingerprints(der)
+	return []wadup.Value{
+		wadup.NewString(""),
+		wadup.NewString(""),
+		wadup.NewString(""),
+		wadup.NewString(""),
+		wadup.NewString(""),
+		wadup.NewString(sha1Sum),
+		wadup.NewString(sha256Sum),
+		wadup.NewString(parseErr.Error()),
+	}
+}
+
+// fingerprints returns the hex SHA-1 and SHA-256 digests of raw DER bytes
+func fingerprints(der []byte) (string, string) {
+	if len(der) == 0 {
+		return "", ""
+	}
+	sha1Sum := sha1.Sum(der)
+	sha256Sum := sha256.Sum256(der)
+	return hex.EncodeToString(sha1Sum[:]), hex.EncodeToString(sha256Sum[:])
+}
+
+// formatTime formats a validity bound, leaving unset times empty
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,14 @@
+package wadup
+
+// ReportProgress tells the host how much of its work on the current content
+// the module has done, in whatever unit suits the parser (bytes, entries,
+// sectors). Pass a negative total if it is unknown. The host shows progress
+// for slow parsers and, if configured, restarts the module's timeout on every
+// report that shows forward progress, so long but steadily advancing jobs are
+// not cut off.
+//
+// Calls are cheap and can be made from inner loops. On hosts without
+// FeatureProgress they do nothing.
+func ReportProgress(done, total int64) {
+	hostReportProgress(done, total)
+}
@@ -0,0 +1,7 @@
+//go:build !wasip1
+
+package wadup
+
+// hostReportProgress drops the report, since there is no report_progress
+// import outside preview1 builds
+func hostReportProgress(done, total int64) {}
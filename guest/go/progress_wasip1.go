@@ -0,0 +1,15 @@
+//go:build wasip1
+
+package wadup
+
+// reportProgress records the module's progress on the host
+//
+//go:wasmimport wadup report_progress
+func reportProgress(done, total int64)
+
+// hostReportProgress forwards a progress report if the host supports it
+func hostReportProgress(done, total int64) {
+	if HostSupports(FeatureProgress) {
+		reportProgress(done, total)
+	}
+}
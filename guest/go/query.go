@@ -0,0 +1,171 @@
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"time"
+)
+
+// PublishedRow is a row another module produced for the current content
+type PublishedRow struct {
+	// Module is the name of the module that produced the row
+	Module string
+	// Values holds the row's values keyed by column name. Sub-content
+	// references are resolved to the child's content ID, as a string.
+	Values map[string]Value
+}
+
+// publishedRow is a row as returned by the query_metadata import
+type publishedRow struct {
+	Module string                     `json:"module"`
+	Values map[string]json.RawMessage `json:"values"`
+}
+
+// QueryMetadata returns the rows of a table that modules which already
+// finished on the current content produced, so a triage module can build on
+// the "pe_sections" table of a PE parser. Only rows whose columns equal every
+// value in filter are returned; a nil filter matches every row. Tables are
+// named as the producing module named them, without a namespace prefix.
+//
+// Hosts without FeatureQueryMetadata share no rows, and QueryMetadata
+// returns an empty list.
+func QueryMetadata(table string, filter map[string]Value) ([]PublishedRow, error) {
+	var encoded []byte
+	if len(filter) > 0 {
+		var err error
+		if encoded, err = json.Marshal(filter); err != nil {
+			return nil, fmt.Errorf("failed to encode filter: %w", err)
+		}
+	}
+
+	data, ok, err := hostQueryMetadata(table, encoded)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var result struct {
+		Rows []publishedRow `json:"rows"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse rows of table '%s': %w", table, err)
+	}
+	rows := make([]PublishedRow, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = PublishedRow{Module: row.Module, Values: make(map[string]Value, len(row.Values))}
+		for column, raw := range row.Values {
+			value, err := decodeValue(raw)
+			if err != nil {
+				return nil, fmt.Errorf("column '%s' of table '%s': %w", column, table, err)
+			}
+			rows[i].Values[column] = value
+		}
+	}
+	return rows, nil
+}
+
+// decodeValue decodes a value in the tagged union encoding of MarshalJSON, as
+// the host returns it. BytesRef values never come back, as the host stores
+// them as Bytes.
+func decodeValue(raw json.RawMessage) (Value, error) {
+	var tagged map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &tagged); err != nil {
+		var tag string
+		if json.Unmarshal(raw, &tag) == nil && tag == "Null" {
+			return Null(), nil
+		}
+		return Value{}, fmt.Errorf("invalid value %s", raw)
+	}
+	for tag, data := range tagged {
+		var err error
+		switch tag {
+		case "Int64":
+			var v int64
+			err = json.Unmarshal(data, &v)
+			return NewInt64(v), err
+		case "Float64":
+			var v float64
+			err = json.Unmarshal(data, &v)
+			return NewFloat64(v), err
+		case "String":
+			var v string
+			err = json.Unmarshal(data, &v)
+			return NewString(v), err
+		case "Boolean":
+			var v bool
+			err = json.Unmarshal(data, &v)
+			return NewBool(v), err
+		case "Bytes":
+			var v []byte
+			err = json.Unmarshal(data, &v)
+			return NewBytes(v), err
+		case "Timestamp":
+			var v time.Time
+			err = json.Unmarshal(data, &v)
+			return NewTime(v), err
+		case "IPAddress":
+			var v netip.Addr
+			err = json.Unmarshal(data, &v)
+			return NewIPAddress(v), err
+		case "Json":
+			return Value{data: jsonDoc(append([]byte(nil), data...))}, nil
+		case "StringArray":
+			var v []string
+			err = json.Unmarshal(data, &v)
+			return NewStringArray(v), err
+		case "Int64Array":
+			var v []int64
+			err = json.Unmarshal(data, &v)
+			return NewInt64Array(v), err
+		case "BytesArray":
+			var v [][]byte
+			err = json.Unmarshal(data, &v)
+			return NewBytesArray(v), err
+		case "Decimal":
+			var v string
+			if err = json.Unmarshal(data, &v); err != nil {
+				return Value{}, err
+			}
+			return NewDecimal(v)
+		case "BigInt":
+			var v string
+			if err = json.Unmarshal(data, &v); err != nil {
+				return Value{}, err
+			}
+			n, ok := new(big.Int).SetString(v, 10)
+			if !ok {
+				return Value{}, fmt.Errorf("invalid big integer '%s'", v)
+			}
+			return NewBigInt(n), nil
+		case "Duration":
+			var v int64
+			err = json.Unmarshal(data, &v)
+			return NewDuration(time.Duration(v)), err
+		case "IPv4", "IPv6", "UUID":
+			var v string
+			if err = json.Unmarshal(data, &v); err != nil {
+				return Value{}, err
+			}
+			return decodeTextValue(tag, v)
+		}
+		return Value{}, fmt.Errorf("unsupported value type '%s'", tag)
+	}
+	return Value{}, fmt.Errorf("invalid value %s", raw)
+}
+
+// decodeTextValue decodes the text of an address or UUID value, validating it as
+// the constructors do
+func decodeTextValue(tag, text string) (Value, error) {
+	if tag == "UUID" {
+		return NewUUID(text)
+	}
+	addr, err := netip.ParseAddr(text)
+	if err != nil {
+		return Value{}, err
+	}
+	if tag == "IPv4" {
+		return NewIPv4(addr)
+	}
+	return NewIPv6(addr)
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostQueryMetadata reports that the host shares no rows, since there is no
+// query_metadata import outside preview1 builds
+func hostQueryMetadata(string, []byte) ([]byte, bool, error) {
+	return nil, false, nil
+}
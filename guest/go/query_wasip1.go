@@ -0,0 +1,37 @@
+//go:build wasip1
+
+package wadup
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// queryMetadata copies the JSON rows of a table matching a JSON filter into
+// out, returning their length, or -1 if the filter is invalid. Nothing is
+// copied if out is too small.
+//
+//go:wasmimport wadup query_metadata
+func queryMetadata(table unsafe.Pointer, tableLen uint32, filter unsafe.Pointer, filterLen uint32, out unsafe.Pointer, outLen uint32) int32
+
+// hostQueryMetadata asks the host for the rows of a table if it supports it.
+// ok is false if the host shares no rows.
+func hostQueryMetadata(table string, filter []byte) (data []byte, ok bool, err error) {
+	if !HostSupports(FeatureQueryMetadata) {
+		return nil, false, nil
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n := queryMetadata(unsafe.Pointer(unsafe.StringData(table)), uint32(len(table)),
+			unsafe.Pointer(unsafe.SliceData(filter)), uint32(len(filter)),
+			unsafe.Pointer(unsafe.SliceData(buf)), uint32(len(buf)))
+		if n < 0 {
+			return nil, true, fmt.Errorf("host rejected the filter for table '%s'", table)
+		}
+		if int(n) <= len(buf) {
+			return buf[:n], true, nil
+		}
+		buf = make([]byte, n)
+	}
+}
@@ -0,0 +1,128 @@
+package wadup
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// RelationshipReconstructed marks sub-content reassembled from fragments of
+// its parent
+const RelationshipReconstructed = "reconstructed"
+
+// SliceSpec is a byte range of the content being processed
+type SliceSpec struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// contentSize returns the size of /data.bin
+func contentSize() (int64, error) {
+	path := contentPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat content '%s': %w", path, err)
+	}
+	return info.Size(), nil
+}
+
+// EmitReconstructed emits data reassembled from fragments of the current
+// content (TCP segments, split archive volumes) and records the contributing
+// spans, in reassembly order, in the sub-content metadata.
+//
+// Every span must be non-empty and lie within /data.bin. The child is linked
+// to its parent with the "reconstructed" relationship.
+func EmitReconstructed(data []byte, filename string, fragmentSpans []SliceSpec) (SubContentRef, error) {
+	if _, err := checkFragments(fragmentSpans); err != nil {
+		return SubContentRef{}, err
+	}
+	return emitBytes(data, reconstructedMetadata(filename, fragmentSpans))
+}
+
+// EmitFragments emits the concatenation of fragments of the current content,
+// in the given order, as reconstructed sub-content.
+//
+// Hosts with the reassembly feature concatenate the fragments themselves, so
+// the data never passes through module memory. Elsewhere the fragments are
+// read from /data.bin and emitted with EmitReconstructed. The reassembled
+// content can't be larger than the current content.
+func EmitFragments(filename string, fragmentSpans []SliceSpec) (SubContentRef, error) {
+	size, err := checkFragments(fragmentSpans)
+	if err != nil {
+		return SubContentRef{}, err
+	}
+	var total int64
+	for _, span := range fragmentSpans {
+		total += span.Length
+	}
+	if total > size {
+		return SubContentRef{}, fmt.Errorf("fragments add up to %d bytes, more than the content's %d", total, size)
+	}
+
+	if currentTransport() != nil || !HostSupports(FeatureReassembly) {
+		data, err := readFragments(fragmentSpans, total)
+		if err != nil {
+			return SubContentRef{}, err
+		}
+		return EmitReconstructed(data, filename, fragmentSpans)
+	}
+
+	// The metadata file alone, without a data file, asks for reassembly
+	metadata := reconstructedMetadata(filename, fragmentSpans)
+	if !passesGate(nil, metadata.Filename) {
+		return SubContentRef{}, nil
+	}
+	n := nextSubContentIndex()
+	if err := writeBytesMetadata(n, metadata, total); err != nil {
+		return SubContentRef{}, err
+	}
+	return SubContentRef{index: n, emitted: true, filename: metadata.Filename}, nil
+}
+
+// reconstructedMetadata is the metadata of content reassembled from fragments
+func reconstructedMetadata(filename string, fragmentSpans []SliceSpec) subContentMetadata {
+	return subContentMetadata{
+		Filename:     filename,
+		Relationship: RelationshipReconstructed,
+		Method:       MethodReassembled,
+		Fragments:    append([]SliceSpec(nil), fragmentSpans...),
+	}
+}
+
+// checkFragments verifies that there is at least one fragment and that every
+// fragment is non-empty and lies within /data.bin, returning the content size
+func checkFragments(fragmentSpans []SliceSpec) (int64, error) {
+	if len(fragmentSpans) == 0 {
+		return 0, fmt.Errorf("reconstructed content requires at least one fragment span")
+	}
+	size, err := contentSize()
+	if err != nil {
+		return 0, err
+	}
+	for i, span := range fragmentSpans {
+		if span.Offset < 0 || span.Length <= 0 || span.Offset > size || span.Length > size-span.Offset {
+			return 0, fmt.Errorf("fragment %d [%d, +%d) is outside content of %d bytes", i, span.Offset, span.Length, size)
+		}
+	}
+	return size, nil
+}
+
+// readFragments reads and concatenates fragments of /data.bin
+func readFragments(fragmentSpans []SliceSpec, total int64) ([]byte, error) {
+	path := contentPath()
+	content, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open content '%s': %w", path, err)
+	}
+	defer content.Close()
+
+	data := make([]byte, 0, total)
+	for i, span := range fragmentSpans {
+		fragment := make([]byte, span.Length)
+		if _, err := content.ReadAt(fragment, span.Offset); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read fragment %d: %w", i, err)
+		}
+		data = append(data, fragment...)
+	}
+	return data, nil
+}
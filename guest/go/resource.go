@@ -0,0 +1,30 @@
+package wadup
+
+import (
+	"errors"
+	"strings"
+)
+
+// RelationshipResource marks sub-content extracted from a parent's resources
+const RelationshipResource = "resource"
+
+// EmitResource emits an extracted resource (icon, string table, embedded file)
+// as sub-content.
+//
+// The resource type and identifier are recorded in the sub-content metadata
+// and the child is linked to its parent with the "resource" relationship.
+// Numbered resources should pass their number formatted as a string.
+func EmitResource(data []byte, resType string, resID string, filename string) (SubContentRef, error) {
+	if strings.TrimSpace(resType) == "" {
+		return SubContentRef{}, errors.New("resource type must not be empty")
+	}
+	if strings.TrimSpace(resID) == "" {
+		return SubContentRef{}, errors.New("resource ID must not be empty")
+	}
+
+	return emitBytes(data, subContentMetadata{
+		Filename:     filename,
+		Relationship: RelationshipResource,
+		Resource:     &resourceInfo{Type: resType, ID: resID},
+	})
+}
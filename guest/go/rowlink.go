@@ -0,0 +1,25 @@
+package wadup
+
+import "fmt"
+
+// EmitBytesForRow emits sub-content bytes linked to a row of a parent table.
+//
+// rowIndex is the zero-based position of the row among those inserted into
+// table for the current content item, counting across redefinitions. The
+// link is recorded in the sub-content metadata so the host can join the
+// child to the row that describes it (for example an "attachments" row and
+// the attachment itself). The row must already have been inserted.
+func EmitBytesForRow(data []byte, filename string, table string, rowIndex int) (SubContentRef, error) {
+	count, ok := tableRowCount(table)
+	if !ok {
+		return SubContentRef{}, fmt.Errorf("table '%s' is not defined", table)
+	}
+	if rowIndex < 0 || rowIndex >= count {
+		return SubContentRef{}, fmt.Errorf("row %d does not exist in table '%s' (%d rows inserted)", rowIndex, table, count)
+	}
+
+	return emitBytes(data, subContentMetadata{
+		Filename:  filename,
+		ParentRow: &parentRowRef{Table: table, RowIndex: rowIndex},
+	})
+}
@@ -0,0 +1,132 @@
+package wadup
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// rowStream is a JSON metadata file being written row by row. Rows are
+// encoded as they are inserted instead of being buffered, and the tables,
+// scan status, attributes, aggregates and dictionaries follow them in a footer written when
+// the file is closed, so the host reads it like any other metadata file.
+type rowStream struct {
+	file     *os.File
+	w        *bufio.Writer
+	filename string
+	rows     int
+	bytes    int
+	dicts    fileDictionaries
+}
+
+// activeStream is the open metadata file, nil if none. Guarded by metadataMu.
+var activeStream *rowStream
+
+// metadataFooter holds the fields of a metadata file that follow its rows
+type metadataFooter struct {
+	Tables       []tableDef      `json:"tables"`
+	ScanStatus   *scanStatus     `json:"scan_status,omitempty"`
+	Aggregates   []aggregateDef  `json:"aggregates,omitempty"`
+	Attributes   []attributeDef  `json:"attributes,omitempty"`
+	Dictionaries []dictionaryDef `json:"dictionaries,omitempty"`
+}
+
+// canStreamRows reports whether rows can be written straight to a JSON
+// metadata file: other wire formats, Arrow output, custom transports and
+// content-addressed names all need the whole payload first. Caller must hold
+// metadataMu.
+func canStreamRows() bool {
+	return currentTransport() == nil && outputNaming != ContentAddressed &&
+		negotiatedWireFormat() == WireJSON && negotiatedOutputFormat() != FormatArrowIPC
+}
+
+// streamRow appends a row to the open metadata file, creating it first if
+// needed. Caller must hold metadataMu.
+func streamRow(row rowDef) error {
+	if activeStream == nil {
+		filename, _ := outputFilename(nil, "json")
+		file, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("failed to create metadata file '%s': %w", filename, err)
+		}
+		activeStream = &rowStream{file: file, w: bufio.NewWriter(file), filename: filename}
+		if _, err := activeStream.w.WriteString(`{"rows":[`); err != nil {
+			return activeStream.fail(err)
+		}
+	}
+
+	if dictionaryEncoding() {
+		row = activeStream.dicts.encode(row)
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+	if activeStream.rows > 0 {
+		if err := activeStream.w.WriteByte(','); err != nil {
+			return activeStream.fail(err)
+		}
+		activeStream.bytes++
+	}
+	if _, err := activeStream.w.Write(data); err != nil {
+		return activeStream.fail(err)
+	}
+	activeStream.rows++
+	activeStream.bytes += len(data)
+	return nil
+}
+
+// closeStream writes the footer of the open metadata file, holding the
+// accumulated tables, scan status, attributes and aggregates, and closes it,
+// which hands it to the host. Caller must hold metadataMu.
+func closeStream() error {
+	footer := metadataFooter{
+		Tables:       []tableDef{},
+		ScanStatus:   accumulatedStatus,
+		Attributes:   accumulatedAttrs,
+		Dictionaries: activeStream.dicts.defs,
+	}
+	if accumulatedTabs != nil {
+		footer.Tables = accumulatedTabs
+	}
+	if aggregatesDirty {
+		footer.Aggregates = snapshotAggregates()
+	}
+	data, err := json.Marshal(footer)
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+
+	// The footer object's fields continue the object opened with the rows
+	if _, err := activeStream.w.WriteString("],"); err != nil {
+		return activeStream.fail(err)
+	}
+	if _, err := activeStream.w.Write(data[1:]); err != nil {
+		return activeStream.fail(err)
+	}
+	if err := activeStream.w.Flush(); err != nil {
+		return activeStream.fail(err)
+	}
+	s := activeStream
+	activeStream = nil
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to write metadata file '%s': %w", s.filename, err)
+	}
+	metadataWritten += int64(len(`{"rows":[`) + s.bytes + len("],") + len(data) - 1)
+	markTablesFlushed()
+
+	accumulatedTabs = nil
+	accumulatedStatus = nil
+	accumulatedAttrs = nil
+	aggregatesDirty = false
+	return nil
+}
+
+// fail abandons a stream that could not be written; the host reports the
+// incomplete file as invalid.
+func (s *rowStream) fail(err error) error {
+	s.file.Close()
+	activeStream = nil
+	return fmt.Errorf("failed to write metadata file '%s': %w", s.filename, err)
+}
@@ -0,0 +1,136 @@
+package wadup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useMetadataDir points the file protocol at a temporary directory for the
+// rest of the test
+func useMetadataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	metadataMu.Lock()
+	previous := metadataDir
+	metadataDir = dir
+	metadataMu.Unlock()
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		metadataDir = previous
+		metadataMu.Unlock()
+		resetTableState(true)
+	})
+	return dir
+}
+
+// metadataFiles returns the metadata files written to dir
+func metadataFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "output_*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestStreamedRowsCompletedByFlush(t *testing.T) {
+	dir := useMetadataDir(t)
+	table, err := DefineTable("streamed_rows", []Column{
+		{Name: "n", DataType: Int64},
+		{Name: "name", DataType: String},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := MetadataWritten()
+
+	// Enough rows to leave the table's buffer, so they are streamed
+	const rows = 3 * tableBufferRows
+	for i := 0; i < rows; i++ {
+		if err := table.InsertRow([]Value{NewInt64(int64(i)), NewString(`quote " and, comma`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The file is open with the rows so far, and not yet valid JSON
+	files := metadataFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("got %d metadata files while streaming, want 1", len(files))
+	}
+	partial, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json.Valid(partial) {
+		t.Errorf("streamed file is complete before Flush")
+	}
+
+	if err := Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if got := metadataFiles(t, dir); len(got) != 1 || got[0] != files[0] {
+		t.Fatalf("Flush wrote %v, want it to complete %s", got, files[0])
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The footer continues the object the rows were streamed into
+	var file struct {
+		Tables []struct {
+			Name string `json:"name"`
+		} `json:"tables"`
+		Rows []struct {
+			TableName string            `json:"table_name"`
+			Values    []json.RawMessage `json:"values"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("completed file is not valid JSON: %v", err)
+	}
+	if len(file.Tables) != 1 || file.Tables[0].Name != "streamed_rows" {
+		t.Errorf("tables = %+v, want streamed_rows", file.Tables)
+	}
+	if len(file.Rows) != rows {
+		t.Fatalf("got %d rows, want %d", len(file.Rows), rows)
+	}
+	for i, row := range file.Rows {
+		if row.TableName != "streamed_rows" || len(row.Values) != 2 {
+			t.Fatalf("row %d = %+v", i, row)
+		}
+		if !strings.Contains(string(row.Values[1]), `quote \" and, comma`) {
+			t.Fatalf("row %d name = %s", i, row.Values[1])
+		}
+	}
+	if written := MetadataWritten() - before; written != int64(len(data)) {
+		t.Errorf("MetadataWritten = %d, want the file size %d", written, len(data))
+	}
+}
+
+func TestFlushWithoutStreamedRows(t *testing.T) {
+	dir := useMetadataDir(t)
+	if _, err := DefineTable("streamed_empty", []Column{{Name: "n", DataType: Int64}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The definition alone is written as an ordinary metadata file
+	files := metadataFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("got %d metadata files, want 1", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(data) || !strings.Contains(string(data), `"streamed_empty"`) {
+		t.Errorf("metadata file = %s", data)
+	}
+}
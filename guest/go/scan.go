@@ -0,0 +1,118 @@
+package wadup
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Pattern is a search pattern for ScanContent: a regular expression or a
+// literal byte sequence
+type Pattern struct {
+	// ID identifies the pattern in matches
+	ID string
+	// Regex is a regular expression in RE2 syntax, matched against the raw
+	// bytes of the content
+	Regex string
+	// Bytes is a literal byte sequence, used when Regex is empty
+	Bytes []byte
+}
+
+// Match is an occurrence of a pattern in the content
+type Match struct {
+	// Pattern is the ID of the pattern that matched
+	Pattern string
+	Offset  int64
+	Length  int64
+}
+
+// scanPattern is a pattern as sent to the scan_content import
+type scanPattern struct {
+	Regex string `json:"regex,omitempty"`
+	Hex   string `json:"hex,omitempty"`
+}
+
+// scanMatch is a match as returned by the scan_content import
+type scanMatch struct {
+	Pattern int   `json:"pattern"`
+	Offset  int64 `json:"offset"`
+	Length  int64 `json:"length"`
+}
+
+// ScanContent finds all non-overlapping, non-empty matches of each pattern in
+// the content, ordered by offset and then by pattern order.
+//
+// If the host advertises FeatureScanContent the scan runs natively on the
+// host, so modules extracting strings or IOCs need not ship a regex engine.
+// Otherwise the content is scanned in the guest with package regexp.
+func ScanContent(patterns []Pattern) ([]Match, error) {
+	wire := make([]scanPattern, len(patterns))
+	for i, p := range patterns {
+		if p.Regex == "" && len(p.Bytes) == 0 {
+			return nil, fmt.Errorf("pattern '%s' has neither a regex nor bytes", p.ID)
+		}
+		if p.Regex != "" {
+			wire[i].Regex = p.Regex
+		} else {
+			wire[i].Hex = hex.EncodeToString(p.Bytes)
+		}
+	}
+
+	found, ok, err := hostScanContent(wire)
+	if !ok {
+		found, err = guestScanContent(patterns)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Offset != found[j].Offset {
+			return found[i].Offset < found[j].Offset
+		}
+		return found[i].Pattern < found[j].Pattern
+	})
+	matches := make([]Match, len(found))
+	for i, m := range found {
+		matches[i] = Match{Pattern: patterns[m.Pattern].ID, Offset: m.Offset, Length: m.Length}
+	}
+	return matches, nil
+}
+
+// guestScanContent scans the content in the guest
+func guestScanContent(patterns []Pattern) ([]scanMatch, error) {
+	content, err := MapContent()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	data := content.Bytes()
+
+	var found []scanMatch
+	for i, p := range patterns {
+		if p.Regex == "" {
+			for start := 0; ; {
+				n := bytes.Index(data[start:], p.Bytes)
+				if n < 0 {
+					break
+				}
+				found = append(found, scanMatch{Pattern: i, Offset: int64(start + n), Length: int64(len(p.Bytes))})
+				start += n + len(p.Bytes)
+			}
+			continue
+		}
+
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", p.ID, err)
+		}
+		for _, loc := range re.FindAllIndex(data, -1) {
+			if loc[1] > loc[0] {
+				found = append(found, scanMatch{Pattern: i, Offset: int64(loc[0]), Length: int64(loc[1] - loc[0])})
+			}
+		}
+	}
+	return found, nil
+}
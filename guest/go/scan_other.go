@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostScanContent reports that the host cannot scan content, since there is
+// no scan_content import outside preview1 builds
+func hostScanContent(patterns []scanPattern) ([]scanMatch, bool, error) {
+	return nil, false, nil
+}
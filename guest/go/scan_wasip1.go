@@ -0,0 +1,51 @@
+//go:build wasip1
+
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+// Error codes returned by the scan_content import
+const (
+	scanErrInvalidPattern = -1
+)
+
+// scanContent runs the JSON-encoded patterns over the content on the host and
+// copies the JSON-encoded matches into out, returning their length or a
+// negative error code. Nothing is copied if out is too small.
+//
+//go:wasmimport wadup scan_content
+func scanContent(patterns unsafe.Pointer, patternsLen uint32, out unsafe.Pointer, outLen uint32) int32
+
+// hostScanContent scans the content natively if the host supports it. ok is
+// false if the host cannot, and the guest must scan the content itself.
+func hostScanContent(patterns []scanPattern) (matches []scanMatch, ok bool, err error) {
+	if !HostSupports(FeatureScanContent) {
+		return nil, false, nil
+	}
+
+	request, err := json.Marshal(patterns)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to encode patterns: %w", err)
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n := scanContent(unsafe.Pointer(unsafe.SliceData(request)), uint32(len(request)),
+			unsafe.Pointer(unsafe.SliceData(buf)), uint32(len(buf)))
+		switch {
+		case n == scanErrInvalidPattern:
+			return nil, true, fmt.Errorf("host rejected scan patterns")
+		case n < 0:
+			return nil, true, fmt.Errorf("failed to scan content: error %d", n)
+		case int(n) <= len(buf):
+			if err := json.Unmarshal(buf[:n], &matches); err != nil {
+				return nil, true, fmt.Errorf("failed to decode scan matches: %w", err)
+			}
+			return matches, true, nil
+		}
+		buf = make([]byte, n)
+	}
+}
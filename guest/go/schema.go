@@ -0,0 +1,67 @@
+package wadup
+
+import "fmt"
+
+// TableSchema describes a defined table
+type TableSchema struct {
+	Name    string   `json:"name"`
+	Columns []Column `json:"columns"`
+}
+
+// registerTable records a table definition, replacing any earlier definition
+// with the same name. Caller must hold metadataMu.
+func registerTable(name string, columns []Column) {
+	schema := TableSchema{Name: name, Columns: append([]Column(nil), columns...)}
+	for i, t := range registeredTables {
+		if t.Name == name {
+			registeredTables[i] = schema
+			return
+		}
+	}
+	registeredTables = append(registeredTables, schema)
+}
+
+// RegisteredTables returns the schema of every table defined so far, in
+// definition order. Redefining a table replaces its schema in place.
+//
+// The result is a snapshot: it is safe to modify and does not change as
+// further tables are defined.
+func RegisteredTables() []TableSchema {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	tables := make([]TableSchema, len(registeredTables))
+	for i, t := range registeredTables {
+		tables[i] = TableSchema{Name: t.Name, Columns: append([]Column(nil), t.Columns...)}
+	}
+	return tables
+}
+
+// DeclareSchema declares every table the module can produce, typically at
+// module load before any content is processed. Declared tables are sent to
+// the host with the next metadata written, even if they never get a row, so
+// consumers see a stable, complete set of tables rather than only those that
+// happened to have data.
+//
+// All schemas are validated before any is declared, so on error none of them
+// are. Declaring a table that is already defined keeps its rows; a table
+// defined later with DefineTable or the table builder replaces its declared
+// schema as usual.
+func DeclareSchema(schemas []TableSchema) error {
+	seen := make(map[string]bool, len(schemas))
+	for _, schema := range schemas {
+		if schema.Name == "" {
+			return fmt.Errorf("declared table has no name")
+		}
+		if seen[schema.Name] {
+			return fmt.Errorf("table '%s' declared twice", schema.Name)
+		}
+		seen[schema.Name] = true
+		if err := checkColumns(schema.Name, schema.Columns); err != nil {
+			return err
+		}
+	}
+	for _, schema := range schemas {
+		ensureTable(schema.Name, append([]Column(nil), schema.Columns...))
+	}
+	return nil
+}
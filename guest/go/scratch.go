@@ -0,0 +1,20 @@
+package wadup
+
+import "os"
+
+// scratchDir is the writable directory hosts with FeatureScratch provide
+const scratchDir = "/tmp"
+
+// ScratchDir returns a writable directory for temporary files, e.g. for
+// parsers that need a SQLite database or an unpacked document on disk. Use
+// it with os.CreateTemp and os.MkdirTemp.
+//
+// On hosts with FeatureScratch the directory is emptied before each content
+// item, and its total size may be capped by a quota, in which case writes
+// beyond it fail with syscall.ENOSPC. Other hosts get os.TempDir.
+func ScratchDir() string {
+	if HostSupports(FeatureScratch) {
+		return scratchDir
+	}
+	return os.TempDir()
+}
@@ -0,0 +1,25 @@
+package wadup
+
+// Kinds of secret understood by the host's configuration
+const (
+	// SecretPassword is a candidate password for encrypted archives and
+	// documents
+	SecretPassword = "password"
+	// SecretKey is a candidate encryption key
+	SecretKey = "key"
+)
+
+// GetSecrets returns the candidate secrets of a kind (see SecretPassword and
+// SecretKey) the host offers for decrypting content, so parsers don't have
+// to hardcode passwords. They come from the host's configuration and from
+// credentials found earlier in the run, in the order they were added.
+//
+// Hosts without FeatureSecrets offer none, and GetSecrets returns an empty
+// list.
+func GetSecrets(kind string) ([]string, error) {
+	secrets, ok, err := hostGetSecrets(kind)
+	if ok {
+		return secrets, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostGetSecrets reports that the host offers no secrets, since there is no
+// get_secrets import outside preview1 builds
+func hostGetSecrets(string) ([]string, bool, error) {
+	return nil, false, nil
+}
@@ -0,0 +1,39 @@
+//go:build wasip1
+
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+// getSecrets copies the JSON array of secrets of a kind into out, returning
+// its length. Nothing is copied if out is too small.
+//
+//go:wasmimport wadup get_secrets
+func getSecrets(kind unsafe.Pointer, kindLen uint32, out unsafe.Pointer, outLen uint32) int32
+
+// hostGetSecrets asks the host for secrets of a kind if it supports it. ok is
+// false if the host cannot offer any.
+func hostGetSecrets(kind string) (secrets []string, ok bool, err error) {
+	if !HostSupports(FeatureSecrets) {
+		return nil, false, nil
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		n := getSecrets(unsafe.Pointer(unsafe.StringData(kind)), uint32(len(kind)),
+			unsafe.Pointer(unsafe.SliceData(buf)), uint32(len(buf)))
+		if n < 0 {
+			return nil, false, nil
+		}
+		if int(n) <= len(buf) {
+			if err := json.Unmarshal(buf[:n], &secrets); err != nil {
+				return nil, true, fmt.Errorf("failed to parse secrets: %w", err)
+			}
+			return secrets, true, nil
+		}
+		buf = make([]byte, n)
+	}
+}
@@ -0,0 +1,35 @@
+package wadup
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SeedPath is where the host provides the per-content deterministic seed
+const SeedPath = "/wadup/seed"
+
+// DefaultSeed is returned by Seed when the host does not provide one
+const DefaultSeed int64 = 0x5741445550 // "WADUP"
+
+// Seed returns a deterministic seed for the content being processed.
+//
+// The host derives the seed from the content hash and writes it as a decimal
+// integer to /wadup/seed, so modules that seed their RNGs with it produce
+// byte-identical output for identical input. When the host provides no seed,
+// DefaultSeed is returned: output is then still reproducible, but every
+// content item shares the same random sequence.
+//
+// The seed is re-read on every call because module instances are reused
+// across content items.
+func Seed() int64 {
+	data, err := os.ReadFile(SeedPath)
+	if err != nil {
+		return DefaultSeed
+	}
+	seed, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return DefaultSeed
+	}
+	return seed
+}
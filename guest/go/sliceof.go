@@ -0,0 +1,29 @@
+package wadup
+
+import "fmt"
+
+// EmitSliceOf emits a zero-copy slice of previously emitted sub-content.
+//
+// parentRef is the Ref of an earlier emission by this module (see
+// LastEmittedRef and EmittedSubContent), e.g. a decompressed buffer; offset
+// and length are relative to that child. The host resolves the slice against
+// the child's bytes, so nothing is copied again. Returns an error wrapping
+// ErrSliceOutOfRange if the range does not lie within the parent.
+func EmitSliceOf(parentRef string, offset, length int64, filename string) (SubContentRef, error) {
+	emissionsMu.Lock()
+	size, ok := emittedSizes[parentRef]
+	emissionsMu.Unlock()
+	if !ok {
+		return SubContentRef{}, fmt.Errorf("unknown sub-content reference '%s'", parentRef)
+	}
+	if offset < 0 || length < 0 || offset > size || length > size-offset {
+		return SubContentRef{}, fmt.Errorf("%w: offset %d, length %d, '%s' size %d", ErrSliceOutOfRange, offset, length, parentRef, size)
+	}
+
+	return emitSlice(subContentSliceMetadata{
+		Filename:  filename,
+		Offset:    offset,
+		Length:    length,
+		ParentRef: parentRef,
+	})
+}
@@ -0,0 +1,37 @@
+package wadup
+
+import "fmt"
+
+// Scan statuses a module can report for the content being processed
+const (
+	// ScanComplete means the content was fully examined
+	ScanComplete = "complete"
+	// ScanPartial means examination stopped early (e.g. a size or time limit)
+	ScanPartial = "partial"
+	// ScanSkipped means the module did not examine the content
+	ScanSkipped = "skipped"
+)
+
+// scanStatus represents the scan-status marker for serialization
+type scanStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// SetScanStatus records how thoroughly the module examined the current content.
+//
+// The marker is written with the next Flush and lets the host distinguish
+// "found nothing" from "didn't fully look". A module that never calls it is
+// treated as ScanComplete. Calling it again replaces the previous marker.
+func SetScanStatus(status string, reason string) error {
+	switch status {
+	case ScanComplete, ScanPartial, ScanSkipped:
+	default:
+		return fmt.Errorf("invalid scan status '%s': must be %q, %q or %q", status, ScanComplete, ScanPartial, ScanSkipped)
+	}
+
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	accumulatedStatus = &scanStatus{Status: status, Reason: reason}
+	return nil
+}
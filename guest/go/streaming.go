@@ -0,0 +1,81 @@
+package wadup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	blobMu      sync.Mutex
+	blobCounter int
+)
+
+// bytesRef references a binary value written to a side file
+type bytesRef struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// InsertRowStreaming inserts a row whose streamCol value is streamed from r
+// to a side file instead of being held in memory.
+//
+// The data is written to /metadata/blob_N.bin and the row stores a BytesRef
+// value (path and size) that the host resolves when ingesting the row, which
+// bounds guest memory for tables with large blob columns. streamCol must be a
+// Bytes or BytesRef column; the value at its position in values is ignored
+// and may be the zero Value.
+func (t *Table) InsertRowStreaming(values []Value, streamCol string, r io.Reader) error {
+	col := columnIndex(t.columns, streamCol)
+	if col < 0 {
+		return fmt.Errorf("column '%s' not found in table '%s'", streamCol, t.name)
+	}
+	if dt := t.columns[col].DataType; dt != BytesRef && dt != Bytes {
+		return fmt.Errorf("column '%s' in table '%s' has type %s, streaming requires %s or %s", streamCol, t.name, dt, Bytes, BytesRef)
+	}
+	if len(values) != len(t.columns) {
+		return fmt.Errorf("table '%s' has %d columns, got %d values", t.name, len(t.columns), len(values))
+	}
+
+	file, err := createBlobFile()
+	if err != nil {
+		return err
+	}
+	path := file.Name()
+	size, err := io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write blob file '%s': %w", path, err)
+	}
+
+	row := append([]Value(nil), values...)
+	row[col] = Value{data: bytesRef{Path: path, Size: size}}
+	return t.InsertRow(row)
+}
+
+// createBlobFile creates the side file for a streamed value. With a custom
+// transport, which has no /metadata directory, it goes to a temporary file.
+func createBlobFile() (*os.File, error) {
+	if currentTransport() != nil {
+		file, err := os.CreateTemp("", "wadup-blob-*.bin")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create blob file: %w", err)
+		}
+		return file, nil
+	}
+
+	blobMu.Lock()
+	n := blobCounter
+	blobCounter++
+	blobMu.Unlock()
+
+	path := fmt.Sprintf("%s/blob_%d.bin", metadataDir, n)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob file '%s': %w", path, err)
+	}
+	return file, nil
+}
@@ -0,0 +1,182 @@
+package wadup
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// FieldType is the encoding of a field in a StructLayout
+type FieldType string
+
+const (
+	// FieldUint is an unsigned integer of width 1, 2, 4 or 8 bytes
+	FieldUint FieldType = "uint"
+	// FieldInt is a two's complement signed integer of width 1, 2, 4 or 8 bytes
+	FieldInt FieldType = "int"
+	// FieldFloat is an IEEE 754 float of width 4 or 8 bytes
+	FieldFloat FieldType = "float"
+	// FieldString is a fixed-width string with trailing NULs removed
+	FieldString FieldType = "string"
+	// FieldBytes is a fixed-width byte field emitted as lowercase hex
+	FieldBytes FieldType = "bytes"
+)
+
+// Endianness is the byte order of a multi-byte field
+type Endianness int
+
+const (
+	LittleEndian Endianness = iota
+	BigEndian
+)
+
+// StructField declares one field of a binary structure
+type StructField struct {
+	Name   string
+	Type   FieldType
+	Width  int
+	Endian Endianness
+}
+
+// StructLayout declares a contiguous binary structure, fields in file order
+type StructLayout struct {
+	Fields []StructField
+}
+
+// Size returns the total width of the layout in bytes
+func (l StructLayout) Size() int64 {
+	var size int64
+	for _, f := range l.Fields {
+		size += int64(f.Width)
+	}
+	return size
+}
+
+// columns validates the layout and returns the matching table columns
+func (l StructLayout) columns() ([]Column, error) {
+	if len(l.Fields) == 0 {
+		return nil, fmt.Errorf("struct layout has no fields")
+	}
+	columns := make([]Column, 0, len(l.Fields))
+	for _, f := range l.Fields {
+		var dataType DataType
+		switch f.Type {
+		case FieldUint, FieldInt:
+			if f.Width != 1 && f.Width != 2 && f.Width != 4 && f.Width != 8 {
+				return nil, fmt.Errorf("field '%s': invalid %s width %d", f.Name, f.Type, f.Width)
+			}
+			dataType = Int64
+		case FieldFloat:
+			if f.Width != 4 && f.Width != 8 {
+				return nil, fmt.Errorf("field '%s': invalid float width %d", f.Name, f.Width)
+			}
+			dataType = Float64
+		case FieldString, FieldBytes:
+			if f.Width <= 0 {
+				return nil, fmt.Errorf("field '%s': invalid %s width %d", f.Name, f.Type, f.Width)
+			}
+			dataType = String
+		default:
+			return nil, fmt.Errorf("field '%s': unknown field type '%s'", f.Name, f.Type)
+		}
+		columns = append(columns, Column{Name: f.Name, DataType: dataType})
+	}
+	return columns, nil
+}
+
+// EmitStruct decodes the structure described by layout at offset in
+// /data.bin and inserts it as one row into tableName.
+//
+// The table has one column per field (integers as Int64, floats as Float64,
+// strings and hex-encoded bytes as String) and is defined automatically, so
+// EmitStruct can be called repeatedly to emit one row per record. Returns an
+// error if the layout is invalid or does not fit within the content.
+func EmitStruct(layout StructLayout, offset int64, tableName string) error {
+	columns, err := layout.columns()
+	if err != nil {
+		return err
+	}
+
+	path := contentPath()
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open content '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat content '%s': %w", path, err)
+	}
+	size := layout.Size()
+	if offset < 0 || offset > info.Size() || size > info.Size()-offset {
+		return fmt.Errorf("struct of %d bytes at offset %d exceeds content size %d", size, offset, info.Size())
+	}
+
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return fmt.Errorf("failed to read struct at offset %d: %w", offset, err)
+	}
+
+	values := make([]Value, 0, len(layout.Fields))
+	pos := 0
+	for _, field := range layout.Fields {
+		value, err := decodeField(field, buf[pos:pos+field.Width])
+		if err != nil {
+			return err
+		}
+		values = append(values, value)
+		pos += field.Width
+	}
+
+	ensureTable(tableName, columns)
+	table := &Table{name: tableName, columns: columns}
+	return table.InsertRow(values)
+}
+
+// decodeField decodes a single field from its bytes
+func decodeField(field StructField, b []byte) (Value, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if field.Endian == BigEndian {
+		order = binary.BigEndian
+	}
+
+	switch field.Type {
+	case FieldUint:
+		u := decodeUint(order, b)
+		if u > math.MaxInt64 {
+			return Value{}, fmt.Errorf("field '%s': value %d overflows Int64", field.Name, u)
+		}
+		return NewInt64(int64(u)), nil
+	case FieldInt:
+		u := decodeUint(order, b)
+		shift := 64 - 8*len(b)
+		return NewInt64(int64(u<<shift) >> shift), nil
+	case FieldFloat:
+		if len(b) == 4 {
+			return NewFloat64(float64(math.Float32frombits(order.Uint32(b)))), nil
+		}
+		return NewFloat64(math.Float64frombits(order.Uint64(b))), nil
+	case FieldString:
+		return NewString(strings.TrimRight(string(b), "\x00")), nil
+	default:
+		return NewString(hex.EncodeToString(b)), nil
+	}
+}
+
+// decodeUint decodes an unsigned integer of 1, 2, 4 or 8 bytes
+func decodeUint(order binary.ByteOrder, b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(order.Uint16(b))
+	case 4:
+		return uint64(order.Uint32(b))
+	default:
+		return order.Uint64(b)
+	}
+}
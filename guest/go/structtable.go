@@ -0,0 +1,264 @@
+package wadup
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// TypedTable is a table whose rows are inserted from values of struct type T
+type TypedTable[T any] struct {
+	*Table
+	fields []structColumn
+}
+
+// structColumn maps a struct field to a table column
+type structColumn struct {
+	index    []int
+	column   Column
+	pointer  bool
+	unsigned bool
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	addrType   = reflect.TypeOf(netip.Addr{})
+	subRefType = reflect.TypeOf(SubContentRef{})
+	bigIntType = reflect.TypeOf(big.Int{})
+	durType    = reflect.TypeOf(time.Duration(0))
+)
+
+// DefineTableFromStruct defines a table whose columns are the fields of T.
+//
+// Fields are mapped in declaration order. The column name and type come from
+// a `wadup:"column_name,type"` tag; either part may be omitted, defaulting to
+// the field name and the type inferred from the field's Go type. A type given
+// in the tag must match the inferred one, which catches schema mistakes when
+// the table is defined rather than when the host ingests it. Fields tagged
+// `wadup:"-"` and unexported fields are skipped. Pointer fields become
+// nullable columns.
+//
+// Supported field types: signed and unsigned integers (Int64), floats
+// (Float64), string, bool, []byte (Bytes), time.Time (Timestamp),
+// time.Duration (Duration), netip.Addr (IPAddress, or IPv4 or IPv6 when
+// tagged so), big.Int (BigInt), [16]byte (UUID), []string (StringArray),
+// []int64 (Int64Array), [][]byte (BytesArray) and SubContentRef
+// (SubContentID).
+func DefineTableFromStruct[T any](name string) (*TypedTable[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("table '%s': %s is not a struct type", name, typ)
+	}
+
+	var fields []structColumn
+	for _, f := range reflect.VisibleFields(typ) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("wadup")
+		if tag == "-" {
+			continue
+		}
+		colName, tagType, _ := strings.Cut(tag, ",")
+		if colName == "" {
+			colName = f.Name
+		}
+
+		fieldType := f.Type
+		pointer := fieldType.Kind() == reflect.Pointer
+		if pointer {
+			fieldType = fieldType.Elem()
+		}
+		dataType, unsigned, ok := inferDataType(fieldType)
+		if !ok {
+			return nil, fmt.Errorf("table '%s': field %s has unsupported type %s", name, f.Name, f.Type)
+		}
+		// An address field may be narrowed to one family
+		if dataType == IPAddress && (DataType(tagType) == IPv4 || DataType(tagType) == IPv6) {
+			dataType = DataType(tagType)
+		}
+		if tagType != "" && DataType(tagType) != dataType {
+			return nil, fmt.Errorf("table '%s': field %s is tagged %s but has Go type %s (%s)", name, f.Name, tagType, f.Type, dataType)
+		}
+
+		fields = append(fields, structColumn{
+			index:    f.Index,
+			column:   Column{Name: colName, DataType: dataType, Nullable: pointer},
+			pointer:  pointer,
+			unsigned: unsigned,
+		})
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("table '%s': %s has no exported fields", name, typ)
+	}
+
+	columns := make([]Column, len(fields))
+	for i, f := range fields {
+		columns[i] = f.column
+	}
+	table, err := DefineTable(name, columns)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedTable[T]{Table: table, fields: fields}, nil
+}
+
+// InsertStruct inserts v as one row
+func (t *TypedTable[T]) InsertStruct(v T) error {
+	values, err := t.structValues(v)
+	if err != nil {
+		return err
+	}
+	return t.InsertRow(values)
+}
+
+// InsertStructs inserts each element of vs as a row
+func (t *TypedTable[T]) InsertStructs(vs []T) error {
+	rows := make([][]Value, 0, len(vs))
+	for _, v := range vs {
+		values, err := t.structValues(v)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, values)
+	}
+	return t.InsertRows(rows)
+}
+
+// structValues converts a struct to row values in column order
+func (t *TypedTable[T]) structValues(v T) ([]Value, error) {
+	rv := reflect.ValueOf(v)
+	values := make([]Value, len(t.fields))
+	for i, f := range t.fields {
+		fv := rv.FieldByIndex(f.index)
+		if f.pointer {
+			if fv.IsNil() {
+				values[i] = Null()
+				continue
+			}
+			fv = fv.Elem()
+		}
+		value, err := reflectValue(fv, f)
+		if err != nil {
+			return nil, fmt.Errorf("table '%s': %w", t.name, err)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// inferDataType maps a Go type to a column type
+func inferDataType(t reflect.Type) (DataType, bool, bool) {
+	switch t {
+	case timeType:
+		return Timestamp, false, true
+	case durType:
+		return Duration, false, true
+	case addrType:
+		return IPAddress, false, true
+	case subRefType:
+		return SubContentID, false, true
+	case bigIntType:
+		return BigInt, false, true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int64, false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Int64, true, true
+	case reflect.Float32, reflect.Float64:
+		return Float64, false, true
+	case reflect.String:
+		return String, false, true
+	case reflect.Bool:
+		return Bool, false, true
+	case reflect.Array:
+		if t.Len() == 16 && t.Elem().Kind() == reflect.Uint8 {
+			return UUID, false, true
+		}
+	case reflect.Slice:
+		switch elem := t.Elem(); elem.Kind() {
+		case reflect.Uint8:
+			return Bytes, false, true
+		case reflect.String:
+			return StringArray, false, true
+		case reflect.Int64:
+			return Int64Array, false, true
+		case reflect.Slice:
+			if elem.Elem().Kind() == reflect.Uint8 {
+				return BytesArray, false, true
+			}
+		}
+	}
+	return "", false, false
+}
+
+// reflectValue converts a field value to a Value of the column's type
+func reflectValue(fv reflect.Value, f structColumn) (Value, error) {
+	switch f.column.DataType {
+	case Int64:
+		if f.unsigned {
+			u := fv.Uint()
+			if u > math.MaxInt64 {
+				return Value{}, fmt.Errorf("column '%s': value %d overflows Int64", f.column.Name, u)
+			}
+			return NewInt64(int64(u)), nil
+		}
+		return NewInt64(fv.Int()), nil
+	case Float64:
+		return NewFloat64(fv.Float()), nil
+	case String:
+		return NewString(fv.String()), nil
+	case Bool:
+		return NewBool(fv.Bool()), nil
+	case Bytes:
+		return NewBytes(fv.Bytes()), nil
+	case Timestamp:
+		return NewTimestamp(fv.Interface().(time.Time)), nil
+	case Duration:
+		return NewDuration(time.Duration(fv.Int())), nil
+	case StringArray:
+		elems := make([]string, fv.Len())
+		for i := range elems {
+			elems[i] = fv.Index(i).String()
+		}
+		return NewStringArray(elems), nil
+	case Int64Array:
+		elems := make([]int64, fv.Len())
+		for i := range elems {
+			elems[i] = fv.Index(i).Int()
+		}
+		return NewInt64Array(elems), nil
+	case BytesArray:
+		elems := make([][]byte, fv.Len())
+		for i := range elems {
+			elems[i] = fv.Index(i).Bytes()
+		}
+		return NewBytesArray(elems), nil
+	case SubContentID:
+		return NewSubContentRef(fv.Interface().(SubContentRef)), nil
+	case BigInt:
+		n := fv.Interface().(big.Int)
+		return NewBigInt(&n), nil
+	case UUID:
+		var id [16]byte
+		reflect.Copy(reflect.ValueOf(id[:]), fv)
+		return NewUUIDFromBytes(id), nil
+	case IPv4, IPv6:
+		addr := fv.Interface().(netip.Addr)
+		value, err := NewIPv4(addr)
+		if f.column.DataType == IPv6 {
+			value, err = NewIPv6(addr)
+		}
+		if err != nil {
+			return Value{}, fmt.Errorf("column '%s': %w", f.column.Name, err)
+		}
+		return value, nil
+	default:
+		return NewIPAddress(fv.Interface().(netip.Addr)), nil
+	}
+}
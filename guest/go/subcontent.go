@@ -2,6 +2,7 @@ package wadup
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
@@ -12,44 +13,126 @@ var (
 	subcontentCounter int
 )
 
+// ErrSliceOutOfRange is returned when a slice does not lie within the content
+var ErrSliceOutOfRange = errors.New("slice out of content range")
+
 // subContentMetadata represents metadata for bytes emission
 type subContentMetadata struct {
-	Filename string `json:"filename"`
+	Filename      string         `json:"filename"`
+	Relationship  string         `json:"relationship,omitempty"`
+	Resource      *resourceInfo  `json:"resource,omitempty"`
+	ParentRow     *parentRowRef  `json:"parent_row,omitempty"`
+	Flags         *ContentFlags  `json:"flags,omitempty"`
+	AnalyzedBy    []string       `json:"analyzed_by,omitempty"`
+	FormatVersion string         `json:"format_version,omitempty"`
+	Method        string         `json:"method,omitempty"`
+	Collection    *collectionRef `json:"collection,omitempty"`
+	Fragments     []SliceSpec    `json:"fragments,omitempty"`
+	Tags          []string       `json:"tags,omitempty"`
+	Parsers       []string       `json:"suggested_parsers,omitempty"`
+	SourcePath    string         `json:"source_path,omitempty"`
+	Encoding      Compression    `json:"encoding,omitempty"`
+}
+
+// parentRowRef identifies the parent table row a child belongs to
+type parentRowRef struct {
+	Table    string `json:"table"`
+	RowIndex int    `json:"row_index"`
+}
+
+// resourceInfo identifies an extracted resource within its parent
+type resourceInfo struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
 }
 
 // subContentSliceMetadata represents metadata for slice emission
 type subContentSliceMetadata struct {
-	Filename string `json:"filename"`
-	Offset   int64  `json:"offset"`
-	Length   int64  `json:"length"`
+	Filename      string        `json:"filename"`
+	Offset        int64         `json:"offset"`
+	Length        int64         `json:"length"`
+	AnalyzedBy    []string      `json:"analyzed_by,omitempty"`
+	Relationship  string        `json:"relationship,omitempty"`
+	Flags         *ContentFlags `json:"flags,omitempty"`
+	FormatVersion string        `json:"format_version,omitempty"`
+	Method        string        `json:"method,omitempty"`
+	Tags          []string      `json:"tags,omitempty"`
+	Parsers       []string      `json:"suggested_parsers,omitempty"`
+	ParentRef     string        `json:"parent_ref,omitempty"`
 }
 
 // EmitBytes emits sub-content bytes for recursive processing.
 //
 // Writes data to /subcontent/data_N.bin and metadata to /subcontent/metadata_N.json.
 // WADUP processes the sub-content when the metadata file is closed.
-func EmitBytes(data []byte, filename string) error {
+// The returned reference can be inserted into a SubContentID column to link
+// a row to the child.
+func EmitBytes(data []byte, filename string) (SubContentRef, error) {
+	return emitBytes(data, subContentMetadata{Filename: filename})
+}
+
+// emitBytes writes the data file followed by the given metadata file
+func emitBytes(data []byte, metadata subContentMetadata) (SubContentRef, error) {
+	if !passesGate(data, metadata.Filename) {
+		return SubContentRef{}, nil
+	}
+
+	n := nextSubContentIndex()
+	ref := SubContentRef{index: n, emitted: true, filename: metadata.Filename}
+	if t := currentTransport(); t != nil {
+		return ref, emitToTransport(t, SubContent{
+			Index:    n,
+			Filename: metadata.Filename,
+			Data:     append([]byte{}, data...),
+			Length:   int64(len(data)),
+			Options:  metadata.emitOptions(),
+		})
+	}
+	payload := data
+	if metadata.Encoding != "" {
+		var err error
+		if payload, err = compressPayload(data, metadata.Encoding); err != nil {
+			return SubContentRef{}, err
+		}
+	}
+
+	// Write data file first
+	if err := writeSubContentData(n, payload); err != nil {
+		return SubContentRef{}, err
+	}
+	if err := writeBytesMetadata(n, metadata, int64(len(data))); err != nil {
+		return SubContentRef{}, err
+	}
+	return ref, nil
+}
+
+// nextSubContentIndex allocates the N used in subcontent file names
+func nextSubContentIndex() int {
 	subcontentMu.Lock()
+	defer subcontentMu.Unlock()
 	n := subcontentCounter
 	subcontentCounter++
-	subcontentMu.Unlock()
+	return n
+}
 
+// writeSubContentData writes /subcontent/data_N.bin
+func writeSubContentData(n int, data []byte) error {
 	dataPath := fmt.Sprintf("/subcontent/data_%d.bin", n)
-	metadataPath := fmt.Sprintf("/subcontent/metadata_%d.json", n)
-
-	// Write data file first
 	dataFile, err := os.Create(dataPath)
 	if err != nil {
 		return fmt.Errorf("failed to create subcontent data file '%s': %w", dataPath, err)
 	}
+	defer dataFile.Close()
 	if _, err := dataFile.Write(data); err != nil {
-		dataFile.Close()
 		return fmt.Errorf("failed to write subcontent data file '%s': %w", dataPath, err)
 	}
-	dataFile.Close()
+	return nil
+}
 
-	// Write metadata file (triggers processing when closed)
-	metadata := subContentMetadata{Filename: filename}
+// writeSubContentMetadata writes /subcontent/metadata_N.json. Closing the
+// metadata file triggers processing, so it is written last.
+func writeSubContentMetadata(n int, metadata interface{}) error {
+	metadataPath := fmt.Sprintf("/subcontent/metadata_%d.json", n)
 	jsonData, err := json.Marshal(metadata)
 	if err != nil {
 		return fmt.Errorf("failed to serialize subcontent metadata: %w", err)
@@ -64,7 +147,24 @@ func EmitBytes(data []byte, filename string) error {
 	if _, err := metaFile.Write(jsonData); err != nil {
 		return fmt.Errorf("failed to write subcontent metadata file '%s': %w", metadataPath, err)
 	}
+	return nil
+}
+
+// writeBytesMetadata writes /subcontent/metadata_N.json for a data file that
+// has already been written and closed. Closing the metadata file triggers
+// processing.
+func writeBytesMetadata(n int, metadata subContentMetadata, size int64) error {
+	metadata.AnalyzedBy = analyzedByChain()
+	if err := writeSubContentMetadata(n, metadata); err != nil {
+		return err
+	}
 
+	recordEmission(EmissionRecord{
+		Index:    n,
+		Filename: metadata.Filename,
+		Kind:     EmissionBytes,
+		Length:   size,
+	})
 	return nil
 }
 
@@ -72,33 +172,86 @@ func EmitBytes(data []byte, filename string) error {
 //
 // The slice references a range of the original /data.bin content without copying.
 // Only writes metadata to /subcontent/metadata_N.json.
-func EmitSlice(offset, length int64, filename string) error {
-	subcontentMu.Lock()
-	n := subcontentCounter
-	subcontentCounter++
-	subcontentMu.Unlock()
-
-	metadataPath := fmt.Sprintf("/subcontent/metadata_%d.json", n)
-
-	metadata := subContentSliceMetadata{
+// Returns an error wrapping ErrSliceOutOfRange if the range does not lie
+// within /data.bin. The returned reference links table rows to the child, as
+// with EmitBytes.
+func EmitSlice(offset, length int64, filename string) (SubContentRef, error) {
+	if err := checkSliceBounds(offset, length); err != nil {
+		return SubContentRef{}, err
+	}
+	return emitSlice(subContentSliceMetadata{
 		Filename: filename,
 		Offset:   offset,
 		Length:   length,
-	}
-	jsonData, err := json.Marshal(metadata)
+	})
+}
+
+// EmitSliceClamped emits a slice like EmitSlice, but shortens a range that
+// extends past the end of /data.bin instead of failing. The offset itself must
+// still lie within the content.
+func EmitSliceClamped(offset, length int64, filename string) (SubContentRef, error) {
+	size, err := contentSize()
 	if err != nil {
-		return fmt.Errorf("failed to serialize subcontent slice metadata: %w", err)
+		return SubContentRef{}, err
 	}
+	if offset < 0 || length < 0 || offset >= size {
+		return SubContentRef{}, fmt.Errorf("%w: offset %d, length %d, content size %d", ErrSliceOutOfRange, offset, length, size)
+	}
+	return emitSlice(subContentSliceMetadata{
+		Filename: filename,
+		Offset:   offset,
+		Length:   min(length, size-offset),
+	})
+}
 
-	metaFile, err := os.Create(metadataPath)
+// checkSliceBounds verifies that a range lies within /data.bin
+func checkSliceBounds(offset, length int64) error {
+	size, err := contentSize()
 	if err != nil {
-		return fmt.Errorf("failed to create subcontent metadata file '%s': %w", metadataPath, err)
+		return err
 	}
-	defer metaFile.Close()
+	if offset < 0 || length < 0 || offset > size || length > size-offset {
+		return fmt.Errorf("%w: offset %d, length %d, content size %d", ErrSliceOutOfRange, offset, length, size)
+	}
+	return nil
+}
 
-	if _, err := metaFile.Write(jsonData); err != nil {
-		return fmt.Errorf("failed to write subcontent metadata file '%s': %w", metadataPath, err)
+// emitSlice writes the metadata file for a slice emission
+func emitSlice(metadata subContentSliceMetadata) (SubContentRef, error) {
+	if !passesGate(nil, metadata.Filename) {
+		return SubContentRef{}, nil
+	}
+	// Overlap is only tracked for ranges of /data.bin
+	if metadata.ParentRef == "" {
+		if err := claimSliceRange(metadata.Offset, metadata.Length); err != nil {
+			return SubContentRef{}, err
+		}
 	}
 
-	return nil
+	n := nextSubContentIndex()
+	ref := SubContentRef{index: n, emitted: true, filename: metadata.Filename}
+	if t := currentTransport(); t != nil {
+		return ref, emitToTransport(t, SubContent{
+			Index:     n,
+			Filename:  metadata.Filename,
+			Slice:     true,
+			Offset:    metadata.Offset,
+			Length:    metadata.Length,
+			ParentRef: metadata.ParentRef,
+			Options:   metadata.emitOptions(),
+		})
+	}
+	metadata.AnalyzedBy = analyzedByChain()
+	if err := writeSubContentMetadata(n, metadata); err != nil {
+		return SubContentRef{}, err
+	}
+
+	recordEmission(EmissionRecord{
+		Index:    n,
+		Filename: metadata.Filename,
+		Kind:     EmissionSlice,
+		Offset:   metadata.Offset,
+		Length:   metadata.Length,
+	})
+	return ref, nil
 }
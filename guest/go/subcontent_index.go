@@ -0,0 +1,123 @@
+package wadup
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SubContentIndexTable is the conventional table written by EmitSubContentIndex
+const SubContentIndexTable = "subcontent_index"
+
+// Kinds of sub-content emission
+const (
+	EmissionBytes = "bytes"
+	EmissionSlice = "slice"
+)
+
+// EmissionRecord describes one successful sub-content emission
+type EmissionRecord struct {
+	// Index is the N of the /subcontent/metadata_N.json file
+	Index int
+	// Ref identifies the emission for EmitSliceOf
+	Ref      string
+	Filename string
+	// Kind is EmissionBytes or EmissionSlice
+	Kind string
+	// Offset is the start of a slice within /data.bin (zero for bytes)
+	Offset int64
+	// Length is the size of the emitted bytes or slice
+	Length int64
+	// MimeHint is the MIME type suggested for the child, if any
+	MimeHint string
+}
+
+var (
+	emissionsMu  sync.Mutex
+	emissions    []EmissionRecord
+	emittedSizes = make(map[string]int64)
+	lastEmitted  string
+)
+
+// recordEmission tracks a completed emission
+func recordEmission(record EmissionRecord) {
+	emissionsMu.Lock()
+	defer emissionsMu.Unlock()
+	record.Ref = fmt.Sprintf("subcontent:%d", record.Index)
+	emissions = append(emissions, record)
+	emittedSizes[record.Ref] = record.Length
+	lastEmitted = record.Ref
+}
+
+// LastEmittedRef returns the reference of the most recent successful
+// emission, or "" if nothing has been emitted.
+func LastEmittedRef() string {
+	emissionsMu.Lock()
+	defer emissionsMu.Unlock()
+	return lastEmitted
+}
+
+// EmittedSubContent returns a snapshot of the sub-content emitted since the
+// last call to EmitSubContentIndex or ResetEmittedSubContent.
+func EmittedSubContent() []EmissionRecord {
+	emissionsMu.Lock()
+	defer emissionsMu.Unlock()
+	return append([]EmissionRecord(nil), emissions...)
+}
+
+// ResetEmittedSubContent clears the tracked emissions. Modules that do not
+// call EmitSubContentIndex should call it at the start of each run, since
+// module instances are reused across content items.
+func ResetEmittedSubContent() {
+	emissionsMu.Lock()
+	defer emissionsMu.Unlock()
+	emissions = nil
+}
+
+// resetEmittedRefs forgets the sizes and the last reference of the previous
+// content's emissions, which only refer to that content's children
+func resetEmittedRefs() {
+	emissionsMu.Lock()
+	defer emissionsMu.Unlock()
+	clear(emittedSizes)
+	lastEmitted = ""
+}
+
+// EmitSubContentIndex inserts one row per tracked emission (index, filename,
+// kind, offset, length, mime_hint) into the subcontent_index table, giving a
+// single queryable list of everything the module extracted.
+//
+// Call it near the end of the run. The tracked emissions are cleared
+// afterwards so the next content item starts with an empty index.
+func EmitSubContentIndex() error {
+	emissionsMu.Lock()
+	records := emissions
+	emissions = nil
+	emissionsMu.Unlock()
+
+	table, err := NewTableBuilder(SubContentIndexTable).
+		Column("index", Int64).
+		Column("filename", String).
+		Column("kind", String).
+		Column("offset", Int64).
+		Column("length", Int64).
+		Column("mime_hint", String).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		err := table.InsertRow([]Value{
+			NewInt64(int64(r.Index)),
+			NewString(r.Filename),
+			NewString(r.Kind),
+			NewInt64(r.Offset),
+			NewInt64(r.Length),
+			NewString(r.MimeHint),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
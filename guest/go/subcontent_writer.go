@@ -0,0 +1,102 @@
+package wadup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// SubContentWriter streams sub-content to /subcontent/data_N.bin so large
+// children never have to be held in memory. The child is emitted when the
+// writer is closed.
+type SubContentWriter struct {
+	mu       sync.Mutex
+	n        int
+	file     *os.File
+	metadata subContentMetadata
+	size     int64
+	// With a custom transport the data is buffered for EmitSubContent
+	transport Transport
+	buf       []byte
+	closed    bool
+	skipped   bool
+}
+
+// NewSubContentWriter starts a streamed sub-content emission.
+//
+// Data written to the returned writer goes straight to the data file; Close
+// writes the metadata file, which triggers processing. If the emit gate
+// rejects the emission, writes are discarded and Close emits nothing. The gate
+// receives nil data since the content is not known yet.
+func NewSubContentWriter(filename string) (io.WriteCloser, error) {
+	return newSubContentWriter(subContentMetadata{Filename: filename})
+}
+
+func newSubContentWriter(metadata subContentMetadata) (*SubContentWriter, error) {
+	if !passesGate(nil, metadata.Filename) {
+		return &SubContentWriter{metadata: metadata, skipped: true}, nil
+	}
+
+	n := nextSubContentIndex()
+	if t := currentTransport(); t != nil {
+		return &SubContentWriter{n: n, metadata: metadata, transport: t}, nil
+	}
+	dataPath := fmt.Sprintf("/subcontent/data_%d.bin", n)
+	file, err := os.Create(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subcontent data file '%s': %w", dataPath, err)
+	}
+	return &SubContentWriter{n: n, file: file, metadata: metadata}, nil
+}
+
+// Write appends p to the sub-content data
+func (w *SubContentWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, fmt.Errorf("write to closed subcontent writer for '%s'", w.metadata.Filename)
+	}
+	if w.skipped {
+		return len(p), nil
+	}
+	if w.transport != nil {
+		w.buf = append(w.buf, p...)
+		w.size += int64(len(p))
+		return len(p), nil
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("failed to write subcontent data file '%s': %w", w.file.Name(), err)
+	}
+	return n, nil
+}
+
+// Close finishes the data file and emits the sub-content. Calling Close more
+// than once is a no-op.
+func (w *SubContentWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.skipped {
+		return nil
+	}
+	if w.transport != nil {
+		return emitToTransport(w.transport, SubContent{
+			Index:    w.n,
+			Filename: w.metadata.Filename,
+			Data:     w.buf,
+			Length:   w.size,
+			Options:  w.metadata.emitOptions(),
+		})
+	}
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close subcontent data file '%s': %w", w.file.Name(), err)
+	}
+	return writeBytesMetadata(w.n, w.metadata, w.size)
+}
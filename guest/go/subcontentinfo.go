@@ -0,0 +1,27 @@
+package wadup
+
+import "errors"
+
+// SubContentInfo is what the host recorded for an emitted sub-content item
+type SubContentInfo struct {
+	// Filename is the name the child is stored under after the host's naming
+	// policy normalized its path and uniquified repeated names
+	Filename string `json:"filename"`
+	// ContentID is the child's content ID
+	ContentID string `json:"content_id"`
+}
+
+// Info returns the filename and content ID the host assigned to the
+// sub-content. Hosts without FeatureSubContentInfo do not report them, so
+// Info falls back to the requested filename and an empty ContentID.
+func (r SubContentRef) Info() (SubContentInfo, error) {
+	if !r.emitted {
+		return SubContentInfo{}, errors.New("sub-content was not emitted")
+	}
+
+	info, ok, err := hostSubContentInfo(r.index)
+	if ok {
+		return info, err
+	}
+	return SubContentInfo{Filename: r.filename}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package wadup
+
+// hostSubContentInfo reports that the host cannot describe sub-content, since
+// there is no subcontent_info import outside preview1 builds
+func hostSubContentInfo(int) (SubContentInfo, bool, error) {
+	return SubContentInfo{}, false, nil
+}
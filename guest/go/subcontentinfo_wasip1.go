@@ -0,0 +1,38 @@
+//go:build wasip1
+
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+// subcontentInfoImport copies the JSON description of sub-content index into
+// out, returning its length. Nothing is copied if out is too small.
+//
+//go:wasmimport wadup subcontent_info
+func subcontentInfoImport(index int64, out unsafe.Pointer, outLen uint32) int32
+
+// hostSubContentInfo asks the host what it recorded for sub-content index if
+// it supports it. ok is false if the host cannot say.
+func hostSubContentInfo(index int) (info SubContentInfo, ok bool, err error) {
+	if !HostSupports(FeatureSubContentInfo) {
+		return SubContentInfo{}, false, nil
+	}
+
+	buf := make([]byte, 256)
+	for {
+		n := subcontentInfoImport(int64(index), unsafe.Pointer(unsafe.SliceData(buf)), uint32(len(buf)))
+		if n < 0 {
+			return SubContentInfo{}, false, nil
+		}
+		if int(n) <= len(buf) {
+			if err := json.Unmarshal(buf[:n], &info); err != nil {
+				return SubContentInfo{}, true, fmt.Errorf("failed to parse sub-content info: %w", err)
+			}
+			return info, true, nil
+		}
+		buf = make([]byte, n)
+	}
+}
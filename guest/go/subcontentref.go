@@ -0,0 +1,26 @@
+package wadup
+
+// SubContentRef identifies a sub-content item emitted while processing the
+// current content, so table rows can point at it. Insert it into a
+// SubContentID column with NewSubContentRef; the host replaces it with the
+// child's content ID.
+type SubContentRef struct {
+	index    int
+	emitted  bool
+	filename string
+}
+
+// Emitted reports whether the sub-content was emitted. It is false when the
+// emit gate rejected it.
+func (r SubContentRef) Emitted() bool {
+	return r.emitted
+}
+
+// NewSubContentRef creates a SubContentID value referring to an emitted
+// sub-content item, or NULL if the item was not emitted
+func NewSubContentRef(r SubContentRef) Value {
+	if !r.emitted {
+		return Null()
+	}
+	return Value{data: r}
+}
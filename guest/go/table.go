@@ -1,26 +1,134 @@
 package wadup
 
-// Table represents a defined table that can accept row insertions
+import (
+	"fmt"
+	"sync"
+)
+
+// Table represents a defined table that can accept row insertions.
+//
+// A Table is safe for use by multiple goroutines. Each table buffers its own
+// rows and hands them to the shared metadata buffer in batches, so goroutines
+// inserting into different tables rarely contend.
 type Table struct {
-	name string
+	name    string
+	columns []Column
+
+	mu      sync.Mutex
+	pending []rowDef
 }
 
 // DefineTable defines a new table with the given columns
 func DefineTable(name string, columns []Column) (*Table, error) {
+	if err := checkColumns(name, columns); err != nil {
+		return nil, err
+	}
 	addTable(name, columns)
-	return &Table{name: name}, nil
+	return &Table{name: name, columns: columns}, nil
+}
+
+// checkColumns validates the column options of a table definition
+func checkColumns(name string, columns []Column) error {
+	for _, col := range columns {
+		if col.PrimaryKey && col.Nullable {
+			return fmt.Errorf("table '%s': primary key column '%s' must not be nullable", name, col.Name)
+		}
+		if col.Dictionary && col.DataType != String {
+			return fmt.Errorf("table '%s': dictionary column '%s' must be String", name, col.Name)
+		}
+	}
+	return nil
+}
+
+// SharedTable defines a table that several modules write into together, such
+// as "strings" or "iocs". When the host namespaces tables by module, a shared
+// table keeps its plain name, so rows from every module land in one table.
+// All modules must define it with compatible columns.
+func SharedTable(name string, columns []Column) (*Table, error) {
+	markShared(name)
+	return DefineTable(name, columns)
 }
 
 // InsertRow inserts a row of values into the table
 func (t *Table) InsertRow(values []Value) error {
-	addRow(t.name, values)
+	row, err := t.prepareRow(values)
+	if err != nil {
+		return err
+	}
+	return t.buffer([]rowDef{row})
+}
+
+// InsertRows inserts several rows at once.
+//
+// All rows are validated, including against uniqueness constraints when
+// enforced (see SetEnforceUniqueness), before any is added, so on error none
+// of them are inserted. With a batch size set (see SetBatchSize) the rows are
+// written out in batches as they accumulate.
+func (t *Table) InsertRows(rows [][]Value) error {
+	prepared := make([]rowDef, 0, len(rows))
+	for i, values := range rows {
+		row, err := t.prepareRow(values)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		prepared = append(prepared, row)
+	}
+	return t.buffer(prepared)
+}
+
+// Close writes any rows still buffered for output. The table stays usable.
+func (t *Table) Close() error {
+	return Finish()
+}
+
+// prepareRow validates a row against the schema and applies the value limits
+func (t *Table) prepareRow(values []Value) (rowDef, error) {
+	if err := t.checkRow(values); err != nil {
+		return rowDef{}, err
+	}
+	values, truncated, err := applyStringLimit(t.name, t.columns, values)
+	if err != nil {
+		return rowDef{}, err
+	}
+	return rowDef{
+		TableName:        t.name,
+		Values:           values,
+		Truncated:        len(truncated) > 0,
+		TruncatedColumns: truncated,
+	}, nil
+}
+
+// checkRow validates the value count and each value's type against the
+// table's columns, so schema violations surface in the guest rather than
+// in the host
+func (t *Table) checkRow(values []Value) error {
+	if len(values) != len(t.columns) {
+		return fmt.Errorf("table '%s' has %d columns, got %d values", t.name, len(t.columns), len(values))
+	}
+	for i, v := range values {
+		col := t.columns[i]
+		if v.IsNull() {
+			if !col.Nullable {
+				return fmt.Errorf("table '%s': column '%s' is not nullable", t.name, col.Name)
+			}
+			continue
+		}
+		got := v.dataType()
+		if got == col.DataType || (col.DataType == Bytes && got == BytesRef) {
+			continue
+		}
+		return fmt.Errorf("table '%s': column '%s' expects %s, got %s", t.name, col.Name, col.DataType, got)
+	}
 	return nil
 }
 
 // TableBuilder provides a fluent API for building tables
 type TableBuilder struct {
-	name    string
-	columns []Column
+	name         string
+	columns      []Column
+	aggregates   []aggregateSpec
+	noProvenance bool
+	shared       bool
 }
 
 // NewTableBuilder creates a new table builder
@@ -40,7 +148,88 @@ func (b *TableBuilder) Column(name string, dataType DataType) *TableBuilder {
 	return b
 }
 
+// Nullable marks the most recently added column as accepting NULL values
+func (b *TableBuilder) Nullable() *TableBuilder {
+	if len(b.columns) > 0 {
+		b.columns[len(b.columns)-1].Nullable = true
+	}
+	return b
+}
+
+// PrimaryKey marks the most recently added column as part of the table's
+// primary key. Marking several columns makes a composite key.
+func (b *TableBuilder) PrimaryKey() *TableBuilder {
+	if len(b.columns) > 0 {
+		b.columns[len(b.columns)-1].PrimaryKey = true
+	}
+	return b
+}
+
+// Unique marks the most recently added column as holding distinct values
+func (b *TableBuilder) Unique() *TableBuilder {
+	if len(b.columns) > 0 {
+		b.columns[len(b.columns)-1].Unique = true
+	}
+	return b
+}
+
+// Dictionary marks the most recently added column as dictionary-encoded (see
+// Column.Dictionary)
+func (b *TableBuilder) Dictionary() *TableBuilder {
+	if len(b.columns) > 0 {
+		b.columns[len(b.columns)-1].Dictionary = true
+	}
+	return b
+}
+
+// WithoutProvenance builds the table with the host's provenance fields
+// turned off (see DisableProvenance)
+func (b *TableBuilder) WithoutProvenance() *TableBuilder {
+	b.noProvenance = true
+	return b
+}
+
+// Shared builds the table as a shared table (see SharedTable)
+func (b *TableBuilder) Shared() *TableBuilder {
+	b.shared = true
+	return b
+}
+
+// Aggregate accumulates fn over col as rows are inserted.
+// The result is written to the aggregates sidecar on Flush, and the host
+// indexes it as an aggregate document. Sums need an Int64 or Float64 column;
+// min and max need an Int64, Float64, String, Timestamp or Duration column.
+func (b *TableBuilder) Aggregate(col string, fn AggFunc) *TableBuilder {
+	return b.AggregateBy(col, fn, "")
+}
+
+// AggregateBy accumulates fn over col separately for each distinct value
+// of the groupBy column.
+func (b *TableBuilder) AggregateBy(col string, fn AggFunc, groupBy string) *TableBuilder {
+	b.aggregates = append(b.aggregates, aggregateSpec{
+		column:  col,
+		fn:      fn,
+		groupBy: groupBy,
+	})
+	return b
+}
+
 // Build creates the table
 func (b *TableBuilder) Build() (*Table, error) {
-	return DefineTable(b.name, b.columns)
+	aggregates, err := newAggregators(b.name, b.columns, b.aggregates)
+	if err != nil {
+		return nil, err
+	}
+	if b.noProvenance {
+		DisableProvenance(b.name)
+	}
+	if b.shared {
+		markShared(b.name)
+	}
+	table, err := DefineTable(b.name, b.columns)
+	if err != nil {
+		return nil, err
+	}
+	setTableAggregates(b.name, aggregates)
+	return table, nil
 }
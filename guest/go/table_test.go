@@ -0,0 +1,115 @@
+package wadup
+
+import (
+	"sync"
+	"testing"
+)
+
+// recordingTransport collects the tables and rows handed to a custom
+// transport
+type recordingTransport struct {
+	mu         sync.Mutex
+	tables     []TableSchema
+	rows       map[string][][]Value
+	subContent []SubContent
+}
+
+func (r *recordingTransport) DefineTable(schema TableSchema) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tables = append(r.tables, schema)
+	return nil
+}
+
+func (r *recordingTransport) InsertRows(table string, rows [][]Value) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rows == nil {
+		r.rows = make(map[string][][]Value)
+	}
+	r.rows[table] = append(r.rows[table], rows...)
+	return nil
+}
+
+func (r *recordingTransport) EmitSubContent(sc SubContent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subContent = append(r.subContent, sc)
+	return nil
+}
+
+func (r *recordingTransport) Log(LogLevel, string) error {
+	return nil
+}
+
+// useRecordingTransport routes output to a recordingTransport for the rest
+// of the test and restores the batching defaults afterwards
+func useRecordingTransport(t *testing.T) *recordingTransport {
+	t.Helper()
+	r := &recordingTransport{}
+	SetTransport(r)
+	t.Cleanup(func() {
+		if err := Finish(); err != nil {
+			t.Errorf("Finish failed: %v", err)
+		}
+		SetTransport(nil)
+		SetBatchSize(0)
+		SetBatchBytes(DefaultBatchBytes)
+		resetTableState(true)
+	})
+	return r
+}
+
+func TestConcurrentInsertRow(t *testing.T) {
+	r := useRecordingTransport(t)
+	// Small batches make inserting goroutines flush while others insert
+	SetBatchSize(7)
+
+	table, err := DefineTable("concurrent_rows", []Column{
+		{Name: "worker", DataType: Int64},
+		{Name: "seq", DataType: Int64},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, perWorker = 8, 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int64) {
+			defer wg.Done()
+			for i := int64(0); i < perWorker; i++ {
+				if err := table.InsertRow([]Value{NewInt64(worker), NewInt64(i)}); err != nil {
+					t.Errorf("InsertRow failed: %v", err)
+					return
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	if count, ok := tableRowCount("concurrent_rows"); !ok || count != workers*perWorker {
+		t.Errorf("tableRowCount = %d, %v; want %d, true", count, ok, workers*perWorker)
+	}
+	if err := Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every row arrives once, and each worker's rows in insertion order
+	rows := r.rows["concurrent_rows"]
+	if len(rows) != workers*perWorker {
+		t.Fatalf("got %d rows, want %d", len(rows), workers*perWorker)
+	}
+	next := make(map[string]int64)
+	for _, row := range rows {
+		worker, seq := row[0].String(), row[1].String()
+		if want := next[worker]; seq != NewInt64(want).String() {
+			t.Fatalf("worker %s: got row %s, want %d", worker, seq, want)
+		}
+		next[worker]++
+	}
+	if len(r.tables) != 1 || r.tables[0].Name != "concurrent_rows" {
+		t.Errorf("tables = %v, want concurrent_rows defined once", r.tables)
+	}
+}
@@ -0,0 +1,86 @@
+package wadup
+
+import "sync"
+
+// tableBufferRows is the number of rows a table buffers before handing them
+// to the shared metadata buffer
+const tableBufferRows = 64
+
+var (
+	pendingMu sync.Mutex
+	// pendingTables holds the tables that may have buffered rows, in the
+	// order their first buffered row arrived
+	pendingTables []*Table
+	pendingSet    = make(map[*Table]struct{})
+)
+
+// buffer claims the rows' unique keys and adds them to the table's buffer,
+// handing the buffer over once it is full. The rows stay together and in
+// order, even when other goroutines insert into the same table.
+func (t *Table) buffer(rows []rowDef) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.claimKeys(rows); err != nil {
+		return err
+	}
+	if len(t.pending) == 0 {
+		markPending(t)
+	}
+	t.pending = append(t.pending, rows...)
+	if len(t.pending) < tableBufferRows {
+		return nil
+	}
+	return t.drainLocked()
+}
+
+// markPending queues a table for the next drainTables
+func markPending(t *Table) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if _, ok := pendingSet[t]; !ok {
+		pendingSet[t] = struct{}{}
+		pendingTables = append(pendingTables, t)
+	}
+}
+
+// drainLocked hands the table's buffered rows to the shared metadata buffer.
+// Rows the metadata buffer didn't take because a write failed stay buffered,
+// ahead of any inserted later, and are handed over by the next drain.
+// Caller must hold t.mu, and must not hold metadataMu: tables are always
+// locked first.
+func (t *Table) drainLocked() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	rows := t.pending
+	t.pending = nil
+	n, err := addRows(rows)
+	if n < len(rows) {
+		t.pending = rows[n:]
+		markPending(t)
+	}
+	return err
+}
+
+// drainTables hands the rows buffered by every table to the shared metadata
+// buffer, ahead of a flush or a row count. Tables are drained in the order
+// their rows arrived, so files list the tables the same way on every run.
+// Caller must not hold metadataMu.
+func drainTables() error {
+	pendingMu.Lock()
+	tables := pendingTables
+	pendingTables = nil
+	clear(pendingSet)
+	pendingMu.Unlock()
+
+	var firstErr error
+	for _, t := range tables {
+		t.mu.Lock()
+		err := t.drainLocked()
+		t.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,106 @@
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// PreviewDir is where preview artifacts are written. Files here are linked to
+// the current content for display and are never processed as sub-content.
+const PreviewDir = "/preview"
+
+// MaxThumbnailBytes bounds the total size of one EmitPageThumbnails call
+const MaxThumbnailBytes = 8 << 20
+
+// PageThumbnail is a rendered preview of one document page
+type PageThumbnail struct {
+	// Page is the one-based page number
+	Page     int
+	MimeType string
+	Data     []byte
+}
+
+// thumbnailEntry describes one thumbnail in the preview manifest
+type thumbnailEntry struct {
+	Page     int    `json:"page"`
+	MimeType string `json:"mime_type"`
+	Path     string `json:"path"`
+	Size     int    `json:"size"`
+}
+
+// thumbnailManifest is the preview manifest written by EmitPageThumbnails
+type thumbnailManifest struct {
+	Thumbnails []thumbnailEntry `json:"thumbnails"`
+}
+
+var (
+	previewMu      sync.Mutex
+	previewCounter int
+)
+
+// EmitPageThumbnails writes page thumbnails to the preview area and links them
+// to the current content, ordered by page number.
+//
+// Each thumbnail is written to /preview/thumb_N.bin and a manifest listing
+// page, MIME type and path is written last to /preview/thumbnails_N.json.
+// Thumbnails are for display only and are not recursively processed. The
+// combined size of all thumbnails must not exceed MaxThumbnailBytes.
+func EmitPageThumbnails(thumbs []PageThumbnail) error {
+	if len(thumbs) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, t := range thumbs {
+		if t.Page < 1 {
+			return fmt.Errorf("invalid thumbnail page number %d", t.Page)
+		}
+		if t.MimeType == "" {
+			return fmt.Errorf("thumbnail for page %d has no MIME type", t.Page)
+		}
+		total += len(t.Data)
+	}
+	if total > MaxThumbnailBytes {
+		return fmt.Errorf("thumbnails total %d bytes, exceeding the limit of %d", total, MaxThumbnailBytes)
+	}
+
+	ordered := append([]PageThumbnail(nil), thumbs...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Page < ordered[j].Page })
+
+	if err := os.MkdirAll(PreviewDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create preview directory '%s': %w", PreviewDir, err)
+	}
+
+	previewMu.Lock()
+	defer previewMu.Unlock()
+
+	manifest := thumbnailManifest{Thumbnails: make([]thumbnailEntry, 0, len(ordered))}
+	for _, t := range ordered {
+		path := fmt.Sprintf("%s/thumb_%d.bin", PreviewDir, previewCounter)
+		previewCounter++
+		if err := os.WriteFile(path, t.Data, 0o644); err != nil {
+			return fmt.Errorf("failed to write thumbnail file '%s': %w", path, err)
+		}
+		manifest.Thumbnails = append(manifest.Thumbnails, thumbnailEntry{
+			Page:     t.Page,
+			MimeType: t.MimeType,
+			Path:     path,
+			Size:     len(t.Data),
+		})
+	}
+
+	jsonData, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to serialize thumbnail manifest: %w", err)
+	}
+	manifestPath := fmt.Sprintf("%s/thumbnails_%d.json", PreviewDir, previewCounter)
+	previewCounter++
+	if err := os.WriteFile(manifestPath, jsonData, 0o644); err != nil {
+		return fmt.Errorf("failed to write thumbnail manifest '%s': %w", manifestPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package wadup
+
+import (
+	"sync"
+)
+
+// Transport delivers a module's output to the host.
+//
+// The transport is chosen by the build, so module code compiles unchanged
+// for either host interface: preview1 builds use FileTransport, the files in
+// /metadata, /subcontent and /log, and component builds (the wasip2 tag) use
+// the wadup:guest/host imports. SetTransport replaces it, which lets parser
+// modules be exercised in ordinary unit tests without a WASM runtime.
+//
+// Table definitions and rows are handed over when metadata is flushed, rows
+// in insertion order within each table, one call at a time even when tables
+// are written from several goroutines. Scan status, attributes and aggregates exist only in the
+// file protocol and are not passed to custom transports.
+type Transport interface {
+	// DefineTable declares a table; rows for it follow in later calls
+	DefineTable(schema TableSchema) error
+	// InsertRows delivers rows for a defined table
+	InsertRows(table string, rows [][]Value) error
+	// EmitSubContent delivers one sub-content emission
+	EmitSubContent(sc SubContent) error
+	// Log delivers a message that passed the SetLogLevel filter
+	Log(level LogLevel, message string) error
+}
+
+// ContentSource is implemented by transports that supply the content being
+// processed. Without it, content is read from /data.bin.
+type ContentSource interface {
+	// ContentFile returns the path of a file holding the content
+	ContentFile() string
+}
+
+// SubContent is a sub-content emission handed to a Transport
+type SubContent struct {
+	// Index is the N the file protocol would use in data_N.bin
+	Index    int
+	Filename string
+	// Data holds the child's bytes; it is nil for slices
+	Data []byte
+	// Slice is set when the child is a range of the content, or of an
+	// earlier emission if ParentRef is set
+	Slice     bool
+	Offset    int64
+	Length    int64
+	ParentRef string
+	// Options carries the attributes recorded with the emission
+	Options EmitOptions
+}
+
+var (
+	transportMu     sync.Mutex
+	customTransport Transport
+)
+
+// SetTransport replaces the build's transport. Passing nil restores it.
+//
+// The transport should be set before any table is defined; data buffered
+// when it changes is delivered to the new transport on the next flush.
+func SetTransport(t Transport) {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	customTransport = t
+}
+
+// activeTransport returns the transport output goes to
+func activeTransport() Transport {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	if customTransport != nil {
+		return customTransport
+	}
+	return defaultTransport()
+}
+
+// currentTransport returns the transport output is handed to, or nil when it
+// is FileTransport, whose files the library writes itself
+func currentTransport() Transport {
+	t := activeTransport()
+	if _, ok := t.(FileTransport); ok {
+		return nil
+	}
+	return t
+}
+
+// contentPath returns the file holding the content being processed
+func contentPath() string {
+	if src, ok := activeTransport().(ContentSource); ok {
+		return src.ContentFile()
+	}
+	return ContentPath
+}
+
+// flushToTransport hands the accumulated tables and rows to a custom
+// transport. Caller must hold metadataMu.
+func flushToTransport(t Transport) error {
+	for _, table := range accumulatedTabs {
+		schema := TableSchema{Name: table.Name, Columns: append([]Column(nil), table.Columns...)}
+		if err := t.DefineTable(schema); err != nil {
+			return err
+		}
+	}
+
+	// Consecutive rows for the same table are delivered together
+	rows := accumulatedRows
+	for len(rows) > 0 {
+		n := 1
+		for n < len(rows) && rows[n].TableName == rows[0].TableName {
+			n++
+		}
+		values := make([][]Value, n)
+		for i, row := range rows[:n] {
+			values[i] = row.Values
+		}
+		if err := t.InsertRows(rows[0].TableName, values); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// emitToTransport hands a sub-content emission to a custom transport and
+// records it like a file-based emission
+func emitToTransport(t Transport, sc SubContent) error {
+	if err := t.EmitSubContent(sc); err != nil {
+		return err
+	}
+	kind := EmissionBytes
+	if sc.Slice {
+		kind = EmissionSlice
+	}
+	recordEmission(EmissionRecord{
+		Index:    sc.Index,
+		Filename: sc.Filename,
+		Kind:     kind,
+		Offset:   sc.Offset,
+		Length:   sc.Length,
+	})
+	return nil
+}
+
+// emitOptions returns the attributes of a bytes emission
+func (m subContentMetadata) emitOptions() EmitOptions {
+	opts := EmitOptions{
+		Tags:             m.Tags,
+		Relationship:     m.Relationship,
+		SuggestedParsers: m.Parsers,
+		FormatVersion:    m.FormatVersion,
+		Method:           m.Method,
+	}
+	if m.Flags != nil {
+		opts.Flags = *m.Flags
+	}
+	return opts
+}
+
+// emitOptions returns the attributes of a slice emission
+func (m subContentSliceMetadata) emitOptions() EmitOptions {
+	opts := EmitOptions{
+		Tags:             m.Tags,
+		Relationship:     m.Relationship,
+		SuggestedParsers: m.Parsers,
+		FormatVersion:    m.FormatVersion,
+		Method:           m.Method,
+	}
+	if m.Flags != nil {
+		opts.Flags = *m.Flags
+	}
+	return opts
+}
@@ -0,0 +1,8 @@
+//go:build !wasip2
+
+package wadup
+
+// defaultTransport returns the transport of preview1 and native builds
+func defaultTransport() Transport {
+	return FileTransport{}
+}
@@ -0,0 +1,109 @@
+package wadup
+
+import (
+	"fmt"
+	"time"
+)
+
+// FileTransport speaks the file-based protocol of preview1 modules: metadata
+// files in /metadata, sub-content in /subcontent and log lines in /log (or the
+// host's log_message import), with content read from /data.bin.
+//
+// It is the default transport outside component builds. While it is in use,
+// the library writes the files itself, which adds what the Transport
+// interface can't carry: scan status, attributes and aggregates, the
+// negotiated metadata encoding and compressed sub-content. The methods exist
+// for transports that wrap it, e.g. to record output while still delivering
+// it to the host; like other transports, they are called with the library's
+// locks held and must not call back into it.
+type FileTransport struct{}
+
+// DefineTable writes a metadata file declaring the table
+func (FileTransport) DefineTable(schema TableSchema) error {
+	return writeTransportMetadata(metadataFile{
+		Tables: []tableDef{newTableDef(schema.Name, schema.Columns)},
+		Rows:   []rowDef{},
+	})
+}
+
+// InsertRows writes the rows to metadata files, chunked like buffered rows
+func (FileTransport) InsertRows(table string, rows [][]Value) error {
+	defs := make([]rowDef, len(rows))
+	for i, values := range rows {
+		defs[i] = rowDef{TableName: table, Values: values}
+	}
+	for _, chunk := range chunkRows(defs) {
+		if err := writeTransportMetadata(metadataFile{Tables: []tableDef{}, Rows: chunk}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EmitSubContent writes the child's data file, unless it is a slice, followed
+// by its metadata file
+func (FileTransport) EmitSubContent(sc SubContent) error {
+	if sc.Slice {
+		metadata := subContentSliceMetadata{
+			Filename:      sc.Filename,
+			Offset:        sc.Offset,
+			Length:        sc.Length,
+			AnalyzedBy:    analyzedByChain(),
+			Relationship:  sc.Options.Relationship,
+			FormatVersion: sc.Options.FormatVersion,
+			Method:        sc.Options.Method,
+			Tags:          sc.Options.Tags,
+			Parsers:       sc.Options.SuggestedParsers,
+			ParentRef:     sc.ParentRef,
+		}
+		if sc.Options.Flags != (ContentFlags{}) {
+			metadata.Flags = &sc.Options.Flags
+		}
+		return writeSubContentMetadata(sc.Index, metadata)
+	}
+
+	metadata := subContentMetadata{
+		Filename:      sc.Filename,
+		AnalyzedBy:    analyzedByChain(),
+		Relationship:  sc.Options.Relationship,
+		FormatVersion: sc.Options.FormatVersion,
+		Method:        sc.Options.Method,
+		Tags:          sc.Options.Tags,
+		Parsers:       sc.Options.SuggestedParsers,
+	}
+	if sc.Options.Flags != (ContentFlags{}) {
+		metadata.Flags = &sc.Options.Flags
+	}
+	if err := writeSubContentData(sc.Index, sc.Data); err != nil {
+		return err
+	}
+	return writeSubContentMetadata(sc.Index, metadata)
+}
+
+// Log passes the message to the host's log_message import, or appends it to
+// /log/messages.jsonl
+func (FileTransport) Log(level LogLevel, message string) error {
+	if hostLogMessage(level, message) {
+		return nil
+	}
+	return appendLogEntry(logEntry{
+		Level:   level.String(),
+		Message: message,
+		Time:    time.Now().UTC(),
+	})
+}
+
+// ContentFile returns /data.bin
+func (FileTransport) ContentFile() string {
+	return ContentPath
+}
+
+// writeTransportMetadata encodes a metadata file in the negotiated encoding
+// and writes it out
+func writeTransportMetadata(m metadataFile) error {
+	payload, ext, err := encodeMetadata(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return writeMetadataPayload(payload, ext)
+}
@@ -1,8 +1,14 @@
 package wadup
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // DataType represents the type of data in a column
@@ -12,12 +18,58 @@ const (
 	Int64   DataType = "Int64"
 	Float64 DataType = "Float64"
 	String  DataType = "String"
+	Bool    DataType = "Boolean"
+	// Bytes is binary data, base64-encoded on the wire
+	Bytes DataType = "Bytes"
+	// BytesRef is a binary value stored in a side file and referenced by path
+	BytesRef DataType = "BytesRef"
+	// Timestamp is a point in time, encoded as RFC3339: in UTC, or with the
+	// original offset for values created with NewTime
+	Timestamp DataType = "Timestamp"
+	// Duration is a length of time, encoded as integer nanoseconds
+	Duration DataType = "Duration"
+	// IPAddress is an IPv4 or IPv6 address in canonical text form
+	IPAddress DataType = "IPAddress"
+	// IPv4 is an IPv4 address in dotted decimal form
+	IPv4 DataType = "IPv4"
+	// IPv6 is an IPv6 address in canonical text form
+	IPv6 DataType = "IPv6"
+	// UUID is a 128-bit identifier in lowercase hyphenated form
+	UUID DataType = "UUID"
+	// Json is a nested document (object, array or scalar) embedded as JSON
+	Json DataType = "Json"
+	// StringArray is a list of strings
+	StringArray DataType = "StringArray"
+	// Int64Array is a list of 64-bit signed integers
+	Int64Array DataType = "Int64Array"
+	// BytesArray is a list of binary values, each base64-encoded on the wire
+	BytesArray DataType = "BytesArray"
+	// Decimal is an exact decimal number, carried as base-10 text such as
+	// "-12.50" so sinks can store it as NUMERIC instead of a lossy Float64
+	Decimal DataType = "Decimal"
+	// BigInt is an integer of arbitrary size, such as a 128-bit identifier,
+	// carried as base-10 text
+	BigInt DataType = "BigInt"
+	// SubContentID links a row to a sub-content item of the current content.
+	// The guest inserts a SubContentRef, which the host replaces with the
+	// child's content ID.
+	SubContentID DataType = "SubContentID"
 )
 
 // Column represents a column definition in a table
 type Column struct {
 	Name     string   `json:"name"`
 	DataType DataType `json:"data_type"`
+	Nullable bool     `json:"nullable,omitempty"`
+	// PrimaryKey marks the column as part of the table's primary key. Key
+	// columns must not be nullable.
+	PrimaryKey bool `json:"primary_key,omitempty"`
+	// Unique means no two rows share a non-NULL value in the column
+	Unique bool `json:"unique,omitempty"`
+	// Dictionary marks a String column with few distinct values, such as a
+	// file type or verdict. Each metadata file then sends the distinct
+	// strings once and the rows only small integer codes.
+	Dictionary bool `json:"dictionary,omitempty"`
 }
 
 // Value represents a value that can be inserted into a table
@@ -25,6 +77,32 @@ type Value struct {
 	data interface{}
 }
 
+// Null returns the NULL value, accepted only by nullable columns
+func Null() Value {
+	return Value{}
+}
+
+// IsNull reports whether the value is NULL
+func (v Value) IsNull() bool {
+	return v.data == nil
+}
+
+// NullableString creates a String value, or NULL if v is nil
+func NullableString(v *string) Value {
+	if v == nil {
+		return Null()
+	}
+	return NewString(*v)
+}
+
+// NullableInt64 creates an Int64 value, or NULL if v is nil
+func NullableInt64(v *int64) Value {
+	if v == nil {
+		return Null()
+	}
+	return NewInt64(*v)
+}
+
 // NewInt64 creates a new Int64 value
 func NewInt64(v int64) Value {
 	return Value{data: v}
@@ -40,16 +118,404 @@ func NewString(v string) Value {
 	return Value{data: v}
 }
 
+// NewBool creates a new Boolean value
+func NewBool(v bool) Value {
+	return Value{data: v}
+}
+
+// NewBytes creates a new Bytes value. The slice is not copied and must not be
+// modified until the metadata has been flushed.
+func NewBytes(v []byte) Value {
+	if v == nil {
+		v = []byte{}
+	}
+	return Value{data: v}
+}
+
+// NewTimestamp creates a new Timestamp value, normalized to UTC
+func NewTimestamp(v time.Time) Value {
+	return Value{data: v.UTC()}
+}
+
+// NewTime creates a new Timestamp value that keeps v's offset from UTC, such
+// as the local time of an email's Date header or a log line, e.g.
+// "2024-03-01T09:30:00+01:00". The instant is the same as with NewTimestamp.
+func NewTime(v time.Time) Value {
+	return Value{data: v.Round(0)}
+}
+
+// NewDuration creates a new Duration value
+func NewDuration(v time.Duration) Value {
+	return Value{data: v}
+}
+
+// NewIPAddress creates a new IPAddress value.
+// IPv4-mapped IPv6 addresses are normalized to plain IPv4.
+func NewIPAddress(v netip.Addr) Value {
+	return Value{data: v.Unmap()}
+}
+
+// Address and identifier values use distinct types so a value's column type
+// can be told apart from IPAddress
+type (
+	ipv4Addr  netip.Addr
+	ipv6Addr  netip.Addr
+	uuidValue [16]byte
+)
+
+// NewIPv4 creates a new IPv4 value. IPv4-mapped IPv6 addresses are accepted
+// as their IPv4 address; any other address is an error.
+func NewIPv4(v netip.Addr) (Value, error) {
+	v = v.Unmap()
+	if !v.Is4() {
+		return Value{}, fmt.Errorf("invalid IPv4 address '%s'", v)
+	}
+	return Value{data: ipv4Addr(v)}, nil
+}
+
+// NewIPv6 creates a new IPv6 value. IPv4 addresses are an error, while
+// IPv4-mapped IPv6 addresses are kept as they are.
+func NewIPv6(v netip.Addr) (Value, error) {
+	if !v.Is6() {
+		return Value{}, fmt.Errorf("invalid IPv6 address '%s'", v)
+	}
+	return Value{data: ipv6Addr(v)}, nil
+}
+
+// NewUUID creates a new UUID value from its text form, hyphenated or not and
+// optionally in braces or with a "urn:uuid:" prefix
+func NewUUID(s string) (Value, error) {
+	text := strings.TrimPrefix(strings.ToLower(s), "urn:uuid:")
+	if strings.HasPrefix(text, "{") && strings.HasSuffix(text, "}") {
+		text = text[1 : len(text)-1]
+	}
+	if len(text) == 36 && text[8] == '-' && text[13] == '-' && text[18] == '-' && text[23] == '-' {
+		text = text[:8] + text[9:13] + text[14:18] + text[19:23] + text[24:]
+	}
+	var id uuidValue
+	if len(text) != 32 {
+		return Value{}, fmt.Errorf("invalid UUID '%s'", s)
+	}
+	if _, err := hex.Decode(id[:], []byte(text)); err != nil {
+		return Value{}, fmt.Errorf("invalid UUID '%s'", s)
+	}
+	return Value{data: id}, nil
+}
+
+// NewUUIDFromBytes creates a new UUID value from its 16 bytes
+func NewUUIDFromBytes(v [16]byte) Value {
+	return Value{data: uuidValue(v)}
+}
+
+// String returns the UUID in lowercase hyphenated form
+func (u uuidValue) String() string {
+	text := hex.EncodeToString(u[:])
+	return text[:8] + "-" + text[8:12] + "-" + text[12:16] + "-" + text[16:20] + "-" + text[20:]
+}
+
+// Array values use distinct types so a value's column type can be told apart
+// from other slices
+type (
+	stringArray []string
+	int64Array  []int64
+	bytesArray  [][]byte
+)
+
+// NewStringArray creates a new StringArray value. The slice is copied; a nil
+// slice is an empty array, not NULL.
+func NewStringArray(v []string) Value {
+	return Value{data: stringArray(append([]string{}, v...))}
+}
+
+// NewInt64Array creates a new Int64Array value. The slice is copied; a nil
+// slice is an empty array, not NULL.
+func NewInt64Array(v []int64) Value {
+	return Value{data: int64Array(append([]int64{}, v...))}
+}
+
+// NewBytesArray creates a new BytesArray value. The outer slice is copied but,
+// as with NewBytes, the elements are not and must not be modified until the
+// metadata has been flushed.
+func NewBytesArray(v [][]byte) Value {
+	elems := make([][]byte, len(v))
+	for i, b := range v {
+		if b == nil {
+			b = []byte{}
+		}
+		elems[i] = b
+	}
+	return Value{data: bytesArray(elems)}
+}
+
+// Decimal and big-integer values use distinct types so they can be told
+// apart from strings
+type (
+	decimalText string
+	bigIntText  string
+)
+
+// NewDecimal creates a new Decimal value from base-10 text such as "12.50",
+// "-0.001" or "1e-3". The digits after the decimal point are kept, trailing
+// zeros included, as they give the value's scale.
+func NewDecimal(s string) (Value, error) {
+	text, ok := normalizeDecimal(s)
+	if !ok {
+		return Value{}, fmt.Errorf("invalid decimal '%s'", s)
+	}
+	return Value{data: decimalText(text)}, nil
+}
+
+// NewDecimalFromRat creates a new Decimal value from r rounded to scale
+// digits after the decimal point, or NULL if r is nil
+func NewDecimalFromRat(r *big.Rat, scale int) Value {
+	if r == nil {
+		return Null()
+	}
+	if scale < 0 {
+		scale = 0
+	}
+	text, _ := normalizeDecimal(r.FloatString(scale))
+	return Value{data: decimalText(text)}
+}
+
+// NewBigInt creates a new BigInt value, or NULL if v is nil
+func NewBigInt(v *big.Int) Value {
+	if v == nil {
+		return Null()
+	}
+	return Value{data: bigIntText(v.String())}
+}
+
+// normalizeDecimal checks that s is a decimal number and returns it in plain
+// notation, without an exponent, redundant leading zeros or a plus sign
+func normalizeDecimal(s string) (string, bool) {
+	mantissa, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil || e > 1000 || e < -1000 {
+			return "", false
+		}
+		mantissa, exp = s[:i], e
+	}
+
+	negative := false
+	switch {
+	case strings.HasPrefix(mantissa, "-"):
+		negative, mantissa = true, mantissa[1:]
+	case strings.HasPrefix(mantissa, "+"):
+		mantissa = mantissa[1:]
+	}
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	if whole == "" && frac == "" || !allDigits(whole) || !allDigits(frac) {
+		return "", false
+	}
+
+	// Shift the decimal point by the exponent
+	digits := whole + frac
+	point := len(whole) + exp
+	for point < 0 {
+		digits = "0" + digits
+		point++
+	}
+	for point > len(digits) {
+		digits += "0"
+	}
+	whole, frac = strings.TrimLeft(digits[:point], "0"), digits[point:]
+	if whole == "" {
+		whole = "0"
+	}
+
+	text := whole
+	if frac != "" {
+		text += "." + frac
+	}
+	if negative && strings.Trim(digits, "0") != "" {
+		text = "-" + text
+	}
+	return text, true
+}
+
+// allDigits reports whether s consists only of ASCII digits
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the value in text form, as used for String-typed columns
+// that hold values of mixed types
+func (v Value) String() string {
+	switch val := v.data.(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case []byte:
+		return hex.EncodeToString(val)
+	case bytesRef:
+		return val.Path
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case time.Duration:
+		return val.String()
+	case netip.Addr:
+		return val.String()
+	case ipv4Addr:
+		return netip.Addr(val).String()
+	case ipv6Addr:
+		return netip.Addr(val).String()
+	case uuidValue:
+		return val.String()
+	case jsonDoc:
+		return string(val)
+	case stringArray, int64Array:
+		data, _ := json.Marshal(val)
+		return string(data)
+	case decimalText:
+		return string(val)
+	case bigIntText:
+		return string(val)
+	case bytesArray:
+		elems := make([]string, len(val))
+		for i, b := range val {
+			elems[i] = hex.EncodeToString(b)
+		}
+		data, _ := json.Marshal(elems)
+		return string(data)
+	case SubContentRef:
+		return "subcontent:" + strconv.Itoa(val.index)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// dataType returns the column type the value belongs to, or "" for NULL
+func (v Value) dataType() DataType {
+	switch v.data.(type) {
+	case int64:
+		return Int64
+	case float64:
+		return Float64
+	case string:
+		return String
+	case bool:
+		return Bool
+	case []byte:
+		return Bytes
+	case bytesRef:
+		return BytesRef
+	case time.Time:
+		return Timestamp
+	case time.Duration:
+		return Duration
+	case netip.Addr:
+		return IPAddress
+	case ipv4Addr:
+		return IPv4
+	case ipv6Addr:
+		return IPv6
+	case uuidValue:
+		return UUID
+	case jsonDoc:
+		return Json
+	case stringArray:
+		return StringArray
+	case int64Array:
+		return Int64Array
+	case bytesArray:
+		return BytesArray
+	case decimalText:
+		return Decimal
+	case bigIntText:
+		return BigInt
+	case SubContentRef:
+		return SubContentID
+	default:
+		return ""
+	}
+}
+
+// key returns a comparable representation of the value for use as a map key
+func (v Value) key() interface{} {
+	switch val := v.data.(type) {
+	case []byte:
+		return string(val)
+	case jsonDoc:
+		return string(val)
+	case stringArray, int64Array, bytesArray:
+		return v.String()
+	}
+	return v.data
+}
+
+// jsonDoc is a nested document, already encoded as JSON
+type jsonDoc json.RawMessage
+
+// NewJSON creates a new Json value from anything encoding/json can marshal,
+// such as a map[string]interface{} or a json.Marshaler. It is encoded
+// immediately, so later changes to v are not reflected.
+func NewJSON(v interface{}) (Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Value{}, fmt.Errorf("failed to encode JSON value: %w", err)
+	}
+	return Value{data: jsonDoc(data)}, nil
+}
+
 // MarshalJSON implements custom JSON encoding for Value
 // Encodes as a tagged union: {"Int64": 42}, {"String": "foo"}, etc.
+// NULL is encoded as the bare tag "Null".
 func (v Value) MarshalJSON() ([]byte, error) {
 	switch val := v.data.(type) {
+	case nil:
+		return json.Marshal("Null")
 	case int64:
 		return json.Marshal(map[string]int64{"Int64": val})
 	case float64:
 		return json.Marshal(map[string]float64{"Float64": val})
 	case string:
 		return json.Marshal(map[string]string{"String": val})
+	case bool:
+		return json.Marshal(map[string]bool{"Boolean": val})
+	case []byte:
+		return json.Marshal(map[string][]byte{"Bytes": val})
+	case bytesRef:
+		return json.Marshal(map[string]bytesRef{"BytesRef": val})
+	case time.Time:
+		return json.Marshal(map[string]string{"Timestamp": val.Format(time.RFC3339Nano)})
+	case time.Duration:
+		return json.Marshal(map[string]int64{"Duration": int64(val)})
+	case netip.Addr:
+		return json.Marshal(map[string]string{"IPAddress": val.String()})
+	case ipv4Addr:
+		return json.Marshal(map[string]string{"IPv4": netip.Addr(val).String()})
+	case ipv6Addr:
+		return json.Marshal(map[string]string{"IPv6": netip.Addr(val).String()})
+	case uuidValue:
+		return json.Marshal(map[string]string{"UUID": val.String()})
+	case jsonDoc:
+		return json.Marshal(map[string]json.RawMessage{"Json": json.RawMessage(val)})
+	case stringArray:
+		return json.Marshal(map[string][]string{"StringArray": val})
+	case int64Array:
+		return json.Marshal(map[string][]int64{"Int64Array": val})
+	case bytesArray:
+		return json.Marshal(map[string][][]byte{"BytesArray": val})
+	case decimalText:
+		return json.Marshal(map[string]string{"Decimal": string(val)})
+	case bigIntText:
+		return json.Marshal(map[string]string{"BigInt": string(val)})
+	case SubContentRef:
+		return json.Marshal(map[string]int{"SubContentID": val.index})
 	default:
 		return nil, fmt.Errorf("unsupported value type: %T", val)
 	}
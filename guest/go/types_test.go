@@ -0,0 +1,244 @@
+package wadup
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestNormalizeDecimal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"12.50", "12.50"},
+		{"-0.001", "-0.001"},
+		{"+7", "7"},
+		{"007.10", "7.10"},
+		{".5", "0.5"},
+		{"5.", "5"},
+		{"1e-3", "0.001"},
+		{"1.5E2", "150"},
+		{"-12.345e1", "-123.45"},
+		{"0.00", "0.00"},
+		{"-0.00", "0.00"},
+		{"-0e5", "0"},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeDecimal(tt.in)
+		if !ok || got != tt.want {
+			t.Errorf("normalizeDecimal(%q) = %q, %v; want %q, true", tt.in, got, ok, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDecimalRejectsInvalid(t *testing.T) {
+	for _, in := range []string{"", ".", "-", "1.2.3", "abc", "1,5", "1e", "1e5000", "--1", "1e-3.5", " 1"} {
+		if got, ok := normalizeDecimal(in); ok {
+			t.Errorf("normalizeDecimal(%q) = %q, want invalid", in, got)
+		}
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	const want = "123e4567-e89b-12d3-a456-426614174000"
+	for _, in := range []string{
+		want,
+		"123E4567-E89B-12D3-A456-426614174000",
+		"123e4567e89b12d3a456426614174000",
+		"{123e4567-e89b-12d3-a456-426614174000}",
+		"urn:uuid:123e4567-e89b-12d3-a456-426614174000",
+	} {
+		v, err := NewUUID(in)
+		if err != nil {
+			t.Errorf("NewUUID(%q) failed: %v", in, err)
+			continue
+		}
+		if got := v.String(); got != want {
+			t.Errorf("NewUUID(%q) = %s, want %s", in, got, want)
+		}
+		if v.dataType() != UUID {
+			t.Errorf("NewUUID(%q) has type %s", in, v.dataType())
+		}
+	}
+}
+
+func TestNewUUIDRejectsInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"123e4567-e89b-12d3-a456-42661417400",
+		"123e4567-e89b-12d3-a456-4266141740000",
+		"123e4567-e89b-12d3-a456_426614174000",
+		"123e4567-e89b-12d3-a456-42661417400g",
+		"{123e4567-e89b-12d3-a456-426614174000",
+	} {
+		if _, err := NewUUID(in); err == nil {
+			t.Errorf("NewUUID(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestNewIPv4(t *testing.T) {
+	for in, want := range map[string]string{
+		"192.0.2.1":        "192.0.2.1",
+		"::ffff:192.0.2.1": "192.0.2.1",
+	} {
+		v, err := NewIPv4(netip.MustParseAddr(in))
+		if err != nil {
+			t.Errorf("NewIPv4(%s) failed: %v", in, err)
+			continue
+		}
+		if got := v.String(); got != want || v.dataType() != IPv4 {
+			t.Errorf("NewIPv4(%s) = %s (%s), want %s", in, got, v.dataType(), want)
+		}
+	}
+	for _, in := range []string{"2001:db8::1", "::1"} {
+		if _, err := NewIPv4(netip.MustParseAddr(in)); err == nil {
+			t.Errorf("NewIPv4(%s) succeeded, want an error", in)
+		}
+	}
+	if _, err := NewIPv4(netip.Addr{}); err == nil {
+		t.Error("NewIPv4 of the zero address succeeded, want an error")
+	}
+}
+
+func TestNewIPv6(t *testing.T) {
+	for in, want := range map[string]string{
+		"2001:DB8:0:0::1":  "2001:db8::1",
+		"::ffff:192.0.2.1": "::ffff:192.0.2.1",
+	} {
+		v, err := NewIPv6(netip.MustParseAddr(in))
+		if err != nil {
+			t.Errorf("NewIPv6(%s) failed: %v", in, err)
+			continue
+		}
+		if got := v.String(); got != want || v.dataType() != IPv6 {
+			t.Errorf("NewIPv6(%s) = %s (%s), want %s", in, got, v.dataType(), want)
+		}
+	}
+	if _, err := NewIPv6(netip.MustParseAddr("192.0.2.1")); err == nil {
+		t.Error("NewIPv6(192.0.2.1) succeeded, want an error")
+	}
+}
+
+func TestNewIPAddressUnmapsIPv4(t *testing.T) {
+	v := NewIPAddress(netip.MustParseAddr("::ffff:10.0.0.1"))
+	if got := v.String(); got != "10.0.0.1" || v.dataType() != IPAddress {
+		t.Errorf("NewIPAddress(::ffff:10.0.0.1) = %s (%s), want 10.0.0.1", got, v.dataType())
+	}
+}
+
+func TestDecodeValueValidatesAddressesAndUUIDs(t *testing.T) {
+	v, err := decodeValue([]byte(`{"IPv4":"10.0.0.1"}`))
+	if err != nil || v.dataType() != IPv4 || v.String() != "10.0.0.1" {
+		t.Errorf("decoding an IPv4 value gave %v (%s), %v", v, v.dataType(), err)
+	}
+	for _, raw := range []string{`{"IPv4":"::1"}`, `{"IPv6":"bogus"}`, `{"UUID":"123"}`} {
+		if _, err := decodeValue([]byte(raw)); err == nil {
+			t.Errorf("decodeValue(%s) succeeded, want an error", raw)
+		}
+	}
+}
+
+// encoderValues covers every data type, with its JSON tagged form
+func encoderValues(t *testing.T) []struct {
+	v    Value
+	json string
+} {
+	t.Helper()
+	must := func(v Value, err error) Value {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	return []struct {
+		v    Value
+		json string
+	}{
+		{Null(), `"Null"`},
+		{NewInt64(-7), `{"Int64":-7}`},
+		{NewFloat64(1.5), `{"Float64":1.5}`},
+		{NewString("pe"), `{"String":"pe"}`},
+		{NewBool(true), `{"Boolean":true}`},
+		{NewBytes([]byte{0, 1, 0xff}), `{"Bytes":"AAH/"}`},
+		{NewTimestamp(time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("", 3600))), `{"Timestamp":"2024-03-01T08:30:00Z"}`},
+		{NewTime(time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("", 3600))), `{"Timestamp":"2024-03-01T09:30:00+01:00"}`},
+		{NewDuration(1500 * time.Millisecond), `{"Duration":1500000000}`},
+		{NewIPAddress(netip.MustParseAddr("::1")), `{"IPAddress":"::1"}`},
+		{must(NewIPv4(netip.MustParseAddr("10.0.0.1"))), `{"IPv4":"10.0.0.1"}`},
+		{must(NewIPv6(netip.MustParseAddr("fe80::1"))), `{"IPv6":"fe80::1"}`},
+		{must(NewUUID("123e4567-e89b-12d3-a456-426614174000")), `{"UUID":"123e4567-e89b-12d3-a456-426614174000"}`},
+		{must(NewJSON(map[string]interface{}{"a": []int{1, 2}})), `{"Json":{"a":[1,2]}}`},
+		{NewStringArray([]string{"a", "b"}), `{"StringArray":["a","b"]}`},
+		{NewInt64Array([]int64{1, -2}), `{"Int64Array":[1,-2]}`},
+		{NewBytesArray([][]byte{{0xff}}), `{"BytesArray":["/w=="]}`},
+		{must(NewDecimal("12.50")), `{"Decimal":"12.50"}`},
+		{NewBigInt(new(big.Int).Lsh(big.NewInt(1), 70)), `{"BigInt":"1180591620717411303424"}`},
+		{NewSubContentRef(SubContentRef{index: 3, emitted: true}), `{"SubContentID":3}`},
+	}
+}
+
+func TestValueMarshalJSON(t *testing.T) {
+	for _, tt := range encoderValues(t) {
+		got, err := json.Marshal(tt.v)
+		if err != nil {
+			t.Errorf("marshaling %s failed: %v", tt.json, err)
+			continue
+		}
+		if string(got) != tt.json {
+			t.Errorf("marshaled value = %s, want %s", got, tt.json)
+		}
+	}
+}
+
+func TestValueJSONRoundTrip(t *testing.T) {
+	for _, tt := range encoderValues(t) {
+		// The host resolves sub-content references before returning values
+		if tt.v.dataType() == SubContentID {
+			continue
+		}
+		v, err := decodeValue([]byte(tt.json))
+		if err != nil {
+			t.Errorf("decodeValue(%s) failed: %v", tt.json, err)
+			continue
+		}
+		if v.dataType() != tt.v.dataType() || v.String() != tt.v.String() {
+			t.Errorf("decodeValue(%s) = %s (%s), want %s (%s)", tt.json, v, v.dataType(), tt.v, tt.v.dataType())
+		}
+	}
+}
+
+func TestValueMsgPack(t *testing.T) {
+	tests := []struct {
+		v    Value
+		want []byte
+	}{
+		{Null(), []byte{0xa4, 'N', 'u', 'l', 'l'}},
+		{NewInt64(7), []byte{0x81, 0xa5, 'I', 'n', 't', '6', '4', 0x07}},
+		{NewInt64(-200), []byte{0x81, 0xa5, 'I', 'n', 't', '6', '4', 0xd1, 0xff, 0x38}},
+		{NewBool(false), []byte{0x81, 0xa7, 'B', 'o', 'o', 'l', 'e', 'a', 'n', 0xc2}},
+		{NewBytes([]byte{1, 2}), []byte{0x81, 0xa5, 'B', 'y', 't', 'e', 's', 0xc4, 0x02, 1, 2}},
+		{NewInt64Array([]int64{1, -1}), []byte{0x81, 0xaa, 'I', 'n', 't', '6', '4', 'A', 'r', 'r', 'a', 'y', 0x92, 0x01, 0xff}},
+	}
+	for _, tt := range tests {
+		w := &msgpackWriter{}
+		if err := w.writeValue(tt.v); err != nil {
+			t.Errorf("writeValue(%s) failed: %v", tt.v, err)
+			continue
+		}
+		if !bytes.Equal(w.buf, tt.want) {
+			t.Errorf("writeValue(%s) = % x, want % x", tt.v, w.buf, tt.want)
+		}
+	}
+
+	// Every type encodes
+	for _, tt := range encoderValues(t) {
+		if err := (&msgpackWriter{}).writeValue(tt.v); err != nil {
+			t.Errorf("writeValue(%s) failed: %v", tt.json, err)
+		}
+	}
+}
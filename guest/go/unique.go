@@ -0,0 +1,109 @@
+package wadup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	uniqueMu      sync.Mutex
+	enforceUnique bool
+	// uniqueKeys holds the keys claimed by inserted rows, per table, for the
+	// current content
+	uniqueKeys = make(map[string]map[string]struct{})
+)
+
+// SetEnforceUniqueness makes InsertRow and InsertRows reject rows that repeat
+// the primary key, or the value of a Unique column, of a row already
+// inserted into the same table for the current content. NULLs in Unique
+// columns never conflict.
+//
+// Off by default: the constraints are then only hints for the host and
+// downstream sinks, which can index or deduplicate on them.
+func SetEnforceUniqueness(enforce bool) {
+	uniqueMu.Lock()
+	defer uniqueMu.Unlock()
+	enforceUnique = enforce
+}
+
+// resetUniqueKeys forgets the keys claimed for the previous content
+func resetUniqueKeys() {
+	uniqueMu.Lock()
+	defer uniqueMu.Unlock()
+	clear(uniqueKeys)
+}
+
+// claimKeys checks rows against the table's uniqueness constraints and, if
+// none is violated, records their keys. Either all rows are claimed or none.
+func (t *Table) claimKeys(rows []rowDef) error {
+	uniqueMu.Lock()
+	defer uniqueMu.Unlock()
+	if !enforceUnique {
+		return nil
+	}
+
+	seen := uniqueKeys[t.name]
+	claimed := make(map[string]struct{})
+	for i, row := range rows {
+		for _, key := range t.rowKeys(row.Values) {
+			_, dup := seen[key.key]
+			if _, inBatch := claimed[key.key]; dup || inBatch {
+				if len(rows) > 1 {
+					return fmt.Errorf("row %d: table '%s': duplicate %s", i, t.name, key.what)
+				}
+				return fmt.Errorf("table '%s': duplicate %s", t.name, key.what)
+			}
+			claimed[key.key] = struct{}{}
+		}
+	}
+
+	if len(claimed) == 0 {
+		return nil
+	}
+	if seen == nil {
+		seen = make(map[string]struct{}, len(claimed))
+		uniqueKeys[t.name] = seen
+	}
+	for key := range claimed {
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+// uniqueKey is a key a row claims, with a description for errors
+type uniqueKey struct {
+	key  string
+	what string
+}
+
+// rowKeys returns the keys of a row: its primary key, if the table has one,
+// and the value of each non-NULL Unique column
+func (t *Table) rowKeys(values []Value) []uniqueKey {
+	var keys []uniqueKey
+	var pk, pkDesc strings.Builder
+	for i, col := range t.columns {
+		if !col.PrimaryKey {
+			continue
+		}
+		fmt.Fprintf(&pk, "%s:%s\x00", values[i].dataType(), values[i])
+		if pkDesc.Len() > 0 {
+			pkDesc.WriteString(", ")
+		}
+		fmt.Fprintf(&pkDesc, "%s=%q", col.Name, values[i].String())
+	}
+	if pk.Len() > 0 {
+		keys = append(keys, uniqueKey{key: "pk\x00" + pk.String(), what: "primary key (" + pkDesc.String() + ")"})
+	}
+
+	for i, col := range t.columns {
+		if !col.Unique || values[i].IsNull() {
+			continue
+		}
+		keys = append(keys, uniqueKey{
+			key:  fmt.Sprintf("u%d\x00%s:%s", i, values[i].dataType(), values[i]),
+			what: fmt.Sprintf("value %q in unique column '%s'", values[i].String(), col.Name),
+		})
+	}
+	return keys
+}
@@ -0,0 +1,13 @@
+package wadup
+
+// EmitBytesVersioned emits sub-content bytes with the detected format version
+// (e.g. "PDF 1.7", "OLE2", "zip64") recorded in the sub-content metadata.
+//
+// The version is advisory metadata that lets the host route the child without
+// re-detecting it. An empty formatVersion is omitted, matching EmitBytes.
+func EmitBytesVersioned(data []byte, filename, formatVersion string) (SubContentRef, error) {
+	return emitBytes(data, subContentMetadata{
+		Filename:      filename,
+		FormatVersion: formatVersion,
+	})
+}
@@ -0,0 +1,148 @@
+package wadtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	wadup "github.com/tordynnar/wadup2/guest/go"
+)
+
+// GoldenSuffix is appended to an input's path to name its golden file
+const GoldenSuffix = ".golden.json"
+
+var update = flag.Bool("update", false, "rewrite wadtest golden files with the current output")
+
+// goldenManifest is the committed summary of a module's output
+type goldenManifest struct {
+	Code       int32                      `json:"code"`
+	Tables     []wadup.TableSchema        `json:"tables"`
+	Rows       map[string][][]wadup.Value `json:"rows"`
+	SubContent []goldenSubContent         `json:"subcontent"`
+}
+
+// goldenSubContent describes one emission; bytes are summarized by digest
+type goldenSubContent struct {
+	Filename     string              `json:"filename"`
+	Slice        bool                `json:"slice,omitempty"`
+	Offset       int64               `json:"offset,omitempty"`
+	Length       int64               `json:"length"`
+	SHA256       string              `json:"sha256,omitempty"`
+	ParentRef    string              `json:"parent_ref,omitempty"`
+	Tags         []string            `json:"tags,omitempty"`
+	Relationship string              `json:"relationship,omitempty"`
+	Parsers      []string            `json:"suggested_parsers,omitempty"`
+	Flags        *wadup.ContentFlags `json:"flags,omitempty"`
+	Version      string              `json:"format_version,omitempty"`
+	Method       string              `json:"method,omitempty"`
+}
+
+// Golden runs a module against a sample file, or against every file in a
+// corpus directory as subtests, and compares the emitted tables, rows and
+// sub-content manifest with the committed golden JSON next to each input
+// (sample.zip is checked against sample.zip.golden.json).
+//
+// Run the tests with -update to write the golden files from the current
+// output instead of comparing.
+func Golden(t *testing.T, path string, run func(ctx wadup.Context) error) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat golden input '%s': %v", path, err)
+	}
+	if !info.IsDir() {
+		checkGolden(t, path, run)
+		return
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatalf("failed to read corpus directory '%s': %v", path, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), GoldenSuffix) {
+			continue
+		}
+		input := filepath.Join(path, entry.Name())
+		t.Run(entry.Name(), func(t *testing.T) {
+			checkGolden(t, input, run)
+		})
+	}
+}
+
+// checkGolden compares the output for one input with its golden file
+func checkGolden(t *testing.T, input string, run func(ctx wadup.Context) error) {
+	t.Helper()
+
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("failed to read golden input '%s': %v", input, err)
+	}
+	got, err := goldenJSON(Run(t, data, run))
+	if err != nil {
+		t.Fatalf("failed to encode output for '%s': %v", input, err)
+	}
+
+	goldenPath := input + GoldenSuffix
+	if *update {
+		if err := os.WriteFile(goldenPath, got, 0o644); err != nil {
+			t.Fatalf("failed to write golden file '%s': %v", goldenPath, err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("failed to read golden file '%s' (run with -update to create it): %v", goldenPath, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output for '%s' differs from %s (run with -update to accept):\n%s", input, goldenPath, got)
+	}
+}
+
+// goldenJSON encodes a result as indented JSON with a trailing newline
+func goldenJSON(r *Result) ([]byte, error) {
+	manifest := goldenManifest{
+		Code:       r.Code,
+		Tables:     append([]wadup.TableSchema(nil), r.Tables...),
+		Rows:       r.Rows,
+		SubContent: make([]goldenSubContent, len(r.SubContent)),
+	}
+	sort.SliceStable(manifest.Tables, func(i, j int) bool { return manifest.Tables[i].Name < manifest.Tables[j].Name })
+	for i, sc := range r.SubContent {
+		entry := goldenSubContent{
+			Filename:     sc.Filename,
+			Slice:        sc.Slice,
+			Offset:       sc.Offset,
+			Length:       sc.Length,
+			ParentRef:    sc.ParentRef,
+			Tags:         sc.Options.Tags,
+			Relationship: sc.Options.Relationship,
+			Parsers:      sc.Options.SuggestedParsers,
+			Version:      sc.Options.FormatVersion,
+			Method:       sc.Options.Method,
+		}
+		if sc.Options.Flags != (wadup.ContentFlags{}) {
+			entry.Flags = &sc.Options.Flags
+		}
+		if !sc.Slice {
+			sum := sha256.Sum256(sc.Data)
+			entry.SHA256 = hex.EncodeToString(sum[:])
+		}
+		manifest.SubContent[i] = entry
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
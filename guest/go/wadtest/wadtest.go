@@ -0,0 +1,117 @@
+// Package wadtest runs WADUP guest modules in-process, so parsers can be
+// tested with ordinary go test against sample files.
+package wadtest
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	wadup "github.com/tordynnar/wadup2/guest/go"
+)
+
+// LogEntry is a captured log message
+type LogEntry struct {
+	Level   wadup.LogLevel
+	Message string
+}
+
+// Result holds everything a module produced during one run
+type Result struct {
+	// Code is the status code returned by wadup.Main
+	Code int32
+	// Tables lists the table definitions in the order they were delivered
+	Tables []wadup.TableSchema
+	// Rows holds the inserted rows, keyed by table name
+	Rows map[string][][]wadup.Value
+	// SubContent lists the emitted sub-content in emission order
+	SubContent []wadup.SubContent
+	// Logs lists the messages that passed the log level filter
+	Logs []LogEntry
+}
+
+// Column returns the values of one column of a table, or nil if the table
+// or column is unknown
+func (r *Result) Column(table, column string) []wadup.Value {
+	for _, schema := range r.Tables {
+		if schema.Name != table {
+			continue
+		}
+		for i, col := range schema.Columns {
+			if col.Name != column {
+				continue
+			}
+			values := make([]wadup.Value, len(r.Rows[table]))
+			for j, row := range r.Rows[table] {
+				values[j] = row[i]
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+// captureTransport records module output in a Result
+type captureTransport struct {
+	mu          sync.Mutex
+	result      *Result
+	contentFile string
+}
+
+func (c *captureTransport) DefineTable(schema wadup.TableSchema) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.result.Tables = append(c.result.Tables, schema)
+	if _, ok := c.result.Rows[schema.Name]; !ok {
+		c.result.Rows[schema.Name] = nil
+	}
+	return nil
+}
+
+func (c *captureTransport) InsertRows(table string, rows [][]wadup.Value) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.result.Rows[table] = append(c.result.Rows[table], rows...)
+	return nil
+}
+
+func (c *captureTransport) EmitSubContent(sc wadup.SubContent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.result.SubContent = append(c.result.SubContent, sc)
+	return nil
+}
+
+func (c *captureTransport) Log(level wadup.LogLevel, message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.result.Logs = append(c.result.Logs, LogEntry{Level: level, Message: message})
+	return nil
+}
+
+func (c *captureTransport) ContentFile() string {
+	return c.contentFile
+}
+
+// Run processes input with run, as wadup.Main would inside the host, and
+// returns the captured output.
+//
+// The guest library keeps its state in package variables, so tests using
+// Run must not run in parallel.
+func Run(t testing.TB, input []byte, run func(ctx wadup.Context) error) *Result {
+	t.Helper()
+
+	contentFile := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(contentFile, input, 0o644); err != nil {
+		t.Fatalf("failed to write content file '%s': %v", contentFile, err)
+	}
+
+	result := &Result{Rows: make(map[string][][]wadup.Value)}
+	wadup.SetTransport(&captureTransport{result: result, contentFile: contentFile})
+	defer wadup.SetTransport(nil)
+	wadup.ResetEmittedSubContent()
+
+	result.Code = wadup.Main(run)
+	return result
+}
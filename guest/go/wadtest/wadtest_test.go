@@ -0,0 +1,120 @@
+package wadtest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	wadup "github.com/tordynnar/wadup2/guest/go"
+)
+
+// countLines is a small parser: one row per line, with each line that starts
+// with '!' emitted as sub-content
+func countLines(ctx wadup.Context) error {
+	f, err := wadup.OpenContent()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	table, err := wadup.NewTableBuilder("lines").
+		Column("number", wadup.Int64).
+		Column("text", wadup.String).
+		Build()
+	if err != nil {
+		return err
+	}
+	offset := int64(0)
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if err := table.InsertRow([]wadup.Value{wadup.NewInt64(int64(i + 1)), wadup.NewString(string(line))}); err != nil {
+			return err
+		}
+		if bytes.HasPrefix(line, []byte("!")) {
+			if _, err := wadup.EmitSlice(offset, int64(len(line)), "line.txt"); err != nil {
+				return err
+			}
+			if _, err := wadup.EmitBytes(bytes.ToUpper(line), "upper.txt"); err != nil {
+				return err
+			}
+		}
+		offset += int64(len(line)) + 1
+	}
+	wadup.Logf(wadup.Debug, "hidden")
+	wadup.Logf(wadup.Info, "%d bytes", len(data))
+	return nil
+}
+
+func TestRunCapturesOutput(t *testing.T) {
+	r := Run(t, []byte("a\n!b"), countLines)
+	if r.Code != 0 {
+		t.Fatalf("Code = %d, want 0", r.Code)
+	}
+	if len(r.Tables) != 1 || r.Tables[0].Name != "lines" || len(r.Tables[0].Columns) != 2 {
+		t.Fatalf("Tables = %+v, want the lines table", r.Tables)
+	}
+	if got := r.Column("lines", "text"); len(got) != 2 || got[0].String() != "a" || got[1].String() != "!b" {
+		t.Errorf("text column = %v, want [a !b]", got)
+	}
+	if got := r.Column("lines", "missing"); got != nil {
+		t.Errorf("unknown column = %v, want nil", got)
+	}
+
+	if len(r.SubContent) != 2 {
+		t.Fatalf("SubContent = %+v, want 2 emissions", r.SubContent)
+	}
+	slice, data := r.SubContent[0], r.SubContent[1]
+	if !slice.Slice || slice.Offset != 2 || slice.Length != 2 || slice.Filename != "line.txt" {
+		t.Errorf("slice emission = %+v, want 2 bytes at offset 2", slice)
+	}
+	if data.Slice || string(data.Data) != "!B" || data.Filename != "upper.txt" {
+		t.Errorf("bytes emission = %+v, want !B", data)
+	}
+
+	if len(r.Logs) != 1 || r.Logs[0] != (LogEntry{Level: wadup.Info, Message: "4 bytes"}) {
+		t.Errorf("Logs = %+v, want the info message only", r.Logs)
+	}
+}
+
+func TestRunIsolatesRuns(t *testing.T) {
+	Run(t, []byte("!x"), countLines)
+	r := Run(t, []byte("y"), countLines)
+	if len(r.SubContent) != 0 || len(r.Rows["lines"]) != 1 {
+		t.Errorf("second run saw %d emissions and %d rows, want 0 and 1", len(r.SubContent), len(r.Rows["lines"]))
+	}
+}
+
+func TestRunReportsFailure(t *testing.T) {
+	r := Run(t, nil, func(ctx wadup.Context) error {
+		return errors.New("bad input")
+	})
+	if r.Code != 1 {
+		t.Errorf("Code = %d, want 1", r.Code)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "sample.txt")
+	if err := os.WriteFile(input, []byte("a\n!b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := goldenJSON(Run(t, []byte("a\n!b"), countLines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(input+GoldenSuffix, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The golden file itself is skipped in a corpus directory
+	Golden(t, dir, countLines)
+	if !bytes.Contains(want, []byte(`"filename": "upper.txt"`)) || !bytes.Contains(want, []byte(`"sha256"`)) {
+		t.Errorf("golden manifest lacks the bytes emission:\n%s", want)
+	}
+}
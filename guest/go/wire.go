@@ -0,0 +1,66 @@
+package wadup
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// WireFormat selects how metadata files are encoded
+type WireFormat int
+
+const (
+	// WireJSON writes metadata as JSON to output_N.json (the default)
+	WireJSON WireFormat = iota
+	// WireMsgPack writes metadata as MessagePack to output_N.msgpack. The
+	// structure and field names are the same as in JSON, but values are
+	// length-prefixed and Bytes columns are stored raw instead of base64.
+	WireMsgPack
+)
+
+var (
+	wireFormat     = WireJSON
+	wireNegotiated bool
+	wireEffective  = WireJSON
+)
+
+// SetWireFormat requests an encoding for metadata files. Formats other than
+// WireJSON are only used if the host advertises support for them (see
+// HostSupports); otherwise Flush falls back to JSON, so modules
+// can opt in unconditionally.
+func SetWireFormat(format WireFormat) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	wireFormat = format
+	wireNegotiated = false
+}
+
+// negotiatedWireFormat returns the format to write, checking the host's
+// capabilities once per requested format. Caller must hold metadataMu.
+func negotiatedWireFormat() WireFormat {
+	if !wireNegotiated {
+		wireEffective = WireJSON
+		if wireFormat == WireMsgPack && HostSupports(FeatureMsgPackMetadata) {
+			wireEffective = WireMsgPack
+		}
+		wireNegotiated = true
+	}
+	return wireEffective
+}
+
+// encodeMetadata serializes a metadata file in the negotiated wire format and
+// returns the payload together with the file extension to use
+func encodeMetadata(metadata metadataFile) ([]byte, string, error) {
+	if negotiatedWireFormat() == WireMsgPack {
+		data, err := encodeMsgPack(metadata)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to serialize metadata: %w", err)
+		}
+		return data, "msgpack", nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+	return data, "json", nil
+}